@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/gorilla/mux"
+)
+
+type AccessTokenController struct {
+	service domain.IAccessTokenService
+}
+
+func NewAccessTokenController(service domain.IAccessTokenService) *AccessTokenController {
+	return &AccessTokenController{
+		service: service,
+	}
+}
+
+// Handler for calls to /users/me/tokens
+
+func (c *AccessTokenController) handleTokens(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return c.handleGetTokens(w, r)
+	case "POST":
+		return c.handleCreateToken(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /users/me/tokens")
+	}
+}
+
+func (c *AccessTokenController) handleGetTokens(w http.ResponseWriter, r *http.Request) error {
+	cognitoId := r.Header.Get("CognitoId")
+	tokens, err := c.service.GetTokens(cognitoId)
+	if err != nil {
+		log.Println("Err fetching access tokens: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, tokens)
+}
+
+func (c *AccessTokenController) handleCreateToken(w http.ResponseWriter, r *http.Request) error {
+	createTokenReq := new(domain.CreateAccessTokenRequest)
+	if err := decodeJson(w, r, createTokenReq); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	createTokenReq.UserCognitoId = r.Header.Get("CognitoId")
+
+	token, err := c.service.CreateToken(createTokenReq)
+	if err != nil {
+		log.Println("Error creating access token: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusCreated, token)
+}
+
+// Handler for calls to /users/me/tokens/{tokenId}
+
+func (c *AccessTokenController) handleToken(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "DELETE":
+		return c.handleRevokeToken(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /users/me/tokens/{tokenId}")
+	}
+}
+
+func (c *AccessTokenController) handleRevokeToken(w http.ResponseWriter, r *http.Request) error {
+	// Ids that are not numbers can not match any token
+	tokenId, err := strconv.Atoi(mux.Vars(r)["tokenId"])
+	if err != nil {
+		return WriteError(w, domain.ErrAccessTokenNotFound)
+	}
+	cognitoId := r.Header.Get("CognitoId")
+
+	if err := c.service.RevokeToken(tokenId, cognitoId); err != nil {
+		log.Println("Err revoking access token: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Access token with id %d revoked successfully", tokenId)})
+}
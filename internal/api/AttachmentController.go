@@ -0,0 +1,213 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/gorilla/mux"
+)
+
+// Form field holding the uploaded file
+const attachmentField = "file"
+
+type AttachmentController struct {
+	service domain.IAttachmentService
+}
+
+func NewAttachmentController(service domain.IAttachmentService) *AttachmentController {
+	return &AttachmentController{
+		service: service,
+	}
+}
+
+// Handler for calls to /projects/{projectId}/tasks/{taskId}/attachments
+
+func (c *AttachmentController) handleAttachments(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return c.handleGetAttachments(w, r)
+	case "POST":
+		return c.handleUploadAttachment(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks/{taskId}/attachments")
+	}
+}
+
+func (c *AttachmentController) handleGetAttachments(w http.ResponseWriter, r *http.Request) error {
+	projectId, taskId, err := taskVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	attachments, err := c.service.GetAttachments(projectId, taskId, r.Header.Get("CognitoId"))
+	if err != nil {
+		log.Println("Err fetching task attachments: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, attachments)
+}
+
+// The file is read from the multipart body as it arrives and streamed to the blob store,
+// it is never held whole in memory nor spooled to disk
+func (c *AttachmentController) handleUploadAttachment(w http.ResponseWriter, r *http.Request) error {
+	projectId, taskId, err := taskVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	file, err := nextFilePart(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+	defer file.Close()
+	content := bufio.NewReader(file)
+	req := &domain.AttachmentRequest{
+		Name:          file.FileName(),
+		ContentType:   partContentType(file.FileName(), file.Header.Get("Content-Type"), content),
+		ProjectId:     projectId,
+		TaskId:        taskId,
+		UserCognitoId: r.Header.Get("CognitoId"),
+	}
+
+	attachment, err := c.service.UploadAttachment(r.Context(), req, content)
+	if err != nil {
+		log.Println("Err uploading attachment: ", err)
+		return WriteError(w, err)
+	}
+	w.Header().Set("Location", fmt.Sprintf("/projects/%d/tasks/%d/attachments/%d", projectId, taskId, attachment.Id))
+	return WriteJson(w, http.StatusCreated, &attachment)
+}
+
+// Handler for calls to /projects/{projectId}/tasks/{taskId}/attachments/{attachmentId}
+
+func (c *AttachmentController) handleAttachment(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return c.handleGetAttachment(w, r)
+	case "DELETE":
+		return c.handleDeleteAttachment(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks/{taskId}/attachments/{attachmentId}")
+	}
+}
+
+func (c *AttachmentController) handleGetAttachment(w http.ResponseWriter, r *http.Request) error {
+	projectId, taskId, attachmentId, err := attachmentVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	attachment, err := c.service.GetAttachment(projectId, taskId, attachmentId, r.Header.Get("CognitoId"))
+	if err != nil {
+		log.Println("Err fetching attachment: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, &attachment)
+}
+
+func (c *AttachmentController) handleDeleteAttachment(w http.ResponseWriter, r *http.Request) error {
+	projectId, taskId, attachmentId, err := attachmentVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	if err := c.service.DeleteAttachment(r.Context(), projectId, taskId, attachmentId, r.Header.Get("CognitoId")); err != nil {
+		log.Println("Err deleting attachment: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Attachment with id %d deleted successfully", attachmentId)})
+}
+
+// Handler for calls to /projects/{projectId}/tasks/{taskId}/attachments/{attachmentId}/content,
+// the content is always sent as a download so a browser never renders it in the page of the api
+func (c *AttachmentController) handleAttachmentContent(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks/{taskId}/attachments/{attachmentId}/content")
+	}
+	projectId, taskId, attachmentId, err := attachmentVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	attachment, content, err := c.service.OpenAttachment(r.Context(), projectId, taskId, attachmentId, r.Header.Get("CognitoId"))
+	if err != nil {
+		log.Println("Err opening attachment: ", err)
+		return WriteError(w, err)
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	// The status is already sent, a failure can only cut the download short
+	if _, err := io.Copy(w, content); err != nil {
+		log.Println("Err sending attachment content: ", err)
+	}
+	return nil
+}
+
+// Skips the form fields sent before the file, they are small values the upload does not use
+func nextFilePart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, unsupportedMediaType(errors.New("request body must be multipart/form-data"))
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, badRequest(fmt.Errorf("request body must contain a %s field with the file", attachmentField))
+		}
+		if err != nil {
+			return nil, badRequest(fmt.Errorf("malformed multipart body: %w", err))
+		}
+		if part.FormName() == attachmentField && part.FileName() != "" {
+			return part, nil
+		}
+		n, err := io.Copy(io.Discard, io.LimitReader(part, maxBodyBytes+1))
+		part.Close()
+		if err != nil {
+			return nil, badRequest(fmt.Errorf("malformed multipart body: %w", err))
+		}
+		if n > maxBodyBytes {
+			return nil, badRequest(fmt.Errorf("form field %s is too large", part.FormName()))
+		}
+	}
+}
+
+// Type declared for the file, guessed from its extension or its first bytes when the client
+// did not tell. Parameters like the charset are dropped
+func partContentType(name, declared string, content *bufio.Reader) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name))); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	head, _ := content.Peek(512)
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return mediaType
+}
+
+func attachmentVars(r *http.Request) (projectId, taskId, attachmentId int, err error) {
+	projectId, taskId, err = taskVars(r)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	attachmentId, err = strconv.Atoi(mux.Vars(r)["attachmentId"])
+	if err != nil {
+		return 0, 0, 0, badRequest(errors.New("attachmentId must be a number"))
+	}
+	return projectId, taskId, attachmentId, nil
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/gorilla/mux"
+)
+
+type CommentController struct {
+	service domain.ICommentService
+}
+
+func NewCommentController(service domain.ICommentService) *CommentController {
+	return &CommentController{
+		service: service,
+	}
+}
+
+// Handler for calls to /projects/{projectId}/tasks/{taskId}/comments
+
+func (c *CommentController) handleComments(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return c.handleGetComments(w, r)
+	case "POST":
+		return c.handleCreateComment(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks/{taskId}/comments")
+	}
+}
+
+func (c *CommentController) handleGetComments(w http.ResponseWriter, r *http.Request) error {
+	projectId, taskId, err := taskVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	comments, err := c.service.GetComments(projectId, taskId, r.Header.Get("CognitoId"))
+	if err != nil {
+		log.Println("Err fetching task comments: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, comments)
+}
+
+func (c *CommentController) handleCreateComment(w http.ResponseWriter, r *http.Request) error {
+	projectId, taskId, err := taskVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	req := new(domain.CommentRequest)
+	if err := decodeJson(w, r, req); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	req.ProjectId = projectId
+	req.TaskId = taskId
+	req.UserCognitoId = r.Header.Get("CognitoId")
+
+	comment, err := c.service.CreateComment(req)
+	if err != nil {
+		log.Println("Err creating comment: ", err)
+		return WriteError(w, err)
+	}
+	w.Header().Set("Location", fmt.Sprintf("/projects/%d/tasks/%d/comments/%d", projectId, taskId, comment.Id))
+	return WriteJson(w, http.StatusCreated, &comment)
+}
+
+// Handler for calls to /projects/{projectId}/tasks/{taskId}/comments/{commentId}
+
+func (c *CommentController) handleComment(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return c.handleGetComment(w, r)
+	case "PUT":
+		return c.handleUpdateComment(w, r)
+	case "DELETE":
+		return c.handleDeleteComment(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks/{taskId}/comments/{commentId}")
+	}
+}
+
+func (c *CommentController) handleGetComment(w http.ResponseWriter, r *http.Request) error {
+	projectId, taskId, commentId, err := commentVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	comment, err := c.service.GetComment(projectId, taskId, commentId, r.Header.Get("CognitoId"))
+	if err != nil {
+		log.Println("Err fetching comment: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, &comment)
+}
+
+func (c *CommentController) handleUpdateComment(w http.ResponseWriter, r *http.Request) error {
+	projectId, taskId, commentId, err := commentVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	// Only the body can be edited, a parentId is not accepted
+	var body struct {
+		Body string `json:"body"`
+	}
+	if err := decodeJson(w, r, &body); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	req := &domain.CommentRequest{
+		Body:          body.Body,
+		ProjectId:     projectId,
+		TaskId:        taskId,
+		UserCognitoId: r.Header.Get("CognitoId"),
+	}
+
+	comment, err := c.service.UpdateComment(commentId, req)
+	if err != nil {
+		log.Println("Err updating comment: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, &comment)
+}
+
+func (c *CommentController) handleDeleteComment(w http.ResponseWriter, r *http.Request) error {
+	projectId, taskId, commentId, err := commentVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	if err := c.service.DeleteComment(projectId, taskId, commentId, r.Header.Get("CognitoId")); err != nil {
+		log.Println("Err deleting comment: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Comment with id %d deleted successfully", commentId)})
+}
+
+// Handler for calls to /projects/{projectId}/tasks/{taskId}/comments/{commentId}/history,
+// the previous bodies of the comment oldest first
+func (c *CommentController) handleCommentHistory(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks/{taskId}/comments/{commentId}/history")
+	}
+	projectId, taskId, commentId, err := commentVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	edits, err := c.service.GetCommentEdits(projectId, taskId, commentId, r.Header.Get("CognitoId"))
+	if err != nil {
+		log.Println("Err fetching comment history: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, edits)
+}
+
+// Handler for calls to /users/me/mentions, ?since= only returns the mentions made after it
+func (c *CommentController) handleMyMentions(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /users/me/mentions")
+	}
+	since, err := parseQueryTimeParam(r.URL.Query(), "since")
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	mentions, err := c.service.GetMentions(r.Header.Get("CognitoId"), since)
+	if err != nil {
+		log.Println("Err fetching mentions: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, mentions)
+}
+
+func taskVars(r *http.Request) (projectId, taskId int, err error) {
+	projectId, err = strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		return 0, 0, badRequest(err)
+	}
+	taskId, err = strconv.Atoi(mux.Vars(r)["taskId"])
+	if err != nil {
+		return 0, 0, badRequest(errors.New("taskId must be a number"))
+	}
+	return projectId, taskId, nil
+}
+
+func commentVars(r *http.Request) (projectId, taskId, commentId int, err error) {
+	projectId, taskId, err = taskVars(r)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	commentId, err = strconv.Atoi(mux.Vars(r)["commentId"])
+	if err != nil {
+		return 0, 0, 0, badRequest(errors.New("commentId must be a number"))
+	}
+	return projectId, taskId, commentId, nil
+}
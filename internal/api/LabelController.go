@@ -0,0 +1,178 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/gorilla/mux"
+)
+
+type LabelController struct {
+	service domain.ILabelService
+}
+
+func NewLabelController(service domain.ILabelService) *LabelController {
+	return &LabelController{
+		service: service,
+	}
+}
+
+// Handler for calls to /projects/{projectId}/labels
+
+func (c *LabelController) handleLabels(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return c.handleGetLabels(w, r)
+	case "POST":
+		return c.handleCreateLabel(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/labels")
+	}
+}
+
+func (c *LabelController) handleGetLabels(w http.ResponseWriter, r *http.Request) error {
+	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	labels, err := c.service.GetLabels(projectId, r.Header.Get("CognitoId"))
+	if err != nil {
+		log.Println("Err fetching project labels: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, labels)
+}
+
+func (c *LabelController) handleCreateLabel(w http.ResponseWriter, r *http.Request) error {
+	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	req := new(domain.LabelRequest)
+	if err := decodeJson(w, r, req); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	req.ProjectId = projectId
+	req.UserCognitoId = r.Header.Get("CognitoId")
+
+	label, err := c.service.CreateLabel(req)
+	if err != nil {
+		log.Println("Err creating label: ", err)
+		return WriteError(w, err)
+	}
+	w.Header().Set("Location", fmt.Sprintf("/projects/%d/labels/%d", label.ProjectId, label.Id))
+	return WriteJson(w, http.StatusCreated, &label)
+}
+
+// Handler for calls to /projects/{projectId}/labels/{labelId}
+
+func (c *LabelController) handleLabel(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return c.handleGetLabel(w, r)
+	case "PUT":
+		return c.handleUpdateLabel(w, r)
+	case "DELETE":
+		return c.handleDeleteLabel(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/labels/{labelId}")
+	}
+}
+
+func (c *LabelController) handleGetLabel(w http.ResponseWriter, r *http.Request) error {
+	projectId, labelId, err := labelVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	label, err := c.service.GetLabel(projectId, labelId, r.Header.Get("CognitoId"))
+	if err != nil {
+		log.Println("Err fetching label: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, &label)
+}
+
+func (c *LabelController) handleUpdateLabel(w http.ResponseWriter, r *http.Request) error {
+	projectId, labelId, err := labelVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	req := new(domain.LabelRequest)
+	if err := decodeJson(w, r, req); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	req.ProjectId = projectId
+	req.UserCognitoId = r.Header.Get("CognitoId")
+
+	label, err := c.service.UpdateLabel(labelId, req)
+	if err != nil {
+		log.Println("Err updating label: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, &label)
+}
+
+func (c *LabelController) handleDeleteLabel(w http.ResponseWriter, r *http.Request) error {
+	projectId, labelId, err := labelVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	if err := c.service.DeleteLabel(projectId, labelId, r.Header.Get("CognitoId")); err != nil {
+		log.Println("Err deleting label: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Label with id %d deleted successfully", labelId)})
+}
+
+// Handler for calls to /projects/{projectId}/labels/{labelId}/merge, a POST moves the tasks
+// of the label to the label of the body and deletes it
+func (c *LabelController) handleMergeLabel(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/labels/{labelId}/merge")
+	}
+	projectId, labelId, err := labelVars(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	var merge struct {
+		Into int `json:"into"`
+	}
+	if err := decodeJson(w, r, &merge); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	if merge.Into == 0 {
+		return WriteError(w, domain.Invalid("into", "into must be the id of the label to merge into"))
+	}
+
+	label, err := c.service.MergeLabel(projectId, labelId, merge.Into, r.Header.Get("CognitoId"))
+	if err != nil {
+		log.Println("Err merging label: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, &label)
+}
+
+func labelVars(r *http.Request) (projectId, labelId int, err error) {
+	projectId, err = strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		return 0, 0, badRequest(err)
+	}
+	labelId, err = strconv.Atoi(mux.Vars(r)["labelId"])
+	if err != nil {
+		return 0, 0, badRequest(errors.New("labelId must be a number"))
+	}
+	return projectId, labelId, nil
+}
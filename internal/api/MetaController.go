@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Serves the values the clients can send for the enum fields of the requests
+type MetaController struct{}
+
+func NewMetaController() *MetaController {
+	return &MetaController{}
+}
+
+type Enums struct {
+	Priorities  []domain.Priority `json:"priorities"`
+	Statuses    []domain.Status   `json:"statuses"`
+	Roles       []domain.Role     `json:"roles"`
+	TokenScopes []string          `json:"tokenScopes"`
+}
+
+// Handler for calls to /meta/enums
+
+func (c *MetaController) handleEnums(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return WriteJson(w, http.StatusOK, Enums{
+			Priorities:  domain.Priorities,
+			Statuses:    domain.Statuses,
+			Roles:       domain.Roles,
+			TokenScopes: domain.Scopes,
+		})
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /meta/enums")
+	}
+}
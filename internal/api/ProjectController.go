@@ -1,10 +1,10 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/Desgue/ttracker-api/internal/domain"
 	"github.com/gorilla/mux"
@@ -29,33 +29,66 @@ func (c *ProjectController) handleProjects(w http.ResponseWriter, r *http.Reques
 	case "POST":
 		return c.handleCreateProject(w, r)
 	default:
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: "Method not allowed on /projects"})
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects")
 	}
 
 }
 func (c *ProjectController) handleGetProjects(w http.ResponseWriter, r *http.Request) error {
 
 	cognitoId := r.Header.Get("CognitoId")
-	projects, err := c.service.GetProjects(cognitoId)
+	filter, err := parseProjectFilter(r)
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+	page, err := parsePage(r, domain.ProjectSortFields)
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	projects, next, err := c.service.GetProjects(cognitoId, filter, page)
 	if err != nil {
 		log.Println("Err fetching projects: ", err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
 	}
+	setNextLink(w, r, page, next)
 	return WriteJson(w, http.StatusOK, projects)
 }
+
+// Reads the project list filters from the query string: ?priority=,
+// ?createdAfter= and ?createdBefore= which accept a RFC 3339 timestamp or a date
+func parseProjectFilter(r *http.Request) (domain.ProjectFilter, error) {
+	var filter domain.ProjectFilter
+	query := r.URL.Query()
+
+	priority, err := domain.ParsePriority(query.Get("priority"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Priority = priority
+	if filter.CreatedAfter, err = parseQueryTimeParam(query, "createdAfter"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseQueryTimeParam(query, "createdBefore"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
 func (c *ProjectController) handleCreateProject(w http.ResponseWriter, r *http.Request) error {
 
 	createProjectReq := new(domain.CreateProjectRequest)
-	if err := json.NewDecoder(r.Body).Decode(createProjectReq); err != nil {
-		log.Panicln("Error decoding request body, terminating program: ", err)
-		return err
+	if err := decodeJson(w, r, createProjectReq); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
 	}
 	createProjectReq.UserCognitoId = r.Header.Get("CognitoId")
-	if err := c.service.CreateProject(createProjectReq); err != nil {
+	project, err := c.service.CreateProject(createProjectReq)
+	if err != nil {
 		log.Println("Error creating project: ", err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
 	}
-	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: "Project created successfully"})
+	w.Header().Set("Location", fmt.Sprintf("/projects/%d", project.Id))
+	setETag(w, project.Version)
+	return WriteJson(w, http.StatusCreated, &project)
 }
 
 // Handler for calls to /projects/{projectId}
@@ -67,10 +100,12 @@ func (c *ProjectController) handleProject(w http.ResponseWriter, r *http.Request
 	case
 		"PUT":
 		return c.handleUpdateProject(w, r)
+	case "PATCH":
+		return c.handlePatchProject(w, r)
 	case "DELETE":
 		return c.handleDeleteProject(w, r)
 	default:
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: "Method not allowed on /projects/{id}"})
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{id}")
 	}
 }
 
@@ -81,8 +116,12 @@ func (c *ProjectController) handleGetProjectById(w http.ResponseWriter, r *http.
 	project, err := c.service.GetProjectById(projectId, cognitoId)
 	if err != nil {
 		log.Println("Err fetching project: ", err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
 	}
+	if notModified(r, project.Version) {
+		return writeNotModified(w, project.Version)
+	}
+	setETag(w, project.Version)
 	return WriteJson(w, http.StatusOK, &project)
 }
 
@@ -91,28 +130,151 @@ func (c *ProjectController) handleUpdateProject(w http.ResponseWriter, r *http.R
 	cognitoId := r.Header.Get("CognitoId")
 
 	project := new(domain.CreateProjectRequest)
-	if err := json.NewDecoder(r.Body).Decode(project); err != nil {
-		log.Panicln("Error decoding request body ", err)
+	if err := decodeJson(w, r, project); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
 	}
 	project.UserCognitoId = cognitoId
+	version, err := ifMatchVersion(r, c.currentVersion(projectId, cognitoId))
+	if err != nil {
+		return WriteError(w, err)
+	}
+	project.Version = version
 
-	if err := c.service.UpdateProject(projectId, project); err != nil {
+	updated, err := c.service.UpdateProject(projectId, project)
+	if err != nil {
 		log.Println("Err updating project: ", err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
 	}
+	setETag(w, updated.Version)
+	return WriteJson(w, http.StatusOK, &updated)
+}
 
-	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Project with id %s updated successfully", projectId)})
+// Accepts a JSON Merge Patch or a JSON Patch, see decodePatch
+func (c *ProjectController) handlePatchProject(w http.ResponseWriter, r *http.Request) error {
+	projectId := mux.Vars(r)["projectId"]
+	cognitoId := r.Header.Get("CognitoId")
+
+	patch := new(domain.PatchProjectRequest)
+	current := func() (any, error) {
+		return c.service.GetProjectById(projectId, cognitoId)
+	}
+	if err := decodePatch(w, r, current, patch); err != nil {
+		log.Println("Error decoding patch: ", err)
+		return WriteError(w, err)
+	}
+	patch.UserCognitoId = cognitoId
+	version, err := ifMatchVersion(r, c.currentVersion(projectId, cognitoId))
+	if err != nil {
+		return WriteError(w, err)
+	}
+	patch.Version = version
+
+	project, err := c.service.PatchProject(projectId, patch)
+	if err != nil {
+		log.Println("Err patching project: ", err)
+		return WriteError(w, err)
+	}
+	setETag(w, project.Version)
+	return WriteJson(w, http.StatusOK, &project)
 }
 
 func (c *ProjectController) handleDeleteProject(w http.ResponseWriter, r *http.Request) error {
 	projectId := mux.Vars(r)["projectId"]
 	cognitoId := r.Header.Get("CognitoId")
 
-	err := c.service.DeleteProject(projectId, cognitoId)
+	version, err := ifMatchVersion(r, c.currentVersion(projectId, cognitoId))
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	err = c.service.DeleteProject(projectId, cognitoId, version)
 	if err != nil {
 		log.Println("Err deleting project: ", err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
 	}
 
 	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Project with id %s deleted successfully", projectId)})
 }
+
+// Version of the project as the caller sees it, used to evaluate If-Match
+func (c *ProjectController) currentVersion(projectId, cognitoId string) func() (int, error) {
+	return func() (int, error) {
+		project, err := c.service.GetProjectById(projectId, cognitoId)
+		return project.Version, err
+	}
+}
+
+// Handler for calls to /projects/{projectId}/shares
+
+func (c *ProjectController) handleShares(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return c.handleGetShares(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/shares")
+	}
+}
+
+func (c *ProjectController) handleGetShares(w http.ResponseWriter, r *http.Request) error {
+	projectId := mux.Vars(r)["projectId"]
+	cognitoId := r.Header.Get("CognitoId")
+
+	shares, err := c.service.GetShares(projectId, cognitoId)
+	if err != nil {
+		log.Println("Err fetching project shares: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, shares)
+}
+
+// Handler for calls to /projects/{projectId}/shares/{userId}
+
+func (c *ProjectController) handleShare(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "PUT":
+		return c.handleShareProject(w, r)
+	case "DELETE":
+		return c.handleRemoveShare(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/shares/{userId}")
+	}
+}
+
+func (c *ProjectController) handleShareProject(w http.ResponseWriter, r *http.Request) error {
+	projectId := mux.Vars(r)["projectId"]
+	userId, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	share := new(domain.ShareProjectRequest)
+	if err := decodeJson(w, r, share); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	share.ProjectId = projectId
+	share.UserId = userId
+	share.UserCognitoId = r.Header.Get("CognitoId")
+
+	if err := c.service.ShareProject(share); err != nil {
+		log.Println("Err sharing project: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Project with id %s shared with user %d as %s", projectId, userId, share.Role)})
+}
+
+func (c *ProjectController) handleRemoveShare(w http.ResponseWriter, r *http.Request) error {
+	projectId := mux.Vars(r)["projectId"]
+	userId, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+	cognitoId := r.Header.Get("CognitoId")
+
+	if err := c.service.RemoveShare(projectId, userId, cognitoId); err != nil {
+		log.Println("Err removing project share: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Project with id %s is no longer shared with user %d", projectId, userId)})
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type SearchController struct {
+	service domain.ISearchService
+}
+
+func NewSearchController(service domain.ISearchService) *SearchController {
+	return &SearchController{
+		service: service,
+	}
+}
+
+// Handler for calls to /search
+
+func (c *SearchController) handleSearch(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /search")
+	}
+	cognitoId := r.Header.Get("CognitoId")
+	query := domain.SearchQuery{Text: r.URL.Query().Get("q")}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > domain.MaxPageLimit {
+			return WriteError(w, badRequest(fmt.Errorf("limit must be a number between 1 and %d", domain.MaxPageLimit)))
+		}
+		query.Limit = limit
+	}
+
+	results, err := c.service.Search(cognitoId, query)
+	if err != nil {
+		log.Println("Err searching: ", err)
+		return WriteError(w, err)
+	}
+	for i := range results {
+		results[i].Snippet = highlight(results[i].Snippet)
+	}
+	return WriteJson(w, http.StatusOK, results)
+}
+
+// Escapes the snippet so it can be inserted in a page as HTML, the matched words are wrapped in <mark>
+func highlight(snippet string) string {
+	return strings.NewReplacer(domain.SnippetStart, "<mark>", domain.SnippetStop, "</mark>").Replace(html.EscapeString(snippet))
+}
@@ -1,11 +1,14 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Desgue/ttracker-api/internal/domain"
 	"github.com/gorilla/mux"
@@ -30,7 +33,7 @@ func (s *TaskController) handleTasks(w http.ResponseWriter, r *http.Request) err
 	case "POST":
 		return s.handleCreateTask(w, r)
 	default:
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: "Method not allowed on /projects/{projectId}/tasks"})
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks")
 	}
 
 }
@@ -41,33 +44,85 @@ func (s *TaskController) handleGetTasks(w http.ResponseWriter, r *http.Request)
 	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
 	if err != nil {
 		log.Println(err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, badRequest(err))
 	}
+	cognitoId := r.Header.Get("CognitoId")
 
-	tasks, err := s.service.GetTasks(projectId)
+	filter, err := parseTaskFilter(r, cognitoId)
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+	page, err := parsePage(r, domain.TaskSortFields)
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	// ?tree=true nests the subtasks under the top level tasks of the page
+	list := s.service.GetTasks
+	if v := r.URL.Query().Get("tree"); v != "" {
+		tree, err := strconv.ParseBool(v)
+		if err != nil {
+			return WriteError(w, badRequest(fmt.Errorf("invalid tree: %w", err)))
+		}
+		if tree {
+			list = s.service.GetTaskTree
+		}
+	}
+
+	tasks, next, err := list(projectId, cognitoId, filter, page)
 	if err != nil {
 		log.Println(err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
 	}
 
+	setNextLink(w, r, page, next)
 	return WriteJson(w, http.StatusOK, tasks)
 }
 
 func (s *TaskController) handleCreateTask(w http.ResponseWriter, r *http.Request) error {
 	log.Println("POST resquest at http://localhost:8000/projects/{projectId}/tasks")
 
+	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, badRequest(err))
+	}
+
 	createTaskReq := new(domain.CreateTaskRequest)
-	if err := json.NewDecoder(r.Body).Decode(createTaskReq); err != nil {
-		log.Panicln(err)
-		return err
+	if err := decodeJson(w, r, createTaskReq); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
 	}
-	_, err := s.service.CreateTask(createTaskReq)
+	createTaskReq.ProjectId = projectId
+	createTaskReq.UserCognitoId = r.Header.Get("CognitoId")
+
+	task, err := s.service.CreateTask(createTaskReq)
 	if err != nil {
 		log.Println("Error from database while creating task: ", err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
+	}
+	w.Header().Set("Location", fmt.Sprintf("/projects/%d/tasks/%d", task.ProjectId, task.Id))
+	setETag(w, task.Version)
+	return WriteJson(w, http.StatusCreated, &task)
+}
+
+// Handler for calls to /users/me/tasks
+func (s *TaskController) handleMyTasks(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /users/me/tasks")
+	}
+	cognitoId := r.Header.Get("CognitoId")
+	filter, err := parseTaskFilter(r, cognitoId)
+	if err != nil {
+		return WriteError(w, badRequest(err))
 	}
 
-	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: "Task created successfully"})
+	tasks, err := s.service.GetAssignedTasks(cognitoId, filter)
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, tasks)
 }
 
 // Handler for calls to /projects/{projectId}/tasks/{taskId}
@@ -77,47 +132,319 @@ func (s *TaskController) handleTask(w http.ResponseWriter, r *http.Request) erro
 		return s.handleGetTaskById(w, r)
 	case "PUT":
 		return s.handleUpdateTask(w, r)
+	case "PATCH":
+		return s.handlePatchTask(w, r)
 	case "DELETE":
 		return s.handleDeleteTask(w, r)
 	default:
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: "Method not allowed on /tasks/{id}"})
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks/{taskId}")
 	}
 
 }
 
 func (s *TaskController) handleGetTaskById(w http.ResponseWriter, r *http.Request) error {
-	id := mux.Vars(r)["taskId"]
+	id, err := taskIdVar(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
 	log.Printf("GET http://localhost:8000/projects/{projectId}/tasks/%s", id)
-	task, err := s.service.GetTaskById(id)
+
+	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
 	if err != nil {
 		log.Println(err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, badRequest(err))
 	}
+	cognitoId := r.Header.Get("CognitoId")
+
+	task, err := s.service.GetTaskById(projectId, id, cognitoId)
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, err)
+	}
+	if notModified(r, task.Version) {
+		return writeNotModified(w, task.Version)
+	}
+	setETag(w, task.Version)
 	return WriteJson(w, http.StatusOK, &task)
 }
 
 func (s *TaskController) handleUpdateTask(w http.ResponseWriter, r *http.Request) error {
-	id := mux.Vars(r)["taskId"]
+	id, err := taskIdVar(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
 	log.Printf("PUT http://localhost:8000/projects/{projectId}/tasks/%s", id)
+
+	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, badRequest(err))
+	}
+
 	task := new(domain.CreateTaskRequest)
-	if err := json.NewDecoder(r.Body).Decode(task); err != nil {
-		log.Panicln(err)
+	if err := decodeJson(w, r, task); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	task.ProjectId = projectId
+	task.UserCognitoId = r.Header.Get("CognitoId")
+	version, err := ifMatchVersion(r, s.currentVersion(projectId, id, task.UserCognitoId))
+	if err != nil {
+		return WriteError(w, err)
+	}
+	task.Version = version
+
+	updated, err := s.service.UpdateTask(id, task)
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, err)
+	}
+	setETag(w, updated.Version)
+	return WriteJson(w, http.StatusOK, &updated)
+}
+
+// Accepts a JSON Merge Patch or a JSON Patch, see decodePatch
+func (s *TaskController) handlePatchTask(w http.ResponseWriter, r *http.Request) error {
+	id, err := taskIdVar(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+	log.Printf("PATCH http://localhost:8000/projects/{projectId}/tasks/%s", id)
+
+	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, badRequest(err))
+	}
+	cognitoId := r.Header.Get("CognitoId")
+
+	patch := new(domain.PatchTaskRequest)
+	current := func() (any, error) {
+		return s.service.GetTaskById(projectId, id, cognitoId)
+	}
+	if err := decodePatch(w, r, current, patch); err != nil {
+		log.Println("Error decoding patch: ", err)
+		return WriteError(w, err)
 	}
-	if err := s.service.UpdateTask(id, task); err != nil {
+	patch.ProjectId = projectId
+	patch.UserCognitoId = cognitoId
+	version, err := ifMatchVersion(r, s.currentVersion(projectId, id, cognitoId))
+	if err != nil {
+		return WriteError(w, err)
+	}
+	patch.Version = version
+
+	task, err := s.service.PatchTask(id, patch)
+	if err != nil {
 		log.Println(err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
 	}
-	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Task with id %s updated successfully", id)})
+	setETag(w, task.Version)
+	return WriteJson(w, http.StatusOK, &task)
 }
 func (s *TaskController) handleDeleteTask(w http.ResponseWriter, r *http.Request) error {
-	id := mux.Vars(r)["taskId"]
+	id, err := taskIdVar(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
 	log.Printf("DELETE request at http://localhost:8000/projects/{projectId}/tasks/%s", id)
 
-	err := s.service.DeleteTask(id)
+	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
 	if err != nil {
 		log.Println(err)
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, badRequest(err))
+	}
+	cognitoId := r.Header.Get("CognitoId")
+
+	version, err := ifMatchVersion(r, s.currentVersion(projectId, id, cognitoId))
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	err = s.service.DeleteTask(projectId, id, cognitoId, version)
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, err)
 	}
 
 	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Task with id %s deleted successfully", id)})
 }
+
+// Handler for calls to /projects/{projectId}/tasks/{taskId}/children
+func (s *TaskController) handleSubtasks(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks/{taskId}/children")
+	}
+	id, err := taskIdVar(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+	log.Printf("GET http://localhost:8000/projects/{projectId}/tasks/%s/children", id)
+
+	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, badRequest(err))
+	}
+	page, err := parsePage(r, domain.TaskSortFields)
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	tasks, next, err := s.service.GetSubtasks(projectId, id, r.Header.Get("CognitoId"), page)
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, err)
+	}
+	setNextLink(w, r, page, next)
+	return WriteJson(w, http.StatusOK, tasks)
+}
+
+// Handler for calls to /projects/{projectId}/tasks/{taskId}/parent, a PUT moves the task
+// under the task of the body, or to the top level when parentId is null
+func (s *TaskController) handleTaskParent(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "PUT" {
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /projects/{projectId}/tasks/{taskId}/parent")
+	}
+	id, err := taskIdVar(r)
+	if err != nil {
+		return WriteError(w, err)
+	}
+	log.Printf("PUT http://localhost:8000/projects/{projectId}/tasks/%s/parent", id)
+
+	projectId, err := strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, badRequest(err))
+	}
+	cognitoId := r.Header.Get("CognitoId")
+
+	var move struct {
+		ParentId domain.Optional[*int] `json:"parentId"`
+	}
+	if err := decodeJson(w, r, &move); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	if !move.ParentId.Set {
+		return WriteError(w, domain.Invalid("parentId", "parentId is required, null moves the task to the top level"))
+	}
+	version, err := ifMatchVersion(r, s.currentVersion(projectId, id, cognitoId))
+	if err != nil {
+		return WriteError(w, err)
+	}
+
+	task, err := s.service.PatchTask(id, &domain.PatchTaskRequest{
+		ParentId:      move.ParentId,
+		ProjectId:     projectId,
+		UserCognitoId: cognitoId,
+		Version:       version,
+	})
+	if err != nil {
+		log.Println(err)
+		return WriteError(w, err)
+	}
+	setETag(w, task.Version)
+	return WriteJson(w, http.StatusOK, &task)
+}
+
+// The task id is passed down as a string, a malformed one is rejected here so every backend
+// answers it the same way
+func taskIdVar(r *http.Request) (string, error) {
+	id := mux.Vars(r)["taskId"]
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", badRequest(errors.New("taskId must be a number"))
+	}
+	return id, nil
+}
+
+// Version of the task as the caller sees it, used to evaluate If-Match
+func (s *TaskController) currentVersion(projectId int, taskId, cognitoId string) func() (int, error) {
+	return func() (int, error) {
+		task, err := s.service.GetTaskById(projectId, taskId, cognitoId)
+		return task.Version, err
+	}
+}
+
+// Reads the task list filters from the query string:
+// ?assignee= accepts a user id or "me" for the caller,
+// ?status=, ?createdAfter=, ?createdBefore=, ?dueBefore= and ?dueAfter= which accept
+// a RFC 3339 timestamp or a date, ?overdue= a boolean and ?labels= a comma separated list
+// of label ids matched as ?labelMatch= says, any of them by default or all of them
+func parseTaskFilter(r *http.Request, cognitoId string) (domain.TaskFilter, error) {
+	var filter domain.TaskFilter
+	query := r.URL.Query()
+
+	switch assignee := query.Get("assignee"); assignee {
+	case "":
+	case "me":
+		filter.AssigneeCognitoId = cognitoId
+	default:
+		id, err := strconv.Atoi(assignee)
+		if err != nil {
+			return filter, errors.New("assignee must be a user id or me")
+		}
+		filter.AssigneeId = id
+	}
+	status, err := domain.ParseStatus(query.Get("status"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Status = status
+	if filter.CreatedAfter, err = parseQueryTimeParam(query, "createdAfter"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseQueryTimeParam(query, "createdBefore"); err != nil {
+		return filter, err
+	}
+	if filter.DueBefore, err = parseQueryTimeParam(query, "dueBefore"); err != nil {
+		return filter, err
+	}
+	if filter.DueAfter, err = parseQueryTimeParam(query, "dueAfter"); err != nil {
+		return filter, err
+	}
+	if v := query.Get("overdue"); v != "" {
+		overdue, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid overdue: %w", err)
+		}
+		filter.Overdue = &overdue
+	}
+	if v := query.Get("labels"); v != "" {
+		for _, label := range strings.Split(v, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(label))
+			if err != nil {
+				return filter, errors.New("labels must be a comma separated list of label ids")
+			}
+			filter.Labels = append(filter.Labels, id)
+		}
+	}
+	switch query.Get("labelMatch") {
+	case "", "any":
+	case "all":
+		filter.AllLabels = true
+	default:
+		return filter, errors.New("labelMatch must be any or all")
+	}
+	return filter, nil
+}
+
+// Reads an optional time parameter, nil when it is not set
+func parseQueryTimeParam(query url.Values, name string) (*time.Time, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := parseQueryTime(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return &t, nil
+}
+
+func parseQueryTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
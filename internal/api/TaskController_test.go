@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	repo "github.com/Desgue/ttracker-api/internal/repository"
+	svc "github.com/Desgue/ttracker-api/internal/services"
+	"github.com/gorilla/mux"
+)
+
+// Router serving the project and task routes from memory, alice owns the project it returns
+func newTestRouter(t *testing.T) (*mux.Router, int) {
+	t.Helper()
+	kv := repo.NewKvRepository()
+	if err := repo.NewKVUserStore(kv).CreateUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	projects := svc.NewProjectService(repo.NewKVProjectStore(kv), repo.NewKVTeamStore(kv))
+	project, err := projects.CreateProject(&domain.CreateProjectRequest{Title: "project", UserCognitoId: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectController := NewProjectController(projects)
+	taskController := NewTaskController(svc.NewTaskService(repo.NewKVTaskStore(kv)))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/projects", makeHttpHandler(projectController.handleProjects))
+	router.HandleFunc("/projects/{projectId}/tasks", makeHttpHandler(taskController.handleTasks))
+	return router, project.Id
+}
+
+func postAsAlice(t *testing.T, h http.Handler, path, body string) (int, Problem) {
+	t.Helper()
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("CognitoId", "alice")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var problem Problem
+	if w.Code >= 400 {
+		if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("invalid problem %s: %v", w.Body, err)
+		}
+	}
+	return w.Code, problem
+}
+
+func TestCreateReportsEveryInvalidField(t *testing.T) {
+	router, projectId := newTestRouter(t)
+	cases := []struct {
+		name   string
+		path   string
+		body   string
+		fields []domain.FieldError
+	}{
+		{"Task", fmt.Sprintf("/projects/%d/tasks", projectId),
+			`{"title":"","startDate":"2024-02-02T00:00:00Z","dueDate":"2024-02-01T00:00:00Z"}`,
+			[]domain.FieldError{
+				{Field: "title", Message: "title is required"},
+				{Field: "startDate", Message: "startDate must not be after dueDate"},
+			}},
+		{"TaskTitleTooLong", fmt.Sprintf("/projects/%d/tasks", projectId),
+			`{"title":"` + strings.Repeat("a", 256) + `","startDate":"2024-02-02T00:00:00Z","dueDate":"2024-02-01T00:00:00Z"}`,
+			[]domain.FieldError{
+				{Field: "title", Message: "title must not be longer than 255 characters"},
+				{Field: "startDate", Message: "startDate must not be after dueDate"},
+			}},
+		{"Project", "/projects", `{"title":"","description":"d"}`,
+			[]domain.FieldError{
+				{Field: "title", Message: "title is required"},
+			}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status, problem := postAsAlice(t, router, c.path, c.body)
+			if status != http.StatusUnprocessableEntity || problem.Status != status {
+				t.Fatalf("got status %d with %+v, want 422", status, problem)
+			}
+			if !reflect.DeepEqual(problem.Errors, c.fields) {
+				t.Fatalf("got field errors %+v, want %+v", problem.Errors, c.fields)
+			}
+			msgs := make([]string, len(c.fields))
+			for i, f := range c.fields {
+				msgs[i] = f.Field + ": " + f.Message
+			}
+			if problem.Detail != strings.Join(msgs, ", ") {
+				t.Fatalf("got detail %q", problem.Detail)
+			}
+		})
+	}
+
+	// A valid request goes through once the fields are fixed
+	status, problem := postAsAlice(t, router, fmt.Sprintf("/projects/%d/tasks", projectId), `{"title":"t","startDate":"2024-02-01T00:00:00Z","dueDate":"2024-02-02T00:00:00Z"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d with %+v for a valid task", status, problem)
+	}
+}
+
+// The project and the owner of a task come from the path and the authenticated user only
+func TestCreateTaskRejectsIdentityFields(t *testing.T) {
+	router, projectId := newTestRouter(t)
+	path := fmt.Sprintf("/projects/%d/tasks", projectId)
+	for _, field := range []string{"projectId", "userCognitoId"} {
+		t.Run(field, func(t *testing.T) {
+			status, problem := postAsAlice(t, router, path, fmt.Sprintf(`{"title":"t",%q:"1"}`, field))
+			if status != http.StatusBadRequest || len(problem.Errors) != 1 || problem.Errors[0].Field != field {
+				t.Fatalf("got status %d with %+v, want 400 on %s", status, problem, field)
+			}
+		})
+	}
+}
@@ -1,12 +1,13 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/gorilla/mux"
 )
 
 type TeamController struct {
@@ -22,24 +23,38 @@ func NewTeamController(service domain.ITeamService) *TeamController {
 // Handler for calls to /teams
 func (c *TeamController) handleTeams(w http.ResponseWriter, r *http.Request) error {
 	switch r.Method {
+	case "GET":
+		return c.handleGetTeams(w, r)
 	case "POST":
 		return c.handleCreateTeam(w, r)
 	default:
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: "Method not allowed on /teams"})
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /teams")
 	}
 
 }
 
+func (c *TeamController) handleGetTeams(w http.ResponseWriter, r *http.Request) error {
+	cognitoId := r.Header.Get("CognitoId")
+	teams, err := c.service.GetTeams(cognitoId)
+	if err != nil {
+		log.Println("Err fetching teams: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, teams)
+}
+
 func (c *TeamController) handleCreateTeam(w http.ResponseWriter, r *http.Request) error {
 
 	team := new(domain.CreateTeamRequest)
 
-	if err := json.NewDecoder(r.Body).Decode(team); err != nil {
-		return err
+	if err := decodeJson(w, r, team); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
 	}
+	team.UserCognitoId = r.Header.Get("CognitoId")
 
 	if err := c.service.CreateTeam(team); err != nil {
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
 	}
 	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Team %s created successfully", team.Name)})
 }
@@ -50,19 +65,179 @@ func (c *TeamController) handleTeam(w http.ResponseWriter, r *http.Request) erro
 	case "GET":
 		return c.handleGetTeam(w, r)
 	default:
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: "Method not allowed on /teams/{teamId}"})
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /teams/{teamId}")
 	}
 }
 
 func (c *TeamController) handleGetTeam(w http.ResponseWriter, r *http.Request) error {
-	teamId, err := strconv.Atoi(r.URL.Query().Get("teamId"))
+	teamId, err := strconv.Atoi(mux.Vars(r)["teamId"])
 	if err != nil {
-		return WriteJson(w, http.StatusInternalServerError, ApiLog{Err: err.Error(), StatusCode: http.StatusInternalServerError})
+		return WriteError(w, badRequest(err))
 	}
+	cognitoId := r.Header.Get("CognitoId")
 
-	team, err := c.service.GetTeam(teamId)
+	team, err := c.service.GetTeam(teamId, cognitoId)
 	if err != nil {
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		return WriteError(w, err)
 	}
 	return WriteJson(w, http.StatusOK, team)
 }
+
+// Handler for calls to /teams/{teamId}/members
+func (c *TeamController) handleMembers(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return c.handleGetMembers(w, r)
+	case "POST":
+		return c.handleAddMember(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /teams/{teamId}/members")
+	}
+}
+
+func (c *TeamController) handleGetMembers(w http.ResponseWriter, r *http.Request) error {
+	teamId, err := strconv.Atoi(mux.Vars(r)["teamId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+	cognitoId := r.Header.Get("CognitoId")
+
+	members, err := c.service.GetMembers(teamId, cognitoId)
+	if err != nil {
+		log.Println("Err fetching team members: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, members)
+}
+
+func (c *TeamController) handleAddMember(w http.ResponseWriter, r *http.Request) error {
+	teamId, err := strconv.Atoi(mux.Vars(r)["teamId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	member := new(domain.TeamMemberRequest)
+	if err := decodeJson(w, r, member); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	member.TeamId = teamId
+	member.UserCognitoId = r.Header.Get("CognitoId")
+
+	if err := c.service.AddMember(member); err != nil {
+		log.Println("Err adding team member: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("User with id %d added to the team", member.UserId)})
+}
+
+// Handler for calls to /teams/{teamId}/members/{userId}
+func (c *TeamController) handleMember(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "PUT":
+		return c.handleUpdateMemberRole(w, r)
+	case "DELETE":
+		return c.handleRemoveMember(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /teams/{teamId}/members/{userId}")
+	}
+}
+
+func (c *TeamController) handleUpdateMemberRole(w http.ResponseWriter, r *http.Request) error {
+	teamId, err := strconv.Atoi(mux.Vars(r)["teamId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+	userId, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	member := new(domain.TeamMemberRequest)
+	if err := decodeJson(w, r, member); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	member.TeamId = teamId
+	member.UserId = userId
+	member.UserCognitoId = r.Header.Get("CognitoId")
+
+	if err := c.service.UpdateMemberRole(member); err != nil {
+		log.Println("Err updating team member: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("Role of user with id %d updated to %s", userId, member.Role)})
+}
+
+func (c *TeamController) handleRemoveMember(w http.ResponseWriter, r *http.Request) error {
+	teamId, err := strconv.Atoi(mux.Vars(r)["teamId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+	userId, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+	cognitoId := r.Header.Get("CognitoId")
+
+	if err := c.service.RemoveMember(teamId, userId, cognitoId); err != nil {
+		log.Println("Err removing team member: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, ApiLog{StatusCode: http.StatusOK, Msg: fmt.Sprintf("User with id %d removed from the team", userId)})
+}
+
+// Handler for calls to /teams/{teamId}/invitations
+func (c *TeamController) handleInvitations(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "POST":
+		return c.handleCreateInvitation(w, r)
+	default:
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /teams/{teamId}/invitations")
+	}
+}
+
+func (c *TeamController) handleCreateInvitation(w http.ResponseWriter, r *http.Request) error {
+	teamId, err := strconv.Atoi(mux.Vars(r)["teamId"])
+	if err != nil {
+		return WriteError(w, badRequest(err))
+	}
+
+	invitation := new(domain.CreateTeamInvitationRequest)
+	if err := decodeJson(w, r, invitation); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	invitation.TeamId = teamId
+	invitation.UserCognitoId = r.Header.Get("CognitoId")
+
+	created, err := c.service.CreateInvitation(invitation)
+	if err != nil {
+		log.Println("Err creating team invitation: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusCreated, created)
+}
+
+// Handler for calls to /invitations/accept
+func (c *TeamController) handleAcceptInvitation(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /invitations/accept")
+	}
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJson(w, r, &body); err != nil {
+		log.Println("Error decoding request body: ", err)
+		return WriteError(w, err)
+	}
+	cognitoId := r.Header.Get("CognitoId")
+
+	member, err := c.service.AcceptInvitation(body.Token, cognitoId)
+	if err != nil {
+		log.Println("Err accepting team invitation: ", err)
+		return WriteError(w, err)
+	}
+	return WriteJson(w, http.StatusOK, member)
+}
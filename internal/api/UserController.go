@@ -23,7 +23,7 @@ func (c *UserController) handleUsers(w http.ResponseWriter, r *http.Request) err
 	case "POST":
 		return c.handleCreateUser(w, r)
 	default:
-		return WriteJson(w, http.StatusBadRequest, ApiLog{Err: "Method not allowed on /users"})
+		return WriteProblem(w, http.StatusMethodNotAllowed, "Method not allowed on /users")
 	}
 
 }
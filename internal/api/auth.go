@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/util"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Authenticator verifies the bearer token sent by the client and returns the id of the user
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (string, error)
+}
+
+// JWTAuthenticator verifies signed JWTs, either with keys from a JWKS endpoint or with a single static key
+type JWTAuthenticator struct {
+	// Keys is nil when the authenticator uses a static key
+	Keys *JWKSCache
+
+	staticAlg jwa.SignatureAlgorithm
+	staticKey interface{}
+
+	issuer   string
+	audience string
+	claims   map[string]interface{}
+}
+
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (string, error) {
+	tokenByte := []byte(token)
+	opts := []jwt.ParseOption{jwt.WithValidate(true), jwt.WithRequiredClaim(jwt.ExpirationKey)}
+
+	if a.Keys != nil {
+		kid, err := getKeyId(tokenByte)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrInvalidToken, err)
+		}
+		set, err := a.Keys.KeySet(ctx, kid)
+		if errors.Is(err, ErrKeySetStale) {
+			return "", err
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrInvalidToken, err)
+		}
+		opts = append(opts, jwt.WithKeySet(set))
+	} else {
+		opts = append(opts, jwt.WithVerify(a.staticAlg, a.staticKey))
+	}
+
+	if a.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		opts = append(opts, jwt.WithAudience(a.audience))
+	}
+	for name, value := range a.claims {
+		opts = append(opts, jwt.WithClaimValue(name, value))
+	}
+
+	parsed, err := jwt.Parse(tokenByte, opts...)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	if parsed.Subject() == "" {
+		return "", fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	return parsed.Subject(), nil
+}
+
+// Cognito access tokens, verified against the user pool keys
+func NewCognitoAuthenticator(ctx context.Context, jwkUrl, issuer string, opts JWKSCacheOptions) *JWTAuthenticator {
+	keys := NewJWKSCache(jwkUrl, opts)
+	keys.Start(ctx)
+	return &JWTAuthenticator{
+		Keys:   keys,
+		issuer: issuer,
+		claims: map[string]interface{}{"token_use": "access"},
+	}
+}
+
+// Any OpenID Connect provider, the keys url is read from the discovery document of the issuer
+func NewOIDCAuthenticator(ctx context.Context, issuer, audience string, opts JWKSCacheOptions) (*JWTAuthenticator, error) {
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	discoveryUrl := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching discovery document: %s", res.Status)
+	}
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JwksUri string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&discovery); err != nil {
+		return nil, err
+	}
+	if discovery.JwksUri == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+	if discovery.Issuer != "" && discovery.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", discovery.Issuer, issuer)
+	}
+
+	keys := NewJWKSCache(discovery.JwksUri, opts)
+	keys.Start(ctx)
+	return &JWTAuthenticator{
+		Keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+	}, nil
+}
+
+// Tokens signed with a shared HMAC secret (HS256), meant for local development and tests
+func NewHMACAuthenticator(secret []byte, issuer string) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		staticAlg: jwa.HS256,
+		staticKey: secret,
+		issuer:    issuer,
+	}
+}
+
+// Tokens signed with a single RSA key (RS256), meant for local development and tests
+func NewRSAAuthenticator(key *rsa.PublicKey, issuer string) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		staticAlg: jwa.RS256,
+		staticKey: key,
+		issuer:    issuer,
+	}
+}
+
+// Builds the authenticator selected by the AUTH_PROVIDER environment variable
+func NewAuthenticator(ctx context.Context) (Authenticator, error) {
+	keyOpts := JWKSCacheOptions{MaxStaleness: util.Jwks_max_staleness}
+
+	switch util.Auth_provider {
+	case "cognito":
+		return NewCognitoAuthenticator(ctx, util.Cognito_jwk_url, util.Cognito_issuer, keyOpts), nil
+	case "oidc":
+		if util.Oidc_issuer == "" {
+			return nil, errors.New("OIDC_ISSUER is required by the oidc auth provider")
+		}
+		return NewOIDCAuthenticator(ctx, util.Oidc_issuer, util.Oidc_audience, keyOpts)
+	case "static":
+		if util.IsProd {
+			return nil, errors.New("the static auth provider can not be used in production")
+		}
+		if util.Auth_static_secret != "" {
+			return NewHMACAuthenticator([]byte(util.Auth_static_secret), util.Auth_static_issuer), nil
+		}
+		if util.Auth_static_public_key != "" {
+			pem, err := os.ReadFile(util.Auth_static_public_key)
+			if err != nil {
+				return nil, err
+			}
+			key, err := jwk.ParseKey(pem, jwk.WithPEM(true))
+			if err != nil {
+				return nil, err
+			}
+			var raw rsa.PublicKey
+			if err := key.Raw(&raw); err != nil {
+				return nil, err
+			}
+			return NewRSAAuthenticator(&raw, util.Auth_static_issuer), nil
+		}
+		return nil, errors.New("AUTH_STATIC_SECRET or AUTH_STATIC_PUBLIC_KEY is required by the static auth provider")
+	default:
+		return nil, fmt.Errorf("unknown auth provider %q", util.Auth_provider)
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/util"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+const testIssuer = "https://issuer.example.com"
+
+// Builds a token for alice valid for an hour, changed by the claims given as name and value pairs
+func newToken(t *testing.T, claims ...any) jwt.Token {
+	t.Helper()
+	token := jwt.New()
+	token.Set(jwt.SubjectKey, "alice")
+	token.Set(jwt.IssuerKey, testIssuer)
+	token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour))
+	for i := 0; i+1 < len(claims); i += 2 {
+		if err := token.Set(claims[i].(string), claims[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return token
+}
+
+func sign(t *testing.T, token jwt.Token, alg jwa.SignatureAlgorithm, key any) string {
+	t.Helper()
+	signed, err := jwt.Sign(token, alg, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func mustAuthenticate(t *testing.T, auth Authenticator, token string) {
+	t.Helper()
+	id, err := auth.Authenticate(context.Background(), token)
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if id != "alice" {
+		t.Fatalf("got user %q, want alice", id)
+	}
+}
+
+func mustReject(t *testing.T, auth Authenticator, token string) {
+	t.Helper()
+	if _, err := auth.Authenticate(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	secret := []byte("a secret of enough length for HS256")
+	auth := NewHMACAuthenticator(secret, testIssuer)
+
+	mustAuthenticate(t, auth, sign(t, newToken(t), jwa.HS256, secret))
+	mustReject(t, auth, sign(t, newToken(t), jwa.HS256, []byte("another secret of enough length")))
+	mustReject(t, auth, sign(t, newToken(t, jwt.IssuerKey, "https://other.example.com"), jwa.HS256, secret))
+	mustReject(t, auth, sign(t, newToken(t, jwt.ExpirationKey, time.Now().Add(-time.Hour)), jwa.HS256, secret))
+	mustReject(t, auth, sign(t, newToken(t, jwt.SubjectKey, ""), jwa.HS256, secret))
+	mustReject(t, auth, "not a token")
+
+	// The expiration is required
+	token := newToken(t)
+	token.Remove(jwt.ExpirationKey)
+	mustReject(t, auth, sign(t, token, jwa.HS256, secret))
+
+	// Any issuer is accepted when none is configured
+	mustAuthenticate(t, NewHMACAuthenticator(secret, ""), sign(t, newToken(t, jwt.IssuerKey, "https://other.example.com"), jwa.HS256, secret))
+}
+
+func TestRSAAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewRSAAuthenticator(&key.PublicKey, testIssuer)
+
+	mustAuthenticate(t, auth, sign(t, newToken(t), jwa.RS256, key))
+	mustReject(t, auth, sign(t, newToken(t), jwa.RS256, other))
+	mustReject(t, auth, sign(t, newToken(t, jwt.IssuerKey, "https://other.example.com"), jwa.RS256, key))
+	// A token signed with another algorithm is not verified with the key
+	mustReject(t, auth, sign(t, newToken(t), jwa.HS256, []byte("a secret of enough length for HS256")))
+}
+
+func TestCognitoAuthenticator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	server := newKeyServer(t)
+	key := server.addKey(t, "a")
+	auth := NewCognitoAuthenticator(ctx, server.URL, testIssuer, JWKSCacheOptions{})
+
+	// Only access tokens are accepted, not the id tokens of the same user pool
+	mustAuthenticate(t, auth, sign(t, newToken(t, "token_use", "access"), jwa.RS256, key))
+	mustReject(t, auth, sign(t, newToken(t, "token_use", "id"), jwa.RS256, key))
+	mustReject(t, auth, sign(t, newToken(t), jwa.RS256, key))
+	mustReject(t, auth, sign(t, newToken(t, "token_use", "access", jwt.IssuerKey, "https://other.example.com"), jwa.RS256, key))
+	// Signed by a key that is not in the set under the same kid
+	mustReject(t, auth, sign(t, newToken(t, "token_use", "access"), jwa.RS256, newRSAKey(t, "a")))
+	mustReject(t, auth, sign(t, newToken(t, "token_use", "access"), jwa.RS256, newRSAKey(t, "unknown")))
+}
+
+// Serves the discovery document of an OpenID Connect provider, doc is called with the url of the server
+func newDiscoveryServer(t *testing.T, doc func(url string) map[string]any) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(doc(server.URL))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	keys := newKeyServer(t)
+	key := keys.addKey(t, "a")
+	provider := newDiscoveryServer(t, func(url string) map[string]any {
+		return map[string]any{"issuer": url, "jwks_uri": keys.URL}
+	})
+
+	auth, err := NewOIDCAuthenticator(ctx, provider.URL, "tasker", JWKSCacheOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := func(claims ...any) jwt.Token {
+		return newToken(t, append([]any{jwt.IssuerKey, provider.URL, jwt.AudienceKey, []string{"tasker"}}, claims...)...)
+	}
+	mustAuthenticate(t, auth, sign(t, valid(), jwa.RS256, key))
+	mustReject(t, auth, sign(t, valid(jwt.AudienceKey, []string{"another client"}), jwa.RS256, key))
+	mustReject(t, auth, sign(t, valid(jwt.IssuerKey, testIssuer), jwa.RS256, key))
+	mustReject(t, auth, sign(t, valid(), jwa.RS256, newRSAKey(t, "a")))
+
+	// The issuer must match the one of the discovery document exactly, a trailing slash included
+	if _, err := NewOIDCAuthenticator(ctx, provider.URL+"/", "", JWKSCacheOptions{}); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("got error %v for an issuer other than the one of the discovery document", err)
+	}
+}
+
+func TestOIDCDiscoveryErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cases := []struct {
+		name string
+		doc  func(url string) map[string]any
+		want string
+	}{
+		{"WrongIssuer", func(url string) map[string]any {
+			return map[string]any{"issuer": "https://other.example.com", "jwks_uri": url + "/jwks"}
+		}, "does not match"},
+		{"MissingJwksUri", func(url string) map[string]any {
+			return map[string]any{"issuer": url}
+		}, "no jwks_uri"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			provider := newDiscoveryServer(t, c.doc)
+			_, err := NewOIDCAuthenticator(ctx, provider.URL, "", JWKSCacheOptions{})
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("got error %v, want one containing %q", err, c.want)
+			}
+		})
+	}
+
+	// The provider does not serve a discovery document
+	provider := newDiscoveryServer(t, nil)
+	if _, err := NewOIDCAuthenticator(ctx, provider.URL+"/missing", "", JWKSCacheOptions{}); err == nil {
+		t.Fatal("no error without a discovery document")
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	provider, secret, isProd := util.Auth_provider, util.Auth_static_secret, util.IsProd
+	t.Cleanup(func() {
+		util.Auth_provider, util.Auth_static_secret, util.IsProd = provider, secret, isProd
+	})
+	util.Auth_static_secret = "a secret of enough length for HS256"
+
+	util.Auth_provider, util.IsProd = "static", false
+	auth, err := NewAuthenticator(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := auth.(*JWTAuthenticator); !ok {
+		t.Fatalf("got authenticator %T for the static provider", auth)
+	}
+
+	util.IsProd = true
+	if _, err := NewAuthenticator(context.Background()); err == nil {
+		t.Fatal("static provider accepted in production")
+	}
+
+	util.Auth_provider = "saml"
+	if _, err := NewAuthenticator(context.Background()); err == nil || !strings.Contains(err.Error(), `unknown auth provider "saml"`) {
+		t.Fatalf("got error %v for an unknown provider", err)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Request bodies are small JSON documents, anything bigger is rejected before decoding
+const maxBodyBytes = 1 << 20
+
+// Decodes the JSON body of r into v. The body must hold a single JSON value no bigger
+// than maxBodyBytes with only the fields of v, any other body is answered with a 400
+// describing what was wrong
+func decodeJson(w http.ResponseWriter, r *http.Request, v any) error {
+	return decodeFrom(http.MaxBytesReader(w, r.Body, maxBodyBytes), v)
+}
+
+func decodeFrom(body io.Reader, v any) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return decodeError(err)
+		}
+		return badRequest(errors.New("request body must only contain a single JSON value"))
+	}
+	return nil
+}
+
+// Translates the errors of the json decoder into request errors a client can act on
+func decodeError(err error) error {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		maxErr    *http.MaxBytesError
+	)
+	switch {
+	case domain.AsError(err) != nil:
+		// Returned by the unmarshalers of the domain types, like an unknown priority
+		return err
+	case errors.Is(err, io.EOF):
+		return badRequest(errors.New("request body must not be empty"))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return badRequest(errors.New("request body contains malformed JSON"))
+	case errors.As(err, &syntaxErr):
+		return badRequest(fmt.Errorf("request body contains malformed JSON at position %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return badRequest(fmt.Errorf("request body must be a JSON object, got a JSON %s", typeErr.Value))
+		}
+		msg := fmt.Sprintf("must be a %s, got a JSON %s", typeErr.Type, typeErr.Value)
+		return requestError{
+			err:    fmt.Errorf("request body contains an invalid value for %q", typeErr.Field),
+			fields: []domain.FieldError{{Field: typeErr.Field, Message: msg}},
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder has no typed error for unknown fields
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return requestError{
+			err:    fmt.Errorf("request body contains unknown field %q", field),
+			fields: []domain.FieldError{{Field: field, Message: "unknown field"}},
+		}
+	case errors.As(err, &maxErr):
+		return badRequest(fmt.Errorf("request body must not be larger than %d bytes", maxErr.Limit))
+	default:
+		// Errors of custom unmarshalers, like a malformed date
+		return badRequest(fmt.Errorf("request body contains an invalid value: %w", err))
+	}
+}
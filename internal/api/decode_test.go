@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type decodeTarget struct {
+	Name     string          `json:"name"`
+	Count    int             `json:"count"`
+	Priority domain.Priority `json:"priority"`
+}
+
+// Answers 200 with the decoded body, decoding errors are written the way the controllers write them
+func decodeHandler(decode func(w http.ResponseWriter, r *http.Request, v any) error) http.Handler {
+	return makeHttpHandler(func(w http.ResponseWriter, r *http.Request) error {
+		v := new(decodeTarget)
+		if err := decode(w, r, v); err != nil {
+			return WriteError(w, err)
+		}
+		return WriteJson(w, http.StatusOK, v)
+	})
+}
+
+func serveBody(t *testing.T, h http.Handler, method, contentType, body string) (int, Problem) {
+	t.Helper()
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var problem Problem
+	if w.Code != http.StatusOK {
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Fatalf("got content type %q for status %d", ct, w.Code)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("invalid problem %s: %v", w.Body, err)
+		}
+	}
+	return w.Code, problem
+}
+
+func TestDecodeJson(t *testing.T) {
+	h := decodeHandler(decodeJson)
+	cases := []struct {
+		name   string
+		body   string
+		status int
+		detail string
+		field  string
+	}{
+		{"Valid", `{"name":"a","count":1,"priority":"high"}`, 200, "", ""},
+		{"Empty", ``, 400, "request body must not be empty", ""},
+		{"Malformed", `{"name":`, 400, "request body contains malformed JSON", ""},
+		{"Syntax", `{"name" "a"}`, 400, "request body contains malformed JSON at position 9", ""},
+		{"NotAnObject", `[1]`, 400, "request body must be a JSON object, got a JSON array", ""},
+		{"WrongType", `{"count":"1"}`, 400, `request body contains an invalid value for "count"`, "count"},
+		{"UnknownField", `{"name":"a","userCognitoId":"mallory"}`, 400, `request body contains unknown field "userCognitoId"`, "userCognitoId"},
+		{"TrailingValue", `{"name":"a"}{"name":"b"}`, 400, "request body must only contain a single JSON value", ""},
+		{"TrailingData", `{"name":"a"} x`, 400, "request body must only contain a single JSON value", ""},
+		{"TrailingSpace", "{\"name\":\"a\"}\n\t ", 200, "", ""},
+		{"InvalidEnum", `{"priority":"urgent"}`, 422, domain.ErrInvalidPriority.Error(), "priority"},
+		{"TooLarge", `{"name":"` + strings.Repeat("a", maxBodyBytes) + `"}`, 400, "request body must not be larger than 1048576 bytes", ""},
+		{"TooLargeAfterValue", `{"name":"a"}` + strings.Repeat(" ", maxBodyBytes), 400, "request body must not be larger than 1048576 bytes", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status, problem := serveBody(t, h, "POST", "application/json", c.body)
+			if status != c.status {
+				t.Fatalf("got status %d with %+v, want %d", status, problem, c.status)
+			}
+			if problem.Status != 0 && problem.Status != status {
+				t.Fatalf("problem status %d does not match the response status %d", problem.Status, status)
+			}
+			if problem.Detail != c.detail {
+				t.Fatalf("got detail %q, want %q", problem.Detail, c.detail)
+			}
+			if c.field == "" && len(problem.Errors) != 0 || c.field != "" && (len(problem.Errors) != 1 || problem.Errors[0].Field != c.field) {
+				t.Fatalf("got field errors %+v, want one on %q", problem.Errors, c.field)
+			}
+		})
+	}
+}
+
+// A body of exactly maxBodyBytes is still accepted
+func TestDecodeJsonLimit(t *testing.T) {
+	body := `{"name":"` + strings.Repeat("a", maxBodyBytes-len(`{"name":""}`)) + `"}`
+	if status, problem := serveBody(t, decodeHandler(decodeJson), "POST", "application/json", body); status != http.StatusOK {
+		t.Fatalf("got status %d with %+v for a body of %d bytes", status, problem, len(body))
+	}
+}
+
+func TestDecodePatchContentType(t *testing.T) {
+	h := decodeHandler(func(w http.ResponseWriter, r *http.Request, v any) error {
+		return decodePatch(w, r, func() (any, error) { return decodeTarget{Name: "a"}, nil }, v)
+	})
+	cases := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/merge-patch+json", `{"name":"b"}`, 200},
+		{"application/merge-patch+json; charset=utf-8", `{"name":"b"}`, 200},
+		{"application/json", `{"name":"b"}`, 200},
+		{"", `{"name":"b"}`, 200},
+		{"application/json-patch+json", `[{"op":"replace","path":"/name","value":"b"}]`, 200},
+		{"text/plain", `{"name":"b"}`, 415},
+		{"application/x-www-form-urlencoded", `name=b`, 415},
+		{"application/xml", `<name>b</name>`, 415},
+		{"not a media type;", `{"name":"b"}`, 415},
+		// The body rules are the same whatever the format
+		{"application/merge-patch+json", `{"name":"b","id":1}`, 400},
+		{"application/json-patch+json", `[{"op":"replace","path":"/name","value":"b"}] []`, 400},
+	}
+	for _, c := range cases {
+		t.Run(c.contentType, func(t *testing.T) {
+			status, problem := serveBody(t, h, "PATCH", c.contentType, c.body)
+			if status != c.status {
+				t.Fatalf("got status %d with %+v, want %d", status, problem, c.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Problem is the RFC 7807 body written for every error response
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Invalid fields of the request, only set on validation errors
+	Errors []domain.FieldError `json:"errors,omitempty"`
+}
+
+// Errors about the request itself, like a malformed body or path parameter, answered with a 400
+// unless another 4xx status fits better
+type requestError struct {
+	err error
+	// The fields of the body the error is about, if any
+	fields []domain.FieldError
+	// Status of the response, 400 when not set
+	status int
+}
+
+func (e requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e requestError) Unwrap() error {
+	return e.err
+}
+
+func badRequest(err error) error {
+	return requestError{err: err}
+}
+
+func unsupportedMediaType(err error) error {
+	return requestError{err: err, status: http.StatusUnsupportedMediaType}
+}
+
+var kindStatus = map[domain.ErrorKind]int{
+	domain.KindNotFound:             http.StatusNotFound,
+	domain.KindForbidden:            http.StatusForbidden,
+	domain.KindConflict:             http.StatusConflict,
+	domain.KindValidation:           http.StatusUnprocessableEntity,
+	domain.KindUnauthorized:         http.StatusUnauthorized,
+	domain.KindPreconditionFailed:   http.StatusPreconditionFailed,
+	domain.KindTooLarge:             http.StatusRequestEntityTooLarge,
+	domain.KindUnsupportedMediaType: http.StatusUnsupportedMediaType,
+}
+
+// Writes err as a problem with the status code matching its kind,
+// errors that are not domain errors are logged and hidden behind a 500
+func WriteError(w http.ResponseWriter, err error) error {
+	var reqErr requestError
+	if errors.As(err, &reqErr) {
+		status := reqErr.status
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		return writeProblem(w, status, err.Error(), reqErr.fields)
+	}
+	if e := domain.AsError(err); e != nil {
+		if status, ok := kindStatus[e.Kind]; ok {
+			return writeProblem(w, status, e.Msg, e.Fields)
+		}
+	}
+	log.Println("Internal error: ", err)
+	return WriteProblem(w, http.StatusInternalServerError, "")
+}
+
+func WriteProblem(w http.ResponseWriter, status int, detail string) error {
+	return writeProblem(w, status, detail, nil)
+}
+
+func writeProblem(w http.ResponseWriter, status int, detail string, fields []domain.FieldError) error {
+	return writeBody(w, status, "application/problem+json", Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Errors: fields,
+	})
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+func writeTestError(t *testing.T, err error) (*httptest.ResponseRecorder, Problem) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := WriteError(w, err); err != nil {
+		t.Fatal(err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("got content type %q, want application/problem+json", ct)
+	}
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("invalid problem %s: %v", w.Body, err)
+	}
+	if problem.Status != w.Code || problem.Type != "about:blank" {
+		t.Fatalf("got problem %+v for status %d", problem, w.Code)
+	}
+	return w, problem
+}
+
+func TestWriteErrorKinds(t *testing.T) {
+	cases := []struct {
+		err    *domain.Error
+		status int
+		title  string
+	}{
+		{domain.NotFound("task not found"), 404, "Not Found"},
+		{domain.Forbidden("not allowed"), 403, "Forbidden"},
+		{domain.Conflict("already exists"), 409, "Conflict"},
+		{domain.PreconditionFailed("modified"), 412, "Precondition Failed"},
+		{domain.TooLarge("too large"), 413, "Request Entity Too Large"},
+		{domain.UnsupportedMediaType("not allowed type"), 415, "Unsupported Media Type"},
+		{domain.Unauthorized("expired"), 401, "Unauthorized"},
+		{domain.Invalid("title", "title is required"), 422, "Unprocessable Entity"},
+		{&domain.Error{Kind: "Unknown", Msg: "internal detail"}, 500, "Internal Server Error"},
+	}
+	for _, c := range cases {
+		t.Run(string(c.err.Kind), func(t *testing.T) {
+			w, problem := writeTestError(t, c.err)
+			if w.Code != c.status || problem.Title != c.title {
+				t.Fatalf("got status %d %q, want %d %q", w.Code, problem.Title, c.status, c.title)
+			}
+			if c.status == 500 {
+				if problem.Detail != "" || strings.Contains(w.Body.String(), c.err.Msg) {
+					t.Fatalf("internal error leaked to the client: %s", w.Body)
+				}
+				return
+			}
+			if problem.Detail != c.err.Msg {
+				t.Fatalf("got detail %q, want %q", problem.Detail, c.err.Msg)
+			}
+		})
+	}
+
+	// Every kind the domain declares has a status of its own
+	for _, kind := range []domain.ErrorKind{domain.KindNotFound, domain.KindForbidden, domain.KindConflict, domain.KindValidation,
+		domain.KindUnauthorized, domain.KindPreconditionFailed, domain.KindTooLarge, domain.KindUnsupportedMediaType} {
+		if _, ok := kindStatus[kind]; !ok {
+			t.Errorf("kind %s has no status", kind)
+		}
+	}
+}
+
+func TestWriteErrorFields(t *testing.T) {
+	err := domain.NewValidationError(
+		domain.FieldError{Field: "title", Message: "title is required"},
+		domain.FieldError{Field: "priority", Message: "priority must be one of High, Medium, Low"},
+	)
+	w, problem := writeTestError(t, err)
+	if w.Code != 422 || len(problem.Errors) != 2 || problem.Errors[0] != err.Fields[0] || problem.Errors[1] != err.Fields[1] {
+		t.Fatalf("got status %d with fields %+v, want 422 with %+v", w.Code, problem.Errors, err.Fields)
+	}
+	// Other errors have no errors member
+	w, _ = writeTestError(t, domain.NotFound("task not found"))
+	if strings.Contains(w.Body.String(), `"errors"`) {
+		t.Fatalf("got fields on a not found error: %s", w.Body)
+	}
+}
+
+func TestWriteErrorInternal(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+	}{
+		{"Plain", errors.New("pq: password authentication failed for user tasker")},
+		{"Wrapped", fmt.Errorf("querying tasks: %w", sql.ErrConnDone)},
+		{"Nil domain error", (*domain.Error)(nil)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w, problem := writeTestError(t, c.err)
+			if w.Code != 500 || problem.Detail != "" {
+				t.Fatalf("got status %d with detail %q, want a 500 without detail", w.Code, problem.Detail)
+			}
+			for _, leak := range []string{"pq:", "querying tasks", "sql:"} {
+				if strings.Contains(w.Body.String(), leak) {
+					t.Fatalf("internal error leaked to the client: %s", w.Body)
+				}
+			}
+		})
+	}
+
+	// A domain error wrapped with context keeps its status, only its own message is shown
+	w, problem := writeTestError(t, fmt.Errorf("loading task 3 from the tasks table: %w", domain.ErrTaskNotFound))
+	if w.Code != 404 || problem.Detail != domain.ErrTaskNotFound.Error() {
+		t.Fatalf("got status %d with detail %q for a wrapped not found error", w.Code, problem.Detail)
+	}
+
+	// Request errors are answered with a 400 unless they carry another status
+	w, problem = writeTestError(t, badRequest(errors.New("taskId must be a number")))
+	if w.Code != 400 || problem.Detail != "taskId must be a number" {
+		t.Fatalf("got status %d with detail %q for a bad request", w.Code, problem.Detail)
+	}
+	if w, _ = writeTestError(t, unsupportedMediaType(errors.New("wrong type"))); w.Code != 415 {
+		t.Fatalf("got status %d for an unsupported media type", w.Code)
+	}
+}
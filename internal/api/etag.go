@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/Desgue/ttracker-api/internal/util"
+)
+
+// Strong entity tag of a resource version, the version is bumped on every write
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// Returns the version the write of r must be applied to, checked again by the store so a
+// concurrent write between the check and the write still fails. Without If-Match the write
+// is unconditional, 0, unless REQUIRE_IF_MATCH is set. current returns the version the
+// resource has now and is only called when the request has an If-Match header
+func ifMatchVersion(r *http.Request, current func() (int, error)) (int, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if util.Require_if_match {
+			return 0, requestError{
+				err:    errors.New("If-Match header is required, send the ETag of the resource"),
+				status: http.StatusPreconditionRequired,
+			}
+		}
+		return 0, nil
+	}
+	version, err := current()
+	if err != nil {
+		return 0, err
+	}
+	for _, tag := range entityTags(header) {
+		// If-Match uses the strong comparison, a weak tag never matches
+		if tag == "*" || tag == etag(version) {
+			return version, nil
+		}
+	}
+	return 0, domain.ErrVersionMismatch
+}
+
+// Reports whether the If-None-Match header of a GET matches the version, in which
+// case the client copy is fresh and the response is a 304 without body
+func notModified(r *http.Request, version int) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range entityTags(header) {
+		// If-None-Match uses the weak comparison
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeNotModified(w http.ResponseWriter, version int) error {
+	setETag(w, version)
+	w.WriteHeader(http.StatusNotModified)
+	return nil
+}
+
+// Splits the comma separated entity tags of a conditional header
+func entityTags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
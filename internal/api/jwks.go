@@ -0,0 +1,251 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+var ErrKeySetStale = errors.New("jwk set is older than the allowed max staleness")
+
+// Options used to tune the behavior of the JWKSCache
+type JWKSCacheOptions struct {
+	// Client used to fetch the key set, defaults to a client with a 10 seconds timeout
+	Client *http.Client
+	// TTL used when the response has no usable Cache-Control max-age
+	DefaultTTL time.Duration
+	// Minimum time between two fetches, protects the provider from being hammered by unknown kids
+	MinRefreshInterval time.Duration
+	// How long an expired key set can still be used when the provider is unreachable
+	MaxStaleness time.Duration
+}
+
+// Snapshot of the cache counters, published through expvar
+type JWKSMetrics struct {
+	Refreshes   uint64    `json:"refreshes"`
+	Failures    uint64    `json:"failures"`
+	LastRefresh time.Time `json:"lastRefresh"`
+	LastError   string    `json:"lastError"`
+}
+
+// JWKSCache holds the key set used to verify the tokens in memory and refreshes it
+// in the background, so verifying a token does not need a network call
+type JWKSCache struct {
+	url  string
+	opts JWKSCacheOptions
+
+	mu          sync.RWMutex
+	set         jwk.Set
+	fetchedAt   time.Time
+	expiresAt   time.Time
+	lastAttempt time.Time
+	lastErr     error
+
+	// Serializes the fetches so concurrent requests with an unknown kid trigger a single call
+	fetchMu sync.Mutex
+
+	refreshes atomic.Uint64
+	failures  atomic.Uint64
+
+	// Replaced by the tests to move the clock without waiting
+	now func() time.Time
+}
+
+func NewJWKSCache(url string, opts JWKSCacheOptions) *JWKSCache {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.DefaultTTL <= 0 {
+		opts.DefaultTTL = time.Hour
+	}
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = 30 * time.Second
+	}
+	if opts.MaxStaleness < 0 {
+		opts.MaxStaleness = 0
+	}
+	return &JWKSCache{
+		url:  url,
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// Start refreshes the key set in the background until the context is canceled
+func (c *JWKSCache) Start(ctx context.Context) {
+	if err := c.Refresh(ctx); err != nil {
+		log.Println("Error fetching the initial jwk set: ", err)
+	}
+	go func() {
+		for {
+			timer := time.NewTimer(c.nextRefresh())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				if err := c.Refresh(ctx); err != nil {
+					log.Println("Error refreshing the jwk set: ", err)
+				}
+			}
+		}
+	}()
+}
+
+// KeySet returns a key set containing the given kid, fetching the set again if the kid is unknown
+func (c *JWKSCache) KeySet(ctx context.Context, kid string) (jwk.Set, error) {
+	set, err := c.current()
+	if err == nil && (kid == "" || hasKey(set, kid)) {
+		return set, nil
+	}
+
+	c.mu.RLock()
+	canFetch := c.now().Sub(c.lastAttempt) >= c.opts.MinRefreshInterval
+	c.mu.RUnlock()
+	if set == nil || canFetch {
+		if ferr := c.Refresh(ctx); ferr != nil {
+			log.Println("Error fetching the jwk set: ", ferr)
+		}
+		set, err = c.current()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if kid != "" && !hasKey(set, kid) {
+		return nil, fmt.Errorf("no key found in the jwk set for kid %q", kid)
+	}
+	return set, nil
+}
+
+// Refresh fetches the key set from the provider and replaces the cached one on success
+func (c *JWKSCache) Refresh(ctx context.Context) error {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	c.mu.Lock()
+	c.lastAttempt = c.now()
+	c.mu.Unlock()
+
+	set, ttl, err := c.fetch(ctx)
+	if err != nil {
+		c.failures.Add(1)
+		c.mu.Lock()
+		c.lastErr = err
+		c.mu.Unlock()
+		return err
+	}
+	c.refreshes.Add(1)
+
+	now := c.now()
+	c.mu.Lock()
+	c.set = set
+	c.fetchedAt = now
+	c.expiresAt = now.Add(ttl)
+	c.lastErr = nil
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *JWKSCache) Metrics() JWKSMetrics {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m := JWKSMetrics{
+		Refreshes:   c.refreshes.Load(),
+		Failures:    c.failures.Load(),
+		LastRefresh: c.fetchedAt,
+	}
+	if c.lastErr != nil {
+		m.LastError = c.lastErr.Error()
+	}
+	return m
+}
+
+// Returns the cached set as long as it is fresh or within the allowed staleness
+func (c *JWKSCache) current() (jwk.Set, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.set == nil {
+		return nil, errors.New("jwk set not loaded")
+	}
+	if c.now().After(c.expiresAt.Add(c.opts.MaxStaleness)) {
+		return nil, ErrKeySetStale
+	}
+	return c.set, nil
+}
+
+// Time to wait until the next background refresh, retries sooner after a failure
+func (c *JWKSCache) nextRefresh() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lastErr != nil || c.set == nil {
+		return c.opts.MinRefreshInterval
+	}
+	wait := c.expiresAt.Sub(c.now())
+	if wait < c.opts.MinRefreshInterval {
+		wait = c.opts.MinRefreshInterval
+	}
+	return wait
+}
+
+func (c *JWKSCache) fetch(ctx context.Context) (jwk.Set, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := c.opts.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status fetching jwk set: %s", res.Status)
+	}
+	set, err := jwk.ParseReader(res.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl, ok := maxAge(res.Header.Get("Cache-Control"))
+	if !ok {
+		ttl = c.opts.DefaultTTL
+	}
+	// We can not refetch sooner than the min interval anyway
+	if ttl < c.opts.MinRefreshInterval {
+		ttl = c.opts.MinRefreshInterval
+	}
+	return set, ttl, nil
+}
+
+// Reads the max-age directive of a Cache-Control header, no-cache and no-store are treated as a zero max-age
+func maxAge(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache", directive == "no-store":
+			return 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds < 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+func hasKey(set jwk.Set, kid string) bool {
+	_, ok := set.LookupKeyID(kid)
+	return ok
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// JWKS endpoint serving the public part of its keys, it can be told to fail
+type keyServer struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	keys         map[string]jwk.Key
+	cacheControl string
+	failing      bool
+	hits         int
+}
+
+func newKeyServer(t *testing.T, kids ...string) *keyServer {
+	t.Helper()
+	s := &keyServer{keys: map[string]jwk.Key{}}
+	for _, kid := range kids {
+		s.addKey(t, kid)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.hits++
+		if s.failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		set := jwk.NewSet()
+		for _, key := range s.keys {
+			public, err := key.PublicKey()
+			if err != nil {
+				t.Error(err)
+			}
+			set.Add(public)
+		}
+		if s.cacheControl != "" {
+			w.Header().Set("Cache-Control", s.cacheControl)
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Adds a new RSA signing key and returns its private part
+func (s *keyServer) addKey(t *testing.T, kid string) jwk.Key {
+	t.Helper()
+	key := newRSAKey(t, kid)
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+	return key
+}
+
+func (s *keyServer) set(fn func(s *keyServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s)
+}
+
+func (s *keyServer) hitCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits
+}
+
+func newRSAKey(t *testing.T, kid string) jwk.Key {
+	t.Helper()
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.New(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Set(jwk.KeyIDKey, kid)
+	key.Set(jwk.AlgorithmKey, jwa.RS256)
+	return key
+}
+
+// Clock of a cache that only moves when the test advances it
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestCache(url string, opts JWKSCacheOptions) (*JWKSCache, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cache := NewJWKSCache(url, opts)
+	cache.now = clock.now
+	return cache, clock
+}
+
+func TestJWKSCacheTTL(t *testing.T) {
+	cases := []struct {
+		cacheControl string
+		want         time.Duration
+	}{
+		{"public, max-age=600", 10 * time.Minute},
+		{"MAX-AGE=3600, must-revalidate", time.Hour},
+		{"", 2 * time.Hour},
+		{"max-age=invalid", 2 * time.Hour},
+		{"max-age=-1", 2 * time.Hour},
+		// Never less than the interval between two fetches
+		{"max-age=5", time.Minute},
+		{"no-cache", time.Minute},
+		{"no-store", time.Minute},
+	}
+	server := newKeyServer(t, "a")
+	for _, c := range cases {
+		t.Run(c.cacheControl, func(t *testing.T) {
+			server.set(func(s *keyServer) { s.cacheControl = c.cacheControl })
+			cache, _ := newTestCache(server.URL, JWKSCacheOptions{DefaultTTL: 2 * time.Hour, MinRefreshInterval: time.Minute})
+			if err := cache.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if ttl := cache.expiresAt.Sub(cache.fetchedAt); ttl != c.want {
+				t.Fatalf("got ttl %s, want %s", ttl, c.want)
+			}
+			if wait := cache.nextRefresh(); wait != c.want {
+				t.Fatalf("next refresh in %s, want %s", wait, c.want)
+			}
+		})
+	}
+}
+
+func TestJWKSCacheUnknownKid(t *testing.T) {
+	ctx := context.Background()
+	server := newKeyServer(t, "a")
+	cache, clock := newTestCache(server.URL, JWKSCacheOptions{MinRefreshInterval: 30 * time.Second})
+
+	// The first call loads the set
+	if _, err := cache.KeySet(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.KeySet(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := server.hitCount(); hits != 1 {
+		t.Fatalf("fetched the set %d times, want 1", hits)
+	}
+
+	// A rotated key is only fetched once the min refresh interval passed
+	server.addKey(t, "b")
+	clock.advance(10 * time.Second)
+	if _, err := cache.KeySet(ctx, "b"); err == nil {
+		t.Fatal("found kid b before the set could be fetched again")
+	}
+	if hits := server.hitCount(); hits != 1 {
+		t.Fatalf("fetched the set %d times within the min refresh interval, want 1", hits)
+	}
+	clock.advance(20 * time.Second)
+	set, err := cache.KeySet(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasKey(set, "a") || !hasKey(set, "b") {
+		t.Fatal("refetched set is missing a key")
+	}
+
+	// Unknown kids can not make the cache hammer the provider
+	for i := 0; i < 5; i++ {
+		if _, err := cache.KeySet(ctx, "unknown"); err == nil {
+			t.Fatal("found an unknown kid")
+		}
+	}
+	if hits := server.hitCount(); hits != 2 {
+		t.Fatalf("fetched the set %d times, want 2", hits)
+	}
+}
+
+func TestJWKSCacheStaleness(t *testing.T) {
+	ctx := context.Background()
+	server := newKeyServer(t, "a")
+	server.set(func(s *keyServer) { s.cacheControl = "max-age=60" })
+	cache, clock := newTestCache(server.URL, JWKSCacheOptions{MinRefreshInterval: 30 * time.Second, MaxStaleness: 10 * time.Minute})
+	if err := cache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired keys keep being served while the provider fails
+	server.set(func(s *keyServer) { s.failing = true })
+	clock.advance(5 * time.Minute)
+	if err := cache.Refresh(ctx); err == nil {
+		t.Fatal("refresh succeeded against a failing provider")
+	}
+	if _, err := cache.KeySet(ctx, "a"); err != nil {
+		t.Fatalf("expired set within the max staleness not served: %v", err)
+	}
+	if wait := cache.nextRefresh(); wait != 30*time.Second {
+		t.Fatalf("next refresh after a failure in %s, want the min refresh interval", wait)
+	}
+
+	clock.advance(6*time.Minute + time.Second)
+	if _, err := cache.KeySet(ctx, "a"); !errors.Is(err, ErrKeySetStale) {
+		t.Fatalf("got error %v, want %v", err, ErrKeySetStale)
+	}
+
+	// A successful fetch makes the set fresh again
+	server.set(func(s *keyServer) { s.failing = false })
+	clock.advance(30 * time.Second)
+	if _, err := cache.KeySet(ctx, "a"); err != nil {
+		t.Fatalf("set not served again after the provider recovered: %v", err)
+	}
+}
+
+func TestJWKSCacheMetrics(t *testing.T) {
+	ctx := context.Background()
+	server := newKeyServer(t, "a")
+	cache, clock := newTestCache(server.URL, JWKSCacheOptions{})
+	if m := cache.Metrics(); m != (JWKSMetrics{}) {
+		t.Fatalf("got metrics %+v before any fetch", m)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := cache.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetchedAt := clock.now()
+	clock.advance(time.Minute)
+	server.set(func(s *keyServer) { s.failing = true })
+	for i := 0; i < 3; i++ {
+		cache.Refresh(ctx)
+	}
+	m := cache.Metrics()
+	if m.Refreshes != 2 || m.Failures != 3 || !m.LastRefresh.Equal(fetchedAt) || m.LastError == "" {
+		t.Fatalf("got metrics %+v after 2 refreshes and 3 failures", m)
+	}
+
+	server.set(func(s *keyServer) { s.failing = false })
+	if err := cache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m = cache.Metrics()
+	if m.Refreshes != 3 || m.Failures != 3 || !m.LastRefresh.Equal(clock.now()) || m.LastError != "" {
+		t.Fatalf("got metrics %+v after a successful refresh", m)
+	}
+}
+
+// The metrics are only served by the admin listener
+func TestAdminHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	adminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); w.Code != http.StatusOK || err != nil || vars["memstats"] == nil {
+		t.Fatalf("got status %d with %.100s from /debug/vars", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	adminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/projects", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d for an API route on the admin listener", w.Code)
+	}
+}
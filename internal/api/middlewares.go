@@ -2,20 +2,94 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"runtime/debug"
 	"strings"
-	"time"
 
-	repo "github.com/Desgue/ttracker-api/internal/repository"
-	svc "github.com/Desgue/ttracker-api/internal/services"
-	"github.com/Desgue/ttracker-api/internal/util"
+	"github.com/Desgue/ttracker-api/internal/domain"
 
-	"github.com/lestrrat-go/jwx/jwk"
-	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/gorilla/mux"
+	"github.com/lestrrat-go/jwx/jws"
 )
 
+// REQUEST ID MIDDLEWARE
+
+type requestIdKey struct{}
+
+// Tags every request with an id, taken from the X-Request-Id header when the client sends one,
+// so the logs of a request can be matched with the response the client got
+func requestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" || len(id) > 128 {
+			id = newRequestId()
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id)))
+	})
+}
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("Error generating request id: ", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func requestId(r *http.Request) string {
+	id, _ := r.Context().Value(requestIdKey{}).(string)
+	return id
+}
+
+// RECOVERY MIDDLEWARE
+
+// Converts a panic in any handler into a logged 500 instead of an aborted connection
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// Used by net/http to abort a response on purpose
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			id := requestId(r)
+			log.Printf("Panic serving request %s %s %s: %v\n%s", id, r.Method, r.URL.Path, rec, debug.Stack())
+			// A partial response can not be turned into an error anymore
+			if rw.wroteHeader {
+				return
+			}
+			WriteProblem(w, http.StatusInternalServerError, fmt.Sprintf("Unexpected error, request id: %s", id))
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Keeps track of whether the response was started, so a panic is only answered once
+type recoveryWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoveryWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoveryWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
 // LOGGING MIDDLEWARE
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -29,9 +103,10 @@ func loggingMiddleware(next http.Handler) http.Handler {
 
 		log.Printf(` 
 		%s -> %s 
+		Request-Id: %s 
 		Referrer: %s 
 		User-Agent: %s`,
-			method, uri, referrer, userAgent)
+			method, uri, requestId(r), referrer, userAgent)
 
 		// Call the next handler, which can be another middleware in the chain, or the final handler.
 		log.Println("Serving next handler")
@@ -41,7 +116,13 @@ func loggingMiddleware(next http.Handler) http.Handler {
 
 // JWT MIDDLEWARE
 
-func verifyJwtMiddleware(next http.Handler) http.Handler {
+func verifyJwtMiddleware(auth Authenticator, tokens domain.IAccessTokenService) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return verifyJwt(auth, tokens, next)
+	}
+}
+
+func verifyJwt(auth Authenticator, tokens domain.IAccessTokenService, next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Authenticating the user")
@@ -50,98 +131,131 @@ func verifyJwtMiddleware(next http.Handler) http.Handler {
 		header := r.Header.Get("Authorization")
 		if header == "" {
 			log.Println("Auth failed due to missing Authorization Header")
-			WriteJson(w, http.StatusUnauthorized, ApiLog{Err: "Missing Authorization Header", StatusCode: http.StatusUnauthorized})
+			WriteProblem(w, http.StatusUnauthorized, "Missing Authorization Header")
 			return
 		}
 		if !strings.HasPrefix(header, "Bearer ") {
 			log.Println("Auth failed due to Invalid Authorization Header")
-			WriteJson(w, http.StatusUnauthorized, ApiLog{Err: "Invalid Authorization Header", StatusCode: http.StatusUnauthorized})
+			WriteProblem(w, http.StatusUnauthorized, "Invalid Authorization Header")
 			return
 		}
 
 		tokenString := strings.Split(header, "Bearer ")[1]
 		log.Println("Authorization Header parsed")
 
-		tokenByte := []byte(tokenString)
+		// Personal access tokens are accepted as an alternative to the provider JWTs
+		if strings.HasPrefix(tokenString, domain.AccessTokenPrefix) {
+			verifyAccessToken(tokens, tokenString, next, w, r)
+			return
+		}
 
-		log.Println("Fetching the public key")
-		verifyKeySet, err := getPublicKey(util.Cognito_jwk_url)
-		if err != nil {
+		log.Println("Validating the token")
+		cognitoId, err := auth.Authenticate(r.Context(), tokenString)
+		if errors.Is(err, ErrKeySetStale) {
 			log.Println("Error fetching the public key: ", err)
-			WriteJson(w, http.StatusInternalServerError, ApiLog{Err: "Error fetching the public key", StatusCode: http.StatusInternalServerError})
+			WriteProblem(w, http.StatusServiceUnavailable, "Error fetching the public key")
 			return
 		}
-
-		log.Println("Parsing the token with the public key to validate")
-		token, err := jwt.Parse(tokenByte, jwt.WithKeySet(verifyKeySet), jwt.WithValidate(true))
 		if err != nil {
 			log.Println("Error parsing the token with err message: ", err)
-			WriteJson(w, http.StatusUnauthorized, ApiLog{Err: fmt.Sprintf("Error parsing the token with err message: %s", err.Error()), StatusCode: http.StatusUnauthorized})
-			return
-		}
-		// Compare token claims for: Expire time, issuer, token_use
-		if token.Expiration().Unix() < time.Now().Unix() {
-			log.Println("Auth failed due to Token expired")
-			WriteJson(w, http.StatusUnauthorized, ApiLog{Err: "Token expired", StatusCode: http.StatusUnauthorized})
-			return
-		}
-		if token.Issuer() != util.Cognito_issuer {
-			log.Println("Auth failied due to Invalid issuer")
-			WriteJson(w, http.StatusUnauthorized, ApiLog{Err: "Invalid issuer", StatusCode: http.StatusUnauthorized})
-			return
-		}
-		if token.PrivateClaims()["token_use"] != "access" {
-			log.Println("Auth failied due to Invalid token use")
-			WriteJson(w, http.StatusUnauthorized, ApiLog{Err: "Invalid token use", StatusCode: http.StatusUnauthorized})
+			WriteProblem(w, http.StatusUnauthorized, fmt.Sprintf("Error parsing the token with err message: %s", err.Error()))
 			return
 		}
 		log.Println("User authenticated successfully")
 
-		// Check if user is present on the database, if not create a new user
-		cognitoId := token.Subject()
 		setUserHeader(r, cognitoId)
 		log.Println("Serving next handler")
 		next.ServeHTTP(w, r)
 	})
 }
 
+func verifyAccessToken(tokens domain.IAccessTokenService, tokenString string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	log.Println("Validating the personal access token")
+	token, err := tokens.Authenticate(tokenString)
+	if errors.Is(err, domain.ErrInvalidAccessToken) || errors.Is(err, domain.ErrAccessTokenExpired) {
+		log.Println("Auth failed due to invalid access token: ", err)
+		WriteError(w, err)
+		return
+	}
+	if err != nil {
+		log.Println("Error validating the access token: ", err)
+		WriteProblem(w, http.StatusInternalServerError, "Error validating the access token")
+		return
+	}
+	if !tokenAllows(token, r) {
+		log.Println("Auth failed due to missing token scope")
+		WriteError(w, domain.ErrInsufficientTokenScope)
+		return
+	}
+	log.Println("User authenticated successfully with access token ", token.Id)
+
+	setUserHeader(r, token.CognitoId)
+	log.Println("Serving next handler")
+	next.ServeHTTP(w, r)
+}
+
+// Access tokens can only reach the projects and tasks routes, a write scope also grants read access.
+// The enum values are public to any valid token, searching reads both projects and tasks
+func tokenAllows(token domain.AccessToken, r *http.Request) bool {
+	var resource string
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/meta/enums":
+		return r.Method == http.MethodGet
+	case r.URL.Path == "/search":
+		return r.Method == http.MethodGet && canRead(token, "projects") && canRead(token, "tasks")
+	case r.URL.Path == "/users/me/mentions":
+		return r.Method == http.MethodGet && canRead(token, "tasks")
+	case r.URL.Path == "/users/me/tasks":
+		resource = "tasks"
+	// /projects/{projectId}/tasks and everything under it
+	case len(segments) >= 3 && segments[0] == "projects" && segments[2] == "tasks":
+		resource = "tasks"
+	case segments[0] == "projects":
+		resource = "projects"
+	default:
+		return false
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return canRead(token, resource)
+	}
+	return token.HasScope(resource + ":write")
+}
+
+func canRead(token domain.AccessToken, resource string) bool {
+	return token.HasScope(resource+":read") || token.HasScope(resource+":write")
+}
+
 // VERIFY USER MIDDLEWARE
 // MUST BE CALLED AFTER JWT MIDDLEWARE
-// TODO PERFORM THE VERIFICATION AT THE CONTROLLER LEVEL TO AVOID OPENING A NEW CONNECTION TO THE DATABASE
-func verifyUserMiddleware(next http.Handler) http.Handler {
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		postgres, _ := repo.NewPostgresStore(util.ConnStr)
-		defer postgres.DB.Close()
-		repo := repo.NewPostgresUserStore(postgres.DB)
-		svc := svc.NewUserService(repo)
-		log.Println("Verifying user in the database")
-		cognitoId := r.Header.Get("CognitoId")
-		ok, err := svc.CheckUser(cognitoId)
-		if err != nil {
-			log.Println("Error verifying user in the database: ", err)
-		}
-		if !ok {
-			log.Println("User not found in the database, creating a new user")
-			svc.CreateUser(cognitoId)
+// Uses the injected user service so the shared connection pool is reused on every request
+func verifyUserMiddleware(users domain.IUserService) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Println("Verifying user in the database")
+			cognitoId := r.Header.Get("CognitoId")
+			if err := users.EnsureUser(cognitoId); err != nil {
+				log.Println("Error verifying user in the database: ", err)
+				WriteProblem(w, http.StatusInternalServerError, "Error verifying user")
+				return
+			}
 			log.Println("Serving next handler")
-
-		}
-		log.Println("User already exists in the database")
-		log.Println("Serving next handler")
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // HELPER FUNCTIONS FOR JWT MIDDLEWARE
 
-func getPublicKey(url string) (jwk.Set, error) {
-	set, err := jwk.Fetch(context.Background(), url)
+func getKeyId(token []byte) (string, error) {
+	msg, err := jws.Parse(token)
 	if err != nil {
-		log.Printf("failed to parse JWK: %s", err)
-		return nil, err
+		return "", err
+	}
+	if len(msg.Signatures()) == 0 {
+		return "", errors.New("token has no signature")
 	}
-	return set, nil
+	return msg.Signatures()[0].ProtectedHeaders().KeyID(), nil
 }
 
 func setUserHeader(r *http.Request, cognitoId string) {
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	repo "github.com/Desgue/ttracker-api/internal/repository"
+	svc "github.com/Desgue/ttracker-api/internal/services"
+)
+
+// Access tokens of alice kept in memory, the secrets are chosen by the test
+type testTokens struct {
+	store   *repo.KVAccessTokenStore
+	service *svc.AccessTokenService
+}
+
+func newTestTokens(t *testing.T) *testTokens {
+	t.Helper()
+	kv := repo.NewKvRepository()
+	if err := repo.NewKVUserStore(kv).CreateUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	store := repo.NewKVAccessTokenStore(kv)
+	return &testTokens{store: store, service: svc.NewAccessTokenService(store)}
+}
+
+// Stores a token with the given scopes directly, so it can be given an expiry in the past,
+// and returns its secret
+func (tt *testTokens) add(t *testing.T, name string, expiresAt *time.Time, scopes ...string) string {
+	t.Helper()
+	secret := domain.AccessTokenPrefix + name
+	sum := sha256.Sum256([]byte(secret))
+	_, err := tt.store.CreateToken(&domain.CreateAccessTokenRequest{Name: name, Scopes: scopes, ExpiresAt: expiresAt, UserCognitoId: "alice"}, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func (tt *testTokens) get(t *testing.T, name string) domain.AccessToken {
+	t.Helper()
+	tokens, err := tt.store.GetTokens("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if token.Name == name {
+			return token
+		}
+	}
+	t.Fatalf("token %s not found", name)
+	return domain.AccessToken{}
+}
+
+// Sends the request through the authentication middleware, the handler behind it answers 200
+// with the user it was called for
+func serveWithToken(t *testing.T, tokens domain.IAccessTokenService, method, path, secret string) *httptest.ResponseRecorder {
+	t.Helper()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CognitoId", r.Header.Get("CognitoId"))
+		w.WriteHeader(http.StatusOK)
+	})
+	r := httptest.NewRequest(method, path, nil)
+	r.Header.Set("Authorization", "Bearer "+secret)
+	w := httptest.NewRecorder()
+	// Provider JWTs are not used by these tests
+	verifyJwt(nil, tokens, next).ServeHTTP(w, r)
+	return w
+}
+
+func TestAccessTokenScopes(t *testing.T) {
+	tokens := newTestTokens(t)
+	secrets := map[string]string{
+		"tasksRead":     tokens.add(t, "tasksRead", nil, domain.ScopeTasksRead),
+		"tasksWrite":    tokens.add(t, "tasksWrite", nil, domain.ScopeTasksWrite),
+		"projectsRead":  tokens.add(t, "projectsRead", nil, domain.ScopeProjectsRead),
+		"projectsWrite": tokens.add(t, "projectsWrite", nil, domain.ScopeProjectsWrite),
+		"readAll":       tokens.add(t, "readAll", nil, domain.ScopeProjectsRead, domain.ScopeTasksRead),
+		"all":           tokens.add(t, "all", nil, domain.Scopes...),
+	}
+	cases := []struct {
+		token  string
+		method string
+		path   string
+		want   int
+	}{
+		// Read scopes only allow reading
+		{"tasksRead", "GET", "/projects/1/tasks", 200},
+		{"tasksRead", "HEAD", "/projects/1/tasks/2", 200},
+		{"tasksRead", "POST", "/projects/1/tasks", 403},
+		{"tasksRead", "PATCH", "/projects/1/tasks/2", 403},
+		{"tasksRead", "DELETE", "/projects/1/tasks/2", 403},
+		{"tasksRead", "GET", "/users/me/tasks", 200},
+		{"projectsRead", "GET", "/projects", 200},
+		{"projectsRead", "GET", "/projects/1/labels", 200},
+		{"projectsRead", "POST", "/projects", 403},
+		{"projectsRead", "PATCH", "/projects/1", 403},
+		{"projectsRead", "DELETE", "/projects/1", 403},
+		{"readAll", "PUT", "/projects/1/tasks/2", 403},
+		// Write scopes also allow reading
+		{"tasksWrite", "GET", "/projects/1/tasks", 200},
+		{"tasksWrite", "POST", "/projects/1/tasks", 200},
+		{"tasksWrite", "DELETE", "/projects/1/tasks/2/comments/3", 200},
+		{"projectsWrite", "GET", "/projects/1", 200},
+		{"projectsWrite", "PATCH", "/projects/1", 200},
+		// The tasks of a project are not reachable with the projects scopes, nor the other way round
+		{"tasksWrite", "GET", "/projects", 403},
+		{"tasksWrite", "GET", "/projects/1", 403},
+		{"tasksWrite", "POST", "/projects", 403},
+		{"projectsWrite", "GET", "/projects/1/tasks", 403},
+		{"projectsWrite", "POST", "/projects/1/tasks", 403},
+		// Searching reads projects and tasks
+		{"tasksRead", "GET", "/search", 403},
+		{"projectsRead", "GET", "/search", 403},
+		{"readAll", "GET", "/search", 200},
+		{"all", "POST", "/search", 403},
+		{"tasksRead", "GET", "/users/me/mentions", 200},
+		{"projectsRead", "GET", "/users/me/mentions", 403},
+		{"projectsRead", "GET", "/meta/enums", 200},
+		{"all", "POST", "/meta/enums", 403},
+		// Everything else is denied whatever the scopes
+		{"all", "GET", "/teams", 403},
+		{"all", "POST", "/teams/1/members", 403},
+		{"all", "GET", "/users/me/tokens", 403},
+		{"all", "POST", "/users/me/tokens", 403},
+		{"all", "DELETE", "/users/me/tokens/1", 403},
+		{"all", "POST", "/invitations/accept", 403},
+		{"all", "GET", "/debug/vars", 403},
+		{"all", "GET", "/", 403},
+		{"all", "GET", "/teams/tasks", 403},
+		{"all", "GET", "/users/me/tokens/tasks", 403},
+		{"all", "GET", "/projectsx", 403},
+		{"all", "GET", "/unknown", 403},
+	}
+	for _, c := range cases {
+		t.Run(c.token+" "+c.method+" "+c.path, func(t *testing.T) {
+			w := serveWithToken(t, tokens.service, c.method, c.path, secrets[c.token])
+			if w.Code != c.want {
+				t.Fatalf("got status %d, want %d", w.Code, c.want)
+			}
+			if c.want == http.StatusOK && w.Header().Get("CognitoId") != "alice" {
+				t.Fatalf("handler called for user %q, want alice", w.Header().Get("CognitoId"))
+			}
+			if c.want == http.StatusForbidden && w.Header().Get("Content-Type") != "application/problem+json" {
+				t.Fatalf("got content type %q for a denied request", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestAccessTokenRejected(t *testing.T) {
+	tokens := newTestTokens(t)
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	expired := tokens.add(t, "expired", &past, domain.Scopes...)
+	valid := tokens.add(t, "valid", &future, domain.Scopes...)
+
+	if w := serveWithToken(t, tokens.service, "GET", "/projects", valid); w.Code != http.StatusOK {
+		t.Fatalf("got status %d for a valid token", w.Code)
+	}
+	if used := tokens.get(t, "valid").LastUsedAt; used == nil || used.Before(now) {
+		t.Fatalf("valid token used at %v, want the time of the request", used)
+	}
+
+	cases := []struct {
+		name   string
+		secret string
+	}{
+		{"Expired", expired},
+		{"Unknown", domain.AccessTokenPrefix + "unknown"},
+		{"Empty", domain.AccessTokenPrefix},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := serveWithToken(t, tokens.service, "GET", "/projects", c.secret)
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("got status %d, want 401", w.Code)
+			}
+		})
+	}
+	if used := tokens.get(t, "expired").LastUsedAt; used != nil {
+		t.Fatalf("expired token recorded as used at %s", used)
+	}
+
+	// A valid token never reaches the handler of a route it does not allow
+	w := serveWithToken(t, tokens.service, "GET", "/teams", valid)
+	if w.Code != http.StatusForbidden || w.Header().Get("CognitoId") != "" {
+		t.Fatalf("got status %d for user %q on a route tokens can not reach", w.Code, w.Header().Get("CognitoId"))
+	}
+}
+
+func serveRecovered(t *testing.T, h http.HandlerFunc, requestId string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/projects", nil)
+	if requestId != "" {
+		r.Header.Set("X-Request-Id", requestId)
+	}
+	w := httptest.NewRecorder()
+	requestIdMiddleware(recoveryMiddleware(h)).ServeHTTP(w, r)
+	return w
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	panicking := func(w http.ResponseWriter, r *http.Request) { panic("boom") }
+
+	w := serveRecovered(t, panicking, "req-1")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want 500", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("got content type %q", ct)
+	}
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Status != 500 || problem.Detail != "Unexpected error, request id: req-1" {
+		t.Fatalf("got problem %+v, want one carrying the request id", problem)
+	}
+	if strings.Contains(w.Body.String(), "boom") {
+		t.Fatalf("panic value leaked to the client: %s", w.Body)
+	}
+	if id := w.Header().Get("X-Request-Id"); id != "req-1" {
+		t.Fatalf("got request id %q, want req-1", id)
+	}
+
+	// A generated id is reported when the client did not send one
+	w = serveRecovered(t, panicking, "")
+	id := w.Header().Get("X-Request-Id")
+	if id == "" || !strings.Contains(w.Body.String(), id) {
+		t.Fatalf("response %s does not carry the generated request id %q", w.Body, id)
+	}
+
+	// A started response is left as it is
+	w = serveRecovered(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("boom")
+	}, "")
+	if w.Code != http.StatusAccepted || w.Body.String() != "partial" {
+		t.Fatalf("got status %d and body %q after a panic in a started response", w.Code, w.Body)
+	}
+}
+
+// net/http aborts a response on purpose with http.ErrAbortHandler, it must reach the server
+func TestRecoveryMiddlewareAbort(t *testing.T) {
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Fatalf("got panic %v, want %v", rec, http.ErrAbortHandler)
+		}
+	}()
+	serveRecovered(t, func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) }, "")
+}
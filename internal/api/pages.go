@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Opaque token of the ?cursor= parameter. The sort is kept with the position so a cursor
+// can not be used with another order than the one of the page it comes from
+type cursorToken struct {
+	Sort domain.SortField `json:"s"`
+	Desc bool             `json:"d,omitempty"`
+	Key  string           `json:"k"`
+	Id   int              `json:"i"`
+}
+
+// Reads the pagination of a list request from the query string:
+// ?limit= between 1 and domain.MaxPageLimit, ?sort= one of fields, prefixed with - for the
+// descending order, and ?cursor= the token of the next link of the previous page
+func parsePage(r *http.Request, fields []domain.SortField) (domain.Page, error) {
+	query := r.URL.Query()
+	page := domain.Page{Limit: domain.DefaultPageLimit, Sort: fields[0]}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > domain.MaxPageLimit {
+			return page, fmt.Errorf("limit must be a number between 1 and %d", domain.MaxPageLimit)
+		}
+		page.Limit = limit
+	}
+	if v := query.Get("sort"); v != "" {
+		page.Desc = strings.HasPrefix(v, "-")
+		page.Sort = domain.SortField(strings.TrimPrefix(v, "-"))
+		if !containsField(fields, page.Sort) {
+			names := make([]string, len(fields))
+			for i, f := range fields {
+				names[i] = string(f)
+			}
+			return page, fmt.Errorf("sort must be one of %s", strings.Join(names, ", "))
+		}
+	}
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v, page)
+		if err != nil {
+			return page, err
+		}
+		page.After = cursor
+	}
+	return page, nil
+}
+
+func containsField(fields []domain.SortField, field domain.SortField) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeCursor(page domain.Page, cursor domain.Cursor) string {
+	b, _ := json.Marshal(cursorToken{Sort: page.Sort, Desc: page.Desc, Key: cursor.Key, Id: cursor.Id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(v string, page domain.Page) (*domain.Cursor, error) {
+	var token cursorToken
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || json.Unmarshal(b, &token) != nil || !domain.ValidSortKey(token.Sort, token.Key) {
+		return nil, errors.New("invalid cursor")
+	}
+	if token.Sort != page.Sort || token.Desc != page.Desc {
+		return nil, errors.New("cursor was issued for another sort, follow the next link of the previous page")
+	}
+	return &domain.Cursor{Key: token.Key, Id: token.Id}, nil
+}
+
+// Points the Link header to the next page of the list, the other parameters of the
+// request are kept. Nothing is written on the last page
+func setNextLink(w http.ResponseWriter, r *http.Request, page domain.Page, next *domain.Cursor) {
+	if next == nil {
+		return
+	}
+	query := r.URL.Query()
+	query.Set("cursor", encodeCursor(page, *next))
+	link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, link.String()))
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/Desgue/ttracker-api/internal/jsonpatch"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// Decodes the body of a PATCH request into v, a patch request made of domain.Optional fields.
+// A JSON Merge Patch (RFC 7386) is decoded as it is since the resources have no nested objects.
+// A JSON Patch (RFC 6902) is applied to the resource returned by current and the top level
+// members it changes are decoded as if they were sent as a merge patch
+func decodePatch(w http.ResponseWriter, r *http.Request, current func() (any, error), v any) error {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil && contentType != "" {
+		return unsupportedMediaType(fmt.Errorf("invalid Content-Type %q", contentType))
+	}
+	switch mediaType {
+	case mergePatchType, "application/json", "":
+		return decodeJson(w, r, v)
+	case jsonPatchType:
+	default:
+		return unsupportedMediaType(fmt.Errorf("Content-Type must be %s or %s", mergePatchType, jsonPatchType))
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		return decodeError(err)
+	}
+	resource, err := current()
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	patched, err := jsonpatch.Apply(doc, patch)
+	switch {
+	case errors.Is(err, jsonpatch.ErrInvalidPatch):
+		return badRequest(err)
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return domain.Conflict(err.Error())
+	case errors.Is(err, jsonpatch.ErrCannotApply):
+		return &domain.Error{Kind: domain.KindValidation, Msg: err.Error()}
+	case err != nil:
+		return err
+	}
+	changes, err := changedMembers(doc, patched)
+	if err != nil {
+		return err
+	}
+	return decodeFrom(bytes.NewReader(changes), v)
+}
+
+// Builds a merge patch holding the top level members of patched that differ from doc,
+// removed members are set to null
+func changedMembers(doc, patched []byte) ([]byte, error) {
+	var before, after map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &before); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patched, &after); err != nil || after == nil {
+		return nil, &domain.Error{Kind: domain.KindValidation, Msg: "json patch must leave an object"}
+	}
+	changes := map[string]json.RawMessage{}
+	for name, value := range after {
+		if old, ok := before[name]; !ok || !sameJson(old, value) {
+			changes[name] = value
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changes[name] = json.RawMessage("null")
+		}
+	}
+	return json.Marshal(changes)
+}
+
+func sameJson(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -2,9 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"expvar"
 	"log"
 	"net/http"
 
+	"github.com/Desgue/ttracker-api/internal/domain"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
@@ -13,39 +15,60 @@ type apiFunc func(http.ResponseWriter, *http.Request) error
 
 type Server struct {
 	addr       string
+	adminAddr  string
 	controller *Controllers
+	auth       Authenticator
+	users      domain.IUserService
+	tokens     domain.IAccessTokenService
 }
 
-func NewServer(addr string, controllers *Controllers) *Server {
+// The admin listener on adminAddr is only started when adminAddr is set
+func NewServer(addr, adminAddr string, controllers *Controllers, auth Authenticator, users domain.IUserService, tokens domain.IAccessTokenService) *Server {
 	return &Server{
 		addr:       addr,
+		adminAddr:  adminAddr,
 		controller: controllers,
+		auth:       auth,
+		users:      users,
+		tokens:     tokens,
 	}
 }
 
 type Controllers struct {
-	Project *ProjectController
-	Task    *TaskController
-	Team    *TeamController
-	User    *UserController
+	Project     *ProjectController
+	Task        *TaskController
+	Team        *TeamController
+	User        *UserController
+	AccessToken *AccessTokenController
+	Meta        *MetaController
+	Search      *SearchController
+	Label       *LabelController
+	Comment     *CommentController
+	Attachment  *AttachmentController
 }
+
+// Body of the responses that only carry a message, errors are written as a Problem
 type ApiLog struct {
-	Err        string `json:"err"`
 	StatusCode int    `json:"statusCode"`
 	Msg        string `json:"msg"`
 }
 
 func WriteJson(w http.ResponseWriter, status int, v any) error {
+	return writeBody(w, status, "application/json", v)
+}
+
+// Headers must be set before WriteHeader, they are ignored afterwards
+func writeBody(w http.ResponseWriter, status int, contentType string, v any) error {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.WriteHeader(status)
-	w.Header().Add("Content-Type", "application/json")
-	w.Header().Add("Access-Control-Allow-Origin", "*")
 	return json.NewEncoder(w).Encode(v)
 }
+
 func makeHttpHandler(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := f(w, r)
-		if err != nil {
-			WriteJson(w, http.StatusBadRequest, ApiLog{Err: err.Error(), StatusCode: http.StatusBadRequest})
+		if err := f(w, r); err != nil {
+			WriteError(w, err)
 		}
 	}
 }
@@ -54,26 +77,57 @@ func (s *Server) Run() {
 
 	router.HandleFunc("/projects/{projectId}/tasks", makeHttpHandler(s.controller.Task.handleTasks))
 	router.HandleFunc("/projects/{projectId}/tasks/{taskId}", makeHttpHandler(s.controller.Task.handleTask))
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/children", makeHttpHandler(s.controller.Task.handleSubtasks))
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/parent", makeHttpHandler(s.controller.Task.handleTaskParent))
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/comments", makeHttpHandler(s.controller.Comment.handleComments))
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/comments/{commentId}", makeHttpHandler(s.controller.Comment.handleComment))
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/comments/{commentId}/history", makeHttpHandler(s.controller.Comment.handleCommentHistory))
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/attachments", makeHttpHandler(s.controller.Attachment.handleAttachments))
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/attachments/{attachmentId}", makeHttpHandler(s.controller.Attachment.handleAttachment))
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/attachments/{attachmentId}/content", makeHttpHandler(s.controller.Attachment.handleAttachmentContent))
 
 	router.HandleFunc("/projects", makeHttpHandler(s.controller.Project.handleProjects))
 	router.HandleFunc("/projects/{projectId}", makeHttpHandler(s.controller.Project.handleProject))
+	router.HandleFunc("/projects/{projectId}/shares", makeHttpHandler(s.controller.Project.handleShares))
+	router.HandleFunc("/projects/{projectId}/shares/{userId}", makeHttpHandler(s.controller.Project.handleShare))
+	router.HandleFunc("/projects/{projectId}/labels", makeHttpHandler(s.controller.Label.handleLabels))
+	router.HandleFunc("/projects/{projectId}/labels/{labelId}", makeHttpHandler(s.controller.Label.handleLabel))
+	router.HandleFunc("/projects/{projectId}/labels/{labelId}/merge", makeHttpHandler(s.controller.Label.handleMergeLabel))
 
 	router.HandleFunc("/teams", makeHttpHandler(s.controller.Team.handleTeams))
 	router.HandleFunc("/teams/{teamId}", makeHttpHandler(s.controller.Team.handleTeam))
+	router.HandleFunc("/teams/{teamId}/members", makeHttpHandler(s.controller.Team.handleMembers))
+	router.HandleFunc("/teams/{teamId}/members/{userId}", makeHttpHandler(s.controller.Team.handleMember))
+	router.HandleFunc("/teams/{teamId}/invitations", makeHttpHandler(s.controller.Team.handleInvitations))
+	router.HandleFunc("/invitations/accept", makeHttpHandler(s.controller.Team.handleAcceptInvitation))
 
 	router.HandleFunc("/users", makeHttpHandler(s.controller.User.handleUsers))
+	router.HandleFunc("/users/me/tasks", makeHttpHandler(s.controller.Task.handleMyTasks))
+	router.HandleFunc("/users/me/mentions", makeHttpHandler(s.controller.Comment.handleMyMentions))
+	router.HandleFunc("/users/me/tokens", makeHttpHandler(s.controller.AccessToken.handleTokens))
+	router.HandleFunc("/users/me/tokens/{tokenId}", makeHttpHandler(s.controller.AccessToken.handleToken))
+
+	router.HandleFunc("/meta/enums", makeHttpHandler(s.controller.Meta.handleEnums))
+
+	router.HandleFunc("/search", makeHttpHandler(s.controller.Search.handleSearch))
 
 	router.Use(loggingMiddleware)
-	router.Use(verifyJwtMiddleware)
-	router.Use(verifyUserMiddleware)
+	router.Use(verifyJwtMiddleware(s.auth, s.tokens))
+	router.Use(verifyUserMiddleware(s.users))
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowCredentials: true,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{"X-Request-Id", "ETag", "Location", "Link"},
 	})
-	handler := c.Handler(router)
+	// Outermost so panics anywhere in the chain, the other middlewares included, are recovered
+	handler := requestIdMiddleware(recoveryMiddleware(c.Handler(router)))
+
+	if s.adminAddr != "" {
+		go s.runAdmin()
+	}
 
 	log.Println("Server running and listening on port: ", s.addr)
 	err := http.ListenAndServe(s.addr, handler)
@@ -83,3 +137,17 @@ func (s *Server) Run() {
 	log.Println("shoud not reach here, server stopped running...")
 
 }
+
+// Metrics are kept off the public router, anyone reaching the admin listener can read them
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+func (s *Server) runAdmin() {
+	log.Println("Admin server listening on: ", s.adminAddr)
+	if err := http.ListenAndServe(s.adminAddr, adminHandler()); err != nil {
+		log.Println("Error starting the admin server: ", err)
+	}
+}
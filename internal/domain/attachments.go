@@ -0,0 +1,120 @@
+package domain
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Desgue/ttracker-api/internal/validate"
+)
+
+var (
+	ErrAttachmentNotFound = NotFound("attachment not found")
+	ErrAttachmentTooLarge = TooLarge("attachment is larger than the maximum size")
+	ErrAttachmentType     = UnsupportedMediaType("attachment type is not allowed")
+	// Returned by the blob stores when there is no content under the key
+	ErrBlobNotFound = NotFound("attachment content not found")
+)
+
+const maxAttachmentNameLength = 255
+
+// Metadata of the attachments, their content is kept by a BlobStore. GetProjectRole must be
+// used to make sure the user is allowed to touch the project before calling the other methods
+type AttachmentStorage interface {
+	GetProjectRole(projectId int, cognitoId string) (Role, error)
+	// Attachments of the task oldest first, fails with ErrTaskNotFound when the task is not
+	// a task of the project
+	GetAttachments(projectId, taskId int) ([]Attachment, error)
+	GetAttachment(projectId, taskId, attachmentId int) (Attachment, error)
+	// Records content already written under r.BlobKey, fails with ErrTaskNotFound when the
+	// task is not a task of the project
+	CreateAttachment(*AttachmentRequest) (Attachment, error)
+	// Removes the attachment from its task, it is then only returned by GetDetachedAttachments
+	// until its content is deleted
+	DetachAttachment(projectId, taskId, attachmentId int) (Attachment, error)
+	// Attachments detached or whose task was deleted, at most limit of them
+	GetDetachedAttachments(limit int) ([]Attachment, error)
+	// Forgets a detached attachment once its content is deleted
+	DeleteAttachment(attachmentId int) error
+}
+
+// BlobStore keeps the content of the attachments. Content is streamed in and out,
+// an implementation must not hold a whole blob in memory
+type BlobStore interface {
+	// Writes everything read from r under key. When r fails the error is returned and
+	// nothing is left under key
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Opens the content under key, ErrBlobNotFound when there is none. The caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Deleting a key without content is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Reading attachments requires ProjectReadRole, uploading and deleting them ProjectWriteRole
+type IAttachmentService interface {
+	GetAttachments(projectId, taskId int, cognitoId string) ([]Attachment, error)
+	GetAttachment(projectId, taskId, attachmentId int, cognitoId string) (Attachment, error)
+	// Returns the attachment with its content, the caller closes it
+	OpenAttachment(ctx context.Context, projectId, taskId, attachmentId int, cognitoId string) (Attachment, io.ReadCloser, error)
+	// Streams content to the blob store, r.Size is counted while it is read
+	UploadAttachment(ctx context.Context, r *AttachmentRequest, content io.Reader) (Attachment, error)
+	DeleteAttachment(ctx context.Context, projectId, taskId, attachmentId int, cognitoId string) error
+}
+
+type Attachment struct {
+	Id     int `json:"id"`
+	TaskId int `json:"taskId"`
+	// File name given by the uploader
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	// Size of the content in bytes
+	Size int64 `json:"size"`
+	// CognitoId of the user who uploaded it
+	UploadedBy string    `json:"uploadedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// Key of the content in the BlobStore
+	BlobKey string `json:"-"`
+}
+
+type AttachmentRequest struct {
+	Name          string
+	ContentType   string
+	ProjectId     int
+	TaskId        int
+	UserCognitoId string
+	// Set by the service once the content is stored
+	Size    int64
+	BlobKey string
+}
+
+// The name is only displayed and sent back when downloading, it can not hold a path
+func (r *AttachmentRequest) Validate() error {
+	v := new(validate.Validator)
+	v.Required("name", r.Name)
+	v.MaxLength("name", r.Name, maxAttachmentNameLength)
+	v.Check(!strings.ContainsAny(r.Name, `/\`) && strings.IndexFunc(r.Name, unicode.IsControl) < 0,
+		"name", "name must be a file name without a path")
+	return validationError(v)
+}
+
+// Limits applied to the uploads
+type AttachmentLimits struct {
+	// Maximum size of the content in bytes
+	MaxSize int64
+	// Accepted media types, a type ending with /* accepts every subtype
+	Types []string
+}
+
+func (l AttachmentLimits) Allows(contentType string) bool {
+	for _, t := range l.Types {
+		if strings.EqualFold(t, contentType) {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(t, "*"); ok && strings.HasSuffix(prefix, "/") && strings.HasPrefix(strings.ToLower(contentType), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
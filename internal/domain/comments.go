@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/validate"
+)
+
+var (
+	ErrCommentNotFound  = NotFound("comment not found")
+	ErrNotCommentAuthor = Forbidden("only the author of a comment can edit or delete it")
+	ErrInvalidReply     = Invalid("parentId", "parentId must be a comment of the same task")
+)
+
+const maxCommentLength = 10000
+
+// Comments are scoped to a task of a project, GetProjectRole must be used to make sure
+// the user is allowed to touch the project before calling the other methods
+type CommentStorage interface {
+	GetProjectRole(projectId int, cognitoId string) (Role, error)
+	// Returns the ids of the users with any role in the project among the cognitoIds
+	GetProjectMembers(projectId int, cognitoIds []string) ([]int, error)
+	// Every comment of the task in the order they were written, replies included.
+	// Fails with ErrTaskNotFound when the task is not a task of the project
+	GetComments(projectId, taskId int) ([]Comment, error)
+	GetComment(projectId, taskId, commentId int) (Comment, error)
+	// Previous bodies of the comment, oldest first
+	GetCommentEdits(projectId, taskId, commentId int) ([]CommentEdit, error)
+	// Records r.Mentions with the comment. A reply must answer a comment of the same task,
+	// ErrInvalidReply otherwise
+	CreateComment(*CommentRequest) (Comment, error)
+	// Replaces the body and mentions, the previous body is kept in the edit history.
+	// Mentions already recorded keep the time they were first made
+	UpdateComment(commentId int, r *CommentRequest) (Comment, error)
+	// A comment having replies is kept without its body, mentions and history so the
+	// thread can still be followed, otherwise it is removed
+	DeleteComment(projectId, taskId, commentId int) error
+	// Mentions of the user in the projects it can still access, newest first.
+	// Only the mentions made after since are returned unless it is nil
+	GetMentions(cognitoId string, since *time.Time) ([]Mention, error)
+}
+
+// Reading comments requires ProjectReadRole and writing them ProjectWriteRole,
+// only their author can edit or delete them
+type ICommentService interface {
+	// Threads of the task, replies are nested in the comment they answer
+	GetComments(projectId, taskId int, cognitoId string) ([]Comment, error)
+	GetComment(projectId, taskId, commentId int, cognitoId string) (Comment, error)
+	GetCommentEdits(projectId, taskId, commentId int, cognitoId string) ([]CommentEdit, error)
+	CreateComment(*CommentRequest) (Comment, error)
+	UpdateComment(commentId int, r *CommentRequest) (Comment, error)
+	DeleteComment(projectId, taskId, commentId int, cognitoId string) error
+	GetMentions(cognitoId string, since *time.Time) ([]Mention, error)
+}
+
+type Comment struct {
+	Id     int `json:"id"`
+	TaskId int `json:"taskId"`
+	// Comment this one replies to, nil for the first comment of a thread
+	ParentId *int `json:"parentId"`
+	AuthorId int  `json:"authorId"`
+	// CognitoId of the author, the handle other users mention it with
+	Author string `json:"author"`
+	// Markdown as written by the author, rendering and sanitizing it is left to the clients
+	Body string `json:"body"`
+	// Ids of the project members mentioned in the body, in ascending order
+	Mentions  []int      `json:"mentions"`
+	CreatedAt time.Time  `json:"createdAt"`
+	EditedAt  *time.Time `json:"editedAt"`
+	// Deleted comments are only kept while they have replies, their body is empty
+	Deleted bool `json:"deleted"`
+	// Nested replies, only filled when the comments are listed as threads
+	Replies []Comment `json:"replies,omitempty"`
+}
+
+// Body of a comment before an edit, WrittenAt is when that body was written
+type CommentEdit struct {
+	Body       string    `json:"body"`
+	WrittenAt  time.Time `json:"writtenAt"`
+	ReplacedAt time.Time `json:"replacedAt"`
+}
+
+// Mention of a user in a comment, what notifications are built from
+type Mention struct {
+	CommentId int    `json:"commentId"`
+	TaskId    int    `json:"taskId"`
+	ProjectId int    `json:"projectId"`
+	Author    string `json:"author"`
+	// When the user was first mentioned in the comment
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Request to write a comment, only the body of an existing comment can be edited
+type CommentRequest struct {
+	Body          string `json:"body"`
+	ParentId      *int   `json:"parentId"`
+	ProjectId     int    `json:"-"`
+	TaskId        int    `json:"-"`
+	UserCognitoId string `json:"-"`
+	// Ids of the users mentioned in the body, resolved by the service
+	Mentions []int `json:"-"`
+}
+
+// The body is stored as it is sent, it only has to contain something else than spaces
+func (r *CommentRequest) Validate() error {
+	v := new(validate.Validator)
+	v.Required("body", strings.TrimSpace(r.Body))
+	v.MaxLength("body", r.Body, maxCommentLength)
+	return validationError(v)
+}
@@ -0,0 +1,154 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Allowed values of the enums, in the order they are listed to the clients
+var (
+	Priorities = []Priority{High, Medium, Low}
+	Statuses   = []Status{Pending, InProgress, Done}
+	Roles      = []Role{RoleOwner, RoleAdmin, RoleMember, RoleViewer}
+)
+
+var (
+	ErrInvalidPriority = Invalid("priority", "priority must be one of High, Medium, Low")
+	ErrInvalidStatus   = Invalid("status", "status must be one of Pending, InProgress, Done")
+)
+
+// ParsePriority accepts any casing of the priority names, with or without a "domain." prefix,
+// and ignores spaces, dashes and underscores. The empty string parses to the empty Priority
+// so callers can apply their own default
+func ParsePriority(s string) (Priority, error) {
+	if s == "" {
+		return "", nil
+	}
+	for _, p := range Priorities {
+		if enumKey(s) == enumKey(string(p)) {
+			return p, nil
+		}
+	}
+	return "", ErrInvalidPriority
+}
+
+// ParseStatus follows the same rules as ParsePriority, "In Progress" and "in_progress" are InProgress
+func ParseStatus(s string) (Status, error) {
+	if s == "" {
+		return "", nil
+	}
+	for _, st := range Statuses {
+		if enumKey(s) == enumKey(string(st)) {
+			return st, nil
+		}
+	}
+	return "", ErrInvalidStatus
+}
+
+func enumKey(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimPrefix(s, "domain.")
+	return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(s)
+}
+
+func (p Priority) Valid() bool {
+	for _, v := range Priorities {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(p))
+}
+
+func (p *Priority) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return ErrInvalidPriority
+	}
+	parsed, err := ParsePriority(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+func (p *Priority) Scan(src any) error {
+	s, err := scanEnum(src)
+	if err != nil {
+		return err
+	}
+	*p, err = ParsePriority(s)
+	return err
+}
+
+// Only the canonical names are written, the column is a priority enum
+func (p Priority) Value() (driver.Value, error) {
+	if !p.Valid() {
+		return nil, ErrInvalidPriority
+	}
+	return string(p), nil
+}
+
+func (s Status) Valid() bool {
+	for _, v := range Statuses {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(s))
+}
+
+func (s *Status) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return ErrInvalidStatus
+	}
+	parsed, err := ParseStatus(str)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+func (s *Status) Scan(src any) error {
+	str, err := scanEnum(src)
+	if err != nil {
+		return err
+	}
+	*s, err = ParseStatus(str)
+	return err
+}
+
+// Only the canonical names are written, the column is a status enum
+func (s Status) Value() (driver.Value, error) {
+	if !s.Valid() {
+		return nil, ErrInvalidStatus
+	}
+	return string(s), nil
+}
+
+// Postgres returns enum columns as bytes or strings depending on the query, NULL as nil
+func scanEnum(src any) (string, error) {
+	switch v := src.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	default:
+		return "", fmt.Errorf("cannot scan %T into an enum", src)
+	}
+}
@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Status
+	}{
+		{"", ""},
+		{"Pending", Pending},
+		{"pending", Pending},
+		{"  PENDING\t", Pending},
+		{"InProgress", InProgress},
+		{"In Progress", InProgress},
+		{"in_progress", InProgress},
+		{"in-progress", InProgress},
+		{"IN PROGRESS", InProgress},
+		{"domain.InProgress", InProgress},
+		{"Domain.done", Done},
+		{"done", Done},
+	}
+	for _, c := range cases {
+		got, err := ParseStatus(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("ParseStatus(%q) = %q, %v, want %q", c.in, got, err, c.want)
+		}
+	}
+
+	for _, in := range []string{"Finished", "In Progres", "pkg.Done", "   ", "Done!"} {
+		_, err := ParseStatus(in)
+		if !errors.Is(err, ErrInvalidStatus) {
+			t.Errorf("ParseStatus(%q) returned %v, want %v", in, err, ErrInvalidStatus)
+		}
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Priority
+	}{
+		{"", ""},
+		{"High", High},
+		{"high", High},
+		{" MEDIUM ", Medium},
+		{"me-di_um", Medium},
+		{"domain.Low", Low},
+		{"DOMAIN.LOW", Low},
+	}
+	for _, c := range cases {
+		got, err := ParsePriority(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("ParsePriority(%q) = %q, %v, want %q", c.in, got, err, c.want)
+		}
+	}
+
+	for _, in := range []string{"Urgent", "Hi", "domain", "1"} {
+		_, err := ParsePriority(in)
+		if !errors.Is(err, ErrInvalidPriority) {
+			t.Errorf("ParsePriority(%q) returned %v, want %v", in, err, ErrInvalidPriority)
+		}
+	}
+}
+
+// The messages and fields are shown to the clients as they are
+func TestEnumErrors(t *testing.T) {
+	cases := []struct {
+		err   error
+		field string
+		msg   string
+	}{
+		{ErrInvalidStatus, "status", "status must be one of Pending, InProgress, Done"},
+		{ErrInvalidPriority, "priority", "priority must be one of High, Medium, Low"},
+	}
+	for _, c := range cases {
+		var e *Error
+		if !errors.As(c.err, &e) || e.Kind != KindValidation {
+			t.Fatalf("got %#v, want a validation error", c.err)
+		}
+		if e.Error() != c.msg || len(e.Fields) != 1 || e.Fields[0].Field != c.field || e.Fields[0].Message != c.msg {
+			t.Errorf("got error %q on fields %+v, want %q on %s", e.Error(), e.Fields, c.msg, c.field)
+		}
+	}
+}
+
+func TestEnumJSON(t *testing.T) {
+	var task struct {
+		Status   Status   `json:"status"`
+		Priority Priority `json:"priority"`
+	}
+	if err := json.Unmarshal([]byte(`{"status":"in progress","priority":"HIGH"}`), &task); err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != InProgress || task.Priority != High {
+		t.Fatalf("got %+v", task)
+	}
+	b, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"status":"InProgress","priority":"High"}` {
+		t.Fatalf("got %s, the canonical names must be written", b)
+	}
+
+	if err := json.Unmarshal([]byte(`{"status":"archived"}`), &task); !errors.Is(err, ErrInvalidStatus) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidStatus)
+	}
+	if err := json.Unmarshal([]byte(`{"priority":3}`), &task); !errors.Is(err, ErrInvalidPriority) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidPriority)
+	}
+}
@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Desgue/ttracker-api/internal/validate"
+)
+
+// Returned by the writes of versioned resources given a version that is not the current one
+var ErrVersionMismatch = PreconditionFailed("the resource was modified since it was read")
+
+// Kind of a domain error, the api uses it to pick the status code of the response
+type ErrorKind string
+
+const (
+	KindNotFound     ErrorKind = "NotFound"
+	KindForbidden    ErrorKind = "Forbidden"
+	KindConflict     ErrorKind = "Conflict"
+	KindValidation   ErrorKind = "Validation"
+	KindUnauthorized ErrorKind = "Unauthorized"
+	// The resource changed since the version the client based its request on
+	KindPreconditionFailed ErrorKind = "PreconditionFailed"
+	// Uploaded content over the size limit or of a type that is not accepted
+	KindTooLarge             ErrorKind = "TooLarge"
+	KindUnsupportedMediaType ErrorKind = "UnsupportedMediaType"
+)
+
+// Error is returned by the stores and services for every failure the client can act on,
+// any other error is an internal failure. The exported Err values are *Error sentinels
+// so they can still be matched with errors.Is
+type Error struct {
+	Kind ErrorKind
+	Msg  string
+	// Only set on validation errors, the fields of the request that are invalid
+	Fields []FieldError
+}
+
+type FieldError = validate.FieldError
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func NotFound(msg string) *Error {
+	return &Error{Kind: KindNotFound, Msg: msg}
+}
+
+func Forbidden(msg string) *Error {
+	return &Error{Kind: KindForbidden, Msg: msg}
+}
+
+func Conflict(msg string) *Error {
+	return &Error{Kind: KindConflict, Msg: msg}
+}
+
+func Unauthorized(msg string) *Error {
+	return &Error{Kind: KindUnauthorized, Msg: msg}
+}
+
+func PreconditionFailed(msg string) *Error {
+	return &Error{Kind: KindPreconditionFailed, Msg: msg}
+}
+
+func TooLarge(msg string) *Error {
+	return &Error{Kind: KindTooLarge, Msg: msg}
+}
+
+func UnsupportedMediaType(msg string) *Error {
+	return &Error{Kind: KindUnsupportedMediaType, Msg: msg}
+}
+
+// Invalid is a validation error about a single field of the request
+func Invalid(field, msg string) *Error {
+	return &Error{Kind: KindValidation, Msg: msg, Fields: []FieldError{{Field: field, Message: msg}}}
+}
+
+// NewValidationError gathers the errors of several fields, the message lists all of them
+func NewValidationError(fields ...FieldError) *Error {
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return &Error{Kind: KindValidation, Msg: strings.Join(msgs, ", "), Fields: fields}
+}
+
+// Turns the errors gathered by v into a validation error, nil when every check passed
+func validationError(v *validate.Validator) error {
+	if v.Valid() {
+		return nil
+	}
+	return NewValidationError(v.Errors()...)
+}
+
+// AsError returns the first domain error of the chain, nil for internal errors
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"regexp"
+
+	"github.com/Desgue/ttracker-api/internal/validate"
+)
+
+var (
+	ErrLabelNotFound     = NotFound("label not found")
+	ErrLabelExists       = Conflict("the project already has a label with this name, merge the labels instead")
+	ErrInvalidLabel      = Invalid("labels", "labels must be labels of the task project")
+	ErrMergeLabelItself  = Invalid("into", "a label can not be merged into itself")
+	ErrInvalidLabelColor = Invalid("color", "color must be a hex color like #1f6feb")
+)
+
+// Color of the labels created without one
+const DefaultLabelColor = "#808080"
+
+const maxLabelNameLength = 50
+
+var labelColorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// Every project has its own catalog of labels, names are unique within a project
+// regardless of their case. GetProjectRole must be used to make sure the user is allowed
+// to touch the project before calling the other methods
+type LabelStorage interface {
+	GetProjectRole(projectId int, cognitoId string) (Role, error)
+	// Labels of the project ordered by name
+	GetLabels(projectId int) ([]Label, error)
+	GetLabel(projectId, labelId int) (Label, error)
+	// Creating or renaming a label fails with ErrLabelExists when the name is taken
+	CreateLabel(*LabelRequest) (Label, error)
+	UpdateLabel(labelId int, r *LabelRequest) (Label, error)
+	// Removes the label from the tasks tagged with it, their version is incremented
+	DeleteLabel(projectId, labelId int) error
+	// Tags the tasks tagged with labelId with intoId instead and deletes labelId,
+	// returns the label merged into
+	MergeLabel(projectId, labelId, intoId int) (Label, error)
+}
+
+// Reading the catalog requires ProjectReadRole, creating and editing labels ProjectWriteRole,
+// deleting and merging them ProjectManageRole since it changes the tasks of every member
+type ILabelService interface {
+	GetLabels(projectId int, cognitoId string) ([]Label, error)
+	GetLabel(projectId, labelId int, cognitoId string) (Label, error)
+	CreateLabel(*LabelRequest) (Label, error)
+	UpdateLabel(labelId int, r *LabelRequest) (Label, error)
+	DeleteLabel(projectId, labelId int, cognitoId string) error
+	MergeLabel(projectId, labelId, intoId int, cognitoId string) (Label, error)
+}
+
+type Label struct {
+	Id        int    `json:"id"`
+	ProjectId int    `json:"projectId"`
+	Name      string `json:"name"`
+	Color     string `json:"color"`
+	// Number of tasks tagged with the label
+	Tasks int `json:"tasks"`
+}
+
+type LabelRequest struct {
+	Name          string `json:"name"`
+	Color         string `json:"color"`
+	ProjectId     int    `json:"-"`
+	UserCognitoId string `json:"-"`
+}
+
+// The color must be lowercased and an empty one defaulted first
+func (r *LabelRequest) Validate() error {
+	v := new(validate.Validator)
+	v.Required("name", r.Name)
+	v.MaxLength("name", r.Name, maxLabelNameLength)
+	v.Check(labelColorPattern.MatchString(r.Color), "color", ErrInvalidLabelColor.Msg)
+	return validationError(v)
+}
@@ -0,0 +1,124 @@
+package domain
+
+import "time"
+
+// Field a list is sorted by, ties are broken by the id of the items so the order is total
+type SortField string
+
+const (
+	SortCreatedAt SortField = "createdAt"
+	SortTitle     SortField = "title"
+	SortPriority  SortField = "priority"
+	SortStatus    SortField = "status"
+	SortDueDate   SortField = "dueDate"
+)
+
+// Fields each list can be sorted by, the first one is the default
+var (
+	ProjectSortFields = []SortField{SortCreatedAt, SortTitle, SortPriority}
+	TaskSortFields    = []SortField{SortCreatedAt, SortTitle, SortStatus, SortDueDate}
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 100
+)
+
+// Keyset pagination of a list. Items are ordered by Sort, createdAt when empty, then by id,
+// and the page starts right after the item After points to. A zero Limit returns every item
+type Page struct {
+	Limit int
+	Sort  SortField
+	Desc  bool
+	After *Cursor
+}
+
+// Position of an item in a sorted list, Key is the SortKey of the item for the sort of the page
+type Cursor struct {
+	Key string
+	Id  int
+}
+
+// Times are written with a fixed width in UTC so their keys compare in the same order as the times
+const sortKeyTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Items without a due date come after every other item in ascending order,
+// Postgres reads this key as the infinite timestamp
+const noDueDateKey = "infinity"
+
+// Priorities and statuses compare in the order of Priorities and Statuses, the other
+// keys compare as strings
+func (p Project) SortKey(field SortField) string {
+	switch field {
+	case SortTitle:
+		return p.Title
+	case SortPriority:
+		return string(p.Priority)
+	default:
+		return timeKey(p.CreatedAt)
+	}
+}
+
+func (t Task) SortKey(field SortField) string {
+	switch field {
+	case SortTitle:
+		return t.Title
+	case SortStatus:
+		return string(t.Status)
+	case SortDueDate:
+		if t.DueDate == nil {
+			return noDueDateKey
+		}
+		return timeKey(*t.DueDate)
+	default:
+		return timeKey(t.CreatedAt)
+	}
+}
+
+func timeKey(t time.Time) string {
+	return t.UTC().Format(sortKeyTimeLayout)
+}
+
+// CompareSortKeys orders two keys of field, it returns a negative number when a comes first
+func CompareSortKeys(field SortField, a, b string) int {
+	switch field {
+	case SortPriority:
+		return enumRank(Priorities, Priority(a)) - enumRank(Priorities, Priority(b))
+	case SortStatus:
+		return enumRank(Statuses, Status(a)) - enumRank(Statuses, Status(b))
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func enumRank[T comparable](values []T, v T) int {
+	for i, value := range values {
+		if v == value {
+			return i
+		}
+	}
+	return len(values)
+}
+
+// Checks the key of a cursor can be compared with the keys of field, cursors come from the clients
+func ValidSortKey(field SortField, key string) bool {
+	switch field {
+	case SortTitle:
+		return true
+	case SortPriority:
+		return Priority(key).Valid()
+	case SortStatus:
+		return Status(key).Valid()
+	case SortDueDate:
+		if key == noDueDateKey {
+			return true
+		}
+	}
+	_, err := time.Parse(sortKeyTimeLayout, key)
+	return err == nil
+}
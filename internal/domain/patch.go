@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Field of a patch request, Set is false when the patch leaves the field untouched.
+// A JSON null sets the field to its zero value, clearing the nullable fields
+type Optional[T any] struct {
+	Set   bool
+	Value T
+}
+
+func (o *Optional[T]) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	if bytes.Equal(b, []byte("null")) {
+		var zero T
+		o.Value = zero
+		return nil
+	}
+	return json.Unmarshal(b, &o.Value)
+}
+
+// Returns the patched value, or current when the patch does not touch the field
+func (o Optional[T]) Or(current T) T {
+	if o.Set {
+		return o.Value
+	}
+	return current
+}
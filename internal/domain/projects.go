@@ -1,6 +1,24 @@
 package domain
 
-import "time"
+import (
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/validate"
+)
+
+var (
+	ErrProjectNotFound  = NotFound("project not found")
+	ErrForbidden        = Forbidden("user is not allowed to access this project")
+	ErrInvalidShareRole = Invalid("role", "invalid share role, must be Admin, Member or Viewer")
+)
+
+// Minimum role a user needs in a project for each kind of operation
+const (
+	ProjectReadRole   = RoleViewer
+	ProjectWriteRole  = RoleMember
+	ProjectManageRole = RoleAdmin
+	ProjectDeleteRole = RoleOwner
+)
 
 // This type is used to define the priority of a project as a Iota
 const (
@@ -12,20 +30,45 @@ const (
 type Priority string
 
 // This is the interface that the service will use to interact with the database
+// A user can reach a project as its creator, as a member of the team owning it or through a share.
+// GetProjects and GetProjectById fill the Role field with the effective role of the user
 type ProjectStorage interface {
-	GetProjects(userId string) ([]Project, error)
+	// Returns ErrProjectNotFound if the project does not exist and ErrForbidden if the user has no role in it
+	GetProjectRole(projectId, cognitoId string) (Role, error)
+	// Returns at most page.Limit projects matching the filter, in the order of the page
+	GetProjects(cognitoId string, filter ProjectFilter, page Page) ([]Project, error)
 	GetProjectById(projectId, cognitoId string) (Project, error)
-	CreateProject(*CreateProjectRequest) error
-	UpdateProject(string, *CreateProjectRequest) error
-	DeleteProject(projectId, cognitoId string) error
+	// Creating, updating or patching a project returns it with the role of r.UserCognitoId.
+	// The writes fail with ErrVersionMismatch when given a version other than the current one,
+	// a zero version skips the check
+	CreateProject(*CreateProjectRequest) (Project, error)
+	UpdateProject(projectId string, r *CreateProjectRequest) (Project, error)
+	PatchProject(projectId string, r *PatchProjectRequest) (Project, error)
+	DeleteProject(projectId string, version int) error
+	GetShares(projectId string) ([]ProjectShare, error)
+	// Shares the project with the user or updates the role of an existing share
+	ShareProject(projectId string, userId int, role Role) error
+	RemoveShare(projectId string, userId int) error
 }
 
 type IProjectService interface {
-	GetProjects(userId string) ([]Project, error)
-	CreateProject(*CreateProjectRequest) error
+	// Returns a page of projects and the cursor of the next one, nil on the last page
+	GetProjects(cognitoId string, filter ProjectFilter, page Page) ([]Project, *Cursor, error)
+	CreateProject(*CreateProjectRequest) (Project, error)
 	GetProjectById(projectId, cognitoId string) (Project, error)
-	UpdateProject(string, *CreateProjectRequest) error
-	DeleteProject(projectId, cognitoId string) error
+	UpdateProject(projectId string, r *CreateProjectRequest) (Project, error)
+	PatchProject(projectId string, r *PatchProjectRequest) (Project, error)
+	DeleteProject(projectId, cognitoId string, version int) error
+	GetShares(projectId, cognitoId string) ([]ProjectShare, error)
+	ShareProject(*ShareProjectRequest) error
+	RemoveShare(projectId string, userId int, cognitoId string) error
+}
+
+// Optional filters for listing projects, zero values are ignored
+type ProjectFilter struct {
+	Priority      Priority
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
 }
 
 // This struct hold the project's tasks received from the database
@@ -37,6 +80,27 @@ type Project struct {
 	Priority    Priority  `json:"priority"`
 	CreatedAt   time.Time `json:"createdAt"`
 	UserId      string    `json:"userId"`
+	// Team owning the project, nil for personal projects
+	TeamId *int `json:"teamId"`
+	// Effective role of the requesting user
+	Role Role `json:"role,omitempty"`
+	// Incremented on every write, sent as the ETag
+	Version int `json:"-"`
+}
+
+type ProjectShare struct {
+	ProjectId int       `json:"projectId"`
+	UserId    int       `json:"userId"`
+	CognitoId string    `json:"cognitoId"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ShareProjectRequest struct {
+	ProjectId     string `json:"projectId"`
+	UserId        int    `json:"userId"`
+	Role          Role   `json:"role"`
+	UserCognitoId string `json:"userCognitoId"`
 }
 
 // This struct is used for holding the request data for creating a new project
@@ -45,6 +109,44 @@ type CreateProjectRequest struct {
 	Description   string   `json:"description"`
 	Priority      Priority `json:"priority"`
 	UserCognitoId string   `json:"userCognitoId"`
+	// Set to create the project inside a team, only used on creation
+	TeamId *int `json:"teamId"`
+	// Version the update is based on, zero to overwrite any version
+	Version int `json:"-"`
+}
+
+// Titles are stored in a varchar(255) column
+const maxTitleLength = 255
+
+// Reports every invalid field of the request, an empty priority must be defaulted first
+func (r *CreateProjectRequest) Validate() error {
+	v := new(validate.Validator)
+	v.Required("title", r.Title)
+	v.MaxLength("title", r.Title, maxTitleLength)
+	v.Check(r.Priority.Valid(), "priority", ErrInvalidPriority.Msg)
+	return validationError(v)
+}
+
+// Partial update of a project, only the fields set are written
+type PatchProjectRequest struct {
+	Title         Optional[string]   `json:"title"`
+	Description   Optional[string]   `json:"description"`
+	Priority      Optional[Priority] `json:"priority"`
+	UserCognitoId string             `json:"-"`
+	// Version the patch is based on, zero to patch any version
+	Version int `json:"-"`
+}
+
+func (r *PatchProjectRequest) Validate() error {
+	v := new(validate.Validator)
+	if r.Title.Set {
+		v.Required("title", r.Title.Value)
+		v.MaxLength("title", r.Title.Value, maxTitleLength)
+	}
+	if r.Priority.Set {
+		v.Check(r.Priority.Value.Valid(), "priority", ErrInvalidPriority.Msg)
+	}
+	return validationError(v)
 }
 
 func NewCreateProjectRequest(title, desc string, priority Priority) *CreateProjectRequest {
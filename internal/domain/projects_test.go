@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Checks err is a validation error listing exactly the want fields, in order
+func mustFailFields(t *testing.T, err error, want ...FieldError) {
+	t.Helper()
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != KindValidation {
+		t.Fatalf("got error %v, want a validation error", err)
+	}
+	if !reflect.DeepEqual(e.Fields, want) {
+		t.Fatalf("got fields %+v, want %+v", e.Fields, want)
+	}
+	msgs := make([]string, len(want))
+	for i, f := range want {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	if e.Msg != strings.Join(msgs, ", ") {
+		t.Fatalf("got message %q", e.Msg)
+	}
+}
+
+func TestCreateProjectRequestValidate(t *testing.T) {
+	r := &CreateProjectRequest{Title: "project", Priority: High}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+
+	r = &CreateProjectRequest{Title: "", Priority: "Urgent"}
+	mustFailFields(t, r.Validate(),
+		FieldError{Field: "title", Message: "title is required"},
+		FieldError{Field: "priority", Message: ErrInvalidPriority.Msg},
+	)
+
+	r = &CreateProjectRequest{Title: strings.Repeat("a", maxTitleLength+1), Priority: "Urgent"}
+	mustFailFields(t, r.Validate(),
+		FieldError{Field: "title", Message: "title must not be longer than 255 characters"},
+		FieldError{Field: "priority", Message: ErrInvalidPriority.Msg},
+	)
+}
+
+func TestPatchProjectRequestValidate(t *testing.T) {
+	// Fields that are not set are not checked
+	if err := new(PatchProjectRequest).Validate(); err != nil {
+		t.Fatalf("empty patch rejected: %v", err)
+	}
+	r := &PatchProjectRequest{Title: Optional[string]{Set: true}, Priority: Optional[Priority]{Set: true, Value: "Urgent"}}
+	mustFailFields(t, r.Validate(),
+		FieldError{Field: "title", Message: "title is required"},
+		FieldError{Field: "priority", Message: ErrInvalidPriority.Msg},
+	)
+}
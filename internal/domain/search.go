@@ -0,0 +1,44 @@
+package domain
+
+var ErrEmptySearch = Invalid("q", "q must contain at least one word to search for")
+
+type SearchKind string
+
+const (
+	SearchProject SearchKind = "project"
+	SearchTask    SearchKind = "task"
+)
+
+// Delimiters the stores put around the matched words of a snippet, the api turns them
+// into markup once the rest of the snippet is escaped
+const (
+	SnippetStart = "\x02"
+	SnippetStop  = "\x03"
+)
+
+// Searches the titles and descriptions of projects and tasks, scoped to the projects
+// the user has any role in. Results come best match first, then projects before tasks and by id
+type SearchStorage interface {
+	Search(cognitoId string, q SearchQuery) ([]SearchResult, error)
+}
+
+type ISearchService interface {
+	Search(cognitoId string, q SearchQuery) ([]SearchResult, error)
+}
+
+type SearchQuery struct {
+	// Words to look for, quoted phrases, OR and -word exclusions are understood like a web search
+	Text  string
+	Limit int
+}
+
+type SearchResult struct {
+	Type SearchKind `json:"type"`
+	Id   int        `json:"id"`
+	// Project of a task, the id itself for a project
+	ProjectId int    `json:"projectId"`
+	Title     string `json:"title"`
+	// Excerpt of the title and description with the matched words between SnippetStart and SnippetStop
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
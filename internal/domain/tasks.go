@@ -2,49 +2,193 @@ package domain
 
 import (
 	"time"
+
+	"github.com/Desgue/ttracker-api/internal/validate"
+)
+
+var (
+	ErrTaskNotFound    = NotFound("task not found")
+	ErrInvalidAssignee = Invalid("assignees", "assignees must be users with access to the project")
+	ErrInvalidParent   = Invalid("parentId", "parentId must be a task of the same project")
+	ErrTaskCycle       = Invalid("parentId", "a task can not be moved under itself or one of its subtasks")
 )
 
 const (
-	Pending    status = "Pending"
-	InProgress status = "InProgress"
-	Done       status = "Done"
+	Pending    Status = "Pending"
+	InProgress Status = "InProgress"
+	Done       Status = "Done"
 )
 
-type status string
+type Status string
 
+// Every task operation is scoped to a project, GetProjectRole must be used
+// to make sure the user is allowed to touch the project before calling the other methods
 type TaskStorage interface {
-	GetTasks(projectId int) ([]Task, error)
-	GetTaskById(string) (Task, error)
-	CreateTask(*CreateTaskRequest) error
-	UpdateTask(string, *CreateTaskRequest) error
-	DeleteTask(string) error
+	GetProjectRole(projectId int, cognitoId string) (Role, error)
+	// Returns the subset of userIds that have any role in the project
+	GetProjectUsers(projectId int, userIds []int) ([]int, error)
+	// Returns at most page.Limit tasks matching the filter, in the order of the page
+	GetTasks(projectId int, filter TaskFilter, page Page) ([]Task, error)
+	GetTaskById(projectId int, taskId string) (Task, error)
+	// Every subtask below the tasks at any depth, in the order they were created
+	GetDescendants(projectId int, taskIds []int) ([]Task, error)
+	// Tasks assigned to the user across every project it still has access to,
+	// ordered by project and status. The assignee fields of the filter are ignored
+	GetAssignedTasks(cognitoId string, filter TaskFilter) ([]AssignedTask, error)
+	// Creating or updating a task replaces its assignees with r.Assignees and its labels with r.Labels,
+	// every label must belong to the project of the task, ErrInvalidLabel otherwise.
+	// The writes fail with ErrVersionMismatch when given a version other than the current one,
+	// a zero version skips the check
+	CreateTask(*CreateTaskRequest) (Task, error)
+	UpdateTask(taskId string, r *CreateTaskRequest) (Task, error)
+	// Only writes the fields set in the patch, the assignees and labels are replaced when they are set.
+	// A new parent must be a task of the same project, ErrInvalidParent otherwise, and not the task
+	// itself or one of its subtasks, ErrTaskCycle otherwise
+	PatchTask(taskId string, r *PatchTaskRequest) (Task, error)
+	// The subtasks of a deleted task are moved to the top level
+	DeleteTask(projectId int, taskId string, version int) error
 }
 
 type ITaskService interface {
-	GetTasks(projectId int) ([]Task, error)
-	CreateTask(*CreateTaskRequest) (*CreateTaskRequest, error)
-	GetTaskById(string) (Task, error)
-	UpdateTask(string, *CreateTaskRequest) error
-	DeleteTask(string) error
+	// Returns a page of tasks and the cursor of the next one, nil on the last page
+	GetTasks(projectId int, cognitoId string, filter TaskFilter, page Page) ([]Task, *Cursor, error)
+	// Same as GetTasks for the top level tasks only, each one with its subtasks nested in Children
+	GetTaskTree(projectId int, cognitoId string, filter TaskFilter, page Page) ([]Task, *Cursor, error)
+	// Direct subtasks of the task, paginated like GetTasks
+	GetSubtasks(projectId int, taskId, cognitoId string, page Page) ([]Task, *Cursor, error)
+	GetAssignedTasks(cognitoId string, filter TaskFilter) ([]ProjectTasks, error)
+	CreateTask(*CreateTaskRequest) (Task, error)
+	GetTaskById(projectId int, taskId, cognitoId string) (Task, error)
+	UpdateTask(taskId string, r *CreateTaskRequest) (Task, error)
+	PatchTask(taskId string, r *PatchTaskRequest) (Task, error)
+	DeleteTask(projectId int, taskId, cognitoId string, version int) error
 }
 
 type CreateTaskRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Status      status `json:"status"`
-	ProjectId   int    `json:"projectId"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Status      Status     `json:"status"`
+	Assignees   []int      `json:"assignees"`
+	StartDate   *time.Time `json:"startDate"`
+	DueDate     *time.Time `json:"dueDate"`
+	ParentId    *int       `json:"parentId"`
+	Labels      []int      `json:"labels"`
+	// Taken from the path and the authenticated user, never from the body
+	ProjectId     int    `json:"-"`
+	UserCognitoId string `json:"-"`
+	// Version the update is based on, zero to overwrite any version
+	Version int `json:"-"`
+}
+
+// Reports every invalid field of the request, an empty status must be defaulted first
+func (r *CreateTaskRequest) Validate() error {
+	v := new(validate.Validator)
+	v.Required("title", r.Title)
+	v.MaxLength("title", r.Title, maxTitleLength)
+	v.Check(r.Status.Valid(), "status", ErrInvalidStatus.Msg)
+	v.Check(r.StartDate == nil || r.DueDate == nil || !r.StartDate.After(*r.DueDate), "startDate", "startDate must not be after dueDate")
+	return validationError(v)
+}
+
+// Partial update of a task, only the fields set are written
+type PatchTaskRequest struct {
+	Title         Optional[string]     `json:"title"`
+	Description   Optional[string]     `json:"description"`
+	Status        Optional[Status]     `json:"status"`
+	Assignees     Optional[[]int]      `json:"assignees"`
+	StartDate     Optional[*time.Time] `json:"startDate"`
+	DueDate       Optional[*time.Time] `json:"dueDate"`
+	ParentId      Optional[*int]       `json:"parentId"`
+	Labels        Optional[[]int]      `json:"labels"`
+	ProjectId     int                  `json:"-"`
+	UserCognitoId string               `json:"-"`
+	// Version the patch is based on, zero to patch any version
+	Version int `json:"-"`
+}
+
+// The dates are checked against the current ones of the task when only one of them is patched
+func (r *PatchTaskRequest) Validate(current Task) error {
+	v := new(validate.Validator)
+	if r.Title.Set {
+		v.Required("title", r.Title.Value)
+		v.MaxLength("title", r.Title.Value, maxTitleLength)
+	}
+	if r.Status.Set {
+		v.Check(r.Status.Value.Valid(), "status", ErrInvalidStatus.Msg)
+	}
+	start, due := r.StartDate.Or(current.StartDate), r.DueDate.Or(current.DueDate)
+	v.Check(start == nil || due == nil || !start.After(*due), "startDate", "startDate must not be after dueDate")
+	return validationError(v)
+}
+
+// Optional filters for listing the tasks of a project, zero values are ignored.
+// AssigneeCognitoId is used to resolve ?assignee=me without knowing the caller user id
+type TaskFilter struct {
+	AssigneeId        int
+	AssigneeCognitoId string
+	Status            Status
+	CreatedAfter      *time.Time
+	CreatedBefore     *time.Time
+	DueBefore         *time.Time
+	DueAfter          *time.Time
+	Overdue           *bool
+	// Only the tasks without a parent
+	TopLevel bool
+	// Only the direct subtasks of this task
+	ParentId int
+	// Only the tasks tagged with any of the labels, or with all of them when AllLabels is set
+	Labels    []int
+	AllLabels bool
 }
 
 type Task struct {
-	Id          int       `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Status      status    `json:"status"`
-	CreatedAt   time.Time `json:"createdAt"`
-	ProjectId   int       `json:"projectId"`
+	Id          int        `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Status      Status     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ProjectId   int        `json:"projectId"`
+	Assignees   []int      `json:"assignees"`
+	StartDate   *time.Time `json:"startDate"`
+	DueDate     *time.Time `json:"dueDate"`
+	Overdue     bool       `json:"overdue"`
+	// Task this one is a subtask of, nil at the top level
+	ParentId *int `json:"parentId"`
+	// Ids of the labels of the task in ascending order
+	Labels []int `json:"labels"`
+	// Completion of the direct subtasks, computed by the stores
+	Subtasks TaskProgress `json:"subtasks"`
+	// Nested subtasks, only filled when the tasks are listed as a tree
+	Children []Task `json:"children,omitempty"`
+	// Incremented on every write, sent as the ETag
+	Version int `json:"-"`
+}
+
+// Number of subtasks done out of the total, 3 of 5 done is {"done": 3, "total": 5}
+type TaskProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// A task is overdue when its due date has passed and it is not done yet,
+// stores filtering on overdue must use the same definition
+func (t Task) IsOverdue(now time.Time) bool {
+	return t.DueDate != nil && t.Status != Done && t.DueDate.Before(now)
+}
+
+type AssignedTask struct {
+	Task
+	ProjectTitle string
+}
+
+// Tasks of a single project grouped by their status, returned by /users/me/tasks
+type ProjectTasks struct {
+	ProjectId    int               `json:"projectId"`
+	ProjectTitle string            `json:"projectTitle"`
+	Tasks        map[Status][]Task `json:"tasks"`
 }
 
-func NewCreateTaskRequest(title, desc string, status status, projectId int) *CreateTaskRequest {
+func NewCreateTaskRequest(title, desc string, status Status, projectId int) *CreateTaskRequest {
 	return &CreateTaskRequest{
 		Title:       title,
 		Description: desc,
@@ -53,7 +197,7 @@ func NewCreateTaskRequest(title, desc string, status status, projectId int) *Cre
 	}
 }
 
-func NewTask(title, desc string, status status, projectId int, createdAt time.Time) *Task {
+func NewTask(title, desc string, status Status, projectId int, createdAt time.Time) *Task {
 	return &Task{
 		Title:       title,
 		Description: desc,
@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateTaskRequestValidate(t *testing.T) {
+	start := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	due := start.Add(-time.Hour)
+
+	r := &CreateTaskRequest{Title: "task", Status: Pending, StartDate: &due, DueDate: &start}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+	// The dates may be equal
+	r = &CreateTaskRequest{Title: "task", Status: Done, StartDate: &start, DueDate: &start}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("task starting when it is due rejected: %v", err)
+	}
+
+	r = &CreateTaskRequest{Title: "", Status: "Finished", StartDate: &start, DueDate: &due}
+	mustFailFields(t, r.Validate(),
+		FieldError{Field: "title", Message: "title is required"},
+		FieldError{Field: "status", Message: ErrInvalidStatus.Msg},
+		FieldError{Field: "startDate", Message: "startDate must not be after dueDate"},
+	)
+
+	// The status must be defaulted before validating
+	r = &CreateTaskRequest{Title: strings.Repeat("é", maxTitleLength+1)}
+	mustFailFields(t, r.Validate(),
+		FieldError{Field: "title", Message: "title must not be longer than 255 characters"},
+		FieldError{Field: "status", Message: ErrInvalidStatus.Msg},
+	)
+}
+
+func TestPatchTaskRequestValidate(t *testing.T) {
+	start := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	due := start.Add(24 * time.Hour)
+	current := Task{StartDate: &start, DueDate: &due}
+
+	if err := new(PatchTaskRequest).Validate(current); err != nil {
+		t.Fatalf("empty patch rejected: %v", err)
+	}
+
+	// A single patched date is checked against the current other one
+	late := due.Add(time.Hour)
+	r := &PatchTaskRequest{
+		Title:     Optional[string]{Set: true},
+		Status:    Optional[Status]{Set: true, Value: "Finished"},
+		StartDate: Optional[*time.Time]{Set: true, Value: &late},
+	}
+	mustFailFields(t, r.Validate(current),
+		FieldError{Field: "title", Message: "title is required"},
+		FieldError{Field: "status", Message: ErrInvalidStatus.Msg},
+		FieldError{Field: "startDate", Message: "startDate must not be after dueDate"},
+	)
+
+	// Clearing the due date lifts the constraint
+	r = &PatchTaskRequest{StartDate: Optional[*time.Time]{Set: true, Value: &late}, DueDate: Optional[*time.Time]{Set: true}}
+	if err := r.Validate(current); err != nil {
+		t.Fatalf("start date without a due date rejected: %v", err)
+	}
+}
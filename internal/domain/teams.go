@@ -1,20 +1,63 @@
 package domain
 
-import "errors"
+import "time"
+
+// Team invitations are sent to the invited user and always start with this prefix
+const TeamInvitationPrefix = "inv_"
+
+const (
+	RoleOwner  Role = "Owner"
+	RoleAdmin  Role = "Admin"
+	RoleMember Role = "Member"
+	RoleViewer Role = "Viewer"
+)
+
+// Role of a user inside a team, from the most to the least privileged: Owner, Admin, Member, Viewer
+type Role string
 
 var (
-	ErrInvalidTeamName  = errors.New("invalid team name")
-	ErrInvalidTeamAdmin = errors.New("invalid team admin")
+	ErrInvalidTeamName      = Invalid("name", "invalid team name")
+	ErrInvalidTeamAdmin     = Invalid("userCognitoId", "invalid team admin")
+	ErrInvalidTeamRole      = Invalid("role", "invalid team role")
+	ErrTeamNotFound         = NotFound("team not found")
+	ErrTeamForbidden        = Forbidden("user is not allowed to manage this team")
+	ErrTeamMemberNotFound   = NotFound("team member not found")
+	ErrAlreadyTeamMember    = Conflict("user is already a member of this team")
+	ErrLastTeamOwner        = Conflict("a team must keep at least one owner")
+	ErrInvitationNotFound   = NotFound("invitation not found, expired or already accepted")
+	ErrInvalidInvitationTTL = Invalid("expiresAt", "invitation expiry must be in the future")
+	ErrUserNotFound         = NotFound("user not found")
 )
 
 type TeamStorage interface {
 	GetTeam(id int) (Team, error)
+	GetTeams(cognitoId string) ([]Team, error)
 	CreateTeam(*CreateTeamRequest) error
+	// Returns ErrTeamNotFound if the team does not exist and ErrTeamMemberNotFound if the user is not a member
+	GetMemberRole(teamId int, cognitoId string) (Role, error)
+	GetMember(teamId, userId int) (TeamMember, error)
+	GetMembers(teamId int) ([]TeamMember, error)
+	CountOwners(teamId int) (int, error)
+	AddMember(teamId, userId int, role Role) error
+	// Both return ErrLastTeamOwner rather than leave the team without an owner, the check and
+	// the write are atomic so owners demoting or removing each other can not both succeed
+	UpdateMemberRole(teamId, userId int, role Role) error
+	RemoveMember(teamId, userId int) error
+	CreateInvitation(r *CreateTeamInvitationRequest, tokenHash string) (TeamInvitation, error)
+	// Marks the invitation as accepted and adds the user to the team in a single transaction
+	AcceptInvitation(tokenHash, cognitoId string) (TeamMember, error)
 }
 
 type ITeamService interface {
-	GetTeam(id int) (Team, error)
+	GetTeam(teamId int, cognitoId string) (Team, error)
+	GetTeams(cognitoId string) ([]Team, error)
 	CreateTeam(*CreateTeamRequest) error
+	GetMembers(teamId int, cognitoId string) ([]TeamMember, error)
+	AddMember(*TeamMemberRequest) error
+	UpdateMemberRole(*TeamMemberRequest) error
+	RemoveMember(teamId, userId int, cognitoId string) error
+	CreateInvitation(*CreateTeamInvitationRequest) (TeamInvitation, error)
+	AcceptInvitation(token, cognitoId string) (TeamMember, error)
 }
 
 type Team struct {
@@ -22,20 +65,80 @@ type Team struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	AdminId     int    `json:"adminId"`
+	// Role of the requesting user, only set when listing the user teams
+	Role Role `json:"role,omitempty"`
+}
+
+type TeamMember struct {
+	TeamId    int       `json:"teamId"`
+	UserId    int       `json:"userId"`
+	CognitoId string    `json:"cognitoId"`
+	Role      Role      `json:"role"`
+	JoinedAt  time.Time `json:"joinedAt"`
+}
+
+type TeamInvitation struct {
+	Id        int       `json:"id"`
+	TeamId    int       `json:"teamId"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Only returned when the invitation is created
+	Token string `json:"token,omitempty"`
 }
 
+// The user creating the team becomes its admin and first owner
 type CreateTeamRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	AdminId     int    `json:"adminId"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	UserCognitoId string `json:"userCognitoId"`
+}
+
+// Used to add a member to a team or to change the role of a member
+type TeamMemberRequest struct {
+	TeamId        int    `json:"teamId"`
+	UserId        int    `json:"userId"`
+	Role          Role   `json:"role"`
+	UserCognitoId string `json:"userCognitoId"`
+}
+
+type CreateTeamInvitationRequest struct {
+	TeamId        int        `json:"teamId"`
+	Role          Role       `json:"role"`
+	ExpiresAt     *time.Time `json:"expiresAt"`
+	UserCognitoId string     `json:"userCognitoId"`
 }
 
 func (t *CreateTeamRequest) Validate() error {
 	if t.Name == "" {
 		return ErrInvalidTeamName
 	}
-	if t.AdminId == 0 {
+	if t.UserCognitoId == "" {
 		return ErrInvalidTeamAdmin
 	}
 	return nil
 }
+
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// AtLeast reports if the role has the same or more privileges than the other role
+func (r Role) AtLeast(other Role) bool {
+	return r.rank() >= other.rank()
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleOwner:
+		return 4
+	case RoleAdmin:
+		return 3
+	case RoleMember:
+		return 2
+	case RoleViewer:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,100 @@
+package domain
+
+import "time"
+
+// Personal access tokens are sent as bearer tokens and always start with this prefix
+const AccessTokenPrefix = "tsk_"
+
+const (
+	ScopeProjectsRead  = "projects:read"
+	ScopeProjectsWrite = "projects:write"
+	ScopeTasksRead     = "tasks:read"
+	ScopeTasksWrite    = "tasks:write"
+)
+
+var Scopes = []string{ScopeProjectsRead, ScopeProjectsWrite, ScopeTasksRead, ScopeTasksWrite}
+
+var (
+	ErrAccessTokenNotFound    = NotFound("access token not found")
+	ErrInvalidAccessToken     = Unauthorized("invalid access token")
+	ErrAccessTokenExpired     = Unauthorized("access token expired")
+	ErrInvalidTokenName       = Invalid("name", "invalid access token name")
+	ErrInvalidTokenScope      = Invalid("scopes", "invalid access token scope")
+	ErrInvalidTokenExpiry     = Invalid("expiresAt", "access token expiry must be in the future")
+	ErrInsufficientTokenScope = Forbidden("access token does not have the scope required by this request")
+)
+
+// Only the hash of the token is stored, the token itself is returned once when it is created
+type AccessTokenStorage interface {
+	CreateToken(r *CreateAccessTokenRequest, tokenHash string) (AccessToken, error)
+	GetTokens(cognitoId string) ([]AccessToken, error)
+	DeleteToken(tokenId int, cognitoId string) error
+	// Looks up the token by its hash and records it as used at now, unless it has expired by then
+	UseToken(tokenHash string, now time.Time) (AccessToken, error)
+}
+
+type IAccessTokenService interface {
+	CreateToken(*CreateAccessTokenRequest) (CreatedAccessToken, error)
+	GetTokens(cognitoId string) ([]AccessToken, error)
+	RevokeToken(tokenId int, cognitoId string) error
+	Authenticate(token string) (AccessToken, error)
+}
+
+type AccessToken struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CognitoId  string     `json:"-"`
+}
+
+func (t AccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Returned only by the create endpoint, Token is the secret the client must store
+type CreatedAccessToken struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+type CreateAccessTokenRequest struct {
+	Name          string     `json:"name"`
+	Scopes        []string   `json:"scopes"`
+	ExpiresAt     *time.Time `json:"expiresAt"`
+	UserCognitoId string     `json:"userCognitoId"`
+}
+
+func (t *CreateAccessTokenRequest) Validate() error {
+	if t.Name == "" || len(t.Name) > 255 {
+		return ErrInvalidTokenName
+	}
+	if len(t.Scopes) == 0 {
+		return ErrInvalidTokenScope
+	}
+	for _, scope := range t.Scopes {
+		if !isKnownScope(scope) {
+			return ErrInvalidTokenScope
+		}
+	}
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now()) {
+		return ErrInvalidTokenExpiry
+	}
+	return nil
+}
+
+func isKnownScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
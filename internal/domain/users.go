@@ -2,6 +2,7 @@ package domain
 
 // This is the interface that that will define the behavior to interact with the database
 
+// CreateUser must not fail if the user already exists
 type UserStorage interface {
 	CheckUser(string) (bool, error)
 	CreateUser(string) error
@@ -10,6 +11,7 @@ type UserStorage interface {
 type IUserService interface {
 	CheckUser(string) (bool, error)
 	CreateUser(string) error
+	EnsureUser(string) error
 }
 
 // User struct is used to hold the user data received from the database
@@ -0,0 +1,266 @@
+// Package jsonpatch applies JSON Patch documents (RFC 6902) to JSON values
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// The patch is not a valid JSON Patch document
+	ErrInvalidPatch = errors.New("invalid json patch")
+	// An operation does not apply to the document, like removing a missing member
+	ErrCannotApply = errors.New("json patch can not be applied")
+	// A test operation did not match the document
+	ErrTestFailed = errors.New("json patch test failed")
+)
+
+type Operation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	From  string `json:"from"`
+	Value Value  `json:"value"`
+}
+
+// Value of an operation, Set tells a null value apart from a missing one
+type Value struct {
+	Set bool
+	Raw json.RawMessage
+}
+
+func (v *Value) UnmarshalJSON(b []byte) error {
+	v.Set = true
+	v.Raw = append(v.Raw[:0], b...)
+	return nil
+}
+
+// Apply applies the operations of patch in order and returns the patched document.
+// The whole patch fails if any operation fails, doc is never modified
+func Apply(doc, patch []byte) ([]byte, error) {
+	var ops []Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	var root any
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		var err error
+		root, err = apply(root, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func apply(root any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if !op.Value.Set {
+			return nil, fmt.Errorf("%w: %s operation without a value", ErrInvalidPatch, op.Op)
+		}
+		var value any
+		if err := json.Unmarshal(op.Value.Raw, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		switch op.Op {
+		case "add":
+			return add(root, path, value)
+		case "replace":
+			if root, err = remove(root, path); err != nil {
+				return nil, err
+			}
+			return add(root, path, value)
+		default:
+			current, err := get(root, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, fmt.Errorf("%w: %s does not match", ErrTestFailed, op.Path)
+			}
+			return root, nil
+		}
+	case "remove":
+		return remove(root, path)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(root, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, fmt.Errorf("%w: can not move %s into one of its children", ErrCannotApply, op.From)
+			}
+			if root, err = remove(root, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+		return add(root, path, value)
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// Splits a JSON Pointer (RFC 6901) into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+func get(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q not found", ErrCannotApply, token)
+			}
+			node = child
+		case []any:
+			i, err := index(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("%w: %q is not inside an object or array", ErrCannotApply, token)
+		}
+	}
+	return node, nil
+}
+
+// Returns the root with value added at path, arrays are rebuilt so the parent must be updated
+func add(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[token] = value
+		return root, nil
+	case []any:
+		i := len(p)
+		if token != "-" {
+			if i, err = index(token, len(p)); err != nil {
+				return nil, err
+			}
+		}
+		updated := append(p[:i:i], append([]any{value}, p[i:]...)...)
+		return replaceNode(root, path[:len(path)-1], updated)
+	default:
+		return nil, fmt.Errorf("%w: can not add %q to a value that is not an object or array", ErrCannotApply, token)
+	}
+}
+
+func remove(root any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		if _, ok := p[token]; !ok {
+			return nil, fmt.Errorf("%w: member %q not found", ErrCannotApply, token)
+		}
+		delete(p, token)
+		return root, nil
+	case []any:
+		i, err := index(token, len(p)-1)
+		if err != nil {
+			return nil, err
+		}
+		updated := append(p[:i:i], p[i+1:]...)
+		return replaceNode(root, path[:len(path)-1], updated)
+	default:
+		return nil, fmt.Errorf("%w: can not remove %q from a value that is not an object or array", ErrCannotApply, token)
+	}
+}
+
+// Puts value at path, which must already exist
+func replaceNode(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[token] = value
+	case []any:
+		i, err := index(token, len(p)-1)
+		if err != nil {
+			return nil, err
+		}
+		p[i] = value
+	}
+	return root, nil
+}
+
+// Parses an array index, leading zeros and values above max are rejected
+func index(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrCannotApply, token)
+	}
+	if i > max {
+		return 0, fmt.Errorf("%w: array index %d out of range", ErrCannotApply, i)
+	}
+	return i, nil
+}
+
+func deepCopy(v any) any {
+	switch n := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(n))
+		for k, child := range n {
+			c[k] = deepCopy(child)
+		}
+		return c
+	case []any:
+		c := make([]any, len(n))
+		for i, child := range n {
+			c[i] = deepCopy(child)
+		}
+		return c
+	default:
+		return v
+	}
+}
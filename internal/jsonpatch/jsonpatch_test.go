@@ -0,0 +1,116 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	const doc = `{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":1,"m~n":2,"nested":{"x":1}}`
+	cases := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"AddMember", `[{"op":"add","path":"/description","value":"d"}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":1,"m~n":2,"nested":{"x":1},"description":"d"}`},
+		{"AddReplacesMember", `[{"op":"add","path":"/title","value":"b"}]`,
+			`{"title":"b","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":1,"m~n":2,"nested":{"x":1}}`},
+		{"AddInsertsInArray", `[{"op":"add","path":"/labels/1","value":3}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,3,2],"a/b":1,"m~n":2,"nested":{"x":1}}`},
+		{"AddAppendsToArray", `[{"op":"add","path":"/labels/-","value":3}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,2,3],"a/b":1,"m~n":2,"nested":{"x":1}}`},
+		{"AddNull", `[{"op":"add","path":"/parentId","value":null}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":1,"m~n":2,"nested":{"x":1},"parentId":null}`},
+		{"Remove", `[{"op":"remove","path":"/dueDate"},{"op":"remove","path":"/labels/0"}]`,
+			`{"title":"a","labels":[2],"a/b":1,"m~n":2,"nested":{"x":1}}`},
+		{"Replace", `[{"op":"replace","path":"/nested/x","value":{"y":[true]}}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":1,"m~n":2,"nested":{"x":{"y":[true]}}}`},
+		{"ReplaceWithNull", `[{"op":"replace","path":"/dueDate","value":null}]`,
+			`{"title":"a","dueDate":null,"labels":[1,2],"a/b":1,"m~n":2,"nested":{"x":1}}`},
+		{"Move", `[{"op":"move","from":"/nested/x","path":"/x"}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":1,"m~n":2,"nested":{},"x":1}`},
+		{"MoveInArray", `[{"op":"move","from":"/labels/0","path":"/labels/-"}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[2,1],"a/b":1,"m~n":2,"nested":{"x":1}}`},
+		{"Copy", `[{"op":"copy","from":"/nested","path":"/copy"},{"op":"replace","path":"/copy/x","value":2}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":1,"m~n":2,"nested":{"x":1},"copy":{"x":2}}`},
+		{"Test", `[{"op":"test","path":"/labels","value":[1,2]},{"op":"replace","path":"/title","value":"b"}]`,
+			`{"title":"b","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":1,"m~n":2,"nested":{"x":1}}`},
+		{"TestNull", `[{"op":"add","path":"/startDate","value":null},{"op":"test","path":"/startDate","value":null}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":1,"m~n":2,"nested":{"x":1},"startDate":null}`},
+		{"EscapedPointers", `[{"op":"replace","path":"/a~1b","value":10},{"op":"remove","path":"/m~0n"}]`,
+			`{"title":"a","dueDate":"2024-01-01T00:00:00Z","labels":[1,2],"a/b":10,"nested":{"x":1}}`},
+		{"ReplaceRoot", `[{"op":"replace","path":"","value":{"title":"z"}}]`, `{"title":"z"}`},
+		{"Empty", `[]`, doc},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Apply([]byte(doc), []byte(c.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !sameJson(t, got, []byte(c.want)) {
+				t.Fatalf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	const doc = `{"title":"a","labels":[1,2],"nested":{"x":1}}`
+	cases := []struct {
+		name  string
+		patch string
+		want  error
+	}{
+		{"NotAnArray", `{"op":"add","path":"/title","value":"b"}`, ErrInvalidPatch},
+		{"UnknownOp", `[{"op":"merge","path":"/title","value":"b"}]`, ErrInvalidPatch},
+		{"MissingValue", `[{"op":"add","path":"/title"}]`, ErrInvalidPatch},
+		{"MissingTestValue", `[{"op":"test","path":"/title"}]`, ErrInvalidPatch},
+		{"RelativePath", `[{"op":"remove","path":"title"}]`, ErrInvalidPatch},
+		{"RemoveMissing", `[{"op":"remove","path":"/description"}]`, ErrCannotApply},
+		{"ReplaceMissing", `[{"op":"replace","path":"/description","value":"d"}]`, ErrCannotApply},
+		{"AddToMissingParent", `[{"op":"add","path":"/missing/x","value":1}]`, ErrCannotApply},
+		{"IndexOutOfRange", `[{"op":"add","path":"/labels/3","value":3}]`, ErrCannotApply},
+		{"IndexWithLeadingZero", `[{"op":"remove","path":"/labels/01"}]`, ErrCannotApply},
+		{"RemoveDash", `[{"op":"remove","path":"/labels/-"}]`, ErrCannotApply},
+		{"MoveIntoChild", `[{"op":"move","from":"/nested","path":"/nested/y"}]`, ErrCannotApply},
+		{"TestFails", `[{"op":"test","path":"/title","value":"b"}]`, ErrTestFailed},
+		{"TestNullFails", `[{"op":"test","path":"/title","value":null}]`, ErrTestFailed},
+		{"TestMissing", `[{"op":"test","path":"/description","value":null}]`, ErrCannotApply},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Apply([]byte(doc), []byte(c.patch))
+			if !errors.Is(err, c.want) {
+				t.Fatalf("got error %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+// A failing operation leaves the document as it was, even after earlier operations applied
+func TestApplyIsAtomic(t *testing.T) {
+	doc := []byte(`{"title":"a","labels":[1,2]}`)
+	patch := []byte(`[{"op":"replace","path":"/title","value":"b"},{"op":"remove","path":"/labels/5"}]`)
+	if _, err := Apply(doc, patch); !errors.Is(err, ErrCannotApply) {
+		t.Fatalf("got error %v, want %v", err, ErrCannotApply)
+	}
+	if string(doc) != `{"title":"a","labels":[1,2]}` {
+		t.Fatalf("document modified to %s", doc)
+	}
+}
+
+func sameJson(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("invalid json %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("invalid json %s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/lib/pq"
+)
+
+type PostgresAccessTokenStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresAccessTokenStore(DB *sql.DB) *PostgresAccessTokenStore {
+	return &PostgresAccessTokenStore{
+		DB: DB,
+	}
+}
+
+func (store *PostgresAccessTokenStore) CreateToken(r *domain.CreateAccessTokenRequest, tokenHash string) (domain.AccessToken, error) {
+	token := domain.AccessToken{CognitoId: r.UserCognitoId}
+	err := store.DB.QueryRow(`
+	INSERT INTO AccessTokens
+	(userId, name, tokenHash, scopes, expiresAt)
+	SELECT Users.id, $2, $3, $4, $5 FROM Users WHERE Users.cognitoId=$1
+	RETURNING id, name, scopes, createdAt, expiresAt, lastUsedAt`,
+		r.UserCognitoId, r.Name, tokenHash, pq.Array(r.Scopes), r.ExpiresAt).Scan(
+		&token.Id,
+		&token.Name,
+		pq.Array(&token.Scopes),
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.AccessToken{}, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return domain.AccessToken{}, err
+	}
+	return token, nil
+}
+
+func (store *PostgresAccessTokenStore) GetTokens(cognitoId string) ([]domain.AccessToken, error) {
+	rows, err := store.DB.Query(`
+	SELECT
+	AccessTokens.id,
+	AccessTokens.name,
+	AccessTokens.scopes,
+	AccessTokens.createdAt,
+	AccessTokens.expiresAt,
+	AccessTokens.lastUsedAt
+	FROM
+	AccessTokens
+	INNER JOIN Users ON AccessTokens.userId=Users.id
+	WHERE Users.cognitoId=$1
+	ORDER BY AccessTokens.createdAt`,
+		cognitoId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []domain.AccessToken{}
+	for rows.Next() {
+		token := domain.AccessToken{CognitoId: cognitoId}
+		err = rows.Scan(&token.Id, &token.Name, pq.Array(&token.Scopes), &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (store *PostgresAccessTokenStore) DeleteToken(tokenId int, cognitoId string) error {
+	res, err := store.DB.Exec(`
+	DELETE FROM AccessTokens
+	USING Users
+	WHERE AccessTokens.userId=Users.id AND AccessTokens.id=$1::bigint AND Users.cognitoId=$2`,
+		tokenId, cognitoId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrAccessTokenNotFound
+	}
+	return nil
+}
+
+func (store *PostgresAccessTokenStore) UseToken(tokenHash string, now time.Time) (domain.AccessToken, error) {
+	var token domain.AccessToken
+	err := store.DB.QueryRow(`
+	UPDATE AccessTokens
+	SET lastUsedAt=CASE WHEN AccessTokens.expiresAt IS NULL OR AccessTokens.expiresAt >= $2::timestamptz
+		THEN $2::timestamptz ELSE AccessTokens.lastUsedAt END
+	FROM Users
+	WHERE AccessTokens.userId=Users.id AND AccessTokens.tokenHash=$1
+	RETURNING AccessTokens.id, AccessTokens.name, AccessTokens.scopes, AccessTokens.createdAt,
+	AccessTokens.expiresAt, AccessTokens.lastUsedAt, Users.cognitoId`,
+		tokenHash, now).Scan(
+		&token.Id,
+		&token.Name,
+		pq.Array(&token.Scopes),
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.CognitoId,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.AccessToken{}, domain.ErrAccessTokenNotFound
+	}
+	if err != nil {
+		return domain.AccessToken{}, err
+	}
+	return token, nil
+}
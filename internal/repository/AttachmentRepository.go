@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type PostgresAttachmentStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresAttachmentStore(DB *sql.DB) *PostgresAttachmentStore {
+	return &PostgresAttachmentStore{
+		DB: DB,
+	}
+}
+
+// Attachment columns scanned by scanAttachment, the task of a detached attachment is NULL
+const attachmentColumns = `
+	Attachments.id,
+	COALESCE(Attachments.taskId, 0),
+	Attachments.name,
+	Attachments.contentType,
+	Attachments.size,
+	Uploaders.cognitoId,
+	Attachments.createdAt,
+	Attachments.blobKey`
+
+// Attachments of the tasks of the project $1
+const attachmentJoins = `
+	Attachments
+	INNER JOIN Users AS Uploaders ON Uploaders.id=Attachments.userId
+	INNER JOIN Tasks ON Tasks.id=Attachments.taskId AND Tasks.projectId=$1`
+
+func (store *PostgresAttachmentStore) GetProjectRole(projectId int, cognitoId string) (domain.Role, error) {
+	return queryProjectRole(store.DB, projectId, cognitoId)
+}
+
+func (store *PostgresAttachmentStore) GetAttachments(projectId, taskId int) ([]domain.Attachment, error) {
+	if err := checkProjectTask(store.DB, projectId, taskId); err != nil {
+		return nil, err
+	}
+	rows, err := store.DB.Query("SELECT"+attachmentColumns+"\n\tFROM"+attachmentJoins+`
+	WHERE Attachments.taskId=$2
+	ORDER BY Attachments.createdAt, Attachments.id`,
+		projectId, taskId)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+func (store *PostgresAttachmentStore) GetAttachment(projectId, taskId, attachmentId int) (domain.Attachment, error) {
+	attachment, err := scanAttachment(store.DB.QueryRow("SELECT"+attachmentColumns+"\n\tFROM"+attachmentJoins+`
+	WHERE Attachments.taskId=$2 AND Attachments.id=$3`,
+		projectId, taskId, attachmentId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Attachment{}, domain.ErrAttachmentNotFound
+	}
+	return attachment, err
+}
+
+func (store *PostgresAttachmentStore) CreateAttachment(r *domain.AttachmentRequest) (domain.Attachment, error) {
+	// The task is checked in the same statement, no row is inserted when it is not a task of the project
+	attachment, err := scanAttachment(store.DB.QueryRow(`
+	WITH Created AS (
+		INSERT INTO Attachments (taskId, userId, name, contentType, size, blobKey)
+		SELECT Tasks.id, Users.id, $3, $4, $5, $6
+		FROM Tasks INNER JOIN Users ON Users.cognitoId=$7
+		WHERE Tasks.id=$2 AND Tasks.projectId=$1
+		RETURNING *
+	)
+	SELECT`+attachmentColumns+`
+	FROM Created AS Attachments INNER JOIN Users AS Uploaders ON Uploaders.id=Attachments.userId`,
+		r.ProjectId, r.TaskId, r.Name, r.ContentType, r.Size, r.BlobKey, r.UserCognitoId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Attachment{}, domain.ErrTaskNotFound
+	}
+	return attachment, err
+}
+
+func (store *PostgresAttachmentStore) DetachAttachment(projectId, taskId, attachmentId int) (domain.Attachment, error) {
+	attachment, err := scanAttachment(store.DB.QueryRow(`
+	WITH Detached AS (
+		UPDATE Attachments SET taskId=NULL
+		FROM Tasks
+		WHERE Attachments.id=$3 AND Attachments.taskId=$2 AND Tasks.id=Attachments.taskId AND Tasks.projectId=$1
+		RETURNING Attachments.*
+	)
+	SELECT`+attachmentColumns+`
+	FROM Detached AS Attachments INNER JOIN Users AS Uploaders ON Uploaders.id=Attachments.userId`,
+		projectId, taskId, attachmentId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Attachment{}, domain.ErrAttachmentNotFound
+	}
+	return attachment, err
+}
+
+func (store *PostgresAttachmentStore) GetDetachedAttachments(limit int) ([]domain.Attachment, error) {
+	rows, err := store.DB.Query(`
+	SELECT`+attachmentColumns+`
+	FROM Attachments INNER JOIN Users AS Uploaders ON Uploaders.id=Attachments.userId
+	WHERE Attachments.taskId IS NULL
+	ORDER BY Attachments.id
+	LIMIT $1`,
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+func (store *PostgresAttachmentStore) DeleteAttachment(attachmentId int) error {
+	res, err := store.DB.Exec("DELETE FROM Attachments WHERE id=$1 AND taskId IS NULL", attachmentId)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return domain.ErrAttachmentNotFound
+	}
+	return nil
+}
+
+func scanAttachments(rows *sql.Rows) ([]domain.Attachment, error) {
+	defer rows.Close()
+	attachments := []domain.Attachment{}
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+func scanAttachment(row rowScanner) (domain.Attachment, error) {
+	var a domain.Attachment
+	err := row.Scan(&a.Id, &a.TaskId, &a.Name, &a.ContentType, &a.Size, &a.UploadedBy, &a.CreatedAt, &a.BlobKey)
+	return a, err
+}
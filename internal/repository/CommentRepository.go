@@ -0,0 +1,358 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/lib/pq"
+)
+
+type PostgresCommentStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresCommentStore(DB *sql.DB) *PostgresCommentStore {
+	return &PostgresCommentStore{
+		DB: DB,
+	}
+}
+
+func (store *PostgresCommentStore) GetProjectRole(projectId int, cognitoId string) (domain.Role, error) {
+	return queryProjectRole(store.DB, projectId, cognitoId)
+}
+
+func (store *PostgresCommentStore) GetProjectMembers(projectId int, cognitoIds []string) ([]int, error) {
+	rows, err := store.DB.Query(`
+	SELECT
+	Users.id
+	FROM
+	Users
+	INNER JOIN Projects ON Projects.id=$1
+	LEFT JOIN TeamMembers ON TeamMembers.teamId=Projects.teamId AND TeamMembers.userId=Users.id
+	LEFT JOIN ProjectShares ON ProjectShares.projectId=Projects.id AND ProjectShares.userId=Users.id
+	WHERE Users.cognitoId=ANY($2) AND (Projects.userId=Users.id OR TeamMembers.userId IS NOT NULL OR ProjectShares.userId IS NOT NULL)
+	ORDER BY Users.id`,
+		projectId, pq.Array(cognitoIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Comment columns scanned by scanComment, the mentions are aggregated from CommentMentions
+const commentColumns = `
+	Comments.id,
+	Comments.taskId,
+	Comments.parentId,
+	Comments.userId,
+	Authors.cognitoId,
+	Comments.body,
+	ARRAY(SELECT CommentMentions.userId FROM CommentMentions WHERE CommentMentions.commentId=Comments.id ORDER BY CommentMentions.userId),
+	Comments.createdAt,
+	Comments.editedAt,
+	Comments.deletedAt IS NOT NULL`
+
+// Comments of the tasks of the project $1
+const commentJoins = `
+	Comments
+	INNER JOIN Users AS Authors ON Authors.id=Comments.userId
+	INNER JOIN Tasks ON Tasks.id=Comments.taskId AND Tasks.projectId=$1`
+
+func (store *PostgresCommentStore) GetComments(projectId, taskId int) ([]domain.Comment, error) {
+	if err := checkProjectTask(store.DB, projectId, taskId); err != nil {
+		return nil, err
+	}
+	rows, err := store.DB.Query("SELECT"+commentColumns+"\n\tFROM"+commentJoins+`
+	WHERE Comments.taskId=$2
+	ORDER BY Comments.createdAt, Comments.id`,
+		projectId, taskId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := []domain.Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+func (store *PostgresCommentStore) GetComment(projectId, taskId, commentId int) (domain.Comment, error) {
+	return queryComment(store.DB, projectId, taskId, commentId)
+}
+
+func (store *PostgresCommentStore) GetCommentEdits(projectId, taskId, commentId int) ([]domain.CommentEdit, error) {
+	if _, err := queryComment(store.DB, projectId, taskId, commentId); err != nil {
+		return nil, err
+	}
+	rows, err := store.DB.Query(`
+	SELECT body, writtenAt, replacedAt FROM CommentEdits
+	WHERE commentId=$1
+	ORDER BY replacedAt, writtenAt`,
+		commentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	edits := []domain.CommentEdit{}
+	for rows.Next() {
+		var edit domain.CommentEdit
+		if err := rows.Scan(&edit.Body, &edit.WrittenAt, &edit.ReplacedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
+}
+
+func (store *PostgresCommentStore) CreateComment(r *domain.CommentRequest) (domain.Comment, error) {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	defer tx.Rollback()
+
+	// The task and the comment replied to are locked so they can not be deleted meanwhile
+	var id int
+	err = tx.QueryRow("SELECT id FROM Tasks WHERE id=$1 AND projectId=$2 FOR KEY SHARE", r.TaskId, r.ProjectId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Comment{}, domain.ErrTaskNotFound
+	}
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	if r.ParentId != nil {
+		err = tx.QueryRow("SELECT id FROM Comments WHERE id=$1 AND taskId=$2 FOR KEY SHARE", *r.ParentId, r.TaskId).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Comment{}, domain.ErrInvalidReply
+		}
+		if err != nil {
+			return domain.Comment{}, err
+		}
+	}
+	err = tx.QueryRow(`
+	INSERT INTO Comments (taskId, parentId, userId, body)
+	SELECT $1, $2, Users.id, $3 FROM Users WHERE Users.cognitoId=$4
+	RETURNING id`,
+		r.TaskId, r.ParentId, r.Body, r.UserCognitoId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Comment{}, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	if err := setMentions(tx, id, r.Mentions); err != nil {
+		return domain.Comment{}, err
+	}
+	comment, err := queryComment(tx, r.ProjectId, r.TaskId, id)
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	return comment, tx.Commit()
+}
+
+func (store *PostgresCommentStore) UpdateComment(commentId int, r *domain.CommentRequest) (domain.Comment, error) {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	defer tx.Rollback()
+
+	var body string
+	var writtenAt time.Time
+	err = tx.QueryRow(`
+	SELECT Comments.body, COALESCE(Comments.editedAt, Comments.createdAt)
+	FROM Comments INNER JOIN Tasks ON Tasks.id=Comments.taskId
+	WHERE Comments.id=$1 AND Comments.taskId=$2 AND Tasks.projectId=$3 AND Comments.deletedAt IS NULL
+	FOR UPDATE OF Comments`,
+		commentId, r.TaskId, r.ProjectId).Scan(&body, &writtenAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Comment{}, domain.ErrCommentNotFound
+	}
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	// Saving the same body again is not an edit
+	if body != r.Body {
+		_, err = tx.Exec("INSERT INTO CommentEdits (commentId, body, writtenAt) VALUES($1, $2, $3)", commentId, body, writtenAt)
+		if err != nil {
+			return domain.Comment{}, err
+		}
+		if _, err := tx.Exec("UPDATE Comments SET body=$1, editedAt=NOW() WHERE id=$2", r.Body, commentId); err != nil {
+			return domain.Comment{}, err
+		}
+	}
+	// A nil slice would be sent as NULL and keep every mention
+	kept := append([]int{}, r.Mentions...)
+	_, err = tx.Exec("DELETE FROM CommentMentions WHERE commentId=$1 AND userId <> ALL($2::integer[])", commentId, pq.Array(kept))
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	if err := setMentions(tx, commentId, r.Mentions); err != nil {
+		return domain.Comment{}, err
+	}
+	comment, err := queryComment(tx, r.ProjectId, r.TaskId, commentId)
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	return comment, tx.Commit()
+}
+
+func (store *PostgresCommentStore) DeleteComment(projectId, taskId, commentId int) error {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the comment waits for the replies being written to it
+	var id int
+	err = tx.QueryRow(`
+	SELECT Comments.id
+	FROM Comments INNER JOIN Tasks ON Tasks.id=Comments.taskId
+	WHERE Comments.id=$1 AND Comments.taskId=$2 AND Tasks.projectId=$3 AND Comments.deletedAt IS NULL
+	FOR UPDATE OF Comments`,
+		commentId, taskId, projectId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrCommentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var replied bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM Comments WHERE parentId=$1)", commentId).Scan(&replied); err != nil {
+		return err
+	}
+	if !replied {
+		// The history and mentions are removed by the cascade
+		if _, err := tx.Exec("DELETE FROM Comments WHERE id=$1", commentId); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if _, err := tx.Exec("UPDATE Comments SET body='', deletedAt=NOW() WHERE id=$1", commentId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM CommentEdits WHERE commentId=$1", commentId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM CommentMentions WHERE commentId=$1", commentId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PostgresCommentStore) GetMentions(cognitoId string, since *time.Time) ([]domain.Mention, error) {
+	args := []any{cognitoId}
+	var after string
+	if since != nil {
+		args = append(args, *since)
+		after = " AND CommentMentions.createdAt > $2"
+	}
+	rows, err := store.DB.Query(`
+	SELECT
+	CommentMentions.commentId,
+	Comments.taskId,
+	Tasks.projectId,
+	Authors.cognitoId,
+	CommentMentions.createdAt
+	FROM
+	CommentMentions
+	INNER JOIN Comments ON Comments.id=CommentMentions.commentId
+	INNER JOIN Users AS Authors ON Authors.id=Comments.userId
+	INNER JOIN Tasks ON Tasks.id=Comments.taskId
+	INNER JOIN Projects ON Projects.id=Tasks.projectId`+projectAccessJoins+`
+	WHERE CommentMentions.userId=Caller.id AND `+projectAccessFilter+after+`
+	ORDER BY CommentMentions.createdAt DESC, CommentMentions.commentId DESC`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mentions := []domain.Mention{}
+	for rows.Next() {
+		var m domain.Mention
+		if err := rows.Scan(&m.CommentId, &m.TaskId, &m.ProjectId, &m.Author, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions, rows.Err()
+}
+
+func setMentions(tx *sql.Tx, commentId int, userIds []int) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+	INSERT INTO CommentMentions (commentId, userId)
+	SELECT $1, UNNEST($2::integer[])
+	ON CONFLICT DO NOTHING`,
+		commentId, pq.Array(userIds))
+	return err
+}
+
+func checkProjectTask(db rowQuerier, projectId, taskId int) error {
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM Tasks WHERE id=$1 AND projectId=$2)", taskId, projectId).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return domain.ErrTaskNotFound
+	}
+	return nil
+}
+
+func queryComment(db rowQuerier, projectId, taskId, commentId int) (domain.Comment, error) {
+	comment, err := scanComment(db.QueryRow("SELECT"+commentColumns+"\n\tFROM"+commentJoins+"\n\tWHERE Comments.taskId=$2 AND Comments.id=$3", projectId, taskId, commentId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Comment{}, domain.ErrCommentNotFound
+	}
+	return comment, err
+}
+
+func scanComment(row rowScanner) (domain.Comment, error) {
+	var comment domain.Comment
+	var parentId sql.NullInt64
+	var editedAt sql.NullTime
+	var mentions pq.Int64Array
+	err := row.Scan(
+		&comment.Id,
+		&comment.TaskId,
+		&parentId,
+		&comment.AuthorId,
+		&comment.Author,
+		&comment.Body,
+		&mentions,
+		&comment.CreatedAt,
+		&editedAt,
+		&comment.Deleted,
+	)
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	if parentId.Valid {
+		id := int(parentId.Int64)
+		comment.ParentId = &id
+	}
+	if editedAt.Valid {
+		comment.EditedAt = &editedAt.Time
+	}
+	comment.Mentions = toInts(mentions)
+	return comment, nil
+}
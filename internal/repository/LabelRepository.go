@@ -0,0 +1,199 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/lib/pq"
+)
+
+type PostgresLabelStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresLabelStore(DB *sql.DB) *PostgresLabelStore {
+	return &PostgresLabelStore{
+		DB: DB,
+	}
+}
+
+func (store *PostgresLabelStore) GetProjectRole(projectId int, cognitoId string) (domain.Role, error) {
+	return queryProjectRole(store.DB, projectId, cognitoId)
+}
+
+// Label columns scanned by scanLabel, the tagged tasks are counted from TaskLabels
+const labelColumns = `
+	Labels.id,
+	Labels.projectId,
+	Labels.name,
+	Labels.color,
+	(SELECT COUNT(*) FROM TaskLabels WHERE TaskLabels.labelId=Labels.id)`
+
+func (store *PostgresLabelStore) GetLabels(projectId int) ([]domain.Label, error) {
+	rows, err := store.DB.Query("SELECT"+labelColumns+`
+	FROM Labels WHERE Labels.projectId=$1
+	ORDER BY lower(Labels.name) COLLATE "C", Labels.id`,
+		projectId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	labels := []domain.Label{}
+	for rows.Next() {
+		label, err := scanLabel(rows)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+	return labels, rows.Err()
+}
+
+func (store *PostgresLabelStore) GetLabel(projectId, labelId int) (domain.Label, error) {
+	return queryLabel(store.DB, projectId, labelId)
+}
+
+func (store *PostgresLabelStore) CreateLabel(r *domain.LabelRequest) (domain.Label, error) {
+	var id int
+	err := store.DB.QueryRow(`
+	INSERT INTO Labels (projectId, name, color)
+	VALUES($1, $2, $3)
+	RETURNING id`,
+		r.ProjectId, r.Name, r.Color).Scan(&id)
+	if isUniqueViolation(err) {
+		return domain.Label{}, domain.ErrLabelExists
+	}
+	if err != nil {
+		return domain.Label{}, err
+	}
+	return queryLabel(store.DB, r.ProjectId, id)
+}
+
+// Renaming a label keeps the tasks tagged with it, their labels are ids
+func (store *PostgresLabelStore) UpdateLabel(labelId int, r *domain.LabelRequest) (domain.Label, error) {
+	res, err := store.DB.Exec(`
+	UPDATE Labels SET name=$1, color=$2
+	WHERE id=$3 AND projectId=$4`,
+		r.Name, r.Color, labelId, r.ProjectId)
+	if isUniqueViolation(err) {
+		return domain.Label{}, domain.ErrLabelExists
+	}
+	if err != nil {
+		return domain.Label{}, err
+	}
+	if err := checkLabelAffected(res); err != nil {
+		return domain.Label{}, err
+	}
+	return queryLabel(store.DB, r.ProjectId, labelId)
+}
+
+func (store *PostgresLabelStore) DeleteLabel(projectId, labelId int) error {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockLabels(tx, projectId, labelId); err != nil {
+		return err
+	}
+	if err := touchLabeledTasks(tx, labelId); err != nil {
+		return err
+	}
+	// The tags are removed by the cascade
+	if _, err := tx.Exec("DELETE FROM Labels WHERE id=$1", labelId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PostgresLabelStore) MergeLabel(projectId, labelId, intoId int) (domain.Label, error) {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return domain.Label{}, err
+	}
+	defer tx.Rollback()
+
+	if err := lockLabels(tx, projectId, labelId, intoId); err != nil {
+		return domain.Label{}, err
+	}
+	if err := touchLabeledTasks(tx, labelId); err != nil {
+		return domain.Label{}, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO TaskLabels (taskId, labelId)
+	SELECT taskId, $2 FROM TaskLabels WHERE labelId=$1
+	ON CONFLICT DO NOTHING`,
+		labelId, intoId)
+	if err != nil {
+		return domain.Label{}, err
+	}
+	if _, err := tx.Exec("DELETE FROM Labels WHERE id=$1", labelId); err != nil {
+		return domain.Label{}, err
+	}
+	label, err := queryLabel(tx, projectId, intoId)
+	if err != nil {
+		return domain.Label{}, err
+	}
+	return label, tx.Commit()
+}
+
+// Locks the labels until the end of tx, in the order of their ids so two merges of
+// the same labels can not deadlock. Fails with ErrLabelNotFound unless all of them
+// are labels of the project
+func lockLabels(tx *sql.Tx, projectId int, labelIds ...int) error {
+	rows, err := tx.Query(`
+	SELECT id FROM Labels WHERE id=ANY($1) AND projectId=$2
+	ORDER BY id FOR UPDATE`,
+		pq.Array(labelIds), projectId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var n int
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if n != len(labelIds) {
+		return domain.ErrLabelNotFound
+	}
+	return nil
+}
+
+// Increments the version of the tasks tagged with the label before their labels change
+func touchLabeledTasks(tx *sql.Tx, labelId int) error {
+	_, err := tx.Exec(`
+	UPDATE Tasks SET version=version+1
+	WHERE id IN (SELECT taskId FROM TaskLabels WHERE labelId=$1)`,
+		labelId)
+	return err
+}
+
+func queryLabel(db rowQuerier, projectId, labelId int) (domain.Label, error) {
+	label, err := scanLabel(db.QueryRow("SELECT"+labelColumns+"\n\tFROM Labels WHERE Labels.id=$1 AND Labels.projectId=$2", labelId, projectId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Label{}, domain.ErrLabelNotFound
+	}
+	return label, err
+}
+
+func scanLabel(row rowScanner) (domain.Label, error) {
+	var label domain.Label
+	err := row.Scan(&label.Id, &label.ProjectId, &label.Name, &label.Color, &label.Tasks)
+	return label, err
+}
+
+func checkLabelAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrLabelNotFound
+	}
+	return nil
+}
@@ -2,6 +2,9 @@ package repo
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
 
 	"github.com/Desgue/ttracker-api/internal/domain"
 	_ "github.com/lib/pq"
@@ -17,150 +20,333 @@ func NewPostgresProjectStore(DB *sql.DB) *PostgresProjectStore {
 	}
 }
 
-func (store *PostgresProjectStore) GetProjects(cognitoId string) ([]domain.Project, error) {
-	// Perform a joing with the users id to retriev all projects associated with the users cognitoId
-	// Then select all projects wich matches the user cognitoId
-
-	rows, err := store.DB.Query(`
-	SELECT 
-	Projects.id, 
-	Projects.title, 
-	Projects.description, 
-	Projects.priority, 
-	Projects.createdAt 
-	FROM 
-	Projects 
-	INNER JOIN Users ON Projects.userId=Users.id 
-	WHERE Users.cognitoId=$1`,
-		cognitoId)
+func (store *PostgresProjectStore) GetProjectRole(projectId, cognitoId string) (domain.Role, error) {
+	id, err := strconv.Atoi(projectId)
 	if err != nil {
-		return nil, err
-	}
-	var projects []domain.Project
-	for rows.Next() {
-		project := domain.Project{}
-		err = rows.Scan(&project.Id, &project.Title, &project.Description, &project.Priority, &project.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		projects = append(projects, project)
+		return "", domain.ErrProjectNotFound
 	}
-	return projects, nil
+	return queryProjectRole(store.DB, id, cognitoId)
+}
 
+// Titles are compared byte by byte so the order does not depend on the collation of the database
+// and matches the in-memory store
+var projectSortColumns = map[domain.SortField]sortColumn{
+	domain.SortCreatedAt: {"Projects.createdAt", "timestamptz"},
+	domain.SortTitle:     {`Projects.title COLLATE "C"`, "text"},
+	domain.SortPriority:  {"Projects.priority", "priority"},
 }
 
-func (store *PostgresProjectStore) GetProjectById(projectId, cognitoId string) (domain.Project, error) {
-	// Perform a join with the users id to retriev the project associated id and the users cognitoId
+func (store *PostgresProjectStore) GetProjects(cognitoId string, filter domain.ProjectFilter, page domain.Page) ([]domain.Project, error) {
+	// Retrieve the projects owned by the user, owned by one of its teams or shared with it
+	args := []any{cognitoId}
+	conditions := projectFilterConditions(filter, &args)
+	after, order := pageClause(page, projectSortColumns, "Projects.id", &args)
+
 	rows, err := store.DB.Query(`
 	SELECT
 	Projects.id,
-	Users.cognitoId,
 	Projects.title,
 	Projects.description,
 	Projects.priority,
-	Projects.createdAt
+	Projects.createdAt,
+	Projects.teamId,
+	Projects.version,`+projectAccessColumns+`
 	FROM
-	Projects
-	INNER JOIN Users ON Projects.userId=Users.id
-	WHERE Projects.id=$1 AND Users.cognitoId=$2`, projectId, cognitoId)
+	Projects`+projectAccessJoins+`
+	WHERE `+projectAccessFilter+conditions+after+order,
+		args...)
 	if err != nil {
-		return domain.Project{}, err
+		return nil, err
 	}
-	var project domain.Project
+	defer rows.Close()
+	var projects []domain.Project
 	for rows.Next() {
-		err = rows.Scan(&project.Id, &project.Title, &project.Description, &project.Priority, &project.CreatedAt)
+		project, err := scanProject(rows)
 		if err != nil {
-			return domain.Project{}, err
+			return nil, err
 		}
+		projects = append(projects, project)
 	}
-	return project, nil
+	return projects, rows.Err()
 
 }
 
-func (store *PostgresProjectStore) CreateProject(p *domain.CreateProjectRequest) error {
+// Same conventions as taskFilterConditions
+func projectFilterConditions(filter domain.ProjectFilter, args *[]any) string {
+	var conditions string
+	add := func(condition string, value any) {
+		*args = append(*args, value)
+		conditions += fmt.Sprintf(condition, len(*args))
+	}
+	if filter.Priority != "" {
+		add(" AND Projects.priority = $%d", filter.Priority)
+	}
+	if filter.CreatedAfter != nil {
+		add(" AND Projects.createdAt > $%d", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		add(" AND Projects.createdAt < $%d", *filter.CreatedBefore)
+	}
+	return conditions
+}
+
+func (store *PostgresProjectStore) GetProjectById(projectId, cognitoId string) (domain.Project, error) {
+	return queryProject(store.DB, projectId, cognitoId)
+}
+
+func (store *PostgresProjectStore) CreateProject(p *domain.CreateProjectRequest) (domain.Project, error) {
 	// Create a new project and associate it with the user cognitoId
 	// The user cognitoId is used to retrieve the user id from the Users table
 	// Then the user id is used to associate the project with the user
-	row, err := store.DB.Query("SELECT id from Users where cognitoId=$1", p.UserCognitoId)
+	tx, err := store.DB.Begin()
 	if err != nil {
-		return err
+		return domain.Project{}, err
 	}
+	defer tx.Rollback()
+
 	var userId int
-	for row.Next() {
-		err = row.Scan(&userId)
+	err = tx.QueryRow("SELECT id from Users where cognitoId=$1", p.UserCognitoId).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Project{}, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return domain.Project{}, err
+	}
+
+	var id int
+	err = tx.QueryRow(`
+	INSERT INTO Projects
+	(title, description, priority, userId, teamId)
+	VALUES($1, $2, $3, $4, $5)
+	RETURNING id`,
+		p.Title, p.Description, p.Priority, userId, p.TeamId).Scan(&id)
+	if err != nil {
+		return domain.Project{}, err
+	}
+	project, err := queryProject(tx, strconv.Itoa(id), p.UserCognitoId)
+	if err != nil {
+		return domain.Project{}, err
+	}
+	return project, tx.Commit()
+}
+
+func (store *PostgresProjectStore) UpdateProject(id string, p *domain.CreateProjectRequest) (domain.Project, error) {
+	// The caller role is checked by the service, the team of the project can not be changed here
+	return store.patch(id, p.UserCognitoId, p.Version, []column{
+		{"title", p.Title},
+		{"description", p.Description},
+		{"priority", p.Priority},
+	})
+}
+
+func (store *PostgresProjectStore) PatchProject(id string, p *domain.PatchProjectRequest) (domain.Project, error) {
+	var columns []column
+	if p.Title.Set {
+		columns = append(columns, column{"title", p.Title.Value})
+	}
+	if p.Description.Set {
+		columns = append(columns, column{"description", p.Description.Value})
+	}
+	if p.Priority.Set {
+		columns = append(columns, column{"priority", p.Priority.Value})
+	}
+	return store.patch(id, p.UserCognitoId, p.Version, columns)
+}
+
+// Writes the columns and reads the project back in the same transaction,
+// the version is only incremented when a column is written
+func (store *PostgresProjectStore) patch(id, cognitoId string, version int, columns []column) (domain.Project, error) {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return domain.Project{}, err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "SELECT version FROM Projects WHERE id=$1 FOR UPDATE", []any{id}, version, domain.ErrProjectNotFound)
+	if err != nil {
+		return domain.Project{}, err
+	}
+	if len(columns) > 0 {
+		set, args := setClause(columns)
+		_, err := tx.Exec(fmt.Sprintf("UPDATE Projects SET %s, version=version+1 WHERE id=$%d", set, len(args)+1), append(args, id)...)
 		if err != nil {
-			return err
+			return domain.Project{}, err
 		}
 	}
+	project, err := queryProject(tx, id, cognitoId)
+	if err != nil {
+		return domain.Project{}, err
+	}
+	return project, tx.Commit()
+}
 
-	_, err = store.DB.Exec(`
-	INSERT INTO Projects 
-	(title, description, priority, userId) 
-	VALUES($1, $2, $3, $4)`,
-		p.Title, p.Description, p.Priority, userId)
+func (store *PostgresProjectStore) DeleteProject(projectId string, version int) error {
+	// Delete all tasks asscoiated with the project id
+	// Then delete the project, the shares are removed by the cascade
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	err = lockVersion(tx, "SELECT version FROM Projects WHERE id=$1 FOR UPDATE", []any{projectId}, version, domain.ErrProjectNotFound)
 	if err != nil {
 		return err
 	}
-	return nil
-}
 
-func (store *PostgresProjectStore) UpdateProject(id string, p *domain.CreateProjectRequest) error {
-	// Update the project with the new values if the project id matches the id of the user
-	// The user id is used to associate the project with the user and must be retrieved using the cognitoId
-	row, err := store.DB.Query("SELECT id from Users where cognitoId=$1", p.UserCognitoId)
+	_, err = tx.Exec(`
+	DELETE FROM Tasks WHERE projectId=$1`,
+		projectId)
 	if err != nil {
 		return err
 	}
-	var userId int
-	for row.Next() {
-		err = row.Scan(&userId)
+	res, err := tx.Exec(`
+	DELETE FROM Projects
+	WHERE id=$1`,
+		projectId)
+	if err != nil {
+		return err
+	}
+	if err := checkProjectAffected(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PostgresProjectStore) GetShares(projectId string) ([]domain.ProjectShare, error) {
+	rows, err := store.DB.Query(`
+	SELECT
+	ProjectShares.projectId,
+	ProjectShares.userId,
+	Users.cognitoId,
+	ProjectShares.role,
+	ProjectShares.createdAt
+	FROM
+	ProjectShares
+	INNER JOIN Users ON ProjectShares.userId=Users.id
+	WHERE ProjectShares.projectId=$1
+	ORDER BY ProjectShares.createdAt`,
+		projectId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	shares := []domain.ProjectShare{}
+	for rows.Next() {
+		share := domain.ProjectShare{}
+		err = rows.Scan(&share.ProjectId, &share.UserId, &share.CognitoId, &share.Role, &share.CreatedAt)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		shares = append(shares, share)
 	}
-	_, err = store.DB.Exec(`
-	UPDATE Projects 
-	SET title=$1, description=$2, priority=$3 
-	WHERE id=$4 AND userId=$5`,
-		p.Title, p.Description, p.Priority, id, userId)
+	return shares, rows.Err()
+}
 
+func (store *PostgresProjectStore) ShareProject(projectId string, userId int, role domain.Role) error {
+	res, err := store.DB.Exec(`
+	INSERT INTO ProjectShares (projectId, userId, role)
+	SELECT $1, Users.id, $3 FROM Users WHERE Users.id=$2
+	ON CONFLICT (projectId, userId) DO UPDATE SET role=EXCLUDED.role`,
+		projectId, userId, role)
 	if err != nil {
 		return err
 	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrUserNotFound
+	}
 	return nil
-
 }
 
-func (store *PostgresProjectStore) DeleteProject(projectId, cognitoId string) error {
-	// Delete all tasks asscoiated with the project id
-	// Then delete the project with the project id where the user id matches the cognito id
-
-	row, err := store.DB.Query("SELECT id from Users where cognitoId=$1", cognitoId)
+func (store *PostgresProjectStore) RemoveShare(projectId string, userId int) error {
+	res, err := store.DB.Exec("DELETE FROM ProjectShares WHERE projectId=$1 AND userId=$2", projectId, userId)
 	if err != nil {
 		return err
 	}
-	var userId int
-	for row.Next() {
-		err = row.Scan(&userId)
-		if err != nil {
-			return err
-		}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrUserNotFound
 	}
+	return nil
+}
 
-	_, err = store.DB.Exec(`
-	DELETE FROM Tasks WHERE projectId=$1`,
-		projectId)
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// Implemented by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Reads a single project with the role of the user, the user must have a role in it
+func queryProject(db rowQuerier, projectId, cognitoId string) (domain.Project, error) {
+	row := db.QueryRow(`
+	SELECT
+	Projects.id,
+	Projects.title,
+	Projects.description,
+	Projects.priority,
+	Projects.createdAt,
+	Projects.teamId,
+	Projects.version,`+projectAccessColumns+`
+	FROM
+	Projects`+projectAccessJoins+`
+	WHERE Projects.id=$2 AND `+projectAccessFilter,
+		cognitoId, projectId)
+	project, err := scanProject(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Project{}, domain.ErrProjectNotFound
+	}
 	if err != nil {
-		return err
+		return domain.Project{}, err
 	}
-	_, err = store.DB.Exec(`
-	DELETE FROM Projects
-	WHERE id=$1 AND userId=$2`,
-		projectId, userId)
+	return project, nil
+}
 
+// Scans the project columns followed by the access columns
+func scanProject(row rowScanner) (domain.Project, error) {
+	var project domain.Project
+	var teamId sql.NullInt64
+	var isOwner bool
+	var teamRole, shareRole sql.NullString
+	err := row.Scan(
+		&project.Id,
+		&project.Title,
+		&project.Description,
+		&project.Priority,
+		&project.CreatedAt,
+		&teamId,
+		&project.Version,
+		&isOwner,
+		&teamRole,
+		&shareRole,
+	)
+	if err != nil {
+		return domain.Project{}, err
+	}
+	if teamId.Valid {
+		id := int(teamId.Int64)
+		project.TeamId = &id
+	}
+	project.Role = effectiveRole(isOwner, teamRole, shareRole)
+	return project, nil
+}
+
+func checkProjectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if n == 0 {
+		return domain.ErrProjectNotFound
+	}
 	return nil
 }
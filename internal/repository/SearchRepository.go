@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"database/sql"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type PostgresSearchStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresSearchStore(DB *sql.DB) *PostgresSearchStore {
+	return &PostgresSearchStore{
+		DB: DB,
+	}
+}
+
+// Options of ts_headline, the delimiters are the domain ones so both stores build the same snippets
+const headlineOptions = `StartSel="` + domain.SnippetStart + `", StopSel="` + domain.SnippetStop + `", ` +
+	`MinWords=10, MaxWords=30, MaxFragments=2, FragmentDelimiter=" ... "`
+
+func (store *PostgresSearchStore) Search(cognitoId string, q domain.SearchQuery) ([]domain.SearchResult, error) {
+	// The search columns are generated from the title and description, see the 0004_search migration
+	rows, err := store.DB.Query(`
+	WITH Query AS (SELECT websearch_to_tsquery('english', $2) AS query)
+	SELECT kind, id, projectId, title, snippet, rank FROM (
+		SELECT
+		'project' AS kind,
+		Projects.id,
+		Projects.id AS projectId,
+		Projects.title,
+		ts_headline('english', concat_ws(' ', Projects.title, Projects.description), Query.query, $3) AS snippet,
+		ts_rank(Projects.search, Query.query) AS rank
+		FROM
+		Projects`+projectAccessJoins+`
+		CROSS JOIN Query
+		WHERE Projects.search @@ Query.query AND `+projectAccessFilter+`
+		UNION ALL
+		SELECT
+		'task',
+		Tasks.id,
+		Tasks.projectId,
+		Tasks.title,
+		ts_headline('english', concat_ws(' ', Tasks.title, Tasks.description), Query.query, $3),
+		ts_rank(Tasks.search, Query.query)
+		FROM
+		Tasks
+		INNER JOIN Projects ON Tasks.projectId=Projects.id`+projectAccessJoins+`
+		CROSS JOIN Query
+		WHERE Tasks.search @@ Query.query AND `+projectAccessFilter+`
+	) AS Results
+	ORDER BY rank DESC, kind, id
+	LIMIT $4`,
+		cognitoId, q.Text, headlineOptions, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []domain.SearchResult{}
+	for rows.Next() {
+		var r domain.SearchResult
+		if err := rows.Scan(&r.Type, &r.Id, &r.ProjectId, &r.Title, &r.Snippet, &r.Rank); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
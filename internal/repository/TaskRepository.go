@@ -2,10 +2,13 @@ package repo
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
 
 	"github.com/Desgue/ttracker-api/internal/domain"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresTaskStore struct {
@@ -18,17 +21,78 @@ func NewPostgresTaskStore(DB *sql.DB) *PostgresTaskStore {
 	}
 }
 
-// SELECT * from Tasks WHERE projectId=$1
-func (store *PostgresTaskStore) GetTasks(projectId int) ([]domain.Task, error) {
-	rows, err := store.DB.Query("SELECT * from Tasks WHERE projectId=$1", projectId)
+func (store *PostgresTaskStore) GetProjectRole(projectId int, cognitoId string) (domain.Role, error) {
+	return queryProjectRole(store.DB, projectId, cognitoId)
+}
+
+// Task columns scanned by scanTask, the assignees and labels are aggregated from TaskAssignees
+// and TaskLabels and the progress is counted from the direct subtasks
+const taskColumns = `
+	Tasks.id,
+	Tasks.title,
+	Tasks.description,
+	Tasks.status,
+	Tasks.createdAt,
+	Tasks.projectId,
+	Tasks.startDate,
+	Tasks.dueDate,
+	Tasks.version,
+	ARRAY(SELECT TaskAssignees.userId FROM TaskAssignees WHERE TaskAssignees.taskId=Tasks.id ORDER BY TaskAssignees.userId),
+	Tasks.parentId,
+	(SELECT COUNT(*) FILTER (WHERE Subtasks.status='Done') FROM Tasks AS Subtasks WHERE Subtasks.parentId=Tasks.id),
+	(SELECT COUNT(*) FROM Tasks AS Subtasks WHERE Subtasks.parentId=Tasks.id),
+	ARRAY(SELECT TaskLabels.labelId FROM TaskLabels WHERE TaskLabels.taskId=Tasks.id ORDER BY TaskLabels.labelId)`
+
+func (store *PostgresTaskStore) GetProjectUsers(projectId int, userIds []int) ([]int, error) {
+	rows, err := store.DB.Query(`
+	SELECT
+	Users.id
+	FROM
+	Users
+	INNER JOIN Projects ON Projects.id=$1
+	LEFT JOIN TeamMembers ON TeamMembers.teamId=Projects.teamId AND TeamMembers.userId=Users.id
+	LEFT JOIN ProjectShares ON ProjectShares.projectId=Projects.id AND ProjectShares.userId=Users.id
+	WHERE Users.id=ANY($2) AND (Projects.userId=Users.id OR TeamMembers.userId IS NOT NULL OR ProjectShares.userId IS NOT NULL)`,
+		projectId, pq.Array(userIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Tasks without a due date come last in ascending order and first in descending order,
+// the same places NULL takes by default
+var taskSortColumns = map[domain.SortField]sortColumn{
+	domain.SortCreatedAt: {"Tasks.createdAt", "timestamptz"},
+	domain.SortTitle:     {`Tasks.title COLLATE "C"`, "text"},
+	domain.SortStatus:    {"Tasks.status", "status"},
+	domain.SortDueDate:   {"COALESCE(Tasks.dueDate, 'infinity')", "timestamptz"},
+}
+
+func (store *PostgresTaskStore) GetTasks(projectId int, filter domain.TaskFilter, page domain.Page) ([]domain.Task, error) {
+	args := []any{projectId}
+	conditions := taskFilterConditions(filter, &args)
+	after, order := pageClause(page, taskSortColumns, "Tasks.id", &args)
+	query := "SELECT" + taskColumns + "\n\tFROM Tasks WHERE Tasks.projectId=$1" + conditions + after + order
+
+	rows, err := store.DB.Query(query, args...)
 	if err != nil {
 		log.Println("Error getting tasks from database: ", err)
 		return nil, err
 	}
+	defer rows.Close()
 	var tasks []domain.Task
 	for rows.Next() {
-		task := domain.Task{}
-		err = rows.Scan(&task.Id, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.ProjectId)
+		task, err := scanTask(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -36,47 +100,507 @@ func (store *PostgresTaskStore) GetTasks(projectId int) ([]domain.Task, error) {
 
 	}
 
-	return tasks, nil
+	return tasks, rows.Err()
 }
 
-func (store *PostgresTaskStore) GetTaskById(id string) (domain.Task, error) {
-	rows, err := store.DB.Query("SELECT * from Tasks WHERE id=$1", id)
+func (store *PostgresTaskStore) GetTaskById(projectId int, taskId string) (domain.Task, error) {
+	id, err := strconv.Atoi(taskId)
+	if err != nil {
+		return domain.Task{}, domain.ErrTaskNotFound
+	}
+	row := store.DB.QueryRow("SELECT"+taskColumns+"\n\tFROM Tasks WHERE Tasks.id=$1 AND Tasks.projectId=$2", id, projectId)
+	task, err := scanTask(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Task{}, domain.ErrTaskNotFound
+	}
 	if err != nil {
 		log.Println("Error getting task from database: ", err)
 		return domain.Task{}, err
 	}
-	var task domain.Task
+	return task, nil
+}
+
+func (store *PostgresTaskStore) GetDescendants(projectId int, taskIds []int) ([]domain.Task, error) {
+	rows, err := store.DB.Query(`
+	WITH RECURSIVE Descendants(id) AS (
+		SELECT id FROM Tasks WHERE parentId=ANY($2) AND projectId=$1
+		UNION
+		SELECT Tasks.id FROM Tasks INNER JOIN Descendants ON Tasks.parentId=Descendants.id
+	)
+	SELECT`+taskColumns+`
+	FROM Tasks WHERE Tasks.id IN (SELECT id FROM Descendants)
+	ORDER BY Tasks.createdAt, Tasks.id`,
+		projectId, pq.Array(taskIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tasks []domain.Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (store *PostgresTaskStore) GetAssignedTasks(cognitoId string, filter domain.TaskFilter) ([]domain.AssignedTask, error) {
+	// Assignments are kept when the user loses access to a project,
+	// the access filter hides those projects until access is granted again
+	filter.AssigneeId, filter.AssigneeCognitoId = 0, ""
+	args := []any{cognitoId}
+	rows, err := store.DB.Query(`
+	SELECT`+taskColumns+`,
+	Projects.title
+	FROM
+	Tasks
+	INNER JOIN TaskAssignees ON TaskAssignees.taskId=Tasks.id
+	INNER JOIN Projects ON Tasks.projectId=Projects.id`+projectAccessJoins+`
+	WHERE TaskAssignees.userId=Caller.id AND `+projectAccessFilter+taskFilterConditions(filter, &args)+`
+	ORDER BY Tasks.projectId, Tasks.status, Tasks.id`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tasks := []domain.AssignedTask{}
 	for rows.Next() {
-		task = domain.Task{}
-		err = rows.Scan(&task.Id, &task.Title, &task.Description, &task.Status, &task.CreatedAt)
+		var task domain.AssignedTask
+		task.Task, err = scanTask(rows, &task.ProjectTitle)
 		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (store *PostgresTaskStore) CreateTask(p *domain.CreateTaskRequest) (domain.Task, error) {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return domain.Task{}, err
+	}
+	defer tx.Rollback()
+
+	if p.ParentId != nil {
+		if err := checkParent(tx, p.ProjectId, 0, *p.ParentId); err != nil {
 			return domain.Task{}, err
 		}
 	}
-	return task, nil
+	var taskId int
+	err = tx.QueryRow(`
+	INSERT INTO Tasks (title, description, status, projectId, startDate, dueDate, parentId)
+	VALUES($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id`,
+		p.Title, p.Description, p.Status, p.ProjectId, p.StartDate, p.DueDate, p.ParentId).Scan(&taskId)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	if err := setAssignees(tx, taskId, p.Assignees); err != nil {
+		return domain.Task{}, err
+	}
+	if err := setLabels(tx, p.ProjectId, taskId, p.Labels); err != nil {
+		return domain.Task{}, err
+	}
+	task, err := queryTask(tx, taskId)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	if err := touchTasks(tx, progressParents(domain.Task{}, task)); err != nil {
+		return domain.Task{}, err
+	}
+	return task, tx.Commit()
+}
+
+func (store *PostgresTaskStore) UpdateTask(taskId string, p *domain.CreateTaskRequest) (domain.Task, error) {
+	return store.patch(taskId, p.ProjectId, taskWrite{
+		columns: []column{
+			{"title", p.Title},
+			{"description", p.Description},
+			{"status", p.Status},
+			{"startDate", p.StartDate},
+			{"dueDate", p.DueDate},
+			{"parentId", p.ParentId},
+		},
+		assignees: &p.Assignees,
+		labels:    &p.Labels,
+		parentId:  p.ParentId,
+		version:   p.Version,
+	})
+}
+
+func (store *PostgresTaskStore) PatchTask(taskId string, p *domain.PatchTaskRequest) (domain.Task, error) {
+	var columns []column
+	if p.Title.Set {
+		columns = append(columns, column{"title", p.Title.Value})
+	}
+	if p.Description.Set {
+		columns = append(columns, column{"description", p.Description.Value})
+	}
+	if p.Status.Set {
+		columns = append(columns, column{"status", p.Status.Value})
+	}
+	if p.StartDate.Set {
+		columns = append(columns, column{"startDate", p.StartDate.Value})
+	}
+	if p.DueDate.Set {
+		columns = append(columns, column{"dueDate", p.DueDate.Value})
+	}
+	w := taskWrite{columns: columns, version: p.Version}
+	if p.ParentId.Set {
+		w.columns = append(w.columns, column{"parentId", p.ParentId.Value})
+		w.parentId = p.ParentId.Value
+	}
+	if p.Assignees.Set {
+		w.assignees = &p.Assignees.Value
+	}
+	if p.Labels.Set {
+		w.labels = &p.Labels.Value
+	}
+	return store.patch(taskId, p.ProjectId, w)
+}
+
+// Changes written by patch, the assignees and labels are left as they are when nil
+type taskWrite struct {
+	columns   []column
+	assignees *[]int
+	labels    *[]int
+	// New parent written by the columns, checked with checkParent unless it is nil
+	parentId *int
+	version  int
 }
 
-func (store *PostgresTaskStore) CreateTask(p *domain.CreateTaskRequest) error {
-	_, err := store.DB.Exec("INSERT INTO Tasks (title, description, status, projectId) VALUES($1, $2, $3, $4)", p.Title, p.Description, p.Status, p.ProjectId)
+// Writes the changes and reads the task back in the same transaction. The task must
+// belong to the project, its version is only incremented when something is written
+func (store *PostgresTaskStore) patch(taskId string, projectId int, w taskWrite) (domain.Task, error) {
+	id, err := strconv.Atoi(taskId)
+	if err != nil {
+		return domain.Task{}, domain.ErrTaskNotFound
+	}
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return domain.Task{}, err
+	}
+	defer tx.Rollback()
+
+	// The tree is locked before the task so moves wait on each other without holding rows
+	if w.parentId != nil {
+		if err := lockTaskTree(tx, projectId); err != nil {
+			return domain.Task{}, err
+		}
+	}
+	err = lockVersion(tx, "SELECT version FROM Tasks WHERE id=$1 AND projectId=$2 FOR UPDATE", []any{id, projectId}, w.version, domain.ErrTaskNotFound)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	if w.parentId != nil {
+		if err := checkParent(tx, projectId, id, *w.parentId); err != nil {
+			return domain.Task{}, err
+		}
+	}
+	before, err := queryTaskPlacement(tx, id)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	if len(w.columns) > 0 || w.assignees != nil || w.labels != nil {
+		set, args := setClause(w.columns)
+		if set != "" {
+			set += ", "
+		}
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE Tasks SET %sversion=version+1 WHERE id=$%d", set, len(args)+1), append(args, id)...); err != nil {
+			return domain.Task{}, err
+		}
+	}
+	if w.assignees != nil {
+		if _, err := tx.Exec("DELETE FROM TaskAssignees WHERE taskId=$1", id); err != nil {
+			return domain.Task{}, err
+		}
+		if err := setAssignees(tx, id, *w.assignees); err != nil {
+			return domain.Task{}, err
+		}
+	}
+	if w.labels != nil {
+		if _, err := tx.Exec("DELETE FROM TaskLabels WHERE taskId=$1", id); err != nil {
+			return domain.Task{}, err
+		}
+		if err := setLabels(tx, projectId, id, *w.labels); err != nil {
+			return domain.Task{}, err
+		}
+	}
+	task, err := queryTask(tx, id)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	if err := touchTasks(tx, progressParents(before, task)); err != nil {
+		return domain.Task{}, err
+	}
+	return task, tx.Commit()
+}
+
+func (store *PostgresTaskStore) DeleteTask(projectId int, taskId string, version int) error {
+	id, err := strconv.Atoi(taskId)
+	if err != nil {
+		return domain.ErrTaskNotFound
+	}
+	tx, err := store.DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	err = lockVersion(tx, "SELECT version FROM Tasks WHERE id=$1 AND projectId=$2 FOR UPDATE", []any{id, projectId}, version, domain.ErrTaskNotFound)
+	if err != nil {
+		return err
+	}
+	before, err := queryTaskPlacement(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := touchTasks(tx, progressParents(before, domain.Task{})); err != nil {
+		return err
+	}
+	// Written here rather than left to the foreign key so the versions of the subtasks change
+	if _, err := tx.Exec("UPDATE Tasks SET parentId=NULL, version=version+1 WHERE parentId=$1", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Tasks WHERE id=$1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setAssignees(tx *sql.Tx, taskId int, userIds []int) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+	INSERT INTO TaskAssignees (taskId, userId)
+	SELECT $1, UNNEST($2::integer[])
+	ON CONFLICT DO NOTHING`,
+		taskId, pq.Array(userIds))
+	return err
 }
 
-func (store *PostgresTaskStore) UpdateTask(id string, p *domain.CreateTaskRequest) error {
-	_, err := store.DB.Exec("UPDATE Tasks SET title=$1, description=$2, status=$3 WHERE id=$4", p.Title, p.Description, p.Status, id)
+// Tags the task with the labels, ErrInvalidLabel when any of them is not a label of the project
+func setLabels(tx *sql.Tx, projectId, taskId int, labelIds []int) error {
+	if len(labelIds) == 0 {
+		return nil
+	}
+	res, err := tx.Exec(`
+	INSERT INTO TaskLabels (taskId, labelId)
+	SELECT $1, Labels.id FROM Labels WHERE Labels.id=ANY($2) AND Labels.projectId=$3
+	ON CONFLICT DO NOTHING`,
+		taskId, pq.Array(labelIds), projectId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	unique := map[int]bool{}
+	for _, id := range labelIds {
+		unique[id] = true
+	}
+	if int(n) != len(unique) {
+		return domain.ErrInvalidLabel
+	}
 	return nil
 }
 
-func (store *PostgresTaskStore) DeleteTask(id string) error {
-	_, err := store.DB.Exec("DELETE FROM Tasks WHERE id=$1", id)
+// Builds the AND conditions of the filter, the values are appended to args
+// so the placeholders keep counting from the arguments already used by the query
+func taskFilterConditions(filter domain.TaskFilter, args *[]any) string {
+	var conditions string
+	add := func(condition string, value any) {
+		*args = append(*args, value)
+		conditions += fmt.Sprintf(condition, len(*args))
+	}
+	if filter.AssigneeId != 0 {
+		add(" AND EXISTS (SELECT 1 FROM TaskAssignees WHERE TaskAssignees.taskId=Tasks.id AND TaskAssignees.userId=$%d)", filter.AssigneeId)
+	}
+	if filter.AssigneeCognitoId != "" {
+		add(` AND EXISTS (
+		SELECT 1 FROM TaskAssignees INNER JOIN Users ON TaskAssignees.userId=Users.id
+		WHERE TaskAssignees.taskId=Tasks.id AND Users.cognitoId=$%d)`, filter.AssigneeCognitoId)
+	}
+	if filter.Status != "" {
+		add(" AND Tasks.status = $%d", filter.Status)
+	}
+	if filter.CreatedAfter != nil {
+		add(" AND Tasks.createdAt > $%d", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		add(" AND Tasks.createdAt < $%d", *filter.CreatedBefore)
+	}
+	if filter.DueBefore != nil {
+		add(" AND Tasks.dueDate < $%d", *filter.DueBefore)
+	}
+	if filter.DueAfter != nil {
+		add(" AND Tasks.dueDate > $%d", *filter.DueAfter)
+	}
+	if filter.TopLevel {
+		conditions += " AND Tasks.parentId IS NULL"
+	}
+	if filter.ParentId != 0 {
+		add(" AND Tasks.parentId=$%d", filter.ParentId)
+	}
+	if len(filter.Labels) > 0 {
+		// The labels of the task contain all the filtered labels or share any of them
+		operator := "&&"
+		if filter.AllLabels {
+			operator = "@>"
+		}
+		add(" AND ARRAY(SELECT TaskLabels.labelId FROM TaskLabels WHERE TaskLabels.taskId=Tasks.id) "+operator+" $%d::integer[]", pq.Array(filter.Labels))
+	}
+	if filter.Overdue != nil {
+		// Same definition as domain.Task.IsOverdue
+		overdue := "Tasks.dueDate < NOW() AND Tasks.status <> 'Done'"
+		if *filter.Overdue {
+			conditions += " AND " + overdue
+		} else {
+			conditions += " AND (" + overdue + ") IS NOT TRUE"
+		}
+	}
+	return conditions
+}
+
+func queryTask(db rowQuerier, taskId int) (domain.Task, error) {
+	return scanTask(db.QueryRow("SELECT"+taskColumns+"\n\tFROM Tasks WHERE Tasks.id=$1", taskId))
+}
+
+// Scans the task columns followed by any extra column selected by the query
+func scanTask(row rowScanner, extra ...any) (domain.Task, error) {
+	var task domain.Task
+	var assignees, labels pq.Int64Array
+	var startDate, dueDate sql.NullTime
+	var parentId sql.NullInt64
+	dest := []any{
+		&task.Id,
+		&task.Title,
+		&task.Description,
+		&task.Status,
+		&task.CreatedAt,
+		&task.ProjectId,
+		&startDate,
+		&dueDate,
+		&task.Version,
+		&assignees,
+		&parentId,
+		&task.Subtasks.Done,
+		&task.Subtasks.Total,
+		&labels,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return domain.Task{}, err
+	}
+	if startDate.Valid {
+		task.StartDate = &startDate.Time
+	}
+	if dueDate.Valid {
+		task.DueDate = &dueDate.Time
+	}
+	if parentId.Valid {
+		id := int(parentId.Int64)
+		task.ParentId = &id
+	}
+	task.Assignees = toInts(assignees)
+	task.Labels = toInts(labels)
+	return task, nil
+}
+
+// Key of the advisory lock serializing the moves of tasks, the second key is the project id
+const taskTreeLockKey = 5
+
+// Held until the end of the transaction, two moves checked at the same time could
+// otherwise put two tasks under each other
+func lockTaskTree(tx *sql.Tx, projectId int) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2)", taskTreeLockKey, projectId)
+	return err
+}
+
+// Checks the parent is a task of the project and, unless taskId is zero for a new task,
+// that it is neither the task nor one of its subtasks. The parent is locked so it can
+// not be deleted before the transaction ends, moves must hold lockTaskTree
+func checkParent(tx *sql.Tx, projectId, taskId, parentId int) error {
+	var id int
+	err := tx.QueryRow("SELECT id FROM Tasks WHERE id=$1 AND projectId=$2 FOR KEY SHARE", parentId, projectId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrInvalidParent
+	}
+	if err != nil || taskId == 0 {
+		return err
+	}
+	var cycle bool
+	err = tx.QueryRow(`
+	WITH RECURSIVE Ancestors(id, parentId) AS (
+		SELECT id, parentId FROM Tasks WHERE id=$1
+		UNION
+		SELECT Tasks.id, Tasks.parentId FROM Tasks INNER JOIN Ancestors ON Tasks.id=Ancestors.parentId
+	)
+	SELECT EXISTS(SELECT 1 FROM Ancestors WHERE id=$2)`,
+		parentId, taskId).Scan(&cycle)
 	if err != nil {
 		return err
 	}
+	if cycle {
+		return domain.ErrTaskCycle
+	}
 	return nil
 }
+
+// Reads only the parent and status of the task, what its parent progress depends on
+func queryTaskPlacement(tx *sql.Tx, taskId int) (domain.Task, error) {
+	var task domain.Task
+	var parentId sql.NullInt64
+	if err := tx.QueryRow("SELECT status, parentId FROM Tasks WHERE id=$1", taskId).Scan(&task.Status, &parentId); err != nil {
+		return domain.Task{}, err
+	}
+	if parentId.Valid {
+		id := int(parentId.Int64)
+		task.ParentId = &id
+	}
+	return task, nil
+}
+
+// Increments the version of the tasks, used for the parents whose progress changed
+func touchTasks(tx *sql.Tx, taskIds []int) error {
+	if len(taskIds) == 0 {
+		return nil
+	}
+	_, err := tx.Exec("UPDATE Tasks SET version=version+1 WHERE id=ANY($1)", pq.Array(taskIds))
+	return err
+}
+
+// Parents whose subtask progress changes when a task goes from before to after, the progress
+// is part of the parents so their version must change with it. A zero task stands for a task
+// that does not exist, before it is created or after it is deleted
+func progressParents(before, after domain.Task) []int {
+	if sameParent(before.ParentId, after.ParentId) {
+		if before.ParentId != nil && (before.Status == domain.Done) != (after.Status == domain.Done) {
+			return []int{*before.ParentId}
+		}
+		return nil
+	}
+	var ids []int
+	for _, id := range []*int{before.ParentId, after.ParentId} {
+		if id != nil {
+			ids = append(ids, *id)
+		}
+	}
+	return ids
+}
+
+func sameParent(a, b *int) bool {
+	return a == b || (a != nil && b != nil && *a == *b)
+}
+
+func toInts(a pq.Int64Array) []int {
+	ints := make([]int, len(a))
+	for i, v := range a {
+		ints[i] = int(v)
+	}
+	return ints
+}
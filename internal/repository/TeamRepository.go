@@ -2,10 +2,15 @@ package repo
 
 import (
 	"database/sql"
+	"errors"
 
 	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/lib/pq"
 )
 
+// Postgres error code raised when a unique or primary key constraint is violated
+const uniqueViolation = "23505"
+
 type PostgresTeamStore struct {
 	DB *sql.DB
 }
@@ -18,12 +23,15 @@ func NewPostgresTeamStore(DB *sql.DB) *PostgresTeamStore {
 
 func (store *PostgresTeamStore) GetTeam(id int) (domain.Team, error) {
 	var team domain.Team
-	err := store.DB.QueryRow("SELECT * from Teams WHERE id=$1", id).Scan(
+	err := store.DB.QueryRow("SELECT id, name, description, adminId from Teams WHERE id=$1", id).Scan(
 		&team.Id,
 		&team.Name,
 		&team.Description,
 		&team.AdminId,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Team{}, domain.ErrTeamNotFound
+	}
 	if err != nil {
 		return domain.Team{}, err
 	}
@@ -31,12 +39,312 @@ func (store *PostgresTeamStore) GetTeam(id int) (domain.Team, error) {
 	return team, nil
 }
 
+func (store *PostgresTeamStore) GetTeams(cognitoId string) ([]domain.Team, error) {
+	rows, err := store.DB.Query(`
+	SELECT
+	Teams.id,
+	Teams.name,
+	Teams.description,
+	Teams.adminId,
+	TeamMembers.role
+	FROM
+	Teams
+	INNER JOIN TeamMembers ON TeamMembers.teamId=Teams.id
+	INNER JOIN Users ON TeamMembers.userId=Users.id
+	WHERE Users.cognitoId=$1
+	ORDER BY Teams.id`,
+		cognitoId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	teams := []domain.Team{}
+	for rows.Next() {
+		team := domain.Team{}
+		err = rows.Scan(&team.Id, &team.Name, &team.Description, &team.AdminId, &team.Role)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
+}
+
+// Create the team and add its creator as the first owner
 func (store *PostgresTeamStore) CreateTeam(p *domain.CreateTeamRequest) error {
-	_, err := store.DB.Exec(`
-	INSERT INTO Teams (name, description, adminId) VALUES($1, $2, $3)`,
-		p.Name, p.Description, p.AdminId)
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var teamId, userId int
+	err = tx.QueryRow(`
+	INSERT INTO Teams (name, description, adminId)
+	SELECT $1, $2, Users.id FROM Users WHERE Users.cognitoId=$3
+	RETURNING id, adminId`,
+		p.Name, p.Description, p.UserCognitoId).Scan(&teamId, &userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO TeamMembers (teamId, userId, role) VALUES($1, $2, $3)", teamId, userId, domain.RoleOwner)
 	if err != nil {
 		return err
 	}
+	return tx.Commit()
+}
+
+func (store *PostgresTeamStore) GetMemberRole(teamId int, cognitoId string) (domain.Role, error) {
+	var role sql.NullString
+	err := store.DB.QueryRow(`
+	SELECT
+	TeamMembers.role
+	FROM
+	Teams
+	LEFT JOIN (TeamMembers INNER JOIN Users ON TeamMembers.userId=Users.id AND Users.cognitoId=$2)
+	ON TeamMembers.teamId=Teams.id
+	WHERE Teams.id=$1`,
+		teamId, cognitoId).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", domain.ErrTeamNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if !role.Valid {
+		return "", domain.ErrTeamMemberNotFound
+	}
+	return domain.Role(role.String), nil
+}
+
+func (store *PostgresTeamStore) GetMember(teamId, userId int) (domain.TeamMember, error) {
+	var member domain.TeamMember
+	err := store.DB.QueryRow(`
+	SELECT
+	TeamMembers.teamId,
+	TeamMembers.userId,
+	Users.cognitoId,
+	TeamMembers.role,
+	TeamMembers.joinedAt
+	FROM
+	TeamMembers
+	INNER JOIN Users ON TeamMembers.userId=Users.id
+	WHERE TeamMembers.teamId=$1 AND TeamMembers.userId=$2`,
+		teamId, userId).Scan(&member.TeamId, &member.UserId, &member.CognitoId, &member.Role, &member.JoinedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.TeamMember{}, domain.ErrTeamMemberNotFound
+	}
+	if err != nil {
+		return domain.TeamMember{}, err
+	}
+	return member, nil
+}
+
+func (store *PostgresTeamStore) GetMembers(teamId int) ([]domain.TeamMember, error) {
+	rows, err := store.DB.Query(`
+	SELECT
+	TeamMembers.teamId,
+	TeamMembers.userId,
+	Users.cognitoId,
+	TeamMembers.role,
+	TeamMembers.joinedAt
+	FROM
+	TeamMembers
+	INNER JOIN Users ON TeamMembers.userId=Users.id
+	WHERE TeamMembers.teamId=$1
+	ORDER BY TeamMembers.joinedAt`,
+		teamId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []domain.TeamMember{}
+	for rows.Next() {
+		member := domain.TeamMember{}
+		err = rows.Scan(&member.TeamId, &member.UserId, &member.CognitoId, &member.Role, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (store *PostgresTeamStore) CountOwners(teamId int) (int, error) {
+	var count int
+	err := store.DB.QueryRow("SELECT COUNT(*) FROM TeamMembers WHERE teamId=$1 AND role=$2", teamId, domain.RoleOwner).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (store *PostgresTeamStore) AddMember(teamId, userId int, role domain.Role) error {
+	res, err := store.DB.Exec(`
+	INSERT INTO TeamMembers (teamId, userId, role)
+	SELECT $1, Users.id, $3 FROM Users WHERE Users.id=$2`,
+		teamId, userId, role)
+	if isUniqueViolation(err) {
+		return domain.ErrAlreadyTeamMember
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrUserNotFound
+	}
 	return nil
 }
+
+func (store *PostgresTeamStore) UpdateMemberRole(teamId, userId int, role domain.Role) error {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role != domain.RoleOwner {
+		if err := checkNotLastOwner(tx, teamId, userId); err != nil {
+			return err
+		}
+	}
+	res, err := tx.Exec("UPDATE TeamMembers SET role=$1 WHERE teamId=$2 AND userId=$3", role, teamId, userId)
+	if err != nil {
+		return err
+	}
+	if err := checkMemberAffected(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PostgresTeamStore) RemoveMember(teamId, userId int) error {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkNotLastOwner(tx, teamId, userId); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM TeamMembers WHERE teamId=$1 AND userId=$2", teamId, userId)
+	if err != nil {
+		return err
+	}
+	if err := checkMemberAffected(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Returns ErrLastTeamOwner if userId is the only owner of the team. The owner rows stay locked
+// until tx ends, so owners demoting or removing each other concurrently are checked one after the other
+func checkNotLastOwner(tx *sql.Tx, teamId, userId int) error {
+	rows, err := tx.Query("SELECT userId FROM TeamMembers WHERE teamId=$1 AND role=$2 FOR UPDATE", teamId, domain.RoleOwner)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var owners []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		owners = append(owners, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(owners) == 1 && owners[0] == userId {
+		return domain.ErrLastTeamOwner
+	}
+	return nil
+}
+
+func (store *PostgresTeamStore) CreateInvitation(r *domain.CreateTeamInvitationRequest, tokenHash string) (domain.TeamInvitation, error) {
+	var invitation domain.TeamInvitation
+	err := store.DB.QueryRow(`
+	INSERT INTO TeamInvitations (teamId, role, tokenHash, invitedBy, expiresAt)
+	SELECT $1, $2, $3, Users.id, $5 FROM Users WHERE Users.cognitoId=$4
+	RETURNING id, teamId, role, createdAt, expiresAt`,
+		r.TeamId, r.Role, tokenHash, r.UserCognitoId, r.ExpiresAt).Scan(
+		&invitation.Id,
+		&invitation.TeamId,
+		&invitation.Role,
+		&invitation.CreatedAt,
+		&invitation.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.TeamInvitation{}, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return domain.TeamInvitation{}, err
+	}
+	return invitation, nil
+}
+
+func (store *PostgresTeamStore) AcceptInvitation(tokenHash, cognitoId string) (domain.TeamMember, error) {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return domain.TeamMember{}, err
+	}
+	defer tx.Rollback()
+
+	member := domain.TeamMember{CognitoId: cognitoId}
+	err = tx.QueryRow(`
+	UPDATE TeamInvitations
+	SET acceptedAt=NOW()
+	WHERE tokenHash=$1 AND acceptedAt IS NULL AND expiresAt > NOW()
+	RETURNING teamId, role`,
+		tokenHash).Scan(&member.TeamId, &member.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.TeamMember{}, domain.ErrInvitationNotFound
+	}
+	if err != nil {
+		return domain.TeamMember{}, err
+	}
+
+	err = tx.QueryRow(`
+	INSERT INTO TeamMembers (teamId, userId, role)
+	SELECT $1, Users.id, $2 FROM Users WHERE Users.cognitoId=$3
+	RETURNING userId, joinedAt`,
+		member.TeamId, member.Role, cognitoId).Scan(&member.UserId, &member.JoinedAt)
+	if isUniqueViolation(err) {
+		return domain.TeamMember{}, domain.ErrAlreadyTeamMember
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.TeamMember{}, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return domain.TeamMember{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.TeamMember{}, err
+	}
+	return member, nil
+}
+
+func checkMemberAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrTeamMemberNotFound
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
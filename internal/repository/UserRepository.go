@@ -2,6 +2,7 @@ package repo
 
 import (
 	"database/sql"
+	"errors"
 
 	_ "github.com/lib/pq"
 )
@@ -20,14 +21,23 @@ func NewPostgresUserStore(DB *sql.DB) *PostgresUserStore {
 func (store *PostgresUserStore) CheckUser(cognitoId string) (bool, error) {
 	var id string
 	err := store.DB.QueryRow("SELECT cognitoId from Users where cognitoId=$1", cognitoId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
+// Insert the user or do nothing if it already exists, safe to call from concurrent requests.
+// Existing users are skipped before inserting, every insert attempt would use up an id of the
+// identity even when ON CONFLICT discards the row, which is only left to settle races
 func (store *PostgresUserStore) CreateUser(cognitoId string) error {
-	_, err := store.DB.Exec("INSERT INTO Users (cognitoId) VALUES($1)", cognitoId)
+	_, err := store.DB.Exec(`
+	INSERT INTO Users (cognitoId)
+	SELECT $1::varchar WHERE NOT EXISTS (SELECT 1 FROM Users WHERE cognitoId=$1::varchar)
+	ON CONFLICT (cognitoId) DO NOTHING`, cognitoId)
 	if err != nil {
 		return err
 	}
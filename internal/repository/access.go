@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Joins used to compute the role of the user $1 in every project: Owner for the project creator,
+// otherwise the highest of the role in the team owning the project and the role of a direct share
+const projectAccessJoins = `
+	INNER JOIN Users AS Owner ON Projects.userId=Owner.id
+	LEFT JOIN Users AS Caller ON Caller.cognitoId=$1
+	LEFT JOIN TeamMembers ON TeamMembers.teamId=Projects.teamId AND TeamMembers.userId=Caller.id
+	LEFT JOIN ProjectShares ON ProjectShares.projectId=Projects.id AND ProjectShares.userId=Caller.id`
+
+// Columns scanned by scanRole, must be selected in this order
+const projectAccessColumns = `
+	Owner.cognitoId=$1,
+	TeamMembers.role,
+	ProjectShares.role`
+
+// Filters the projects the user $1 has any role in
+const projectAccessFilter = `(Owner.cognitoId=$1 OR TeamMembers.userId IS NOT NULL OR ProjectShares.userId IS NOT NULL)`
+
+func queryProjectRole(db *sql.DB, projectId int, cognitoId string) (domain.Role, error) {
+	var isOwner bool
+	var teamRole, shareRole sql.NullString
+	err := db.QueryRow(`
+	SELECT`+projectAccessColumns+`
+	FROM
+	Projects`+projectAccessJoins+`
+	WHERE Projects.id=$2`,
+		cognitoId, projectId).Scan(&isOwner, &teamRole, &shareRole)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", domain.ErrProjectNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	role := effectiveRole(isOwner, teamRole, shareRole)
+	if role == "" {
+		return "", domain.ErrForbidden
+	}
+	return role, nil
+}
+
+func effectiveRole(isOwner bool, roles ...sql.NullString) domain.Role {
+	if isOwner {
+		return domain.RoleOwner
+	}
+	var highest domain.Role
+	for _, r := range roles {
+		if r.Valid && domain.Role(r.String).AtLeast(highest) {
+			highest = domain.Role(r.String)
+		}
+	}
+	return highest
+}
@@ -0,0 +1,48 @@
+package repo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/Desgue/ttracker-api/internal/repository/storagetest"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	storagetest.RunBlobs(t, func(t *testing.T) domain.BlobStore {
+		store, err := NewLocalBlobStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+// TEST_S3_ENDPOINT must be the url of an S3 compatible service, like a local MinIO, where
+// TEST_S3_BUCKET exists. The keys are written under a random prefix and deleted by the suite
+func TestS3BlobStore(t *testing.T) {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT not set, skipping the S3 blob store tests")
+	}
+	storagetest.RunBlobs(t, func(t *testing.T) domain.BlobStore {
+		prefix := make([]byte, 4)
+		if _, err := rand.Read(prefix); err != nil {
+			t.Fatal(err)
+		}
+		store, err := NewS3BlobStore(S3Config{
+			Endpoint:  endpoint,
+			Region:    os.Getenv("TEST_S3_REGION"),
+			Bucket:    os.Getenv("TEST_S3_BUCKET"),
+			AccessKey: os.Getenv("TEST_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("TEST_S3_SECRET_KEY"),
+			Prefix:    "ttracker_test_" + hex.EncodeToString(prefix) + "/",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
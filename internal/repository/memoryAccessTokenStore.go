@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type KVAccessTokenStore struct {
+	DB *KVRepository
+}
+
+func NewKVAccessTokenStore(DB *KVRepository) *KVAccessTokenStore {
+	return &KVAccessTokenStore{
+		DB: DB,
+	}
+}
+
+func (store *KVAccessTokenStore) CreateToken(r *domain.CreateAccessTokenRequest, tokenHash string) (domain.AccessToken, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	user, ok := store.DB.userByCognito(r.UserCognitoId)
+	if !ok {
+		return domain.AccessToken{}, domain.ErrUserNotFound
+	}
+	store.DB.lastTokenId++
+	token := domain.AccessToken{
+		Id:        store.DB.lastTokenId,
+		Name:      r.Name,
+		Scopes:    append([]string{}, r.Scopes...),
+		CreatedAt: time.Now(),
+		ExpiresAt: copyTime(r.ExpiresAt),
+		CognitoId: r.UserCognitoId,
+	}
+	store.DB.tokens[token.Id] = kvToken{token: token, userId: user.id, hash: tokenHash}
+	return copyToken(token), nil
+}
+
+func (store *KVAccessTokenStore) GetTokens(cognitoId string) ([]domain.AccessToken, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	tokens := []domain.AccessToken{}
+	user, ok := store.DB.userByCognito(cognitoId)
+	if !ok {
+		return tokens, nil
+	}
+	for _, t := range store.DB.tokens {
+		if t.userId == user.id {
+			tokens = append(tokens, copyToken(t.token))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].Id < tokens[j].Id
+		}
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+func (store *KVAccessTokenStore) DeleteToken(id int, cognitoId string) error {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	t, ok := store.DB.tokens[id]
+	if !ok || store.DB.users[t.userId].cognitoId != cognitoId {
+		return domain.ErrAccessTokenNotFound
+	}
+	delete(store.DB.tokens, id)
+	return nil
+}
+
+func (store *KVAccessTokenStore) UseToken(tokenHash string, now time.Time) (domain.AccessToken, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	for id, t := range store.DB.tokens {
+		if t.hash == tokenHash {
+			if t.token.ExpiresAt == nil || !now.After(*t.token.ExpiresAt) {
+				t.token.LastUsedAt = &now
+				store.DB.tokens[id] = t
+			}
+			return copyToken(t.token), nil
+		}
+	}
+	return domain.AccessToken{}, domain.ErrAccessTokenNotFound
+}
+
+func copyToken(t domain.AccessToken) domain.AccessToken {
+	t.Scopes = append([]string{}, t.Scopes...)
+	t.ExpiresAt = copyTime(t.ExpiresAt)
+	t.LastUsedAt = copyTime(t.LastUsedAt)
+	return t
+}
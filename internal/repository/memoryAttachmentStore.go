@@ -0,0 +1,137 @@
+package repo
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type KVAttachmentStore struct {
+	DB *KVRepository
+}
+
+func NewKVAttachmentStore(DB *KVRepository) *KVAttachmentStore {
+	return &KVAttachmentStore{
+		DB: DB,
+	}
+}
+
+func (store *KVAttachmentStore) GetProjectRole(projectId int, cognitoId string) (domain.Role, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+	return store.DB.projectRoleOf(projectId, cognitoId)
+}
+
+func (store *KVAttachmentStore) GetAttachments(projectId, taskId int) ([]domain.Attachment, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	if task, ok := store.DB.tasks[taskId]; !ok || task.ProjectId != projectId {
+		return nil, domain.ErrTaskNotFound
+	}
+	attachments := []domain.Attachment{}
+	for _, attachment := range store.DB.attachments {
+		if attachment.TaskId == taskId {
+			attachments = append(attachments, attachment)
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		if !attachments[i].CreatedAt.Equal(attachments[j].CreatedAt) {
+			return attachments[i].CreatedAt.Before(attachments[j].CreatedAt)
+		}
+		return attachments[i].Id < attachments[j].Id
+	})
+	return attachments, nil
+}
+
+func (store *KVAttachmentStore) GetAttachment(projectId, taskId, attachmentId int) (domain.Attachment, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+	return store.DB.attachmentOf(projectId, taskId, attachmentId)
+}
+
+func (store *KVAttachmentStore) CreateAttachment(r *domain.AttachmentRequest) (domain.Attachment, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	if task, ok := store.DB.tasks[r.TaskId]; !ok || task.ProjectId != r.ProjectId {
+		return domain.Attachment{}, domain.ErrTaskNotFound
+	}
+	if _, ok := store.DB.userByCognito(r.UserCognitoId); !ok {
+		return domain.Attachment{}, domain.ErrTaskNotFound
+	}
+	store.DB.lastAttachmentId++
+	attachment := domain.Attachment{
+		Id:          store.DB.lastAttachmentId,
+		TaskId:      r.TaskId,
+		Name:        r.Name,
+		ContentType: r.ContentType,
+		Size:        r.Size,
+		UploadedBy:  r.UserCognitoId,
+		CreatedAt:   time.Now(),
+		BlobKey:     r.BlobKey,
+	}
+	store.DB.attachments[attachment.Id] = attachment
+	return attachment, nil
+}
+
+func (store *KVAttachmentStore) DetachAttachment(projectId, taskId, attachmentId int) (domain.Attachment, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	attachment, err := store.DB.attachmentOf(projectId, taskId, attachmentId)
+	if err != nil {
+		return domain.Attachment{}, err
+	}
+	attachment.TaskId = 0
+	store.DB.attachments[attachmentId] = attachment
+	return attachment, nil
+}
+
+func (store *KVAttachmentStore) GetDetachedAttachments(limit int) ([]domain.Attachment, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	attachments := []domain.Attachment{}
+	for _, attachment := range store.DB.attachments {
+		if attachment.TaskId == 0 {
+			attachments = append(attachments, attachment)
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool { return attachments[i].Id < attachments[j].Id })
+	if len(attachments) > limit {
+		attachments = attachments[:limit]
+	}
+	return attachments, nil
+}
+
+func (store *KVAttachmentStore) DeleteAttachment(attachmentId int) error {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	attachment, ok := store.DB.attachments[attachmentId]
+	if !ok || attachment.TaskId != 0 {
+		return domain.ErrAttachmentNotFound
+	}
+	delete(store.DB.attachments, attachmentId)
+	return nil
+}
+
+func (db *KVRepository) attachmentOf(projectId, taskId, attachmentId int) (domain.Attachment, error) {
+	attachment, ok := db.attachments[attachmentId]
+	if !ok || attachment.TaskId == 0 || attachment.TaskId != taskId || db.tasks[taskId].ProjectId != projectId {
+		return domain.Attachment{}, domain.ErrAttachmentNotFound
+	}
+	return attachment, nil
+}
+
+// Detaches the attachments of a deleted task, what the ON DELETE SET NULL of the Attachments table does
+func (db *KVRepository) detachAttachments(taskId int) {
+	for attachmentId, attachment := range db.attachments {
+		if attachment.TaskId == taskId {
+			attachment.TaskId = 0
+			db.attachments[attachmentId] = attachment
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package repo
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type KVCommentStore struct {
+	DB *KVRepository
+}
+
+func NewKVCommentStore(DB *KVRepository) *KVCommentStore {
+	return &KVCommentStore{
+		DB: DB,
+	}
+}
+
+func (store *KVCommentStore) GetProjectRole(projectId int, cognitoId string) (domain.Role, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+	return store.DB.projectRoleOf(projectId, cognitoId)
+}
+
+func (store *KVCommentStore) GetProjectMembers(projectId int, cognitoIds []string) ([]int, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	p, ok := store.DB.projects[projectId]
+	if !ok {
+		return nil, nil
+	}
+	var ids []int
+	for _, cognitoId := range cognitoIds {
+		user, ok := store.DB.userByCognito(cognitoId)
+		if ok && store.DB.projectRole(p, user.id) != "" && !containsInt(ids, user.id) {
+			ids = append(ids, user.id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (store *KVCommentStore) GetComments(projectId, taskId int) ([]domain.Comment, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	if task, ok := store.DB.tasks[taskId]; !ok || task.ProjectId != projectId {
+		return nil, domain.ErrTaskNotFound
+	}
+	comments := []domain.Comment{}
+	for _, c := range store.DB.comments {
+		if c.comment.TaskId == taskId {
+			comments = append(comments, store.DB.readComment(c))
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		if !comments[i].CreatedAt.Equal(comments[j].CreatedAt) {
+			return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+		}
+		return comments[i].Id < comments[j].Id
+	})
+	return comments, nil
+}
+
+func (store *KVCommentStore) GetComment(projectId, taskId, commentId int) (domain.Comment, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	c, err := store.DB.commentOf(projectId, taskId, commentId)
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	return store.DB.readComment(c), nil
+}
+
+func (store *KVCommentStore) GetCommentEdits(projectId, taskId, commentId int) ([]domain.CommentEdit, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	c, err := store.DB.commentOf(projectId, taskId, commentId)
+	if err != nil {
+		return nil, err
+	}
+	return append([]domain.CommentEdit{}, c.edits...), nil
+}
+
+func (store *KVCommentStore) CreateComment(r *domain.CommentRequest) (domain.Comment, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	if task, ok := store.DB.tasks[r.TaskId]; !ok || task.ProjectId != r.ProjectId {
+		return domain.Comment{}, domain.ErrTaskNotFound
+	}
+	if r.ParentId != nil {
+		if parent, ok := store.DB.comments[*r.ParentId]; !ok || parent.comment.TaskId != r.TaskId {
+			return domain.Comment{}, domain.ErrInvalidReply
+		}
+	}
+	user, ok := store.DB.userByCognito(r.UserCognitoId)
+	if !ok {
+		return domain.Comment{}, domain.ErrUserNotFound
+	}
+	now := time.Now()
+	store.DB.lastCommentId++
+	c := kvComment{
+		comment: domain.Comment{
+			Id:        store.DB.lastCommentId,
+			TaskId:    r.TaskId,
+			ParentId:  copyInt(r.ParentId),
+			AuthorId:  user.id,
+			Body:      r.Body,
+			CreatedAt: now,
+		},
+		mentions: make(map[int]time.Time),
+	}
+	c.mention(r.Mentions, now)
+	store.DB.comments[c.comment.Id] = c
+	return store.DB.readComment(c), nil
+}
+
+func (store *KVCommentStore) UpdateComment(commentId int, r *domain.CommentRequest) (domain.Comment, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	c, err := store.DB.commentOf(r.ProjectId, r.TaskId, commentId)
+	if err != nil || c.comment.Deleted {
+		return domain.Comment{}, domain.ErrCommentNotFound
+	}
+	now := time.Now()
+	// Saving the same body again is not an edit
+	if c.comment.Body != r.Body {
+		writtenAt := c.comment.CreatedAt
+		if c.comment.EditedAt != nil {
+			writtenAt = *c.comment.EditedAt
+		}
+		c.edits = append(c.edits, domain.CommentEdit{Body: c.comment.Body, WrittenAt: writtenAt, ReplacedAt: now})
+		c.comment.Body = r.Body
+		c.comment.EditedAt = &now
+	}
+	for userId := range c.mentions {
+		if !containsInt(r.Mentions, userId) {
+			delete(c.mentions, userId)
+		}
+	}
+	c.mention(r.Mentions, now)
+	store.DB.comments[commentId] = c
+	return store.DB.readComment(c), nil
+}
+
+func (store *KVCommentStore) DeleteComment(projectId, taskId, commentId int) error {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	c, err := store.DB.commentOf(projectId, taskId, commentId)
+	if err != nil || c.comment.Deleted {
+		return domain.ErrCommentNotFound
+	}
+	for _, reply := range store.DB.comments {
+		if reply.comment.ParentId != nil && *reply.comment.ParentId == commentId {
+			c.comment.Body = ""
+			c.comment.Deleted = true
+			c.edits = nil
+			c.mentions = make(map[int]time.Time)
+			store.DB.comments[commentId] = c
+			return nil
+		}
+	}
+	delete(store.DB.comments, commentId)
+	return nil
+}
+
+func (store *KVCommentStore) GetMentions(cognitoId string, since *time.Time) ([]domain.Mention, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	mentions := []domain.Mention{}
+	user, ok := store.DB.userByCognito(cognitoId)
+	if !ok {
+		return mentions, nil
+	}
+	for _, c := range store.DB.comments {
+		createdAt, ok := c.mentions[user.id]
+		if !ok || (since != nil && !createdAt.After(*since)) {
+			continue
+		}
+		task := store.DB.tasks[c.comment.TaskId]
+		if store.DB.projectRole(store.DB.projects[task.ProjectId], user.id) == "" {
+			continue
+		}
+		mentions = append(mentions, domain.Mention{
+			CommentId: c.comment.Id,
+			TaskId:    task.Id,
+			ProjectId: task.ProjectId,
+			Author:    store.DB.users[c.comment.AuthorId].cognitoId,
+			CreatedAt: createdAt,
+		})
+	}
+	sort.Slice(mentions, func(i, j int) bool {
+		if !mentions[i].CreatedAt.Equal(mentions[j].CreatedAt) {
+			return mentions[i].CreatedAt.After(mentions[j].CreatedAt)
+		}
+		return mentions[i].CommentId > mentions[j].CommentId
+	})
+	return mentions, nil
+}
+
+// Records the users not mentioned yet, like the ON CONFLICT DO NOTHING of setMentions
+func (c kvComment) mention(userIds []int, now time.Time) {
+	for _, userId := range userIds {
+		if _, ok := c.mentions[userId]; !ok {
+			c.mentions[userId] = now
+		}
+	}
+}
+
+func (db *KVRepository) commentOf(projectId, taskId, commentId int) (kvComment, error) {
+	c, ok := db.comments[commentId]
+	if !ok || c.comment.TaskId != taskId || db.tasks[taskId].ProjectId != projectId {
+		return kvComment{}, domain.ErrCommentNotFound
+	}
+	return c, nil
+}
+
+// Copy of the comment with its author and mentions filled like scanComment does
+func (db *KVRepository) readComment(c kvComment) domain.Comment {
+	comment := c.comment
+	comment.ParentId = copyInt(c.comment.ParentId)
+	comment.EditedAt = copyTime(c.comment.EditedAt)
+	comment.Author = db.users[comment.AuthorId].cognitoId
+	comment.Mentions = []int{}
+	for userId := range c.mentions {
+		comment.Mentions = append(comment.Mentions, userId)
+	}
+	sort.Ints(comment.Mentions)
+	return comment
+}
+
+// Removes the comments of a task, what the cascade of the Comments table does
+func (db *KVRepository) deleteComments(taskId int) {
+	for commentId, c := range db.comments {
+		if c.comment.TaskId == taskId {
+			delete(db.comments, commentId)
+		}
+	}
+}
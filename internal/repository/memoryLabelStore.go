@@ -0,0 +1,161 @@
+package repo
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type KVLabelStore struct {
+	DB *KVRepository
+}
+
+func NewKVLabelStore(DB *KVRepository) *KVLabelStore {
+	return &KVLabelStore{
+		DB: DB,
+	}
+}
+
+func (store *KVLabelStore) GetProjectRole(projectId int, cognitoId string) (domain.Role, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+	return store.DB.projectRoleOf(projectId, cognitoId)
+}
+
+func (store *KVLabelStore) GetLabels(projectId int) ([]domain.Label, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	labels := []domain.Label{}
+	for _, label := range store.DB.labels {
+		if label.ProjectId == projectId {
+			labels = append(labels, store.DB.countTagged(label))
+		}
+	}
+	// Same order as the lowercased names compared byte by byte in the Postgres store
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := strings.ToLower(labels[i].Name), strings.ToLower(labels[j].Name)
+		if a != b {
+			return a < b
+		}
+		return labels[i].Id < labels[j].Id
+	})
+	return labels, nil
+}
+
+func (store *KVLabelStore) GetLabel(projectId, labelId int) (domain.Label, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	label, ok := store.DB.labels[labelId]
+	if !ok || label.ProjectId != projectId {
+		return domain.Label{}, domain.ErrLabelNotFound
+	}
+	return store.DB.countTagged(label), nil
+}
+
+func (store *KVLabelStore) CreateLabel(r *domain.LabelRequest) (domain.Label, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	if store.DB.labelNameTaken(r.ProjectId, 0, r.Name) {
+		return domain.Label{}, domain.ErrLabelExists
+	}
+	store.DB.lastLabelId++
+	label := domain.Label{
+		Id:        store.DB.lastLabelId,
+		ProjectId: r.ProjectId,
+		Name:      r.Name,
+		Color:     r.Color,
+	}
+	store.DB.labels[label.Id] = label
+	return label, nil
+}
+
+func (store *KVLabelStore) UpdateLabel(labelId int, r *domain.LabelRequest) (domain.Label, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	label, ok := store.DB.labels[labelId]
+	if !ok || label.ProjectId != r.ProjectId {
+		return domain.Label{}, domain.ErrLabelNotFound
+	}
+	if store.DB.labelNameTaken(r.ProjectId, labelId, r.Name) {
+		return domain.Label{}, domain.ErrLabelExists
+	}
+	label.Name = r.Name
+	label.Color = r.Color
+	store.DB.labels[labelId] = label
+	return store.DB.countTagged(label), nil
+}
+
+func (store *KVLabelStore) DeleteLabel(projectId, labelId int) error {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	label, ok := store.DB.labels[labelId]
+	if !ok || label.ProjectId != projectId {
+		return domain.ErrLabelNotFound
+	}
+	store.DB.retag(labelId, 0)
+	delete(store.DB.labels, labelId)
+	return nil
+}
+
+func (store *KVLabelStore) MergeLabel(projectId, labelId, intoId int) (domain.Label, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	label, ok := store.DB.labels[labelId]
+	into, found := store.DB.labels[intoId]
+	if !ok || !found || label.ProjectId != projectId || into.ProjectId != projectId {
+		return domain.Label{}, domain.ErrLabelNotFound
+	}
+	store.DB.retag(labelId, intoId)
+	delete(store.DB.labels, labelId)
+	return store.DB.countTagged(into), nil
+}
+
+// Names are compared regardless of their case like the unique index of the Postgres store
+func (db *KVRepository) labelNameTaken(projectId, labelId int, name string) bool {
+	for _, label := range db.labels {
+		if label.ProjectId == projectId && label.Id != labelId && strings.EqualFold(label.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces labelId with intoId on every task tagged with it, or only removes it when intoId
+// is zero. The version of those tasks is incremented
+func (db *KVRepository) retag(labelId, intoId int) {
+	for taskId, task := range db.tasks {
+		if !containsInt(task.Labels, labelId) {
+			continue
+		}
+		labels := []int{}
+		for _, id := range task.Labels {
+			if id != labelId && id != intoId {
+				labels = append(labels, id)
+			}
+		}
+		if intoId != 0 {
+			labels = append(labels, intoId)
+			sort.Ints(labels)
+		}
+		task.Labels = labels
+		task.Version++
+		db.tasks[taskId] = task
+	}
+}
+
+func (db *KVRepository) countTagged(label domain.Label) domain.Label {
+	label.Tasks = 0
+	for _, task := range db.tasks {
+		if containsInt(task.Labels, label.Id) {
+			label.Tasks++
+		}
+	}
+	return label
+}
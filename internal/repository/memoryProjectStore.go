@@ -0,0 +1,267 @@
+package repo
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type KVProjectStore struct {
+	DB *KVRepository
+}
+
+func NewKVProjectStore(DB *KVRepository) *KVProjectStore {
+	return &KVProjectStore{
+		DB: DB,
+	}
+}
+
+func (store *KVProjectStore) GetProjectRole(projectId, cognitoId string) (domain.Role, error) {
+	id, ok := parseKVId(projectId)
+	if !ok {
+		return "", domain.ErrProjectNotFound
+	}
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+	return store.DB.projectRoleOf(id, cognitoId)
+}
+
+func (store *KVProjectStore) GetProjects(cognitoId string, filter domain.ProjectFilter, page domain.Page) ([]domain.Project, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	user, ok := store.DB.userByCognito(cognitoId)
+	if !ok {
+		return nil, nil
+	}
+	var projects []domain.Project
+	for _, p := range store.DB.projects {
+		if role := store.DB.projectRole(p, user.id); role != "" && matchProject(p.project, filter) {
+			projects = append(projects, copyProject(p.project, role))
+		}
+	}
+	return kvPage(projects, page, func(p domain.Project) int { return p.Id }), nil
+}
+
+// Same conditions as projectFilterConditions
+func matchProject(p domain.Project, filter domain.ProjectFilter) bool {
+	if filter.Priority != "" && p.Priority != filter.Priority {
+		return false
+	}
+	if filter.CreatedAfter != nil && !p.CreatedAt.After(*filter.CreatedAfter) {
+		return false
+	}
+	if filter.CreatedBefore != nil && !p.CreatedAt.Before(*filter.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+func (store *KVProjectStore) GetProjectById(projectId, cognitoId string) (domain.Project, error) {
+	id, ok := parseKVId(projectId)
+	if !ok {
+		return domain.Project{}, domain.ErrProjectNotFound
+	}
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	p, ok := store.DB.projects[id]
+	if !ok {
+		return domain.Project{}, domain.ErrProjectNotFound
+	}
+	user, ok := store.DB.userByCognito(cognitoId)
+	if !ok {
+		return domain.Project{}, domain.ErrProjectNotFound
+	}
+	role := store.DB.projectRole(p, user.id)
+	if role == "" {
+		return domain.Project{}, domain.ErrProjectNotFound
+	}
+	return copyProject(p.project, role), nil
+}
+
+func (store *KVProjectStore) CreateProject(r *domain.CreateProjectRequest) (domain.Project, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	user, ok := store.DB.userByCognito(r.UserCognitoId)
+	if !ok {
+		return domain.Project{}, domain.ErrUserNotFound
+	}
+	if r.TeamId != nil {
+		if _, ok := store.DB.teams[*r.TeamId]; !ok {
+			return domain.Project{}, domain.ErrTeamNotFound
+		}
+	}
+	store.DB.lastProjectId++
+	project := domain.Project{
+		Id:          store.DB.lastProjectId,
+		Title:       r.Title,
+		Description: r.Description,
+		Priority:    r.Priority,
+		CreatedAt:   time.Now(),
+		Version:     1,
+	}
+	if r.TeamId != nil {
+		teamId := *r.TeamId
+		project.TeamId = &teamId
+	}
+	p := kvProject{project: project, userId: user.id}
+	store.DB.projects[project.Id] = p
+	return copyProject(project, store.DB.projectRole(p, user.id)), nil
+}
+
+func (store *KVProjectStore) UpdateProject(projectId string, r *domain.CreateProjectRequest) (domain.Project, error) {
+	return store.PatchProject(projectId, &domain.PatchProjectRequest{
+		Title:         domain.Optional[string]{Set: true, Value: r.Title},
+		Description:   domain.Optional[string]{Set: true, Value: r.Description},
+		Priority:      domain.Optional[domain.Priority]{Set: true, Value: r.Priority},
+		UserCognitoId: r.UserCognitoId,
+		Version:       r.Version,
+	})
+}
+
+func (store *KVProjectStore) PatchProject(projectId string, r *domain.PatchProjectRequest) (domain.Project, error) {
+	id, ok := parseKVId(projectId)
+	if !ok {
+		return domain.Project{}, domain.ErrProjectNotFound
+	}
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	p, ok := store.DB.projects[id]
+	if !ok {
+		return domain.Project{}, domain.ErrProjectNotFound
+	}
+	// The project is returned as GetProjectById would, nothing is written if that fails
+	user, ok := store.DB.userByCognito(r.UserCognitoId)
+	if !ok {
+		return domain.Project{}, domain.ErrProjectNotFound
+	}
+	role := store.DB.projectRole(p, user.id)
+	if role == "" {
+		return domain.Project{}, domain.ErrProjectNotFound
+	}
+	if r.Version != 0 && r.Version != p.project.Version {
+		return domain.Project{}, domain.ErrVersionMismatch
+	}
+	if r.Title.Set || r.Description.Set || r.Priority.Set {
+		p.project.Version++
+	}
+	p.project.Title = r.Title.Or(p.project.Title)
+	p.project.Description = r.Description.Or(p.project.Description)
+	p.project.Priority = r.Priority.Or(p.project.Priority)
+	store.DB.projects[id] = p
+	return copyProject(p.project, role), nil
+}
+
+// Removes the project with its tasks and shares
+func (store *KVProjectStore) DeleteProject(projectId string, version int) error {
+	id, ok := parseKVId(projectId)
+	if !ok {
+		return domain.ErrProjectNotFound
+	}
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	p, ok := store.DB.projects[id]
+	if !ok {
+		return domain.ErrProjectNotFound
+	}
+	if version != 0 && version != p.project.Version {
+		return domain.ErrVersionMismatch
+	}
+	for taskId, task := range store.DB.tasks {
+		if task.ProjectId == id {
+			store.DB.deleteComments(taskId)
+			store.DB.detachAttachments(taskId)
+			delete(store.DB.tasks, taskId)
+		}
+	}
+	for labelId, label := range store.DB.labels {
+		if label.ProjectId == id {
+			delete(store.DB.labels, labelId)
+		}
+	}
+	delete(store.DB.shares, id)
+	delete(store.DB.projects, id)
+	return nil
+}
+
+func (store *KVProjectStore) GetShares(projectId string) ([]domain.ProjectShare, error) {
+	shares := []domain.ProjectShare{}
+	id, ok := parseKVId(projectId)
+	if !ok {
+		return shares, nil
+	}
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	for userId, share := range store.DB.shares[id] {
+		shares = append(shares, domain.ProjectShare{
+			ProjectId: id,
+			UserId:    userId,
+			CognitoId: store.DB.users[userId].cognitoId,
+			Role:      share.role,
+			CreatedAt: share.createdAt,
+		})
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].CreatedAt.Equal(shares[j].CreatedAt) {
+			return shares[i].UserId < shares[j].UserId
+		}
+		return shares[i].CreatedAt.Before(shares[j].CreatedAt)
+	})
+	return shares, nil
+}
+
+func (store *KVProjectStore) ShareProject(projectId string, userId int, role domain.Role) error {
+	id, ok := parseKVId(projectId)
+	if !ok {
+		return domain.ErrProjectNotFound
+	}
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	if _, ok := store.DB.users[userId]; !ok {
+		return domain.ErrUserNotFound
+	}
+	if _, ok := store.DB.projects[id]; !ok {
+		return domain.ErrProjectNotFound
+	}
+	if store.DB.shares[id] == nil {
+		store.DB.shares[id] = make(map[int]kvShare)
+	}
+	share, ok := store.DB.shares[id][userId]
+	if !ok {
+		share.createdAt = time.Now()
+	}
+	share.role = role
+	store.DB.shares[id][userId] = share
+	return nil
+}
+
+func (store *KVProjectStore) RemoveShare(projectId string, userId int) error {
+	id, ok := parseKVId(projectId)
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	if _, ok := store.DB.shares[id][userId]; !ok {
+		return domain.ErrUserNotFound
+	}
+	delete(store.DB.shares[id], userId)
+	return nil
+}
+
+func copyProject(p domain.Project, role domain.Role) domain.Project {
+	if p.TeamId != nil {
+		teamId := *p.TeamId
+		p.TeamId = &teamId
+	}
+	p.Role = role
+	return p
+}
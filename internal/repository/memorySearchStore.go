@@ -0,0 +1,252 @@
+package repo
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type KVSearchStore struct {
+	DB *KVRepository
+}
+
+func NewKVSearchStore(DB *KVRepository) *KVSearchStore {
+	return &KVSearchStore{
+		DB: DB,
+	}
+}
+
+// Weights of the title and description matches, the ones Postgres gives to the A and B labels
+const (
+	titleWeight       = 1.0
+	descriptionWeight = 0.4
+)
+
+// Snippets start a few words before the first match and hold at most snippetWords words
+const (
+	snippetLead  = 5
+	snippetWords = 30
+)
+
+// Same results as the Postgres store for simple searches. The words are only approximately
+// stemmed and ranks are computed differently, they only compare results of the same search
+func (store *KVSearchStore) Search(cognitoId string, q domain.SearchQuery) ([]domain.SearchResult, error) {
+	query := parseKVSearch(q.Text)
+
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	results := []domain.SearchResult{}
+	user, ok := store.DB.userByCognito(cognitoId)
+	if !ok {
+		return results, nil
+	}
+	for _, p := range store.DB.projects {
+		if store.DB.projectRole(p, user.id) == "" {
+			continue
+		}
+		if rank, ok := query.rank(p.project.Title, p.project.Description); ok {
+			results = append(results, domain.SearchResult{
+				Type:      domain.SearchProject,
+				Id:        p.project.Id,
+				ProjectId: p.project.Id,
+				Title:     p.project.Title,
+				Snippet:   query.snippet(p.project.Title + " " + p.project.Description),
+				Rank:      rank,
+			})
+		}
+	}
+	for _, task := range store.DB.tasks {
+		if store.DB.projectRole(store.DB.projects[task.ProjectId], user.id) == "" {
+			continue
+		}
+		if rank, ok := query.rank(task.Title, task.Description); ok {
+			results = append(results, domain.SearchResult{
+				Type:      domain.SearchTask,
+				Id:        task.Id,
+				ProjectId: task.ProjectId,
+				Title:     task.Title,
+				Snippet:   query.snippet(task.Title + " " + task.Description),
+				Rank:      rank,
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Id < b.Id
+	})
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+// A search in the syntax of websearch_to_tsquery: every clause must match unless it is negated,
+// a clause matches when any of its phrases, alternatives joined by OR, is found
+type kvSearch []kvClause
+
+type kvClause struct {
+	phrases [][]string
+	negated bool
+}
+
+var searchTokens = regexp.MustCompile(`-?"[^"]*"?|\S+`)
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+func parseKVSearch(text string) kvSearch {
+	var search kvSearch
+	or := false
+	for _, token := range searchTokens.FindAllString(text, -1) {
+		if strings.EqualFold(token, "or") {
+			or = len(search) > 0
+			continue
+		}
+		negated := strings.HasPrefix(token, "-")
+		phrase := searchWords(strings.TrimPrefix(token, "-"))
+		if len(phrase) == 0 {
+			continue
+		}
+		if or && !negated && !search[len(search)-1].negated {
+			last := &search[len(search)-1]
+			last.phrases = append(last.phrases, phrase)
+		} else {
+			search = append(search, kvClause{phrases: [][]string{phrase}, negated: negated})
+		}
+		or = false
+	}
+	return search
+}
+
+func (s kvSearch) rank(title, description string) (float64, bool) {
+	titleWords, descriptionWords := searchWords(title), searchWords(description)
+	var rank float64
+	var positive int
+	for _, c := range s {
+		inTitle, inDescription := c.matches(titleWords), c.matches(descriptionWords)
+		if c.negated {
+			if inTitle || inDescription {
+				return 0, false
+			}
+			continue
+		}
+		positive++
+		switch {
+		case inTitle:
+			rank += titleWeight
+		case inDescription:
+			rank += descriptionWeight
+		default:
+			return 0, false
+		}
+	}
+	if positive == 0 {
+		return 0, false
+	}
+	return rank / float64(positive), true
+}
+
+func (c kvClause) matches(words []string) bool {
+	for _, phrase := range c.phrases {
+		for i := 0; i+len(phrase) <= len(words); i++ {
+			if equalWords(words[i:i+len(phrase)], phrase) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func equalWords(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Marks the words of text found in the search, the text around them is kept as it is
+func (s kvSearch) snippet(text string) string {
+	matched := map[string]bool{}
+	for _, c := range s {
+		if c.negated {
+			continue
+		}
+		for _, phrase := range c.phrases {
+			for _, w := range phrase {
+				matched[w] = true
+			}
+		}
+	}
+	spans := wordPattern.FindAllStringIndex(text, -1)
+	first := 0
+	for i, span := range spans {
+		if matched[searchWord(text[span[0]:span[1]])] {
+			first = i
+			break
+		}
+	}
+	from := max(first-snippetLead, 0)
+	to := min(from+snippetWords, len(spans))
+	if from >= to {
+		return strings.TrimSpace(text)
+	}
+
+	var b strings.Builder
+	last := spans[from][0]
+	for _, span := range spans[from:to] {
+		b.WriteString(text[last:span[0]])
+		word := text[span[0]:span[1]]
+		if matched[searchWord(word)] {
+			b.WriteString(domain.SnippetStart + word + domain.SnippetStop)
+		} else {
+			b.WriteString(word)
+		}
+		last = span[1]
+	}
+	return b.String()
+}
+
+// Words of text as they are compared, stop words are dropped like Postgres does
+func searchWords(text string) []string {
+	var words []string
+	for _, w := range wordPattern.FindAllString(text, -1) {
+		if w = searchWord(w); w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// Lowercases the word and strips the most common English suffixes, a rough version of the
+// stemming of the english text search configuration. Stop words become the empty string
+func searchWord(w string) string {
+	w = strings.ToLower(w)
+	if stopWords[w] {
+		return ""
+	}
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if strings.HasSuffix(w, suffix) && len(w) > len(suffix)+2 && !strings.HasSuffix(w, "ss") {
+			return strings.TrimSuffix(w, suffix)
+		}
+	}
+	return w
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "from": true, "has": true, "have": true, "in": true, "into": true,
+	"is": true, "it": true, "its": true, "no": true, "not": true, "of": true, "on": true, "or": true,
+	"so": true, "than": true, "that": true, "the": true, "their": true, "then": true, "there": true,
+	"these": true, "they": true, "this": true, "to": true, "was": true, "were": true, "will": true,
+	"with": true,
+}
@@ -1,41 +1,189 @@
 package repo
 
 import (
-	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/Desgue/ttracker-api/internal/domain"
 )
 
+// KVRepository keeps every table in memory, it plays the role of *sql.DB for the KV stores
+// which share it the same way the Postgres stores share the connection pool.
+// A single lock guards all the tables so operations spanning several of them stay atomic
 type KVRepository struct {
-	Tasks map[int]domain.Task
+	mu sync.RWMutex
+
+	users          map[int]kvUser
+	usersByCognito map[string]int
+	projects       map[int]kvProject
+	// projectId -> userId -> share
+	shares map[int]map[int]kvShare
+	tasks  map[int]domain.Task
+	labels map[int]domain.Label
+	// The author is resolved from the users when a comment is read
+	comments map[int]kvComment
+	// Detached attachments have a zero TaskId
+	attachments map[int]domain.Attachment
+	teams       map[int]domain.Team
+	// teamId -> userId -> member
+	members     map[int]map[int]kvMember
+	invitations map[string]kvInvitation
+	tokens      map[int]kvToken
+
+	// Last id given to every table, ids start at 1 like identity columns
+	lastUserId, lastProjectId, lastTaskId, lastLabelId, lastCommentId, lastAttachmentId, lastTeamId, lastInvitationId, lastTokenId int
+}
+
+type kvUser struct {
+	id        int
+	cognitoId string
+	createdAt time.Time
+}
+
+type kvProject struct {
+	project domain.Project
+	userId  int
+}
+
+type kvComment struct {
+	comment domain.Comment
+	edits   []domain.CommentEdit
+	// userId -> when it was first mentioned
+	mentions map[int]time.Time
+}
+
+type kvShare struct {
+	role      domain.Role
+	createdAt time.Time
+}
+
+type kvMember struct {
+	role     domain.Role
+	joinedAt time.Time
+}
+
+type kvInvitation struct {
+	invitation domain.TeamInvitation
+	invitedBy  int
+	acceptedAt *time.Time
+}
+
+type kvToken struct {
+	token  domain.AccessToken
+	userId int
+	hash   string
 }
 
 func NewKvRepository() *KVRepository {
 	return &KVRepository{
-		Tasks: make(map[int]domain.Task),
+		users:          make(map[int]kvUser),
+		usersByCognito: make(map[string]int),
+		projects:       make(map[int]kvProject),
+		shares:         make(map[int]map[int]kvShare),
+		tasks:          make(map[int]domain.Task),
+		labels:         make(map[int]domain.Label),
+		comments:       make(map[int]kvComment),
+		attachments:    make(map[int]domain.Attachment),
+		teams:          make(map[int]domain.Team),
+		members:        make(map[int]map[int]kvMember),
+		invitations:    make(map[string]kvInvitation),
+		tokens:         make(map[int]kvToken),
+	}
+}
+
+// The helpers below expect the caller to hold the lock
+
+func (db *KVRepository) userByCognito(cognitoId string) (kvUser, bool) {
+	id, ok := db.usersByCognito[cognitoId]
+	if !ok {
+		return kvUser{}, false
 	}
+	return db.users[id], true
 }
 
-func (db *KVRepository) GetTasks() ([]domain.Task, error) {
-	if len(db.Tasks) == -1 {
-		return []domain.Task{}, nil
+// Same rules as projectAccessJoins: Owner for the creator, otherwise the highest
+// of the role in the team owning the project and the role of a direct share
+func (db *KVRepository) projectRole(p kvProject, userId int) domain.Role {
+	if p.userId == userId {
+		return domain.RoleOwner
+	}
+	var role domain.Role
+	if p.project.TeamId != nil {
+		if member, ok := db.members[*p.project.TeamId][userId]; ok {
+			role = member.role
+		}
+	}
+	if share, ok := db.shares[p.project.Id][userId]; ok && share.role.AtLeast(role) {
+		role = share.role
+	}
+	return role
+}
+
+// Resolves the role of the user in the project like queryProjectRole
+func (db *KVRepository) projectRoleOf(projectId int, cognitoId string) (domain.Role, error) {
+	p, ok := db.projects[projectId]
+	if !ok {
+		return "", domain.ErrProjectNotFound
+	}
+	user, ok := db.userByCognito(cognitoId)
+	if !ok {
+		return "", domain.ErrForbidden
 	}
-	var projects []domain.Task
-	for _, project := range db.Tasks {
-		projects = append(projects, project)
+	role := db.projectRole(p, user.id)
+	if role == "" {
+		return "", domain.ErrForbidden
 	}
-	return projects, nil
+	return role, nil
 }
 
-func (db *KVRepository) GetTaskById(id string) (domain.Task, error) {
-	return domain.Task{}, nil
+// Ids coming from the url are strings, an id that is not a number can not match any row
+func parseKVId(id string) (int, bool) {
+	n, err := strconv.Atoi(id)
+	return n, err == nil
 }
 
-func (db *KVRepository) CreateTask(p domain.Task) error {
-	_, ok := db.Tasks[p.Id]
-	if ok {
-		return errors.New("error creating task, conflit with id")
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+func copyInt(i *int) *int {
+	if i == nil {
+		return nil
+	}
+	c := *i
+	return &c
+}
+
+// Sorts the items in the order of the page and returns the ones following page.After,
+// keys compare with domain.CompareSortKeys which follows the order of the Postgres stores
+func kvPage[T interface{ SortKey(domain.SortField) string }](items []T, page domain.Page, id func(T) int) []T {
+	compare := func(keyA string, idA int, keyB string, idB int) int {
+		c := domain.CompareSortKeys(page.Sort, keyA, keyB)
+		if c == 0 {
+			c = idA - idB
+		}
+		if page.Desc {
+			c = -c
+		}
+		return c
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return compare(items[i].SortKey(page.Sort), id(items[i]), items[j].SortKey(page.Sort), id(items[j])) < 0
+	})
+	if page.After != nil {
+		start := sort.Search(len(items), func(i int) bool {
+			return compare(items[i].SortKey(page.Sort), id(items[i]), page.After.Key, page.After.Id) > 0
+		})
+		items = items[start:]
+	}
+	if page.Limit > 0 && len(items) > page.Limit {
+		items = items[:page.Limit]
 	}
-	db.Tasks[p.Id] = p
-	return nil
+	return items
 }
@@ -0,0 +1,33 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/repository/storagetest"
+)
+
+func TestKVStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Stores {
+		kv := NewKvRepository()
+		return storagetest.Stores{
+			Users:       NewKVUserStore(kv),
+			Projects:    NewKVProjectStore(kv),
+			Tasks:       NewKVTaskStore(kv),
+			Teams:       NewKVTeamStore(kv),
+			Search:      NewKVSearchStore(kv),
+			Labels:      NewKVLabelStore(kv),
+			Comments:    NewKVCommentStore(kv),
+			Attachments: NewKVAttachmentStore(kv),
+			Tokens:      NewKVAccessTokenStore(kv),
+			UserId: func(t *testing.T, cognitoId string) int {
+				kv.mu.RLock()
+				defer kv.mu.RUnlock()
+				id, ok := kv.usersByCognito[cognitoId]
+				if !ok {
+					t.Fatalf("user %s not found", cognitoId)
+				}
+				return id
+			},
+		}
+	})
+}
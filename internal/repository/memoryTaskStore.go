@@ -0,0 +1,416 @@
+package repo
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type KVTaskStore struct {
+	DB *KVRepository
+}
+
+func NewKVTaskStore(DB *KVRepository) *KVTaskStore {
+	return &KVTaskStore{
+		DB: DB,
+	}
+}
+
+func (store *KVTaskStore) GetProjectRole(projectId int, cognitoId string) (domain.Role, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+	return store.DB.projectRoleOf(projectId, cognitoId)
+}
+
+func (store *KVTaskStore) GetProjectUsers(projectId int, userIds []int) ([]int, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	p, ok := store.DB.projects[projectId]
+	if !ok {
+		return nil, nil
+	}
+	var ids []int
+	for _, id := range userIds {
+		if _, ok := store.DB.users[id]; ok && store.DB.projectRole(p, id) != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (store *KVTaskStore) GetTasks(projectId int, filter domain.TaskFilter, page domain.Page) ([]domain.Task, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	now := time.Now()
+	progress := store.DB.subtaskProgress()
+	var tasks []domain.Task
+	for _, task := range store.DB.tasks {
+		if task.ProjectId == projectId && store.DB.matchTask(task, filter, now) {
+			tasks = append(tasks, copyTask(task, progress))
+		}
+	}
+	return kvPage(tasks, page, func(t domain.Task) int { return t.Id }), nil
+}
+
+func (store *KVTaskStore) GetTaskById(projectId int, taskId string) (domain.Task, error) {
+	id, ok := parseKVId(taskId)
+	if !ok {
+		return domain.Task{}, domain.ErrTaskNotFound
+	}
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	task, ok := store.DB.tasks[id]
+	if !ok || task.ProjectId != projectId {
+		return domain.Task{}, domain.ErrTaskNotFound
+	}
+	return copyTask(task, store.DB.subtaskProgress()), nil
+}
+
+func (store *KVTaskStore) GetDescendants(projectId int, taskIds []int) ([]domain.Task, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	below := map[int]bool{}
+	for _, id := range taskIds {
+		if task, ok := store.DB.tasks[id]; ok && task.ProjectId == projectId {
+			below[id] = true
+		}
+	}
+	// Walks up from every task, the tasks never form a cycle so walking up ends
+	var isBelow func(task domain.Task) bool
+	isBelow = func(task domain.Task) bool {
+		return task.ParentId != nil && (below[*task.ParentId] || isBelow(store.DB.tasks[*task.ParentId]))
+	}
+	progress := store.DB.subtaskProgress()
+	var tasks []domain.Task
+	for _, task := range store.DB.tasks {
+		if task.ProjectId == projectId && isBelow(task) {
+			tasks = append(tasks, copyTask(task, progress))
+		}
+	}
+	return kvPage(tasks, domain.Page{}, func(t domain.Task) int { return t.Id }), nil
+}
+
+func (store *KVTaskStore) GetAssignedTasks(cognitoId string, filter domain.TaskFilter) ([]domain.AssignedTask, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	tasks := []domain.AssignedTask{}
+	user, ok := store.DB.userByCognito(cognitoId)
+	if !ok {
+		return tasks, nil
+	}
+	filter.AssigneeId, filter.AssigneeCognitoId = user.id, ""
+	now := time.Now()
+	progress := store.DB.subtaskProgress()
+	for _, task := range store.DB.tasks {
+		p := store.DB.projects[task.ProjectId]
+		if !store.DB.matchTask(task, filter, now) || store.DB.projectRole(p, user.id) == "" {
+			continue
+		}
+		tasks = append(tasks, domain.AssignedTask{Task: copyTask(task, progress), ProjectTitle: p.project.Title})
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.ProjectId != b.ProjectId {
+			return a.ProjectId < b.ProjectId
+		}
+		if a.Status != b.Status {
+			return statusRank(a.Status) < statusRank(b.Status)
+		}
+		return a.Id < b.Id
+	})
+	return tasks, nil
+}
+
+func (store *KVTaskStore) CreateTask(r *domain.CreateTaskRequest) (domain.Task, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	if _, ok := store.DB.projects[r.ProjectId]; !ok {
+		return domain.Task{}, domain.ErrProjectNotFound
+	}
+	assignees, err := store.DB.checkAssignees(r.Assignees)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	labels, err := store.DB.checkLabels(r.ProjectId, r.Labels)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	if r.ParentId != nil {
+		if err := store.DB.checkParent(r.ProjectId, 0, *r.ParentId); err != nil {
+			return domain.Task{}, err
+		}
+	}
+	store.DB.lastTaskId++
+	task := domain.Task{
+		Id:          store.DB.lastTaskId,
+		Title:       r.Title,
+		Description: r.Description,
+		Status:      r.Status,
+		CreatedAt:   time.Now(),
+		ProjectId:   r.ProjectId,
+		Assignees:   assignees,
+		StartDate:   copyTime(r.StartDate),
+		DueDate:     copyTime(r.DueDate),
+		ParentId:    copyInt(r.ParentId),
+		Labels:      labels,
+		Version:     1,
+	}
+	store.DB.tasks[task.Id] = task
+	store.DB.touchTasks(progressParents(domain.Task{}, task))
+	return copyTask(task, store.DB.subtaskProgress()), nil
+}
+
+func (store *KVTaskStore) UpdateTask(taskId string, r *domain.CreateTaskRequest) (domain.Task, error) {
+	return store.PatchTask(taskId, &domain.PatchTaskRequest{
+		Title:       domain.Optional[string]{Set: true, Value: r.Title},
+		Description: domain.Optional[string]{Set: true, Value: r.Description},
+		Status:      domain.Optional[domain.Status]{Set: true, Value: r.Status},
+		Assignees:   domain.Optional[[]int]{Set: true, Value: r.Assignees},
+		StartDate:   domain.Optional[*time.Time]{Set: true, Value: r.StartDate},
+		DueDate:     domain.Optional[*time.Time]{Set: true, Value: r.DueDate},
+		ParentId:    domain.Optional[*int]{Set: true, Value: r.ParentId},
+		Labels:      domain.Optional[[]int]{Set: true, Value: r.Labels},
+		ProjectId:   r.ProjectId,
+		Version:     r.Version,
+	})
+}
+
+func (store *KVTaskStore) PatchTask(taskId string, r *domain.PatchTaskRequest) (domain.Task, error) {
+	id, ok := parseKVId(taskId)
+	if !ok {
+		return domain.Task{}, domain.ErrTaskNotFound
+	}
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	task, ok := store.DB.tasks[id]
+	if !ok || task.ProjectId != r.ProjectId {
+		return domain.Task{}, domain.ErrTaskNotFound
+	}
+	before := task
+	if r.Version != 0 && r.Version != task.Version {
+		return domain.Task{}, domain.ErrVersionMismatch
+	}
+	if r.Assignees.Set {
+		assignees, err := store.DB.checkAssignees(r.Assignees.Value)
+		if err != nil {
+			return domain.Task{}, err
+		}
+		task.Assignees = assignees
+	}
+	if r.Labels.Set {
+		labels, err := store.DB.checkLabels(task.ProjectId, r.Labels.Value)
+		if err != nil {
+			return domain.Task{}, err
+		}
+		task.Labels = labels
+	}
+	if r.ParentId.Set && r.ParentId.Value != nil {
+		if err := store.DB.checkParent(task.ProjectId, id, *r.ParentId.Value); err != nil {
+			return domain.Task{}, err
+		}
+	}
+	task.Title = r.Title.Or(task.Title)
+	task.Description = r.Description.Or(task.Description)
+	task.Status = r.Status.Or(task.Status)
+	task.StartDate = copyTime(r.StartDate.Or(task.StartDate))
+	task.DueDate = copyTime(r.DueDate.Or(task.DueDate))
+	task.ParentId = copyInt(r.ParentId.Or(task.ParentId))
+	if r.Title.Set || r.Description.Set || r.Status.Set || r.Assignees.Set || r.StartDate.Set || r.DueDate.Set || r.ParentId.Set || r.Labels.Set {
+		task.Version++
+	}
+	store.DB.tasks[id] = task
+	store.DB.touchTasks(progressParents(before, task))
+	return copyTask(task, store.DB.subtaskProgress()), nil
+}
+
+func (store *KVTaskStore) DeleteTask(projectId int, taskId string, version int) error {
+	id, ok := parseKVId(taskId)
+	if !ok {
+		return domain.ErrTaskNotFound
+	}
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	task, ok := store.DB.tasks[id]
+	if !ok || task.ProjectId != projectId {
+		return domain.ErrTaskNotFound
+	}
+	if version != 0 && version != task.Version {
+		return domain.ErrVersionMismatch
+	}
+	for childId, child := range store.DB.tasks {
+		if child.ParentId != nil && *child.ParentId == id {
+			child.ParentId = nil
+			child.Version++
+			store.DB.tasks[childId] = child
+		}
+	}
+	store.DB.deleteComments(id)
+	store.DB.detachAttachments(id)
+	delete(store.DB.tasks, id)
+	store.DB.touchTasks(progressParents(task, domain.Task{}))
+	return nil
+}
+
+// Same as the Postgres touchTasks
+func (db *KVRepository) touchTasks(taskIds []int) {
+	for _, id := range taskIds {
+		if task, ok := db.tasks[id]; ok {
+			task.Version++
+			db.tasks[id] = task
+		}
+	}
+}
+
+// Same conditions as taskFilterConditions
+func (db *KVRepository) matchTask(task domain.Task, filter domain.TaskFilter, now time.Time) bool {
+	if filter.AssigneeId != 0 && !containsInt(task.Assignees, filter.AssigneeId) {
+		return false
+	}
+	if filter.AssigneeCognitoId != "" {
+		user, ok := db.userByCognito(filter.AssigneeCognitoId)
+		if !ok || !containsInt(task.Assignees, user.id) {
+			return false
+		}
+	}
+	if filter.Status != "" && task.Status != filter.Status {
+		return false
+	}
+	if filter.CreatedAfter != nil && !task.CreatedAt.After(*filter.CreatedAfter) {
+		return false
+	}
+	if filter.CreatedBefore != nil && !task.CreatedAt.Before(*filter.CreatedBefore) {
+		return false
+	}
+	if filter.DueBefore != nil && (task.DueDate == nil || !task.DueDate.Before(*filter.DueBefore)) {
+		return false
+	}
+	if filter.DueAfter != nil && (task.DueDate == nil || !task.DueDate.After(*filter.DueAfter)) {
+		return false
+	}
+	if filter.TopLevel && task.ParentId != nil {
+		return false
+	}
+	if filter.ParentId != 0 && (task.ParentId == nil || *task.ParentId != filter.ParentId) {
+		return false
+	}
+	if len(filter.Labels) > 0 && !matchLabels(task.Labels, filter.Labels, filter.AllLabels) {
+		return false
+	}
+	if filter.Overdue != nil && task.IsOverdue(now) != *filter.Overdue {
+		return false
+	}
+	return true
+}
+
+// Same checks as the Postgres checkParent, the tasks never form a cycle so walking up ends
+func (db *KVRepository) checkParent(projectId, taskId, parentId int) error {
+	parent, ok := db.tasks[parentId]
+	if !ok || parent.ProjectId != projectId {
+		return domain.ErrInvalidParent
+	}
+	for id := &parentId; id != nil; id = db.tasks[*id].ParentId {
+		if *id == taskId {
+			return domain.ErrTaskCycle
+		}
+	}
+	return nil
+}
+
+// Progress of the direct subtasks of every task having any
+func (db *KVRepository) subtaskProgress() map[int]domain.TaskProgress {
+	progress := map[int]domain.TaskProgress{}
+	for _, task := range db.tasks {
+		if task.ParentId == nil {
+			continue
+		}
+		p := progress[*task.ParentId]
+		p.Total++
+		if task.Status == domain.Done {
+			p.Done++
+		}
+		progress[*task.ParentId] = p
+	}
+	return progress
+}
+
+// Assignees are kept sorted and without duplicates, like the aggregated column of the Postgres store
+func (db *KVRepository) checkAssignees(userIds []int) ([]int, error) {
+	assignees := []int{}
+	for _, id := range userIds {
+		if _, ok := db.users[id]; !ok {
+			return nil, domain.ErrUserNotFound
+		}
+		if !containsInt(assignees, id) {
+			assignees = append(assignees, id)
+		}
+	}
+	sort.Ints(assignees)
+	return assignees, nil
+}
+
+// Labels are kept sorted and without duplicates like the assignees
+func (db *KVRepository) checkLabels(projectId int, labelIds []int) ([]int, error) {
+	labels := []int{}
+	for _, id := range labelIds {
+		if label, ok := db.labels[id]; !ok || label.ProjectId != projectId {
+			return nil, domain.ErrInvalidLabel
+		}
+		if !containsInt(labels, id) {
+			labels = append(labels, id)
+		}
+	}
+	sort.Ints(labels)
+	return labels, nil
+}
+
+func matchLabels(labels, filter []int, all bool) bool {
+	for _, id := range filter {
+		found := containsInt(labels, id)
+		if found && !all {
+			return true
+		}
+		if !found && all {
+			return false
+		}
+	}
+	return all
+}
+
+// domain.Statuses is listed in the same order as the status enum in the database
+func statusRank(s domain.Status) int {
+	for i, v := range domain.Statuses {
+		if s == v {
+			return i
+		}
+	}
+	return len(domain.Statuses)
+}
+
+func containsInt(ints []int, n int) bool {
+	for _, i := range ints {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Copies the task as the stores return it, with the progress of its subtasks
+func copyTask(t domain.Task, progress map[int]domain.TaskProgress) domain.Task {
+	t.Assignees = append([]int{}, t.Assignees...)
+	t.StartDate = copyTime(t.StartDate)
+	t.DueDate = copyTime(t.DueDate)
+	t.ParentId = copyInt(t.ParentId)
+	t.Labels = append([]int{}, t.Labels...)
+	t.Subtasks = progress[t.Id]
+	return t
+}
@@ -0,0 +1,259 @@
+package repo
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type KVTeamStore struct {
+	DB *KVRepository
+}
+
+func NewKVTeamStore(DB *KVRepository) *KVTeamStore {
+	return &KVTeamStore{
+		DB: DB,
+	}
+}
+
+func (store *KVTeamStore) GetTeam(id int) (domain.Team, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	team, ok := store.DB.teams[id]
+	if !ok {
+		return domain.Team{}, domain.ErrTeamNotFound
+	}
+	return team, nil
+}
+
+func (store *KVTeamStore) GetTeams(cognitoId string) ([]domain.Team, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	teams := []domain.Team{}
+	user, ok := store.DB.userByCognito(cognitoId)
+	if !ok {
+		return teams, nil
+	}
+	for id, team := range store.DB.teams {
+		if member, ok := store.DB.members[id][user.id]; ok {
+			team.Role = member.role
+			teams = append(teams, team)
+		}
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		return teams[i].Id < teams[j].Id
+	})
+	return teams, nil
+}
+
+// Create the team and add its creator as the first owner
+func (store *KVTeamStore) CreateTeam(r *domain.CreateTeamRequest) error {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	user, ok := store.DB.userByCognito(r.UserCognitoId)
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+	store.DB.lastTeamId++
+	team := domain.Team{
+		Id:          store.DB.lastTeamId,
+		Name:        r.Name,
+		Description: r.Description,
+		AdminId:     user.id,
+	}
+	store.DB.teams[team.Id] = team
+	store.DB.members[team.Id] = map[int]kvMember{
+		user.id: {role: domain.RoleOwner, joinedAt: time.Now()},
+	}
+	return nil
+}
+
+func (store *KVTeamStore) GetMemberRole(teamId int, cognitoId string) (domain.Role, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	if _, ok := store.DB.teams[teamId]; !ok {
+		return "", domain.ErrTeamNotFound
+	}
+	user, ok := store.DB.userByCognito(cognitoId)
+	if !ok {
+		return "", domain.ErrTeamMemberNotFound
+	}
+	member, ok := store.DB.members[teamId][user.id]
+	if !ok {
+		return "", domain.ErrTeamMemberNotFound
+	}
+	return member.role, nil
+}
+
+func (store *KVTeamStore) GetMember(teamId, userId int) (domain.TeamMember, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	member, ok := store.DB.members[teamId][userId]
+	if !ok {
+		return domain.TeamMember{}, domain.ErrTeamMemberNotFound
+	}
+	return store.DB.teamMember(teamId, userId, member), nil
+}
+
+func (store *KVTeamStore) GetMembers(teamId int) ([]domain.TeamMember, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	members := []domain.TeamMember{}
+	for userId, member := range store.DB.members[teamId] {
+		members = append(members, store.DB.teamMember(teamId, userId, member))
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].UserId < members[j].UserId
+		}
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+	return members, nil
+}
+
+func (store *KVTeamStore) CountOwners(teamId int) (int, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+
+	var count int
+	for _, member := range store.DB.members[teamId] {
+		if member.role == domain.RoleOwner {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (store *KVTeamStore) AddMember(teamId, userId int, role domain.Role) error {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	if _, ok := store.DB.users[userId]; !ok {
+		return domain.ErrUserNotFound
+	}
+	return store.DB.addMember(teamId, userId, role, time.Now())
+}
+
+func (store *KVTeamStore) UpdateMemberRole(teamId, userId int, role domain.Role) error {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	member, ok := store.DB.members[teamId][userId]
+	if !ok {
+		return domain.ErrTeamMemberNotFound
+	}
+	if role != domain.RoleOwner && store.DB.isLastOwner(teamId, userId) {
+		return domain.ErrLastTeamOwner
+	}
+	member.role = role
+	store.DB.members[teamId][userId] = member
+	return nil
+}
+
+func (store *KVTeamStore) RemoveMember(teamId, userId int) error {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	if _, ok := store.DB.members[teamId][userId]; !ok {
+		return domain.ErrTeamMemberNotFound
+	}
+	if store.DB.isLastOwner(teamId, userId) {
+		return domain.ErrLastTeamOwner
+	}
+	delete(store.DB.members[teamId], userId)
+	return nil
+}
+
+// Must be called with the lock held
+func (db *KVRepository) isLastOwner(teamId, userId int) bool {
+	if db.members[teamId][userId].role != domain.RoleOwner {
+		return false
+	}
+	for id, member := range db.members[teamId] {
+		if id != userId && member.role == domain.RoleOwner {
+			return false
+		}
+	}
+	return true
+}
+
+func (store *KVTeamStore) CreateInvitation(r *domain.CreateTeamInvitationRequest, tokenHash string) (domain.TeamInvitation, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	user, ok := store.DB.userByCognito(r.UserCognitoId)
+	if !ok {
+		return domain.TeamInvitation{}, domain.ErrUserNotFound
+	}
+	if _, ok := store.DB.teams[r.TeamId]; !ok {
+		return domain.TeamInvitation{}, domain.ErrTeamNotFound
+	}
+	if r.ExpiresAt == nil {
+		return domain.TeamInvitation{}, domain.ErrInvalidInvitationTTL
+	}
+	store.DB.lastInvitationId++
+	invitation := domain.TeamInvitation{
+		Id:        store.DB.lastInvitationId,
+		TeamId:    r.TeamId,
+		Role:      r.Role,
+		CreatedAt: time.Now(),
+		ExpiresAt: *r.ExpiresAt,
+	}
+	store.DB.invitations[tokenHash] = kvInvitation{invitation: invitation, invitedBy: user.id}
+	return invitation, nil
+}
+
+// Every check is done before touching the data so a failed acceptance leaves the invitation usable,
+// like the rolled back transaction of the Postgres store
+func (store *KVTeamStore) AcceptInvitation(tokenHash, cognitoId string) (domain.TeamMember, error) {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+
+	now := time.Now()
+	inv, ok := store.DB.invitations[tokenHash]
+	if !ok || inv.acceptedAt != nil || !inv.invitation.ExpiresAt.After(now) {
+		return domain.TeamMember{}, domain.ErrInvitationNotFound
+	}
+	user, ok := store.DB.userByCognito(cognitoId)
+	if !ok {
+		return domain.TeamMember{}, domain.ErrUserNotFound
+	}
+	teamId := inv.invitation.TeamId
+	if err := store.DB.addMember(teamId, user.id, inv.invitation.Role, now); err != nil {
+		return domain.TeamMember{}, err
+	}
+	inv.acceptedAt = &now
+	store.DB.invitations[tokenHash] = inv
+	return store.DB.teamMember(teamId, user.id, store.DB.members[teamId][user.id]), nil
+}
+
+func (db *KVRepository) addMember(teamId, userId int, role domain.Role, joinedAt time.Time) error {
+	if _, ok := db.teams[teamId]; !ok {
+		return domain.ErrTeamNotFound
+	}
+	if _, ok := db.members[teamId][userId]; ok {
+		return domain.ErrAlreadyTeamMember
+	}
+	if db.members[teamId] == nil {
+		db.members[teamId] = make(map[int]kvMember)
+	}
+	db.members[teamId][userId] = kvMember{role: role, joinedAt: joinedAt}
+	return nil
+}
+
+func (db *KVRepository) teamMember(teamId, userId int, member kvMember) domain.TeamMember {
+	return domain.TeamMember{
+		TeamId:    teamId,
+		UserId:    userId,
+		CognitoId: db.users[userId].cognitoId,
+		Role:      member.role,
+		JoinedAt:  member.joinedAt,
+	}
+}
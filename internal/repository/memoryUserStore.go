@@ -0,0 +1,33 @@
+package repo
+
+import "time"
+
+type KVUserStore struct {
+	DB *KVRepository
+}
+
+func NewKVUserStore(DB *KVRepository) *KVUserStore {
+	return &KVUserStore{
+		DB: DB,
+	}
+}
+
+func (store *KVUserStore) CheckUser(cognitoId string) (bool, error) {
+	store.DB.mu.RLock()
+	defer store.DB.mu.RUnlock()
+	_, ok := store.DB.usersByCognito[cognitoId]
+	return ok, nil
+}
+
+func (store *KVUserStore) CreateUser(cognitoId string) error {
+	store.DB.mu.Lock()
+	defer store.DB.mu.Unlock()
+	if _, ok := store.DB.usersByCognito[cognitoId]; ok {
+		return nil
+	}
+	store.DB.lastUserId++
+	user := kvUser{id: store.DB.lastUserId, cognitoId: cognitoId, createdAt: time.Now()}
+	store.DB.users[user.id] = user
+	store.DB.usersByCognito[cognitoId] = user.id
+	return nil
+}
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS TaskAssignees;
+DROP TABLE IF EXISTS Tasks;
+DROP TABLE IF EXISTS ProjectShares;
+DROP TABLE IF EXISTS Projects;
+DROP TABLE IF EXISTS TeamInvitations;
+DROP TABLE IF EXISTS TeamMembers;
+DROP TABLE IF EXISTS Teams;
+DROP TABLE IF EXISTS AccessTokens;
+DROP TABLE IF EXISTS Users;
+
+DROP TYPE IF EXISTS memberRole;
+DROP TYPE IF EXISTS priority;
+DROP TYPE IF EXISTS status;
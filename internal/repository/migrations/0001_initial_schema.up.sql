@@ -0,0 +1,106 @@
+-- Schema previously created by PostgresStore.Init, every statement is idempotent
+-- so databases created before the migrations existed can be brought under version control
+
+DO $$ BEGIN
+	CREATE TYPE status AS ENUM('Pending', 'InProgress', 'Done');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+DO $$ BEGIN
+	CREATE TYPE priority AS ENUM('High', 'Medium', 'Low');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+DO $$ BEGIN
+	CREATE TYPE memberRole AS ENUM('Owner', 'Admin', 'Member', 'Viewer');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE TABLE IF NOT EXISTS Users (
+	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	cognitoId varchar(255) NOT NULL UNIQUE,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- Tables created before cognitoId was unique need the index for the ON CONFLICT upsert
+CREATE UNIQUE INDEX IF NOT EXISTS users_cognitoid_key ON Users(cognitoId);
+
+CREATE TABLE IF NOT EXISTS AccessTokens (
+	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	userId SMALLINT NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+	name varchar(255) NOT NULL,
+	tokenHash char(64) NOT NULL UNIQUE,
+	scopes text[] NOT NULL,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expiresAt TIMESTAMPTZ,
+	lastUsedAt TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS Teams (
+	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	name varchar(255) NOT NULL,
+	description text,
+	adminId SMALLINT NOT NULL REFERENCES Users(id)
+);
+
+CREATE TABLE IF NOT EXISTS TeamMembers (
+	teamId SMALLINT NOT NULL REFERENCES Teams(id) ON DELETE CASCADE,
+	userId SMALLINT NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+	role memberRole NOT NULL DEFAULT 'Member',
+	joinedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (teamId, userId)
+);
+
+CREATE TABLE IF NOT EXISTS TeamInvitations (
+	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	teamId SMALLINT NOT NULL REFERENCES Teams(id) ON DELETE CASCADE,
+	role memberRole NOT NULL DEFAULT 'Member',
+	tokenHash char(64) NOT NULL UNIQUE,
+	invitedBy SMALLINT NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expiresAt TIMESTAMPTZ NOT NULL,
+	acceptedAt TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS Projects (
+	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	userId SMALLINT NOT NULL REFERENCES Users(id),
+	title varchar(255),
+	description text,
+	priority priority DEFAULT 'Low',
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	teamId SMALLINT REFERENCES Teams(id) ON DELETE SET NULL
+);
+-- Projects created before teams could own projects
+ALTER TABLE Projects ADD COLUMN IF NOT EXISTS teamId SMALLINT REFERENCES Teams(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS ProjectShares (
+	projectId SMALLINT NOT NULL REFERENCES Projects(id) ON DELETE CASCADE,
+	userId SMALLINT NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+	role memberRole NOT NULL DEFAULT 'Viewer',
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (projectId, userId)
+);
+
+CREATE TABLE IF NOT EXISTS Tasks (
+	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	title varchar(255),
+	description text,
+	status status DEFAULT 'Pending',
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	projectId SMALLINT NOT NULL REFERENCES Projects(id),
+	startDate TIMESTAMPTZ,
+	dueDate TIMESTAMPTZ
+);
+-- Tasks created before start and due dates were tracked
+ALTER TABLE Tasks
+	ADD COLUMN IF NOT EXISTS startDate TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS dueDate TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS tasks_duedate_idx ON Tasks(dueDate);
+
+CREATE TABLE IF NOT EXISTS TaskAssignees (
+	taskId SMALLINT NOT NULL REFERENCES Tasks(id) ON DELETE CASCADE,
+	userId SMALLINT NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+	assignedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (taskId, userId)
+);
+CREATE INDEX IF NOT EXISTS taskassignees_userid_idx ON TaskAssignees(userId);
@@ -0,0 +1,2 @@
+ALTER TABLE Tasks DROP COLUMN IF EXISTS version;
+ALTER TABLE Projects DROP COLUMN IF EXISTS version;
@@ -0,0 +1,3 @@
+-- Incremented on every write, exposed as the ETag of the resource
+ALTER TABLE Projects ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE Tasks ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
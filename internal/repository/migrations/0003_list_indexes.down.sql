@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS projects_created_idx;
+DROP INDEX IF EXISTS tasks_project_created_idx;
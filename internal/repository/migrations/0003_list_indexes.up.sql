@@ -0,0 +1,3 @@
+-- Keyset pagination of the task lists in the default createdAt order
+CREATE INDEX IF NOT EXISTS tasks_project_created_idx ON Tasks(projectId, createdAt, id);
+CREATE INDEX IF NOT EXISTS projects_created_idx ON Projects(createdAt, id);
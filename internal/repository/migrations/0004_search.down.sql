@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS tasks_search_idx;
+ALTER TABLE Tasks DROP COLUMN IF EXISTS search;
+DROP INDEX IF EXISTS projects_search_idx;
+ALTER TABLE Projects DROP COLUMN IF EXISTS search;
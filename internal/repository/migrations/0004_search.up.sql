@@ -0,0 +1,12 @@
+-- Full-text search of projects and tasks, titles weigh more than descriptions in the ranking
+ALTER TABLE Projects ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(description, '')), 'B')
+) STORED;
+CREATE INDEX IF NOT EXISTS projects_search_idx ON Projects USING GIN (search);
+
+ALTER TABLE Tasks ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(description, '')), 'B')
+) STORED;
+CREATE INDEX IF NOT EXISTS tasks_search_idx ON Tasks USING GIN (search);
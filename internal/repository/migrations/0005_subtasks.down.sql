@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS tasks_parent_idx;
+ALTER TABLE Tasks DROP COLUMN IF EXISTS parentId;
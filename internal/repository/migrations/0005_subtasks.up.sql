@@ -0,0 +1,3 @@
+-- Subtasks, deleting a task moves its subtasks to the top level
+ALTER TABLE Tasks ADD COLUMN IF NOT EXISTS parentId SMALLINT REFERENCES Tasks(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS tasks_parent_idx ON Tasks(parentId);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS TaskLabels;
+DROP TABLE IF EXISTS Labels;
@@ -0,0 +1,16 @@
+-- Label catalogs of the projects and the labels of the tasks
+CREATE TABLE IF NOT EXISTS Labels (
+	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	projectId SMALLINT NOT NULL REFERENCES Projects(id) ON DELETE CASCADE,
+	name varchar(50) NOT NULL,
+	color char(7) NOT NULL,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS labels_project_name_idx ON Labels(projectId, lower(name));
+
+CREATE TABLE IF NOT EXISTS TaskLabels (
+	taskId SMALLINT NOT NULL REFERENCES Tasks(id) ON DELETE CASCADE,
+	labelId SMALLINT NOT NULL REFERENCES Labels(id) ON DELETE CASCADE,
+	PRIMARY KEY (taskId, labelId)
+);
+CREATE INDEX IF NOT EXISTS tasklabels_labelid_idx ON TaskLabels(labelId);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS CommentMentions;
+DROP TABLE IF EXISTS CommentEdits;
+DROP TABLE IF EXISTS Comments;
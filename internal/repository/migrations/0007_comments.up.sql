@@ -0,0 +1,29 @@
+-- Comment threads of the tasks, their edit history and the users mentioned in them
+CREATE TABLE IF NOT EXISTS Comments (
+	id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	taskId SMALLINT NOT NULL REFERENCES Tasks(id) ON DELETE CASCADE,
+	parentId INTEGER REFERENCES Comments(id) ON DELETE CASCADE,
+	userId SMALLINT NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+	body text NOT NULL,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	editedAt TIMESTAMPTZ,
+	deletedAt TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS comments_task_idx ON Comments(taskId, createdAt, id);
+CREATE INDEX IF NOT EXISTS comments_parent_idx ON Comments(parentId);
+
+CREATE TABLE IF NOT EXISTS CommentEdits (
+	commentId INTEGER NOT NULL REFERENCES Comments(id) ON DELETE CASCADE,
+	body text NOT NULL,
+	writtenAt TIMESTAMPTZ NOT NULL,
+	replacedAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS commentedits_comment_idx ON CommentEdits(commentId, replacedAt);
+
+CREATE TABLE IF NOT EXISTS CommentMentions (
+	commentId INTEGER NOT NULL REFERENCES Comments(id) ON DELETE CASCADE,
+	userId SMALLINT NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (commentId, userId)
+);
+CREATE INDEX IF NOT EXISTS commentmentions_user_idx ON CommentMentions(userId, createdAt);
@@ -0,0 +1,14 @@
+-- Metadata of the files attached to the tasks, their content is kept by the blob store.
+-- Deleting a task detaches its attachments so their content can still be deleted
+CREATE TABLE IF NOT EXISTS Attachments (
+	id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	taskId SMALLINT REFERENCES Tasks(id) ON DELETE SET NULL,
+	userId SMALLINT NOT NULL REFERENCES Users(id),
+	name varchar(255) NOT NULL,
+	contentType varchar(255) NOT NULL,
+	size BIGINT NOT NULL,
+	blobKey text NOT NULL UNIQUE,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS attachments_task_idx ON Attachments(taskId, createdAt, id);
+CREATE INDEX IF NOT EXISTS attachments_detached_idx ON Attachments(id) WHERE taskId IS NULL;
@@ -0,0 +1,43 @@
+-- Fails once an id does not fit in a SMALLINT anymore
+ALTER TABLE TaskLabels ALTER COLUMN labelId TYPE SMALLINT;
+ALTER SEQUENCE IF EXISTS labels_id_seq AS SMALLINT;
+ALTER TABLE Labels ALTER COLUMN id TYPE SMALLINT;
+
+ALTER TABLE Attachments ALTER COLUMN taskId TYPE SMALLINT;
+ALTER TABLE Comments ALTER COLUMN taskId TYPE SMALLINT;
+ALTER TABLE TaskLabels ALTER COLUMN taskId TYPE SMALLINT;
+ALTER TABLE TaskAssignees ALTER COLUMN taskId TYPE SMALLINT;
+ALTER TABLE Tasks ALTER COLUMN parentId TYPE SMALLINT;
+ALTER SEQUENCE IF EXISTS tasks_id_seq AS SMALLINT;
+ALTER TABLE Tasks ALTER COLUMN id TYPE SMALLINT;
+
+ALTER TABLE Labels ALTER COLUMN projectId TYPE SMALLINT;
+ALTER TABLE Tasks ALTER COLUMN projectId TYPE SMALLINT;
+ALTER TABLE ProjectShares ALTER COLUMN projectId TYPE SMALLINT;
+ALTER SEQUENCE IF EXISTS projects_id_seq AS SMALLINT;
+ALTER TABLE Projects ALTER COLUMN id TYPE SMALLINT;
+
+ALTER SEQUENCE IF EXISTS teaminvitations_id_seq AS SMALLINT;
+ALTER TABLE TeamInvitations ALTER COLUMN id TYPE SMALLINT;
+
+ALTER TABLE Projects ALTER COLUMN teamId TYPE SMALLINT;
+ALTER TABLE TeamInvitations ALTER COLUMN teamId TYPE SMALLINT;
+ALTER TABLE TeamMembers ALTER COLUMN teamId TYPE SMALLINT;
+ALTER SEQUENCE IF EXISTS teams_id_seq AS SMALLINT;
+ALTER TABLE Teams ALTER COLUMN id TYPE SMALLINT;
+
+ALTER SEQUENCE IF EXISTS accesstokens_id_seq AS SMALLINT;
+ALTER TABLE AccessTokens ALTER COLUMN id TYPE SMALLINT;
+
+ALTER TABLE AccessTokens ALTER COLUMN userId TYPE SMALLINT;
+ALTER TABLE Teams ALTER COLUMN adminId TYPE SMALLINT;
+ALTER TABLE TeamMembers ALTER COLUMN userId TYPE SMALLINT;
+ALTER TABLE TeamInvitations ALTER COLUMN invitedBy TYPE SMALLINT;
+ALTER TABLE Projects ALTER COLUMN userId TYPE SMALLINT;
+ALTER TABLE ProjectShares ALTER COLUMN userId TYPE SMALLINT;
+ALTER TABLE TaskAssignees ALTER COLUMN userId TYPE SMALLINT;
+ALTER TABLE Comments ALTER COLUMN userId TYPE SMALLINT;
+ALTER TABLE CommentMentions ALTER COLUMN userId TYPE SMALLINT;
+ALTER TABLE Attachments ALTER COLUMN userId TYPE SMALLINT;
+ALTER SEQUENCE IF EXISTS users_id_seq AS SMALLINT;
+ALTER TABLE Users ALTER COLUMN id TYPE SMALLINT;
@@ -0,0 +1,43 @@
+-- Ids outgrow SMALLINT, every id is widened together with the columns referencing it
+ALTER TABLE Users ALTER COLUMN id TYPE INTEGER;
+ALTER SEQUENCE IF EXISTS users_id_seq AS INTEGER;
+ALTER TABLE AccessTokens ALTER COLUMN userId TYPE INTEGER;
+ALTER TABLE Teams ALTER COLUMN adminId TYPE INTEGER;
+ALTER TABLE TeamMembers ALTER COLUMN userId TYPE INTEGER;
+ALTER TABLE TeamInvitations ALTER COLUMN invitedBy TYPE INTEGER;
+ALTER TABLE Projects ALTER COLUMN userId TYPE INTEGER;
+ALTER TABLE ProjectShares ALTER COLUMN userId TYPE INTEGER;
+ALTER TABLE TaskAssignees ALTER COLUMN userId TYPE INTEGER;
+ALTER TABLE Comments ALTER COLUMN userId TYPE INTEGER;
+ALTER TABLE CommentMentions ALTER COLUMN userId TYPE INTEGER;
+ALTER TABLE Attachments ALTER COLUMN userId TYPE INTEGER;
+
+ALTER TABLE AccessTokens ALTER COLUMN id TYPE INTEGER;
+ALTER SEQUENCE IF EXISTS accesstokens_id_seq AS INTEGER;
+
+ALTER TABLE Teams ALTER COLUMN id TYPE INTEGER;
+ALTER SEQUENCE IF EXISTS teams_id_seq AS INTEGER;
+ALTER TABLE TeamMembers ALTER COLUMN teamId TYPE INTEGER;
+ALTER TABLE TeamInvitations ALTER COLUMN teamId TYPE INTEGER;
+ALTER TABLE Projects ALTER COLUMN teamId TYPE INTEGER;
+
+ALTER TABLE TeamInvitations ALTER COLUMN id TYPE INTEGER;
+ALTER SEQUENCE IF EXISTS teaminvitations_id_seq AS INTEGER;
+
+ALTER TABLE Projects ALTER COLUMN id TYPE INTEGER;
+ALTER SEQUENCE IF EXISTS projects_id_seq AS INTEGER;
+ALTER TABLE ProjectShares ALTER COLUMN projectId TYPE INTEGER;
+ALTER TABLE Tasks ALTER COLUMN projectId TYPE INTEGER;
+ALTER TABLE Labels ALTER COLUMN projectId TYPE INTEGER;
+
+ALTER TABLE Tasks ALTER COLUMN id TYPE INTEGER;
+ALTER SEQUENCE IF EXISTS tasks_id_seq AS INTEGER;
+ALTER TABLE Tasks ALTER COLUMN parentId TYPE INTEGER;
+ALTER TABLE TaskAssignees ALTER COLUMN taskId TYPE INTEGER;
+ALTER TABLE TaskLabels ALTER COLUMN taskId TYPE INTEGER;
+ALTER TABLE Comments ALTER COLUMN taskId TYPE INTEGER;
+ALTER TABLE Attachments ALTER COLUMN taskId TYPE INTEGER;
+
+ALTER TABLE Labels ALTER COLUMN id TYPE INTEGER;
+ALTER SEQUENCE IF EXISTS labels_id_seq AS INTEGER;
+ALTER TABLE TaskLabels ALTER COLUMN labelId TYPE INTEGER;
@@ -2,47 +2,15 @@ package repo
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 
+	"github.com/Desgue/ttracker-api/internal/domain"
 	_ "github.com/lib/pq"
 )
 
-const (
-	createStatusEnumQuery = `CREATE TYPE status as ENUM('Pending', 'InProgress', 'Done');`
-	createTaskTableQuery  = `
-	CREATE TABLE IF NOT EXISTS Tasks (
-	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
-	title varchar(255),
-	description text,
-	status status DEFAULT 'Pending',
-	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-	projectId SMALLINT NOT NULL REFERENCES Projects(id)
-);`
-	createPriorityEnumQuery = `CREATE TYPE priority as ENUM('High', 'Medium', 'Low');`
-	createProjectTableQuery = `
-	CREATE TABLE IF NOT EXISTS Projects (
-	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
-	userId SMALLINT NOT NULL REFERENCES Users(id),
-	title varchar(255),
-	description text,
-	priority priority DEFAULT 'Low',
-	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);`
-	createUserTableQuery = `
-	CREATE TABLE IF NOT EXISTS Users (
-	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
-	cognitoId varchar(255) NOT NULL,
-	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);`
-	createTeamTableQuery = `
-	CREATE TABLE IF NOT EXISTS Teams (
-	id SMALLINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
-	name varchar(255) NOT NULL,
-	description text,
-	adminId SMALLINT NOT NULL REFERENCES Users(id)
-);`
-)
-
 type PostgresStore struct {
 	connStr string
 	DB      *sql.DB
@@ -56,54 +24,76 @@ func (store *PostgresStore) Ping() error {
 	return nil
 }
 
-func (store *PostgresStore) Init() {
-	store.createEnums()
-	store.createTables()
-}
-
-func (store *PostgresStore) createEnums() {
-	_, err := store.DB.Exec(createStatusEnumQuery)
-	if err != nil {
-		log.Println(err)
-		log.Println("Error creating status enum continuing with the program...")
-	}
-	_, err = store.DB.Exec(createPriorityEnumQuery)
+func NewPostgresStore(connStr string) (*PostgresStore, error) {
+	DB, err := sql.Open("postgres", connStr)
 	if err != nil {
-		log.Println(err)
-		log.Println("Error creating priority enum continuing with the program...")
+		return nil, err
 	}
-
+	return &PostgresStore{
+		connStr: connStr,
+		DB:      DB,
+	}, nil
 }
 
-func (store *PostgresStore) createTables() {
-	var err error
+// Column written by an UPDATE, see setClause
+type column struct {
+	name  string
+	value any
+}
 
-	_, err = store.DB.Exec(createTeamTableQuery)
-	if err != nil {
-		log.Fatalln(err)
+// Builds the SET list of an UPDATE, the placeholders start at $1 in the order of columns
+func setClause(columns []column) (string, []any) {
+	sets := make([]string, len(columns))
+	args := make([]any, len(columns))
+	for i, c := range columns {
+		sets[i] = fmt.Sprintf("%s=$%d", c.name, i+1)
+		args[i] = c.value
 	}
-	_, err = store.DB.Exec(createUserTableQuery)
-	if err != nil {
-		log.Fatalln(err)
+	return strings.Join(sets, ", "), args
+}
+
+// Locks the row selected by query until the end of tx and checks its version, query must
+// select the version column only. A zero expected version accepts any version
+func lockVersion(tx *sql.Tx, query string, args []any, expected int, notFound error) error {
+	var current int
+	err := tx.QueryRow(query, args...).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound
 	}
-	_, err = store.DB.Exec(createProjectTableQuery)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
-	_, err = store.DB.Exec(createTaskTableQuery)
-	if err != nil {
-		log.Fatalln(err)
+	if expected != 0 && current != expected {
+		return domain.ErrVersionMismatch
 	}
+	return nil
+}
 
+// Column a list can be sorted by, cast is the type the key of a cursor is read as
+type sortColumn struct {
+	expr string
+	cast string
 }
 
-func NewPostgresStore(connStr string) (*PostgresStore, error) {
-	DB, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return nil, err
+// Builds the keyset condition following page.After and the ORDER BY and LIMIT of the page,
+// id breaks the ties of the sort column. The values are appended to args
+func pageClause(page domain.Page, columns map[domain.SortField]sortColumn, id string, args *[]any) (condition, order string) {
+	column, ok := columns[page.Sort]
+	if !ok {
+		column = columns[domain.SortCreatedAt]
 	}
-	return &PostgresStore{
-		connStr: connStr,
-		DB:      DB,
-	}, nil
+	dir, op := "ASC", ">"
+	if page.Desc {
+		dir, op = "DESC", "<"
+	}
+	if page.After != nil {
+		*args = append(*args, page.After.Key, page.After.Id)
+		condition = fmt.Sprintf(" AND (%s, %s) %s ($%d::%s, $%d)", column.expr, id, op, len(*args)-1, column.cast, len(*args))
+	}
+	order = fmt.Sprintf(" ORDER BY %s %s, %s %s", column.expr, dir, id, dir)
+	if page.Limit > 0 {
+		*args = append(*args, page.Limit)
+		order += fmt.Sprintf(" LIMIT $%d", len(*args))
+	}
+	return condition, order
 }
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/repository/storagetest"
+)
+
+// TEST_DATABASE_URL must be a postgres:// url of a server where the user can create databases,
+// the suite runs in a throwaway database dropped at the end of the test
+func TestPostgresStorage(t *testing.T) {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping the Postgres storage tests")
+	}
+	db := newThrowawayDatabase(t, connStr)
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Stores {
+		_, err := db.Exec(`TRUNCATE Attachments, CommentMentions, CommentEdits, Comments, TaskLabels, Labels, TaskAssignees, Tasks, ProjectShares, Projects, TeamInvitations,
+		TeamMembers, Teams, AccessTokens, Users RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storagetest.Stores{
+			Users:       NewPostgresUserStore(db),
+			Projects:    NewPostgresProjectStore(db),
+			Tasks:       NewPostgresTaskStore(db),
+			Teams:       NewPostgresTeamStore(db),
+			Search:      NewPostgresSearchStore(db),
+			Labels:      NewPostgresLabelStore(db),
+			Comments:    NewPostgresCommentStore(db),
+			Attachments: NewPostgresAttachmentStore(db),
+			Tokens:      NewPostgresAccessTokenStore(db),
+			UserId: func(t *testing.T, cognitoId string) int {
+				var id int
+				if err := db.QueryRow("SELECT id FROM Users WHERE cognitoId=$1", cognitoId).Scan(&id); err != nil {
+					t.Fatalf("user %s not found: %v", cognitoId, err)
+				}
+				return id
+			},
+		}
+	})
+}
+
+func newThrowawayDatabase(t *testing.T, connStr string) *sql.DB {
+	t.Helper()
+	admin, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	name := "ttracker_test_" + hex.EncodeToString(suffix)
+	if _, err := admin.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Path = "/" + name
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if _, err := admin.Exec("DROP DATABASE IF EXISTS " + name + " WITH (FORCE)"); err != nil {
+			t.Log("Error dropping the test database: ", err)
+		}
+	})
+	return db
+}
@@ -0,0 +1,348 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Smallest part S3 accepts in a multipart upload, except for the last one
+const minS3PartSize = 5 << 20
+
+// Payload hash of the requests, the bodies are streamed so they are not signed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+type S3Config struct {
+	// Base url of the service, like https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	// for a local stand-in. Buckets are addressed in the path so any S3 compatible service works
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prepended to every key, lets several environments share a bucket
+	Prefix string
+}
+
+// S3BlobStore keeps the blobs in a bucket of an S3 compatible service. Uploads are sent in
+// parts of PartSize bytes so at most one part per upload is held in memory
+type S3BlobStore struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	PartSize int
+}
+
+func NewS3BlobStore(config S3Config) (*S3BlobStore, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q, must be an http or https url", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("no S3 bucket configured")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	return &S3BlobStore{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{},
+		PartSize: minS3PartSize,
+	}, nil
+}
+
+// Content that fits in a single part is sent with a plain PUT, anything bigger goes through
+// a multipart upload which is aborted when reading r fails
+func (store *S3BlobStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	buf := make([]byte, store.PartSize)
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return store.putObject(ctx, key, buf[:n], contentType)
+	}
+	if err != nil {
+		return err
+	}
+
+	uploadId, err := store.createUpload(ctx, key, contentType)
+	if err != nil {
+		return err
+	}
+	var etags []string
+	for n > 0 {
+		etag, err := store.uploadPart(ctx, key, uploadId, len(etags)+1, buf[:n])
+		if err != nil {
+			store.abortUpload(key, uploadId)
+			return err
+		}
+		etags = append(etags, etag)
+		n, err = io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			store.abortUpload(key, uploadId)
+			return err
+		}
+	}
+	if err := store.completeUpload(ctx, key, uploadId, etags); err != nil {
+		store.abortUpload(key, uploadId)
+		return err
+	}
+	return nil
+}
+
+func (store *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := store.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, domain.ErrBlobNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp, http.MethodGet, key)
+	}
+	return resp.Body, nil
+}
+
+func (store *S3BlobStore) Delete(ctx context.Context, key string) error {
+	resp, err := store.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp, http.MethodDelete, key)
+	}
+	return nil
+}
+
+func (store *S3BlobStore) putObject(ctx context.Context, key string, content []byte, contentType string) error {
+	resp, err := store.do(ctx, http.MethodPut, key, nil, contentHeader(contentType), content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, http.MethodPut, key)
+	}
+	return nil
+}
+
+func (store *S3BlobStore) createUpload(ctx context.Context, key, contentType string) (string, error) {
+	resp, err := store.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, contentHeader(contentType), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", s3Error(resp, http.MethodPost, key)
+	}
+	var result struct {
+		UploadId string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decoding the S3 multipart upload of %s: %w", key, err)
+	}
+	return result.UploadId, nil
+}
+
+func (store *S3BlobStore) uploadPart(ctx context.Context, key, uploadId string, number int, content []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadId}}
+	resp, err := store.do(ctx, http.MethodPut, key, query, nil, content)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", s3Error(resp, http.MethodPut, key)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart
+}
+
+type completedPart struct {
+	XMLName    xml.Name `xml:"Part"`
+	PartNumber int
+	ETag       string
+}
+
+func (store *S3BlobStore) completeUpload(ctx context.Context, key, uploadId string, etags []string) error {
+	body := completeMultipartUpload{}
+	for i, etag := range etags {
+		body.Parts = append(body.Parts, completedPart{PartNumber: i + 1, ETag: etag})
+	}
+	content, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := store.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadId}}, nil, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, http.MethodPost, key)
+	}
+	// The completion can fail after the 200 is sent, the body then holds an error
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding the S3 completion of %s: %w", key, err)
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("S3 POST %s: %s %s", key, result.Code, result.Message)
+	}
+	return nil
+}
+
+// Aborting frees the parts already uploaded, it is done even when the request was canceled
+func (store *S3BlobStore) abortUpload(key, uploadId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	resp, err := store.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadId}}, nil, nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+func contentHeader(contentType string) http.Header {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return header
+}
+
+// Sends a signed request about the object under key
+func (store *S3BlobStore) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *store.endpoint
+	objectPath := strings.TrimSuffix(u.Path, "/") + "/" + store.config.Bucket + "/" + store.config.Prefix + key
+	u.Path = objectPath
+	u.RawPath = s3Escape(objectPath, false)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	store.sign(req, unsignedPayload, time.Now())
+	return store.client.Do(req)
+}
+
+// Signs the request with AWS Signature Version 4, every header set so far is signed
+func (store *S3BlobStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + store.config.Region + "/s3/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.Host}
+	if req.Host == "" {
+		headers["host"] = req.URL.Host
+	}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + store.config.SecretKey)
+	for _, part := range []string{amzDate[:8], store.config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+store.config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Percent encodes everything but the unreserved characters as SigV4 requires,
+// the slashes of a path are kept
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// Turns an S3 error response into an error, the body is an XML document with a code and message
+func s3Error(resp *http.Response, method, key string) error {
+	defer resp.Body.Close()
+	var body struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	if body.Code == "" {
+		return fmt.Errorf("S3 %s %s: %s", method, key, resp.Status)
+	}
+	return fmt.Errorf("S3 %s %s: %s %s: %s", method, key, resp.Status, body.Code, body.Message)
+}
@@ -0,0 +1,461 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/Desgue/ttracker-api/internal/repository/storagetest"
+)
+
+const (
+	fakeS3Bucket    = "blobs"
+	fakeS3Region    = "eu-west-1"
+	fakeS3AccessKey = "AKIDTEST"
+	fakeS3SecretKey = "test-secret"
+)
+
+// In-process stand-in for the parts of the S3 API the blob store uses. Every request must carry
+// a valid Signature Version 4, checked independently of the signing code of the store
+type fakeS3 struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+	uploads map[string]*fakeS3Upload
+	lastId  int
+	// Part number of the multipart uploads answered with a 500, none when zero
+	failPart int
+	aborted  []string
+	requests []string
+}
+
+type fakeS3Object struct {
+	content     []byte
+	contentType string
+}
+
+type fakeS3Upload struct {
+	key         string
+	contentType string
+	parts       map[int][]byte
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	s := &fakeS3{objects: map[string]fakeS3Object{}, uploads: map[string]*fakeS3Upload{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newFakeS3Store(t *testing.T, s *fakeS3, secretKey string) *S3BlobStore {
+	t.Helper()
+	store, err := NewS3BlobStore(S3Config{
+		Endpoint:  s.URL,
+		Region:    fakeS3Region,
+		Bucket:    fakeS3Bucket,
+		AccessKey: fakeS3AccessKey,
+		SecretKey: secretKey,
+		Prefix:    "env/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func (s *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s3Fail(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if err := verifySigV4(r, body); err != nil {
+		s3Fail(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+fakeS3Bucket+"/")
+	if !ok {
+		s3Fail(w, http.StatusNotFound, "NoSuchBucket", r.URL.Path)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	_, uploads := query["uploads"]
+	uploadId := query.Get("uploadId")
+	op := r.Method
+	switch {
+	case r.Method == http.MethodPost && uploads:
+		op = "CreateUpload"
+		s.lastId++
+		id := "upload-" + strconv.Itoa(s.lastId)
+		s.uploads[id] = &fakeS3Upload{key: key, contentType: r.Header.Get("Content-Type"), parts: map[int][]byte{}}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", fakeS3Bucket, key, id)
+	case r.Method == http.MethodPut && uploadId != "":
+		op = "UploadPart"
+		upload, ok := s.uploads[uploadId]
+		number, err := strconv.Atoi(query.Get("partNumber"))
+		switch {
+		case !ok || upload.key != key:
+			s3Fail(w, http.StatusNotFound, "NoSuchUpload", uploadId)
+		case err != nil || number < 1:
+			s3Fail(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		case number == s.failPart:
+			s3Fail(w, http.StatusInternalServerError, "InternalError", "part upload failed")
+		default:
+			upload.parts[number] = body
+			w.Header().Set("ETag", partETag(body))
+		}
+	case r.Method == http.MethodPost && uploadId != "":
+		op = "CompleteUpload"
+		s.complete(w, key, uploadId, body)
+	case r.Method == http.MethodDelete && uploadId != "":
+		op = "AbortUpload"
+		if _, ok := s.uploads[uploadId]; !ok {
+			s3Fail(w, http.StatusNotFound, "NoSuchUpload", uploadId)
+			break
+		}
+		delete(s.uploads, uploadId)
+		s.aborted = append(s.aborted, uploadId)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		s.objects[key] = fakeS3Object{content: body, contentType: r.Header.Get("Content-Type")}
+	case r.Method == http.MethodGet:
+		object, ok := s.objects[key]
+		if !ok {
+			s3Fail(w, http.StatusNotFound, "NoSuchKey", key)
+			break
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Write(object.content)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+	s.requests = append(s.requests, op)
+}
+
+// Must be called with the lock held
+func (s *fakeS3) complete(w http.ResponseWriter, key, uploadId string, body []byte) {
+	upload, ok := s.uploads[uploadId]
+	if !ok || upload.key != key {
+		s3Fail(w, http.StatusNotFound, "NoSuchUpload", uploadId)
+		return
+	}
+	var request struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		s3Fail(w, http.StatusBadRequest, "MalformedXML", fmt.Sprint(err))
+		return
+	}
+	var content []byte
+	for i, part := range request.Parts {
+		data, ok := upload.parts[part.PartNumber]
+		if part.PartNumber != i+1 || !ok || part.ETag != partETag(data) {
+			s3Fail(w, http.StatusBadRequest, "InvalidPart", strconv.Itoa(part.PartNumber))
+			return
+		}
+		if i < len(request.Parts)-1 && len(data) < minS3PartSize {
+			s3Fail(w, http.StatusBadRequest, "EntityTooSmall", strconv.Itoa(part.PartNumber))
+			return
+		}
+		content = append(content, data...)
+	}
+	delete(s.uploads, uploadId)
+	s.objects[key] = fakeS3Object{content: content, contentType: upload.contentType}
+	fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+}
+
+func (s *fakeS3) state() (requests, aborted []string, pending int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...), append([]string{}, s.aborted...), len(s.uploads)
+}
+
+func (s *fakeS3) object(key string) (fakeS3Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[key]
+	return object, ok
+}
+
+func partETag(content []byte) string {
+	sum := md5.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func s3Fail(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, msg)
+}
+
+// Checks the Authorization header the way S3 does, from the request as it was received
+func verifySigV4(r *http.Request, body []byte) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("missing AWS4-HMAC-SHA256 authorization")
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != fakeS3AccessKey || credential[2] != fakeS3Region || credential[3] != "s3" || credential[4] != "aws4_request" {
+		return fmt.Errorf("invalid credential %q", fields["Credential"])
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || amzDate[:8] != credential[1] {
+		return fmt.Errorf("invalid date %q for the credential scope %s", amzDate, credential[1])
+	}
+	if d := time.Since(signedAt); d > 15*time.Minute || d < -15*time.Minute {
+		return fmt.Errorf("request signed at %s", signedAt)
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if sum := sha256.Sum256(body); payloadHash != unsignedPayload && payloadHash != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("invalid payload hash %q", payloadHash)
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signed) {
+		return fmt.Errorf("signed headers %v are not sorted", signed)
+	}
+	required := []string{"host", "x-amz-date", "x-amz-content-sha256"}
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" {
+			required = append(required, name)
+		}
+	}
+	for _, name := range required {
+		if !contains(signed, name) {
+			return fmt.Errorf("header %s is not signed", name)
+		}
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := strings.Join(r.Header.Values(name), ",")
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	var params []string
+	if r.URL.RawQuery != "" {
+		for _, param := range strings.Split(r.URL.RawQuery, "&") {
+			rawName, rawValue, _ := strings.Cut(param, "=")
+			name, err1 := url.QueryUnescape(rawName)
+			value, err2 := url.QueryUnescape(rawValue)
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("invalid query %q", r.URL.RawQuery)
+			}
+			params = append(params, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(params)
+
+	path, _, _ := strings.Cut(r.RequestURI, "?")
+	canonicalRequest := strings.Join([]string{r.Method, path, strings.Join(params, "&"), headers.String(), fields["SignedHeaders"], payloadHash}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, strings.Join(credential[1:], "/"), hex.EncodeToString(requestHash[:])}, "\n")
+	key := []byte("AWS4" + fakeS3SecretKey)
+	for _, part := range credential[1:] {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	if want := hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature does not match the canonical request:\n%s", canonicalRequest)
+	}
+	return nil
+}
+
+// Query escaping of SigV4, spaces are %20 and only the unreserved characters are kept
+func awsEscape(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(url.QueryEscape(s), "+", "%20"), "%7E", "~")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestS3BlobStoreStandIn(t *testing.T) {
+	storagetest.RunBlobs(t, func(t *testing.T) domain.BlobStore {
+		return newFakeS3Store(t, newFakeS3(t), fakeS3SecretKey)
+	})
+}
+
+func TestS3BlobStoreSinglePart(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeS3(t)
+	store := newFakeS3Store(t, s, fakeS3SecretKey)
+
+	// Keys are escaped in the path and the signature alike
+	key := "tasks/1/a report (final)+v2 é.txt"
+	mustPut(t, store.Put(ctx, key, strings.NewReader("hello"), "text/plain; charset=utf-8"))
+	object, ok := s.object("env/" + key)
+	if !ok || string(object.content) != "hello" || object.contentType != "text/plain; charset=utf-8" {
+		t.Fatalf("got object %+v, %t", object, ok)
+	}
+	blob, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil || string(content) != "hello" {
+		t.Fatalf("got content %q, %v", content, err)
+	}
+	mustPut(t, store.Delete(ctx, key))
+	if _, ok := s.object("env/" + key); ok {
+		t.Fatal("object still stored after its deletion")
+	}
+
+	requests, _, _ := s.state()
+	if want := []string{"PUT", "GET", "DELETE"}; !equalStrings(requests, want) {
+		t.Fatalf("got requests %v, want %v", requests, want)
+	}
+}
+
+func TestS3BlobStoreMultipart(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeS3(t)
+	store := newFakeS3Store(t, s, fakeS3SecretKey)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), (2*minS3PartSize+1000)/16)
+	mustPut(t, store.Put(ctx, "large", bytes.NewReader(content), "application/pdf"))
+	object, ok := s.object("env/large")
+	if !ok || !bytes.Equal(object.content, content) || object.contentType != "application/pdf" {
+		t.Fatalf("multipart object stored with %d bytes of type %q, want %d bytes", len(object.content), object.contentType, len(content))
+	}
+	requests, aborted, pending := s.state()
+	if want := []string{"CreateUpload", "UploadPart", "UploadPart", "UploadPart", "CompleteUpload"}; !equalStrings(requests, want) {
+		t.Fatalf("got requests %v, want %v", requests, want)
+	}
+	if len(aborted) != 0 || pending != 0 {
+		t.Fatalf("got %d aborted and %d pending uploads", len(aborted), pending)
+	}
+
+	// Content of exactly one part is sent with a single request
+	mustPut(t, store.Put(ctx, "one part", bytes.NewReader(content[:minS3PartSize-1]), "application/pdf"))
+	if requests, _, _ := s.state(); requests[len(requests)-1] != "PUT" {
+		t.Fatalf("got requests %v for content smaller than a part", requests)
+	}
+}
+
+func TestS3BlobStoreAbortsFailedUpload(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeS3(t)
+	s.failPart = 2
+	store := newFakeS3Store(t, s, fakeS3SecretKey)
+
+	content := bytes.Repeat([]byte{1}, 3*minS3PartSize)
+	err := store.Put(ctx, "large", bytes.NewReader(content), "application/pdf")
+	if err == nil || !strings.Contains(err.Error(), "InternalError") {
+		t.Fatalf("got error %v, want the error of the failed part", err)
+	}
+	requests, aborted, pending := s.state()
+	if want := []string{"CreateUpload", "UploadPart", "UploadPart", "AbortUpload"}; !equalStrings(requests, want) {
+		t.Fatalf("got requests %v, want %v", requests, want)
+	}
+	if len(aborted) != 1 || pending != 0 {
+		t.Fatalf("got %d aborted and %d pending uploads, want the upload aborted", len(aborted), pending)
+	}
+	if _, ok := s.object("env/large"); ok {
+		t.Fatal("object stored after a failed upload")
+	}
+
+	// Aborting does not depend on the request context, it is done after a cancellation too
+	canceled, cancel := context.WithCancel(ctx)
+	s.failPart = 0
+	err = store.Put(canceled, "large", io.MultiReader(bytes.NewReader(content[:minS3PartSize+10]), cancelingReader{cancel}), "application/pdf")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if _, aborted, pending = s.state(); len(aborted) != 2 || pending != 0 {
+		t.Fatalf("got %d aborted and %d pending uploads after a cancellation", len(aborted), pending)
+	}
+}
+
+func TestS3BlobStoreSignature(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeS3(t)
+	store := newFakeS3Store(t, s, "wrong-secret")
+
+	err := store.Put(ctx, "a", strings.NewReader("hello"), "text/plain")
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("got error %v, want the signature rejected", err)
+	}
+	if _, err := store.Get(ctx, "a"); err == nil || errors.Is(err, domain.ErrBlobNotFound) {
+		t.Fatalf("got error %v for a rejected get, want a failure rather than not found", err)
+	}
+	if err := store.Delete(ctx, "a"); err == nil {
+		t.Fatal("rejected delete reported as successful")
+	}
+}
+
+// Cancels the upload once the first part was read, then fails like a reader of a canceled request
+type cancelingReader struct {
+	cancel context.CancelFunc
+}
+
+func (r cancelingReader) Read([]byte) (int, error) {
+	r.cancel()
+	return 0, context.Canceled
+}
+
+func mustPut(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package storagetest
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+func runAttachmentTests(t *testing.T, newStores Factory) {
+	runCases(t, newStores, []testCase{
+		{"Attachments", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			task := s.createTask(t, id, "t", nil)
+			sibling := s.createTask(t, id, "sibling", nil)
+			elsewhere := s.createTask(t, other, "elsewhere", nil)
+
+			created, err := s.Attachments.CreateAttachment(&domain.AttachmentRequest{
+				Name: "spec.pdf", ContentType: "application/pdf", Size: 1234, BlobKey: "blob/1",
+				ProjectId: id, TaskId: task, UserCognitoId: "alice",
+			})
+			mustNotFail(t, err)
+			if created.TaskId != task || created.Name != "spec.pdf" || created.ContentType != "application/pdf" ||
+				created.Size != 1234 || created.BlobKey != "blob/1" || created.UploadedBy != "alice" || created.CreatedAt.IsZero() {
+				t.Fatalf("got attachment %+v", created)
+			}
+			second := s.createAttachment(t, id, task, "blob/2")
+			s.createAttachment(t, id, sibling, "blob/3")
+			_, err = s.Attachments.CreateAttachment(&domain.AttachmentRequest{Name: "x", ContentType: "text/plain", BlobKey: "blob/4", ProjectId: id, TaskId: elsewhere, UserCognitoId: "alice"})
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+
+			attachments, err := s.Attachments.GetAttachments(id, task)
+			mustNotFail(t, err)
+			if ids := attachmentIds(attachments); !reflect.DeepEqual(ids, []int{created.Id, second}) {
+				t.Fatalf("got attachments %v, want %v", ids, []int{created.Id, second})
+			}
+			attachment, err := s.Attachments.GetAttachment(id, task, created.Id)
+			mustNotFail(t, err)
+			if !attachment.CreatedAt.Equal(created.CreatedAt) {
+				t.Fatalf("create returned %+v, stored %+v", created, attachment)
+			}
+			_, err = s.Attachments.GetAttachments(id, elsewhere)
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			_, err = s.Attachments.GetAttachment(id, sibling, created.Id)
+			mustFailWith(t, err, domain.ErrAttachmentNotFound)
+			_, err = s.Attachments.GetAttachment(other, task, created.Id)
+			mustFailWith(t, err, domain.ErrAttachmentNotFound)
+		}},
+		{"DetachAttachment", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			task := s.createTask(t, id, "t", nil)
+			deleted := s.createTask(t, id, "deleted", nil)
+			otherTask := s.createTask(t, other, "t", nil)
+			kept := s.createAttachment(t, id, task, "blob/kept")
+			removed := s.createAttachment(t, id, task, "blob/removed")
+			withTask := s.createAttachment(t, id, deleted, "blob/task")
+			withProject := s.createAttachment(t, other, otherTask, "blob/project")
+
+			detached, err := s.Attachments.DetachAttachment(id, task, removed)
+			mustNotFail(t, err)
+			if detached.BlobKey != "blob/removed" {
+				t.Fatalf("detach returned %+v", detached)
+			}
+			_, err = s.Attachments.DetachAttachment(id, task, removed)
+			mustFailWith(t, err, domain.ErrAttachmentNotFound)
+			_, err = s.Attachments.GetAttachment(id, task, removed)
+			mustFailWith(t, err, domain.ErrAttachmentNotFound)
+
+			// Deleting a task or its project detaches its attachments
+			mustNotFail(t, s.Tasks.DeleteTask(id, strconv.Itoa(deleted), 0))
+			mustNotFail(t, s.Projects.DeleteProject(strconv.Itoa(other), 0))
+
+			orphans, err := s.Attachments.GetDetachedAttachments(10)
+			mustNotFail(t, err)
+			if ids := attachmentIds(orphans); !reflect.DeepEqual(ids, []int{removed, withTask, withProject}) {
+				t.Fatalf("got detached attachments %v, want %v", ids, []int{removed, withTask, withProject})
+			}
+			orphans, err = s.Attachments.GetDetachedAttachments(1)
+			mustNotFail(t, err)
+			if ids := attachmentIds(orphans); !reflect.DeepEqual(ids, []int{removed}) {
+				t.Fatalf("got detached attachments %v with a limit of 1", ids)
+			}
+
+			// Only detached attachments can be deleted
+			mustFailWith(t, s.Attachments.DeleteAttachment(kept), domain.ErrAttachmentNotFound)
+			for _, attachmentId := range []int{removed, withTask, withProject} {
+				mustNotFail(t, s.Attachments.DeleteAttachment(attachmentId))
+			}
+			orphans, err = s.Attachments.GetDetachedAttachments(10)
+			mustNotFail(t, err)
+			if len(orphans) != 0 {
+				t.Fatalf("got detached attachments %v after deleting them", attachmentIds(orphans))
+			}
+			_, err = s.Attachments.GetAttachment(id, task, kept)
+			mustNotFail(t, err)
+		}},
+	})
+}
+
+func attachmentIds(attachments []domain.Attachment) []int {
+	var ids []int
+	for _, attachment := range attachments {
+		ids = append(ids, attachment.Id)
+	}
+	return ids
+}
+
+func (s Stores) createAttachment(t *testing.T, projectId, taskId int, blobKey string) int {
+	t.Helper()
+	attachment, err := s.Attachments.CreateAttachment(&domain.AttachmentRequest{
+		Name: "file.txt", ContentType: "text/plain", Size: 1, BlobKey: blobKey,
+		ProjectId: projectId, TaskId: taskId, UserCognitoId: "alice",
+	})
+	mustNotFail(t, err)
+	return attachment.Id
+}
@@ -0,0 +1,107 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// BlobFactory returns an empty blob store, it is called once per test
+type BlobFactory func(t *testing.T) domain.BlobStore
+
+// RunBlobs runs the contract suite of domain.BlobStore against the store built by newStore
+func RunBlobs(t *testing.T, newStore BlobFactory) {
+	ctx := context.Background()
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, store domain.BlobStore)
+	}{
+		{"PutGetDelete", func(t *testing.T, store domain.BlobStore) {
+			mustNotFail(t, store.Put(ctx, "tasks/1/a", bytes.NewReader([]byte("hello")), "text/plain"))
+			if got := readBlob(t, store, "tasks/1/a"); string(got) != "hello" {
+				t.Fatalf("got content %q, want %q", got, "hello")
+			}
+			// Empty content is still content
+			mustNotFail(t, store.Put(ctx, "tasks/1/empty", bytes.NewReader(nil), "text/plain"))
+			if got := readBlob(t, store, "tasks/1/empty"); len(got) != 0 {
+				t.Fatalf("got content %q, want none", got)
+			}
+
+			mustNotFail(t, store.Delete(ctx, "tasks/1/a"))
+			_, err := store.Get(ctx, "tasks/1/a")
+			mustFailWith(t, err, domain.ErrBlobNotFound)
+			mustNotFail(t, store.Delete(ctx, "tasks/1/a"))
+			mustNotFail(t, store.Delete(ctx, "tasks/1/empty"))
+		}},
+		{"LargeContent", func(t *testing.T, store domain.BlobStore) {
+			// Bigger than the parts of a multipart upload, generated while it is read
+			const size = 11<<20 + 123
+			want := sha256.New()
+			mustNotFail(t, store.Put(ctx, "large", io.TeeReader(io.LimitReader(patternReader{}, size), want), "application/octet-stream"))
+			blob, err := store.Get(ctx, "large")
+			mustNotFail(t, err)
+			defer blob.Close()
+			got := sha256.New()
+			n, err := io.Copy(got, blob)
+			mustNotFail(t, err)
+			if n != size || !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+				t.Fatalf("read %d bytes back out of %d, or they differ", n, size)
+			}
+			mustNotFail(t, store.Delete(ctx, "large"))
+		}},
+		{"FailedPut", func(t *testing.T, store domain.BlobStore) {
+			// A reader failing midway leaves nothing behind, whether the content fits in a part or not
+			for _, size := range []int64{10, 6 << 20} {
+				failure := errors.New("reading the upload failed")
+				r := io.MultiReader(io.LimitReader(patternReader{}, size), failingReader{failure})
+				if err := store.Put(ctx, "failed", r, "text/plain"); !errors.Is(err, failure) {
+					t.Fatalf("got error %v after %d bytes, want %v", err, size, failure)
+				}
+				_, err := store.Get(ctx, "failed")
+				mustFailWith(t, err, domain.ErrBlobNotFound)
+			}
+		}},
+		{"Missing", func(t *testing.T, store domain.BlobStore) {
+			_, err := store.Get(ctx, "tasks/404/missing")
+			mustFailWith(t, err, domain.ErrBlobNotFound)
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.fn(t, newStore(t))
+		})
+	}
+}
+
+func readBlob(t *testing.T, store domain.BlobStore, key string) []byte {
+	t.Helper()
+	blob, err := store.Get(context.Background(), key)
+	mustNotFail(t, err)
+	defer blob.Close()
+	content, err := io.ReadAll(blob)
+	mustNotFail(t, err)
+	return content
+}
+
+// Endless stream of bytes that is not the same in every part
+type patternReader struct{}
+
+func (patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(i*7 + i/251)
+	}
+	return len(p), nil
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,226 @@
+package storagetest
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+func runCommentTests(t *testing.T, newStores Factory) {
+	runCases(t, newStores, []testCase{
+		{"Threads", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			s.newUser(t, "carol")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			mustNotFail(t, s.Projects.ShareProject(strconv.Itoa(id), bob, domain.RoleMember))
+			task := s.createTask(t, id, "t", nil)
+			sibling := s.createTask(t, id, "sibling", nil)
+			elsewhere := s.createTask(t, other, "elsewhere", nil)
+
+			// Only the members of the project are resolved
+			members, err := s.Comments.GetProjectMembers(id, []string{"bob", "carol", "alice", "nobody"})
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(members, []int{alice, bob}) {
+				t.Fatalf("got members %v, want %v", members, []int{alice, bob})
+			}
+
+			created, err := s.Comments.CreateComment(&domain.CommentRequest{Body: "**first**", ProjectId: id, TaskId: task, UserCognitoId: "alice", Mentions: []int{bob}})
+			mustNotFail(t, err)
+			if created.Body != "**first**" || created.Author != "alice" || created.AuthorId != alice || created.TaskId != task ||
+				created.ParentId != nil || created.EditedAt != nil || created.Deleted || !reflect.DeepEqual(created.Mentions, []int{bob}) {
+				t.Fatalf("got comment %+v", created)
+			}
+			first := created.Id
+			reply := s.createComment(t, id, task, "bob", &first)
+			second := s.createComment(t, id, task, "alice", nil)
+			s.createComment(t, id, sibling, "alice", nil)
+
+			// A reply answers a comment of the same task
+			_, err = s.Comments.CreateComment(&domain.CommentRequest{Body: "x", ParentId: &first, ProjectId: id, TaskId: sibling, UserCognitoId: "bob"})
+			mustFailWith(t, err, domain.ErrInvalidReply)
+			_, err = s.Comments.CreateComment(&domain.CommentRequest{Body: "x", ProjectId: id, TaskId: elsewhere, UserCognitoId: "bob"})
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+
+			comments, err := s.Comments.GetComments(id, task)
+			mustNotFail(t, err)
+			if ids := commentIds(comments); !reflect.DeepEqual(ids, []int{first, reply, second}) {
+				t.Fatalf("got comments %v, want %v in the order they were written", ids, []int{first, reply, second})
+			}
+			if comments[1].ParentId == nil || *comments[1].ParentId != first || comments[1].Author != "bob" {
+				t.Fatalf("got reply %+v", comments[1])
+			}
+			comment, err := s.Comments.GetComment(id, task, first)
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(comment, created) {
+				t.Fatalf("create returned %+v, stored %+v", created, comment)
+			}
+			_, err = s.Comments.GetComments(id, elsewhere)
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			_, err = s.Comments.GetComment(id, sibling, first)
+			mustFailWith(t, err, domain.ErrCommentNotFound)
+			_, err = s.Comments.GetComment(other, task, first)
+			mustFailWith(t, err, domain.ErrCommentNotFound)
+		}},
+		{"EditHistory", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			carol := s.newUser(t, "carol")
+			id := s.createProject(t, "alice", "p", nil)
+			task := s.createTask(t, id, "t", nil)
+			created, err := s.Comments.CreateComment(&domain.CommentRequest{Body: "v1", ProjectId: id, TaskId: task, UserCognitoId: "alice", Mentions: []int{bob}})
+			mustNotFail(t, err)
+
+			edited, err := s.Comments.UpdateComment(created.Id, &domain.CommentRequest{Body: "v2", ProjectId: id, TaskId: task, UserCognitoId: "alice", Mentions: []int{bob, carol}})
+			mustNotFail(t, err)
+			if edited.Body != "v2" || edited.EditedAt == nil || !edited.CreatedAt.Equal(created.CreatedAt) || !reflect.DeepEqual(edited.Mentions, []int{bob, carol}) {
+				t.Fatalf("got edited comment %+v", edited)
+			}
+			_, err = s.Comments.UpdateComment(created.Id, &domain.CommentRequest{Body: "v3", ProjectId: id, TaskId: task, UserCognitoId: "alice"})
+			mustNotFail(t, err)
+			// Saving the same body again is not recorded
+			unchanged, err := s.Comments.UpdateComment(created.Id, &domain.CommentRequest{Body: "v3", ProjectId: id, TaskId: task, UserCognitoId: "alice"})
+			mustNotFail(t, err)
+			if len(unchanged.Mentions) != 0 {
+				t.Fatalf("mentions %v were kept after being removed from the body", unchanged.Mentions)
+			}
+
+			edits, err := s.Comments.GetCommentEdits(id, task, created.Id)
+			mustNotFail(t, err)
+			if len(edits) != 2 || edits[0].Body != "v1" || edits[1].Body != "v2" {
+				t.Fatalf("got edits %+v, want v1 then v2", edits)
+			}
+			if !edits[0].WrittenAt.Equal(created.CreatedAt) || !edits[1].WrittenAt.Equal(*edited.EditedAt) || edits[0].ReplacedAt.Before(edits[0].WrittenAt) {
+				t.Fatalf("got edits %+v of a comment created at %v and edited at %v", edits, created.CreatedAt, *edited.EditedAt)
+			}
+
+			_, err = s.Comments.UpdateComment(created.Id+1, &domain.CommentRequest{Body: "x", ProjectId: id, TaskId: task, UserCognitoId: "alice"})
+			mustFailWith(t, err, domain.ErrCommentNotFound)
+			_, err = s.Comments.GetCommentEdits(id, task+1, created.Id)
+			mustFailWith(t, err, domain.ErrCommentNotFound)
+		}},
+		{"DeleteComment", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			id := s.createProject(t, "alice", "p", nil)
+			task := s.createTask(t, id, "t", nil)
+			created, err := s.Comments.CreateComment(&domain.CommentRequest{Body: "v1", ProjectId: id, TaskId: task, UserCognitoId: "alice", Mentions: []int{bob}})
+			mustNotFail(t, err)
+			parent := created.Id
+			_, err = s.Comments.UpdateComment(parent, &domain.CommentRequest{Body: "v2", ProjectId: id, TaskId: task, UserCognitoId: "alice", Mentions: []int{bob}})
+			mustNotFail(t, err)
+			reply := s.createComment(t, id, task, "alice", &parent)
+			alone := s.createComment(t, id, task, "alice", nil)
+
+			// A comment with replies is kept without its content
+			mustNotFail(t, s.Comments.DeleteComment(id, task, parent))
+			comment, err := s.Comments.GetComment(id, task, parent)
+			mustNotFail(t, err)
+			if !comment.Deleted || comment.Body != "" || len(comment.Mentions) != 0 {
+				t.Fatalf("got deleted comment %+v", comment)
+			}
+			edits, err := s.Comments.GetCommentEdits(id, task, parent)
+			mustNotFail(t, err)
+			if len(edits) != 0 {
+				t.Fatalf("deleted comment kept its history %+v", edits)
+			}
+			mentions, err := s.Comments.GetMentions("bob", nil)
+			mustNotFail(t, err)
+			if len(mentions) != 0 {
+				t.Fatalf("deleted comment kept its mentions %+v", mentions)
+			}
+			mustFailWith(t, s.Comments.DeleteComment(id, task, parent), domain.ErrCommentNotFound)
+			_, err = s.Comments.UpdateComment(parent, &domain.CommentRequest{Body: "v3", ProjectId: id, TaskId: task, UserCognitoId: "alice"})
+			mustFailWith(t, err, domain.ErrCommentNotFound)
+
+			mustNotFail(t, s.Comments.DeleteComment(id, task, alone))
+			_, err = s.Comments.GetComment(id, task, alone)
+			mustFailWith(t, err, domain.ErrCommentNotFound)
+
+			// Deleting the task deletes its comments
+			mustNotFail(t, s.Tasks.DeleteTask(id, strconv.Itoa(task), 0))
+			_, err = s.Comments.GetComment(id, task, reply)
+			mustFailWith(t, err, domain.ErrCommentNotFound)
+		}},
+		{"Mentions", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			mustNotFail(t, s.Projects.ShareProject(strconv.Itoa(id), bob, domain.RoleViewer))
+			mustNotFail(t, s.Projects.ShareProject(strconv.Itoa(other), bob, domain.RoleViewer))
+			task := s.createTask(t, id, "t", nil)
+			otherTask := s.createTask(t, other, "t", nil)
+
+			first, err := s.Comments.CreateComment(&domain.CommentRequest{Body: "@bob", ProjectId: id, TaskId: task, UserCognitoId: "alice", Mentions: []int{bob}})
+			mustNotFail(t, err)
+			second, err := s.Comments.CreateComment(&domain.CommentRequest{Body: "@bob", ProjectId: other, TaskId: otherTask, UserCognitoId: "alice", Mentions: []int{bob}})
+			mustNotFail(t, err)
+			s.createComment(t, id, task, "alice", nil)
+
+			mentions, err := s.Comments.GetMentions("bob", nil)
+			mustNotFail(t, err)
+			want := []domain.Mention{
+				{CommentId: second.Id, TaskId: otherTask, ProjectId: other, Author: "alice", CreatedAt: second.CreatedAt},
+				{CommentId: first.Id, TaskId: task, ProjectId: id, Author: "alice", CreatedAt: first.CreatedAt},
+			}
+			if !sameMentions(mentions, want) {
+				t.Fatalf("got mentions %+v, want %+v", mentions, want)
+			}
+			mentions, err = s.Comments.GetMentions("bob", &first.CreatedAt)
+			mustNotFail(t, err)
+			if !sameMentions(mentions, want[:1]) {
+				t.Fatalf("got mentions %+v since %v, want %+v", mentions, first.CreatedAt, want[:1])
+			}
+
+			// Editing the comment keeps the time of the mention
+			_, err = s.Comments.UpdateComment(first.Id, &domain.CommentRequest{Body: "@bob again", ProjectId: id, TaskId: task, UserCognitoId: "alice", Mentions: []int{bob}})
+			mustNotFail(t, err)
+			mentions, err = s.Comments.GetMentions("bob", &second.CreatedAt)
+			mustNotFail(t, err)
+			if len(mentions) != 0 {
+				t.Fatalf("got mentions %+v after editing an old comment", mentions)
+			}
+
+			// Mentions in projects the user lost access to are not returned
+			mustNotFail(t, s.Projects.RemoveShare(strconv.Itoa(other), bob))
+			mentions, err = s.Comments.GetMentions("bob", nil)
+			mustNotFail(t, err)
+			if !sameMentions(mentions, want[1:]) {
+				t.Fatalf("got mentions %+v after losing access, want %+v", mentions, want[1:])
+			}
+		}},
+	})
+}
+
+func commentIds(comments []domain.Comment) []int {
+	var ids []int
+	for _, comment := range comments {
+		ids = append(ids, comment.Id)
+	}
+	return ids
+}
+
+// Times are compared with Equal, the backends do not keep the same location
+func sameMentions(got, want []domain.Mention) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !got[i].CreatedAt.Equal(want[i].CreatedAt) {
+			return false
+		}
+		got[i].CreatedAt = want[i].CreatedAt
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func (s Stores) createComment(t *testing.T, projectId, taskId int, author string, parentId *int) int {
+	t.Helper()
+	comment, err := s.Comments.CreateComment(&domain.CommentRequest{Body: "comment", ParentId: parentId, ProjectId: projectId, TaskId: taskId, UserCognitoId: author})
+	mustNotFail(t, err)
+	return comment.Id
+}
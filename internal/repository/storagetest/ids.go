@@ -0,0 +1,38 @@
+package storagetest
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Ids taken from the path are only checked to be numbers, any id a table can hand out
+// must be looked up and not found rather than failing the query
+const largeId = 40000
+
+func runIdTests(t *testing.T, newStores Factory) {
+	runCases(t, newStores, []testCase{
+		{"LookupIdsAboveSmallint", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			projectId := s.createProject(t, "alice", "p", nil)
+			taskId := s.createTask(t, projectId, "t", nil)
+
+			_, err := s.Projects.GetProjectById(strconv.Itoa(largeId), "alice")
+			mustFailWith(t, err, domain.ErrProjectNotFound)
+			_, err = s.Tasks.GetTaskById(projectId, strconv.Itoa(largeId))
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			_, err = s.Tasks.GetTaskById(largeId, strconv.Itoa(taskId))
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			mustFailWith(t, s.Tasks.DeleteTask(projectId, strconv.Itoa(largeId), 0), domain.ErrTaskNotFound)
+			_, err = s.Labels.GetLabel(projectId, largeId)
+			mustFailWith(t, err, domain.ErrLabelNotFound)
+			_, err = s.Comments.GetComment(projectId, taskId, largeId)
+			mustFailWith(t, err, domain.ErrCommentNotFound)
+			_, err = s.Attachments.GetAttachment(projectId, largeId, 1)
+			mustFailWith(t, err, domain.ErrAttachmentNotFound)
+			_, err = s.Teams.GetTeam(largeId)
+			mustFailWith(t, err, domain.ErrTeamNotFound)
+		}},
+	})
+}
@@ -0,0 +1,183 @@
+package storagetest
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+func runLabelTests(t *testing.T, newStores Factory) {
+	runCases(t, newStores, []testCase{
+		{"LabelCatalog", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			frontend := s.createLabel(t, id, "Frontend")
+			bug := s.createLabel(t, id, "bug")
+			elsewhere := s.createLabel(t, other, "bug")
+
+			// Names are unique in a project regardless of their case
+			_, err := s.Labels.CreateLabel(&domain.LabelRequest{Name: "BUG", Color: domain.DefaultLabelColor, ProjectId: id})
+			mustFailWith(t, err, domain.ErrLabelExists)
+
+			labels, err := s.Labels.GetLabels(id)
+			mustNotFail(t, err)
+			if ids := labelIds(labels); !reflect.DeepEqual(ids, []int{bug, frontend}) {
+				t.Fatalf("got labels %v, want %v ordered by name", ids, []int{bug, frontend})
+			}
+			if labels[0].Name != "bug" || labels[0].Color != domain.DefaultLabelColor || labels[0].ProjectId != id {
+				t.Fatalf("got label %+v", labels[0])
+			}
+			_, err = s.Labels.GetLabel(id, elsewhere)
+			mustFailWith(t, err, domain.ErrLabelNotFound)
+
+			renamed, err := s.Labels.UpdateLabel(frontend, &domain.LabelRequest{Name: "UI", Color: "#1f6feb", ProjectId: id})
+			mustNotFail(t, err)
+			label, err := s.Labels.GetLabel(id, frontend)
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(renamed, label) || label.Name != "UI" || label.Color != "#1f6feb" {
+				t.Fatalf("update returned %+v, stored %+v", renamed, label)
+			}
+			// A label can change the case of its own name but not take the name of another one
+			_, err = s.Labels.UpdateLabel(frontend, &domain.LabelRequest{Name: "ui", Color: "#1f6feb", ProjectId: id})
+			mustNotFail(t, err)
+			_, err = s.Labels.UpdateLabel(frontend, &domain.LabelRequest{Name: "Bug", Color: "#1f6feb", ProjectId: id})
+			mustFailWith(t, err, domain.ErrLabelExists)
+			_, err = s.Labels.UpdateLabel(elsewhere, &domain.LabelRequest{Name: "x", Color: "#1f6feb", ProjectId: id})
+			mustFailWith(t, err, domain.ErrLabelNotFound)
+
+			// Deleting a project deletes its labels
+			mustNotFail(t, s.Projects.DeleteProject(strconv.Itoa(other), 0))
+			_, err = s.Labels.GetLabel(other, elsewhere)
+			mustFailWith(t, err, domain.ErrLabelNotFound)
+		}},
+		{"TaskLabels", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			bug := s.createLabel(t, id, "bug")
+			ui := s.createLabel(t, id, "ui")
+			elsewhere := s.createLabel(t, other, "bug")
+
+			// Labels are returned sorted and without duplicates
+			created, err := s.Tasks.CreateTask(&domain.CreateTaskRequest{Title: "both", Status: domain.Pending, ProjectId: id, Labels: []int{ui, bug, ui}})
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(created.Labels, []int{bug, ui}) {
+				t.Fatalf("created task has labels %v", created.Labels)
+			}
+			both := created.Id
+			onlyBug := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "bug", Status: domain.Pending, ProjectId: id, Labels: []int{bug}})
+			none := s.createTask(t, id, "none", nil)
+
+			_, err = s.Tasks.CreateTask(&domain.CreateTaskRequest{Title: "t", Status: domain.Pending, ProjectId: id, Labels: []int{elsewhere}})
+			mustFailWith(t, err, domain.ErrInvalidLabel)
+			_, err = s.Tasks.PatchTask(strconv.Itoa(none), &domain.PatchTaskRequest{
+				Labels:    domain.Optional[[]int]{Set: true, Value: []int{elsewhere}},
+				ProjectId: id,
+			})
+			mustFailWith(t, err, domain.ErrInvalidLabel)
+
+			check := func(filter domain.TaskFilter, want ...int) {
+				t.Helper()
+				tasks, err := s.Tasks.GetTasks(id, filter, domain.Page{})
+				mustNotFail(t, err)
+				if ids := taskIds(tasks); !reflect.DeepEqual(ids, want) {
+					t.Fatalf("filter %+v returned %v, want %v", filter, ids, want)
+				}
+			}
+			check(domain.TaskFilter{Labels: []int{bug}}, both, onlyBug)
+			check(domain.TaskFilter{Labels: []int{bug, ui}}, both, onlyBug)
+			check(domain.TaskFilter{Labels: []int{bug, ui}, AllLabels: true}, both)
+			check(domain.TaskFilter{Labels: []int{elsewhere}})
+
+			patched, err := s.Tasks.PatchTask(strconv.Itoa(none), &domain.PatchTaskRequest{
+				Labels:    domain.Optional[[]int]{Set: true, Value: []int{ui}},
+				ProjectId: id,
+			})
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(patched.Labels, []int{ui}) {
+				t.Fatalf("patched task has labels %v", patched.Labels)
+			}
+			check(domain.TaskFilter{Labels: []int{ui}}, both, none)
+			label, err := s.Labels.GetLabel(id, ui)
+			mustNotFail(t, err)
+			if label.Tasks != 2 {
+				t.Fatalf("label %+v counts %d tasks, want 2", label, label.Tasks)
+			}
+		}},
+		{"DeleteLabel", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			bug := s.createLabel(t, id, "bug")
+			ui := s.createLabel(t, id, "ui")
+			tagged := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "t", Status: domain.Pending, ProjectId: id, Labels: []int{bug, ui}})
+			before := s.getTask(t, id, tagged)
+
+			mustFailWith(t, s.Labels.DeleteLabel(id, ui+1), domain.ErrLabelNotFound)
+			mustNotFail(t, s.Labels.DeleteLabel(id, bug))
+			_, err := s.Labels.GetLabel(id, bug)
+			mustFailWith(t, err, domain.ErrLabelNotFound)
+			task := s.getTask(t, id, tagged)
+			if !reflect.DeepEqual(task.Labels, []int{ui}) || task.Version <= before.Version {
+				t.Fatalf("got task %+v after deleting a label, was %+v", task, before)
+			}
+		}},
+		{"MergeLabel", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			bug := s.createLabel(t, id, "bug")
+			defect := s.createLabel(t, id, "defect")
+			ui := s.createLabel(t, id, "ui")
+			elsewhere := s.createLabel(t, other, "bug")
+			onlyDefect := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "defect", Status: domain.Pending, ProjectId: id, Labels: []int{defect, ui}})
+			both := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "both", Status: domain.Pending, ProjectId: id, Labels: []int{bug, defect}})
+			onlyBug := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "bug", Status: domain.Pending, ProjectId: id, Labels: []int{bug}})
+			untouched := s.getTask(t, id, onlyBug)
+
+			_, err := s.Labels.MergeLabel(id, defect, elsewhere)
+			mustFailWith(t, err, domain.ErrLabelNotFound)
+
+			merged, err := s.Labels.MergeLabel(id, defect, bug)
+			mustNotFail(t, err)
+			if merged.Id != bug || merged.Tasks != 3 {
+				t.Fatalf("merge returned %+v, want label %d on 3 tasks", merged, bug)
+			}
+			_, err = s.Labels.GetLabel(id, defect)
+			mustFailWith(t, err, domain.ErrLabelNotFound)
+			for taskId, want := range map[int][]int{onlyDefect: {bug, ui}, both: {bug}, onlyBug: {bug}} {
+				if task := s.getTask(t, id, taskId); !reflect.DeepEqual(task.Labels, want) {
+					t.Fatalf("task %d has labels %v after the merge, want %v", taskId, task.Labels, want)
+				}
+			}
+			// Only the tasks whose labels changed get a new version
+			if task := s.getTask(t, id, onlyBug); task.Version != untouched.Version {
+				t.Fatalf("task without the merged label went from version %d to %d", untouched.Version, task.Version)
+			}
+		}},
+	})
+}
+
+func labelIds(labels []domain.Label) []int {
+	var ids []int
+	for _, label := range labels {
+		ids = append(ids, label.Id)
+	}
+	return ids
+}
+
+func (s Stores) createLabel(t *testing.T, projectId int, name string) int {
+	t.Helper()
+	label, err := s.Labels.CreateLabel(&domain.LabelRequest{Name: name, Color: domain.DefaultLabelColor, ProjectId: projectId})
+	mustNotFail(t, err)
+	return label.Id
+}
+
+func (s Stores) getTask(t *testing.T, projectId, taskId int) domain.Task {
+	t.Helper()
+	task, err := s.Tasks.GetTaskById(projectId, strconv.Itoa(taskId))
+	mustNotFail(t, err)
+	return task
+}
@@ -0,0 +1,340 @@
+package storagetest
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+func runProjectTests(t *testing.T, newStores Factory) {
+	runCases(t, newStores, []testCase{
+		{"CreateProjectUnknownUser", func(t *testing.T, s Stores) {
+			_, err := s.Projects.CreateProject(&domain.CreateProjectRequest{Title: "p", Priority: domain.Low, UserCognitoId: "unknown"})
+			mustFailWith(t, err, domain.ErrUserNotFound)
+		}},
+		{"CreateProjectReturnsProject", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			created, err := s.Projects.CreateProject(&domain.CreateProjectRequest{Title: "p", Description: "d", Priority: domain.Medium, UserCognitoId: "alice"})
+			mustNotFail(t, err)
+			project, err := s.Projects.GetProjectById(strconv.Itoa(created.Id), "alice")
+			mustNotFail(t, err)
+			if created.Id == 0 || created.CreatedAt.IsZero() || created.Role != domain.RoleOwner {
+				t.Fatalf("create returned %+v", created)
+			}
+			if created.Title != project.Title || created.Description != project.Description || created.Priority != project.Priority ||
+				!created.CreatedAt.Equal(project.CreatedAt) {
+				t.Fatalf("create returned %+v, stored %+v", created, project)
+			}
+		}},
+		{"GetProjectsScopedToOwner", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newUser(t, "bob")
+			first := s.createProject(t, "alice", "first", nil)
+			second := s.createProject(t, "alice", "second", nil)
+			s.createProject(t, "bob", "other", nil)
+
+			projects, err := s.Projects.GetProjects("alice", domain.ProjectFilter{}, domain.Page{})
+			mustNotFail(t, err)
+			if len(projects) != 2 || projects[0].Id != first || projects[1].Id != second {
+				t.Fatalf("got projects %+v, want ids %d and %d in order", projects, first, second)
+			}
+			for _, p := range projects {
+				if p.Role != domain.RoleOwner {
+					t.Fatalf("owner got role %q on project %d", p.Role, p.Id)
+				}
+			}
+			projects, err = s.Projects.GetProjects("unknown", domain.ProjectFilter{}, domain.Page{})
+			mustNotFail(t, err)
+			if len(projects) != 0 {
+				t.Fatalf("unknown user got projects %+v", projects)
+			}
+		}},
+		{"ProjectFilters", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			first := s.createProject(t, "alice", "first", nil)
+			high, err := s.Projects.CreateProject(&domain.CreateProjectRequest{Title: "high", Priority: domain.High, UserCognitoId: "alice"})
+			mustNotFail(t, err)
+			last := s.createProject(t, "alice", "last", nil)
+
+			check := func(filter domain.ProjectFilter, want ...int) {
+				t.Helper()
+				projects, err := s.Projects.GetProjects("alice", filter, domain.Page{})
+				mustNotFail(t, err)
+				var ids []int
+				for _, p := range projects {
+					ids = append(ids, p.Id)
+				}
+				if !reflect.DeepEqual(ids, want) {
+					t.Fatalf("filter %+v returned %v, want %v", filter, ids, want)
+				}
+			}
+			check(domain.ProjectFilter{Priority: domain.Low}, first, last)
+			check(domain.ProjectFilter{Priority: domain.High}, high.Id)
+			check(domain.ProjectFilter{CreatedAfter: &high.CreatedAt}, last)
+			check(domain.ProjectFilter{CreatedBefore: &high.CreatedAt}, first)
+		}},
+		{"ProjectPages", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newUser(t, "bob")
+			create := func(title string, priority domain.Priority) int {
+				project, err := s.Projects.CreateProject(&domain.CreateProjectRequest{Title: title, Priority: priority, UserCognitoId: "alice"})
+				mustNotFail(t, err)
+				return project.Id
+			}
+			beta := create("beta", domain.Low)
+			alpha := create("alpha", domain.High)
+			gamma := create("Gamma", domain.Medium)
+			alpha2 := create("alpha", domain.Low)
+			s.createProject(t, "bob", "hidden", nil)
+
+			// Priorities come in the order of domain.Priorities
+			for _, c := range []struct {
+				sort domain.SortField
+				want []int
+			}{
+				{domain.SortCreatedAt, []int{beta, alpha, gamma, alpha2}},
+				{domain.SortTitle, []int{gamma, alpha, alpha2, beta}},
+				{domain.SortPriority, []int{alpha, gamma, beta, alpha2}},
+			} {
+				for _, desc := range []bool{false, true} {
+					want := c.want
+					if desc {
+						want = reversed(want)
+					}
+					page := domain.Page{Limit: 3, Sort: c.sort, Desc: desc}
+					ids := pageThrough(t, page, func(page domain.Page) ([]domain.Project, error) {
+						return s.Projects.GetProjects("alice", domain.ProjectFilter{}, page)
+					}, func(p domain.Project) int { return p.Id })
+					if !reflect.DeepEqual(ids, want) {
+						t.Fatalf("sort %s desc %v returned %v, want %v", c.sort, desc, ids, want)
+					}
+				}
+			}
+		}},
+		{"GetProjectById", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+
+			project, err := s.Projects.GetProjectById(strconv.Itoa(id), "alice")
+			mustNotFail(t, err)
+			if project.Id != id || project.Title != "p" || project.Priority != domain.Low || project.Role != domain.RoleOwner || project.TeamId != nil {
+				t.Fatalf("got project %+v", project)
+			}
+			if project.CreatedAt.IsZero() {
+				t.Fatal("createdAt not set")
+			}
+		}},
+		{"GetProjectByIdNotFound", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newUser(t, "bob")
+			id := s.createProject(t, "alice", "p", nil)
+
+			_, err := s.Projects.GetProjectById(strconv.Itoa(id+1), "alice")
+			mustFailWith(t, err, domain.ErrProjectNotFound)
+			// Projects of other users are not disclosed
+			_, err = s.Projects.GetProjectById(strconv.Itoa(id), "bob")
+			mustFailWith(t, err, domain.ErrProjectNotFound)
+		}},
+		{"GetProjectRole", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newUser(t, "bob")
+			id := s.createProject(t, "alice", "p", nil)
+
+			role, err := s.Projects.GetProjectRole(strconv.Itoa(id), "alice")
+			mustNotFail(t, err)
+			if role != domain.RoleOwner {
+				t.Fatalf("owner got role %q", role)
+			}
+			_, err = s.Projects.GetProjectRole(strconv.Itoa(id), "bob")
+			mustFailWith(t, err, domain.ErrForbidden)
+			_, err = s.Projects.GetProjectRole(strconv.Itoa(id+1), "alice")
+			mustFailWith(t, err, domain.ErrProjectNotFound)
+		}},
+		{"TeamProjectRoles", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			s.newUser(t, "carol")
+			teamId := s.createTeam(t, "alice", "team")
+			mustNotFail(t, s.Teams.AddMember(teamId, bob, domain.RoleViewer))
+			id := s.createProject(t, "alice", "p", &teamId)
+
+			project, err := s.Projects.GetProjectById(strconv.Itoa(id), "bob")
+			mustNotFail(t, err)
+			if project.Role != domain.RoleViewer || project.TeamId == nil || *project.TeamId != teamId {
+				t.Fatalf("team member got project %+v", project)
+			}
+			// A share with a higher role than the team role wins
+			mustNotFail(t, s.Projects.ShareProject(strconv.Itoa(id), bob, domain.RoleAdmin))
+			role, err := s.Projects.GetProjectRole(strconv.Itoa(id), "bob")
+			mustNotFail(t, err)
+			if role != domain.RoleAdmin {
+				t.Fatalf("got role %q, want the share role", role)
+			}
+			_, err = s.Projects.GetProjectRole(strconv.Itoa(id), "carol")
+			mustFailWith(t, err, domain.ErrForbidden)
+			projects, err := s.Projects.GetProjects("carol", domain.ProjectFilter{}, domain.Page{})
+			mustNotFail(t, err)
+			if len(projects) != 0 {
+				t.Fatalf("outsider got projects %+v", projects)
+			}
+		}},
+		{"UpdateProject", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+
+			updated, err := s.Projects.UpdateProject(strconv.Itoa(id), &domain.CreateProjectRequest{Title: "new", Description: "d", Priority: domain.High, UserCognitoId: "alice"})
+			mustNotFail(t, err)
+			if updated.Id != id || updated.Title != "new" || updated.Role != domain.RoleOwner {
+				t.Fatalf("update returned %+v", updated)
+			}
+			project, err := s.Projects.GetProjectById(strconv.Itoa(id), "alice")
+			mustNotFail(t, err)
+			if project.Title != "new" || project.Description != "d" || project.Priority != domain.High {
+				t.Fatalf("got project %+v after update", project)
+			}
+			_, err = s.Projects.UpdateProject(strconv.Itoa(id+1), &domain.CreateProjectRequest{Title: "new", Priority: domain.High, UserCognitoId: "alice"})
+			mustFailWith(t, err, domain.ErrProjectNotFound)
+		}},
+		{"PatchProject", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			_, err := s.Projects.UpdateProject(strconv.Itoa(id), &domain.CreateProjectRequest{Title: "p", Description: "kept", Priority: domain.Low, UserCognitoId: "alice"})
+			mustNotFail(t, err)
+
+			patched, err := s.Projects.PatchProject(strconv.Itoa(id), &domain.PatchProjectRequest{
+				Priority:      domain.Optional[domain.Priority]{Set: true, Value: domain.High},
+				UserCognitoId: "alice",
+			})
+			mustNotFail(t, err)
+			if patched.Title != "p" || patched.Description != "kept" || patched.Priority != domain.High || patched.Role != domain.RoleOwner {
+				t.Fatalf("patch returned %+v, want only the priority changed", patched)
+			}
+			// An empty patch only reads the project back
+			patched, err = s.Projects.PatchProject(strconv.Itoa(id), &domain.PatchProjectRequest{UserCognitoId: "alice"})
+			mustNotFail(t, err)
+			if patched.Priority != domain.High {
+				t.Fatalf("empty patch returned %+v", patched)
+			}
+			_, err = s.Projects.PatchProject(strconv.Itoa(id+1), &domain.PatchProjectRequest{UserCognitoId: "alice"})
+			mustFailWith(t, err, domain.ErrProjectNotFound)
+		}},
+		{"ProjectVersions", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			created, err := s.Projects.CreateProject(&domain.CreateProjectRequest{Title: "p", Priority: domain.Low, UserCognitoId: "alice"})
+			mustNotFail(t, err)
+			if created.Version != 1 {
+				t.Fatalf("new project has version %d, want 1", created.Version)
+			}
+			id := strconv.Itoa(created.Id)
+
+			updated, err := s.Projects.UpdateProject(id, &domain.CreateProjectRequest{Title: "new", Priority: domain.Low, UserCognitoId: "alice", Version: 1})
+			mustNotFail(t, err)
+			if updated.Version != 2 {
+				t.Fatalf("updated project has version %d, want 2", updated.Version)
+			}
+			patched, err := s.Projects.PatchProject(id, &domain.PatchProjectRequest{
+				Title:         domain.Optional[string]{Set: true, Value: "patched"},
+				UserCognitoId: "alice",
+			})
+			mustNotFail(t, err)
+			if patched.Version != 3 {
+				t.Fatalf("patched project has version %d, want 3", patched.Version)
+			}
+			// Nothing is written so the version is kept
+			patched, err = s.Projects.PatchProject(id, &domain.PatchProjectRequest{UserCognitoId: "alice", Version: 3})
+			mustNotFail(t, err)
+			if patched.Version != 3 {
+				t.Fatalf("empty patch changed the version to %d", patched.Version)
+			}
+
+			_, err = s.Projects.UpdateProject(id, &domain.CreateProjectRequest{Title: "stale", Priority: domain.Low, UserCognitoId: "alice", Version: 2})
+			mustFailWith(t, err, domain.ErrVersionMismatch)
+			_, err = s.Projects.PatchProject(id, &domain.PatchProjectRequest{
+				Title:         domain.Optional[string]{Set: true, Value: "stale"},
+				UserCognitoId: "alice",
+				Version:       2,
+			})
+			mustFailWith(t, err, domain.ErrVersionMismatch)
+			mustFailWith(t, s.Projects.DeleteProject(id, 2), domain.ErrVersionMismatch)
+			project, err := s.Projects.GetProjectById(id, "alice")
+			mustNotFail(t, err)
+			if project.Title != "patched" || project.Version != 3 {
+				t.Fatalf("got project %+v after the stale writes", project)
+			}
+			mustNotFail(t, s.Projects.DeleteProject(id, 3))
+		}},
+		{"DeleteProjectCascades", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			taskId := s.createTask(t, id, "t", nil)
+			otherTask := s.createTask(t, other, "kept", nil)
+			mustNotFail(t, s.Projects.ShareProject(strconv.Itoa(id), bob, domain.RoleMember))
+
+			mustNotFail(t, s.Projects.DeleteProject(strconv.Itoa(id), 0))
+			_, err := s.Projects.GetProjectById(strconv.Itoa(id), "alice")
+			mustFailWith(t, err, domain.ErrProjectNotFound)
+			_, err = s.Tasks.GetTaskById(id, strconv.Itoa(taskId))
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			tasks, err := s.Tasks.GetTasks(id, domain.TaskFilter{}, domain.Page{})
+			mustNotFail(t, err)
+			if len(tasks) != 0 {
+				t.Fatalf("tasks of the deleted project still listed: %+v", tasks)
+			}
+			projects, err := s.Projects.GetProjects("bob", domain.ProjectFilter{}, domain.Page{})
+			mustNotFail(t, err)
+			if len(projects) != 0 {
+				t.Fatalf("share of the deleted project still listed: %+v", projects)
+			}
+			// Other projects are untouched
+			_, err = s.Tasks.GetTaskById(other, strconv.Itoa(otherTask))
+			mustNotFail(t, err)
+
+			mustFailWith(t, s.Projects.DeleteProject(strconv.Itoa(id), 0), domain.ErrProjectNotFound)
+		}},
+		{"Shares", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			carol := s.newUser(t, "carol")
+			id := strconv.Itoa(s.createProject(t, "alice", "p", nil))
+
+			shares, err := s.Projects.GetShares(id)
+			mustNotFail(t, err)
+			if len(shares) != 0 {
+				t.Fatalf("new project has shares %+v", shares)
+			}
+			mustNotFail(t, s.Projects.ShareProject(id, bob, domain.RoleViewer))
+			mustNotFail(t, s.Projects.ShareProject(id, carol, domain.RoleMember))
+			// Sharing again updates the role and keeps the share position
+			mustNotFail(t, s.Projects.ShareProject(id, bob, domain.RoleAdmin))
+
+			shares, err = s.Projects.GetShares(id)
+			mustNotFail(t, err)
+			if len(shares) != 2 || shares[0].UserId != bob || shares[0].Role != domain.RoleAdmin || shares[0].CognitoId != "bob" ||
+				shares[1].UserId != carol || shares[1].Role != domain.RoleMember {
+				t.Fatalf("got shares %+v", shares)
+			}
+
+			mustFailWith(t, s.Projects.ShareProject(id, carol+100, domain.RoleViewer), domain.ErrUserNotFound)
+			mustNotFail(t, s.Projects.RemoveShare(id, bob))
+			mustFailWith(t, s.Projects.RemoveShare(id, bob), domain.ErrUserNotFound)
+			_, err = s.Projects.GetProjectRole(id, "bob")
+			mustFailWith(t, err, domain.ErrForbidden)
+		}},
+	})
+}
+
+func (s Stores) createProject(t *testing.T, owner, title string, teamId *int) int {
+	t.Helper()
+	return s.createProjectWith(t, &domain.CreateProjectRequest{Title: title, Priority: domain.Low, UserCognitoId: owner, TeamId: teamId})
+}
+
+func (s Stores) createProjectWith(t *testing.T, r *domain.CreateProjectRequest) int {
+	t.Helper()
+	project, err := s.Projects.CreateProject(r)
+	mustNotFail(t, err)
+	return project.Id
+}
@@ -0,0 +1,127 @@
+package storagetest
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+func runSearchTests(t *testing.T, newStores Factory) {
+	runCases(t, newStores, []testCase{
+		{"SearchScopedToAccess", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			s.newUser(t, "carol")
+			project := s.createProjectWith(t, &domain.CreateProjectRequest{Title: "Apollo", Description: "rocket design", Priority: domain.Low, UserCognitoId: "alice"})
+			task := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "Review the rocket engine", Status: domain.Pending, ProjectId: project})
+			hidden := s.createProjectWith(t, &domain.CreateProjectRequest{Title: "Secret rocket", Priority: domain.Low, UserCognitoId: "carol"})
+			s.createTask(t, hidden, "rocket fuel", nil)
+
+			results := s.search(t, "alice", "rocket")
+			if got := resultKeys(results); !reflect.DeepEqual(got, []string{"project:" + strconv.Itoa(project), "task:" + strconv.Itoa(task)}) {
+				t.Fatalf("got results %v", got)
+			}
+			for _, r := range results {
+				if r.ProjectId != project {
+					t.Fatalf("result %+v has project %d, want %d", r, r.ProjectId, project)
+				}
+			}
+			// Shared projects are searched too
+			mustNotFail(t, s.Projects.ShareProject(strconv.Itoa(hidden), bob, domain.RoleViewer))
+			if got := s.search(t, "bob", "fuel"); len(got) != 1 || got[0].Type != domain.SearchTask || got[0].ProjectId != hidden {
+				t.Fatalf("shared project search returned %+v", got)
+			}
+			if got := s.search(t, "unknown", "rocket"); len(got) != 0 {
+				t.Fatalf("unknown user got results %+v", got)
+			}
+		}},
+		{"SearchRanksTitlesFirst", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			inDescription := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "Quarterly numbers", Description: "check the budget", Status: domain.Pending, ProjectId: id})
+			inTitle := s.createTask(t, id, "Budget review", nil)
+
+			results := s.search(t, "alice", "budget")
+			if len(results) != 2 || results[0].Id != inTitle || results[1].Id != inDescription || results[0].Rank <= results[1].Rank {
+				t.Fatalf("got results %+v, want the title match first", results)
+			}
+			if !strings.Contains(results[1].Snippet, domain.SnippetStart+"budget"+domain.SnippetStop) {
+				t.Fatalf("snippet %q does not mark the match", results[1].Snippet)
+			}
+			if got := s.searchLimit(t, "alice", "budget", 1); len(got) != 1 || got[0].Id != inTitle {
+				t.Fatalf("limited search returned %+v", got)
+			}
+		}},
+		{"SearchSyntax", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			engine := s.createTask(t, id, "rocket engine", nil)
+			fuel := s.createTask(t, id, "rocket fuel", nil)
+			reversed := s.createTask(t, id, "engine of the rocket", nil)
+
+			check := func(text string, want ...int) {
+				t.Helper()
+				var ids []int
+				for _, r := range s.search(t, "alice", text) {
+					ids = append(ids, r.Id)
+				}
+				if !reflect.DeepEqual(sorted(ids...), sorted(want...)) {
+					t.Fatalf("search %q returned %v, want %v", text, ids, want)
+				}
+			}
+			check("rocket engine", engine, reversed)
+			check(`"rocket engine"`, engine)
+			check("rocket -engine", fuel)
+			check("fuel or engine", engine, fuel, reversed)
+			check("ROCKETS", engine, fuel, reversed)
+			check("moon")
+		}},
+		{"SearchFollowsWrites", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			task := s.createTask(t, id, "draft", nil)
+
+			_, err := s.Tasks.PatchTask(strconv.Itoa(task), &domain.PatchTaskRequest{
+				Title:     domain.Optional[string]{Set: true, Value: "final"},
+				ProjectId: id,
+			})
+			mustNotFail(t, err)
+			if got := s.search(t, "alice", "draft"); len(got) != 0 {
+				t.Fatalf("old title still found: %+v", got)
+			}
+			if got := s.search(t, "alice", "final"); len(got) != 1 || got[0].Id != task {
+				t.Fatalf("new title not found: %+v", got)
+			}
+			mustNotFail(t, s.Tasks.DeleteTask(id, strconv.Itoa(task), 0))
+			if got := s.search(t, "alice", "final"); len(got) != 0 {
+				t.Fatalf("deleted task still found: %+v", got)
+			}
+		}},
+	})
+}
+
+func (s Stores) search(t *testing.T, cognitoId, text string) []domain.SearchResult {
+	t.Helper()
+	return s.searchLimit(t, cognitoId, text, domain.DefaultPageLimit)
+}
+
+func (s Stores) searchLimit(t *testing.T, cognitoId, text string, limit int) []domain.SearchResult {
+	t.Helper()
+	results, err := s.Search.Search(cognitoId, domain.SearchQuery{Text: text, Limit: limit})
+	mustNotFail(t, err)
+	return results
+}
+
+// Type and id of the results, sorted so results of equal rank can be compared
+func resultKeys(results []domain.SearchResult) []string {
+	var keys []string
+	for _, r := range results {
+		keys = append(keys, string(r.Type)+":"+strconv.Itoa(r.Id))
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+// Package storagetest is a contract test suite for the storage interfaces of the domain package.
+// Every backend runs it from its own tests so they all keep the same semantics:
+// not found errors, access scoping, cascade deletes, ordering, search, labels, comments, attachments and access tokens.
+// The blob stores have their own suite run by RunBlobs.
+package storagetest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Stores under test, they must share the same underlying data
+type Stores struct {
+	Users       domain.UserStorage
+	Projects    domain.ProjectStorage
+	Tasks       domain.TaskStorage
+	Teams       domain.TeamStorage
+	Search      domain.SearchStorage
+	Labels      domain.LabelStorage
+	Comments    domain.CommentStorage
+	Attachments domain.AttachmentStorage
+	Tokens      domain.AccessTokenStorage
+	// Resolves the id of a user created through Users, the storage interfaces do not expose it
+	UserId func(t *testing.T, cognitoId string) int
+}
+
+// Factory returns stores backed by empty storage, it is called once per test
+type Factory func(t *testing.T) Stores
+
+// Run runs the whole contract suite against the backend built by newStores
+func Run(t *testing.T, newStores Factory) {
+	t.Run("Users", func(t *testing.T) { runUserTests(t, newStores) })
+	t.Run("Projects", func(t *testing.T) { runProjectTests(t, newStores) })
+	t.Run("Tasks", func(t *testing.T) { runTaskTests(t, newStores) })
+	t.Run("Teams", func(t *testing.T) { runTeamTests(t, newStores) })
+	t.Run("Search", func(t *testing.T) { runSearchTests(t, newStores) })
+	t.Run("Labels", func(t *testing.T) { runLabelTests(t, newStores) })
+	t.Run("Comments", func(t *testing.T) { runCommentTests(t, newStores) })
+	t.Run("Attachments", func(t *testing.T) { runAttachmentTests(t, newStores) })
+	t.Run("Tokens", func(t *testing.T) { runTokenTests(t, newStores) })
+	t.Run("Ids", func(t *testing.T) { runIdTests(t, newStores) })
+}
+
+type testCase struct {
+	name string
+	fn   func(t *testing.T, s Stores)
+}
+
+func runCases(t *testing.T, newStores Factory, cases []testCase) {
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.fn(t, newStores(t))
+		})
+	}
+}
+
+func (s Stores) newUser(t *testing.T, cognitoId string) int {
+	t.Helper()
+	if err := s.Users.CreateUser(cognitoId); err != nil {
+		t.Fatalf("CreateUser(%q): %v", cognitoId, err)
+	}
+	return s.UserId(t, cognitoId)
+}
+
+func mustNotFail(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func mustFailWith(t *testing.T, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("got error %v, want %v", err, want)
+	}
+}
+
+// Lists every item by following the cursors of pages of page.Limit items, the last page is
+// the first one holding fewer items. Returns the ids in the order they were listed
+func pageThrough[T interface{ SortKey(domain.SortField) string }](t *testing.T, page domain.Page, list func(domain.Page) ([]T, error), id func(T) int) []int {
+	t.Helper()
+	var ids []int
+	for {
+		items, err := list(page)
+		mustNotFail(t, err)
+		for _, item := range items {
+			ids = append(ids, id(item))
+		}
+		if len(items) < page.Limit {
+			return ids
+		}
+		last := items[len(items)-1]
+		page.After = &domain.Cursor{Key: last.SortKey(page.Sort), Id: id(last)}
+	}
+}
+
+func reversed(ids []int) []int {
+	r := make([]int, len(ids))
+	for i, id := range ids {
+		r[len(ids)-1-i] = id
+	}
+	return r
+}
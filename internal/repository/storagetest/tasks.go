@@ -0,0 +1,568 @@
+package storagetest
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+func runTaskTests(t *testing.T, newStores Factory) {
+	runCases(t, newStores, []testCase{
+		{"GetProjectRole", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newUser(t, "bob")
+			id := s.createProject(t, "alice", "p", nil)
+
+			role, err := s.Tasks.GetProjectRole(id, "alice")
+			mustNotFail(t, err)
+			if role != domain.RoleOwner {
+				t.Fatalf("owner got role %q", role)
+			}
+			_, err = s.Tasks.GetProjectRole(id, "bob")
+			mustFailWith(t, err, domain.ErrForbidden)
+			_, err = s.Tasks.GetProjectRole(id+1, "alice")
+			mustFailWith(t, err, domain.ErrProjectNotFound)
+		}},
+		{"GetTasksScopedToProject", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			first := s.createTask(t, id, "first", nil)
+			s.createTask(t, other, "elsewhere", nil)
+			second := s.createTask(t, id, "second", nil)
+
+			tasks, err := s.Tasks.GetTasks(id, domain.TaskFilter{}, domain.Page{})
+			mustNotFail(t, err)
+			if len(tasks) != 2 || tasks[0].Id != first || tasks[1].Id != second {
+				t.Fatalf("got tasks %+v, want ids %d and %d in order", tasks, first, second)
+			}
+			if tasks[0].Title != "first" || tasks[0].Status != domain.Pending || tasks[0].ProjectId != id || tasks[0].CreatedAt.IsZero() {
+				t.Fatalf("got task %+v", tasks[0])
+			}
+		}},
+		{"CreateTaskReturnsTask", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			due := time.Now().Add(time.Hour).Truncate(time.Second)
+
+			created, err := s.Tasks.CreateTask(&domain.CreateTaskRequest{Title: "t", Description: "d", Status: domain.InProgress, ProjectId: id, Assignees: []int{alice}, DueDate: &due})
+			mustNotFail(t, err)
+			if created.Id == 0 || created.ProjectId != id || created.CreatedAt.IsZero() {
+				t.Fatalf("create returned %+v", created)
+			}
+			task, err := s.Tasks.GetTaskById(id, strconv.Itoa(created.Id))
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(normalized(created), normalized(task)) {
+				t.Fatalf("create returned %+v, stored %+v", created, task)
+			}
+		}},
+		{"GetTaskByIdNotFound", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			taskId := s.createTask(t, id, "t", nil)
+
+			_, err := s.Tasks.GetTaskById(id, strconv.Itoa(taskId+1))
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			// A task is only reachable through its own project
+			_, err = s.Tasks.GetTaskById(other, strconv.Itoa(taskId))
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			// Ids that are not numbers are not found rather than failing the query
+			_, err = s.Tasks.GetTaskById(id, "abc")
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			mustFailWith(t, s.Tasks.DeleteTask(id, "abc", 0), domain.ErrTaskNotFound)
+			_, err = s.Tasks.PatchTask("abc", &domain.PatchTaskRequest{ProjectId: id})
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+		}},
+		{"UpdateTask", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			taskId := s.createTask(t, id, "t", []int{alice})
+			due := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+
+			update := &domain.CreateTaskRequest{Title: "new", Description: "d", Status: domain.InProgress, ProjectId: id, DueDate: &due}
+			updated, err := s.Tasks.UpdateTask(strconv.Itoa(taskId), update)
+			mustNotFail(t, err)
+			task, err := s.Tasks.GetTaskById(id, strconv.Itoa(taskId))
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(normalized(updated), normalized(task)) {
+				t.Fatalf("update returned %+v, stored %+v", updated, task)
+			}
+			if task.Title != "new" || task.Description != "d" || task.Status != domain.InProgress ||
+				task.DueDate == nil || !task.DueDate.Equal(due) || task.StartDate != nil {
+				t.Fatalf("got task %+v after update", task)
+			}
+			// Updating replaces the assignees
+			if len(task.Assignees) != 0 {
+				t.Fatalf("assignees %v kept after an update without assignees", task.Assignees)
+			}
+
+			update.ProjectId = other
+			_, err = s.Tasks.UpdateTask(strconv.Itoa(taskId), update)
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			update.ProjectId = id
+			_, err = s.Tasks.UpdateTask(strconv.Itoa(taskId+1), update)
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+		}},
+		{"PatchTask", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			due := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+			taskId := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "t", Description: "kept", Status: domain.Pending, ProjectId: id, Assignees: []int{alice}, DueDate: &due})
+
+			patched, err := s.Tasks.PatchTask(strconv.Itoa(taskId), &domain.PatchTaskRequest{
+				Status:    domain.Optional[domain.Status]{Set: true, Value: domain.Done},
+				ProjectId: id,
+			})
+			mustNotFail(t, err)
+			if patched.Title != "t" || patched.Description != "kept" || patched.Status != domain.Done ||
+				!reflect.DeepEqual(patched.Assignees, []int{alice}) || patched.DueDate == nil || !patched.DueDate.Equal(due) {
+				t.Fatalf("patch returned %+v, want only the status changed", patched)
+			}
+
+			// A set field with a zero value clears it
+			patched, err = s.Tasks.PatchTask(strconv.Itoa(taskId), &domain.PatchTaskRequest{
+				Assignees: domain.Optional[[]int]{Set: true, Value: []int{bob}},
+				DueDate:   domain.Optional[*time.Time]{Set: true},
+				ProjectId: id,
+			})
+			mustNotFail(t, err)
+			task, err := s.Tasks.GetTaskById(id, strconv.Itoa(taskId))
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(normalized(patched), normalized(task)) {
+				t.Fatalf("patch returned %+v, stored %+v", patched, task)
+			}
+			if task.DueDate != nil || !reflect.DeepEqual(task.Assignees, []int{bob}) || task.Status != domain.Done {
+				t.Fatalf("got task %+v after patch", task)
+			}
+
+			_, err = s.Tasks.PatchTask(strconv.Itoa(taskId), &domain.PatchTaskRequest{ProjectId: other})
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			_, err = s.Tasks.PatchTask(strconv.Itoa(taskId+1), &domain.PatchTaskRequest{ProjectId: id})
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+		}},
+		{"DeleteTask", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			taskId := s.createTask(t, id, "t", nil)
+
+			mustFailWith(t, s.Tasks.DeleteTask(other, strconv.Itoa(taskId), 0), domain.ErrTaskNotFound)
+			mustNotFail(t, s.Tasks.DeleteTask(id, strconv.Itoa(taskId), 0))
+			_, err := s.Tasks.GetTaskById(id, strconv.Itoa(taskId))
+			mustFailWith(t, err, domain.ErrTaskNotFound)
+			mustFailWith(t, s.Tasks.DeleteTask(id, strconv.Itoa(taskId), 0), domain.ErrTaskNotFound)
+		}},
+		{"TaskVersions", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			created, err := s.Tasks.CreateTask(&domain.CreateTaskRequest{Title: "t", Status: domain.Pending, ProjectId: id})
+			mustNotFail(t, err)
+			if created.Version != 1 {
+				t.Fatalf("new task has version %d, want 1", created.Version)
+			}
+			taskId := strconv.Itoa(created.Id)
+
+			updated, err := s.Tasks.UpdateTask(taskId, &domain.CreateTaskRequest{Title: "new", Status: domain.Pending, ProjectId: id, Version: 1})
+			mustNotFail(t, err)
+			if updated.Version != 2 {
+				t.Fatalf("updated task has version %d, want 2", updated.Version)
+			}
+			// Changing only the assignees is a write too
+			patched, err := s.Tasks.PatchTask(taskId, &domain.PatchTaskRequest{
+				Assignees: domain.Optional[[]int]{Set: true, Value: []int{alice}},
+				ProjectId: id,
+			})
+			mustNotFail(t, err)
+			if patched.Version != 3 {
+				t.Fatalf("patched task has version %d, want 3", patched.Version)
+			}
+			patched, err = s.Tasks.PatchTask(taskId, &domain.PatchTaskRequest{ProjectId: id, Version: 3})
+			mustNotFail(t, err)
+			if patched.Version != 3 {
+				t.Fatalf("empty patch changed the version to %d", patched.Version)
+			}
+
+			_, err = s.Tasks.UpdateTask(taskId, &domain.CreateTaskRequest{Title: "stale", Status: domain.Done, ProjectId: id, Version: 2})
+			mustFailWith(t, err, domain.ErrVersionMismatch)
+			_, err = s.Tasks.PatchTask(taskId, &domain.PatchTaskRequest{
+				Status:    domain.Optional[domain.Status]{Set: true, Value: domain.Done},
+				ProjectId: id,
+				Version:   2,
+			})
+			mustFailWith(t, err, domain.ErrVersionMismatch)
+			mustFailWith(t, s.Tasks.DeleteTask(id, taskId, 2), domain.ErrVersionMismatch)
+			task, err := s.Tasks.GetTaskById(id, taskId)
+			mustNotFail(t, err)
+			if task.Title != "new" || task.Status != domain.Pending || task.Version != 3 {
+				t.Fatalf("got task %+v after the stale writes", task)
+			}
+			mustNotFail(t, s.Tasks.DeleteTask(id, taskId, 3))
+		}},
+		{"Assignees", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			id := s.createProject(t, "alice", "p", nil)
+			both := s.createTask(t, id, "both", []int{bob, alice})
+			s.createTask(t, id, "nobody", nil)
+
+			task, err := s.Tasks.GetTaskById(id, strconv.Itoa(both))
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(task.Assignees, sorted(alice, bob)) {
+				t.Fatalf("got assignees %v", task.Assignees)
+			}
+
+			tasks, err := s.Tasks.GetTasks(id, domain.TaskFilter{AssigneeId: bob}, domain.Page{})
+			mustNotFail(t, err)
+			if len(tasks) != 1 || tasks[0].Id != both {
+				t.Fatalf("assignee filter returned %+v", tasks)
+			}
+			tasks, err = s.Tasks.GetTasks(id, domain.TaskFilter{AssigneeCognitoId: "alice"}, domain.Page{})
+			mustNotFail(t, err)
+			if len(tasks) != 1 || tasks[0].Id != both {
+				t.Fatalf("assignee cognito filter returned %+v", tasks)
+			}
+		}},
+		{"GetProjectUsers", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			carol := s.newUser(t, "carol")
+			id := s.createProject(t, "alice", "p", nil)
+			mustNotFail(t, s.Projects.ShareProject(strconv.Itoa(id), bob, domain.RoleViewer))
+
+			users, err := s.Tasks.GetProjectUsers(id, []int{alice, bob, carol, carol + 100})
+			mustNotFail(t, err)
+			if !reflect.DeepEqual(sorted(users...), sorted(alice, bob)) {
+				t.Fatalf("got project users %v", users)
+			}
+		}},
+		{"GetAssignedTasks", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			first := s.createProject(t, "alice", "first", nil)
+			second := s.createProject(t, "alice", "second", nil)
+			hidden := s.createProject(t, "alice", "hidden", nil)
+			for _, id := range []int{first, second, hidden} {
+				mustNotFail(t, s.Projects.ShareProject(strconv.Itoa(id), bob, domain.RoleMember))
+			}
+			done := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "done", Status: domain.Done, ProjectId: second, Assignees: []int{bob}})
+			pending := s.createTask(t, second, "pending", []int{bob})
+			inFirst := s.createTask(t, first, "first", []int{bob})
+			s.createTask(t, first, "unassigned", nil)
+			s.createTask(t, hidden, "hidden", []int{bob})
+			// Assignments of projects the user lost access to are not listed
+			mustNotFail(t, s.Projects.RemoveShare(strconv.Itoa(hidden), bob))
+
+			tasks, err := s.Tasks.GetAssignedTasks("bob", domain.TaskFilter{})
+			mustNotFail(t, err)
+			var ids []int
+			for _, task := range tasks {
+				ids = append(ids, task.Id)
+			}
+			if want := []int{inFirst, pending, done}; !reflect.DeepEqual(ids, want) {
+				t.Fatalf("got assigned tasks %v, want %v ordered by project and status", ids, want)
+			}
+			if tasks[0].ProjectTitle != "first" || tasks[1].ProjectTitle != "second" {
+				t.Fatalf("got project titles %q and %q", tasks[0].ProjectTitle, tasks[1].ProjectTitle)
+			}
+
+			tasks, err = s.Tasks.GetAssignedTasks("unknown", domain.TaskFilter{})
+			mustNotFail(t, err)
+			if len(tasks) != 0 {
+				t.Fatalf("unknown user got assigned tasks %+v", tasks)
+			}
+		}},
+		{"DueDateFilters", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			now := time.Now()
+			past, future := now.Add(-48*time.Hour), now.Add(48*time.Hour)
+			late := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "late", Status: domain.Pending, ProjectId: id, DueDate: &past})
+			doneLate := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "done late", Status: domain.Done, ProjectId: id, DueDate: &past})
+			upcoming := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "upcoming", Status: domain.Pending, ProjectId: id, DueDate: &future})
+			undated := s.createTask(t, id, "undated", nil)
+
+			check := func(filter domain.TaskFilter, want ...int) {
+				t.Helper()
+				tasks, err := s.Tasks.GetTasks(id, filter, domain.Page{})
+				mustNotFail(t, err)
+				var ids []int
+				for _, task := range tasks {
+					ids = append(ids, task.Id)
+				}
+				if !reflect.DeepEqual(ids, want) {
+					t.Fatalf("filter %+v returned %v, want %v", filter, ids, want)
+				}
+			}
+			yes, no := true, false
+			check(domain.TaskFilter{Overdue: &yes}, late)
+			check(domain.TaskFilter{Overdue: &no}, doneLate, upcoming, undated)
+			check(domain.TaskFilter{DueAfter: &now}, upcoming)
+			check(domain.TaskFilter{DueBefore: &now}, late, doneLate)
+		}},
+		{"TaskFilters", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			first := s.createTask(t, id, "first", nil)
+			done := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "done", Status: domain.Done, ProjectId: id})
+			last := s.createTask(t, id, "last", nil)
+			created, err := s.Tasks.GetTaskById(id, strconv.Itoa(done))
+			mustNotFail(t, err)
+
+			check := func(filter domain.TaskFilter, want ...int) {
+				t.Helper()
+				tasks, err := s.Tasks.GetTasks(id, filter, domain.Page{})
+				mustNotFail(t, err)
+				if ids := taskIds(tasks); !reflect.DeepEqual(ids, want) {
+					t.Fatalf("filter %+v returned %v, want %v", filter, ids, want)
+				}
+			}
+			check(domain.TaskFilter{Status: domain.Pending}, first, last)
+			check(domain.TaskFilter{Status: domain.Done}, done)
+			check(domain.TaskFilter{CreatedAfter: &created.CreatedAt}, last)
+			check(domain.TaskFilter{CreatedBefore: &created.CreatedAt}, first)
+		}},
+		{"TaskPages", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			past := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+			future := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+			beta := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "beta", Status: domain.Done, ProjectId: id, DueDate: &future})
+			alpha := s.createTask(t, id, "alpha", nil)
+			gamma := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "Gamma", Status: domain.InProgress, ProjectId: id, DueDate: &past})
+			alpha2 := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "alpha", Status: domain.Pending, ProjectId: id, DueDate: &future})
+			s.createTask(t, s.createProject(t, "alice", "other", nil), "elsewhere", nil)
+
+			// Ties are broken by id, titles compare byte by byte and tasks without a due date come last
+			for _, c := range []struct {
+				sort domain.SortField
+				want []int
+			}{
+				{domain.SortCreatedAt, []int{beta, alpha, gamma, alpha2}},
+				{domain.SortTitle, []int{gamma, alpha, alpha2, beta}},
+				{domain.SortStatus, []int{alpha, alpha2, gamma, beta}},
+				{domain.SortDueDate, []int{gamma, beta, alpha2, alpha}},
+			} {
+				for _, desc := range []bool{false, true} {
+					want := c.want
+					if desc {
+						want = reversed(want)
+					}
+					page := domain.Page{Limit: 3, Sort: c.sort, Desc: desc}
+					ids := pageThrough(t, page, func(page domain.Page) ([]domain.Task, error) {
+						return s.Tasks.GetTasks(id, domain.TaskFilter{}, page)
+					}, func(task domain.Task) int { return task.Id })
+					if !reflect.DeepEqual(ids, want) {
+						t.Fatalf("sort %s desc %v returned %v, want %v", c.sort, desc, ids, want)
+					}
+				}
+			}
+		}},
+		{"Subtasks", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			parent := s.createTask(t, id, "parent", nil)
+			child := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "child", Status: domain.Done, ProjectId: id, ParentId: &parent})
+			grandchild := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "grandchild", Status: domain.Pending, ProjectId: id, ParentId: &child})
+			sibling := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "sibling", Status: domain.Pending, ProjectId: id, ParentId: &parent})
+			elsewhere := s.createTask(t, other, "elsewhere", nil)
+
+			_, err := s.Tasks.CreateTask(&domain.CreateTaskRequest{Title: "t", Status: domain.Pending, ProjectId: id, ParentId: &elsewhere})
+			mustFailWith(t, err, domain.ErrInvalidParent)
+			missing := elsewhere + 1
+			_, err = s.Tasks.CreateTask(&domain.CreateTaskRequest{Title: "t", Status: domain.Pending, ProjectId: id, ParentId: &missing})
+			mustFailWith(t, err, domain.ErrInvalidParent)
+
+			// The progress only counts the direct subtasks
+			task, err := s.Tasks.GetTaskById(id, strconv.Itoa(parent))
+			mustNotFail(t, err)
+			if task.ParentId != nil || task.Subtasks != (domain.TaskProgress{Done: 1, Total: 2}) {
+				t.Fatalf("got parent %+v", task)
+			}
+			task, err = s.Tasks.GetTaskById(id, strconv.Itoa(grandchild))
+			mustNotFail(t, err)
+			if task.ParentId == nil || *task.ParentId != child || task.Subtasks != (domain.TaskProgress{}) {
+				t.Fatalf("got grandchild %+v", task)
+			}
+
+			check := func(filter domain.TaskFilter, want ...int) {
+				t.Helper()
+				tasks, err := s.Tasks.GetTasks(id, filter, domain.Page{})
+				mustNotFail(t, err)
+				if ids := taskIds(tasks); !reflect.DeepEqual(ids, want) {
+					t.Fatalf("filter %+v returned %v, want %v", filter, ids, want)
+				}
+			}
+			check(domain.TaskFilter{TopLevel: true}, parent)
+			check(domain.TaskFilter{ParentId: parent}, child, sibling)
+			check(domain.TaskFilter{ParentId: child}, grandchild)
+
+			move := func(taskId int, parentId *int) (domain.Task, error) {
+				return s.Tasks.PatchTask(strconv.Itoa(taskId), &domain.PatchTaskRequest{
+					ParentId:  domain.Optional[*int]{Set: true, Value: parentId},
+					ProjectId: id,
+				})
+			}
+			_, err = move(parent, &grandchild)
+			mustFailWith(t, err, domain.ErrTaskCycle)
+			_, err = move(parent, &parent)
+			mustFailWith(t, err, domain.ErrTaskCycle)
+			_, err = move(parent, &elsewhere)
+			mustFailWith(t, err, domain.ErrInvalidParent)
+
+			moved, err := move(grandchild, &sibling)
+			mustNotFail(t, err)
+			if moved.ParentId == nil || *moved.ParentId != sibling {
+				t.Fatalf("got task %+v after moving it under %d", moved, sibling)
+			}
+			moved, err = move(grandchild, nil)
+			mustNotFail(t, err)
+			if moved.ParentId != nil {
+				t.Fatalf("got task %+v after moving it to the top level", moved)
+			}
+			check(domain.TaskFilter{TopLevel: true}, parent, grandchild)
+			_, err = move(grandchild, &child)
+			mustNotFail(t, err)
+
+			// Deleting a task moves its subtasks to the top level as a new version
+			before, err := s.Tasks.GetTaskById(id, strconv.Itoa(grandchild))
+			mustNotFail(t, err)
+			mustNotFail(t, s.Tasks.DeleteTask(id, strconv.Itoa(child), 0))
+			task, err = s.Tasks.GetTaskById(id, strconv.Itoa(grandchild))
+			mustNotFail(t, err)
+			if task.ParentId != nil || task.Version <= before.Version {
+				t.Fatalf("got subtask %+v of a deleted task, was %+v", task, before)
+			}
+			task, err = s.Tasks.GetTaskById(id, strconv.Itoa(parent))
+			mustNotFail(t, err)
+			if task.Subtasks != (domain.TaskProgress{Done: 0, Total: 1}) {
+				t.Fatalf("got progress %+v after deleting a subtask", task.Subtasks)
+			}
+		}},
+		{"Descendants", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			other := s.createProject(t, "alice", "other", nil)
+			sub := func(projectId, parentId int) int {
+				t.Helper()
+				return s.createTaskWith(t, &domain.CreateTaskRequest{Title: "t", Status: domain.Pending, ProjectId: projectId, ParentId: &parentId})
+			}
+			a := s.createTask(t, id, "a", nil)
+			b := s.createTask(t, id, "b", nil)
+			c := s.createTask(t, id, "c", nil)
+			a1 := sub(id, a)
+			b1 := sub(id, b)
+			a11 := sub(id, a1)
+			c1 := sub(id, c)
+			a2 := sub(id, a)
+			elsewhere := sub(other, s.createTask(t, other, "elsewhere", nil))
+
+			tasks, err := s.Tasks.GetDescendants(id, []int{a, b})
+			mustNotFail(t, err)
+			if ids := taskIds(tasks); !reflect.DeepEqual(ids, []int{a1, b1, a11, a2}) {
+				t.Fatalf("got descendants %v, want %v", ids, []int{a1, b1, a11, a2})
+			}
+			tasks, err = s.Tasks.GetDescendants(id, []int{c1, a11})
+			mustNotFail(t, err)
+			if len(tasks) != 0 {
+				t.Fatalf("got descendants %v of tasks without subtasks", taskIds(tasks))
+			}
+			// Tasks of other projects are never returned
+			tasks, err = s.Tasks.GetDescendants(id, []int{elsewhere - 1})
+			mustNotFail(t, err)
+			if len(tasks) != 0 {
+				t.Fatalf("got descendants %v from another project", taskIds(tasks))
+			}
+		}},
+		{"ParentVersions", func(t *testing.T, s Stores) {
+			// The progress is part of the parent, every change of it must change the version
+			s.newUser(t, "alice")
+			id := s.createProject(t, "alice", "p", nil)
+			parent := s.createTask(t, id, "parent", nil)
+			other := s.createTask(t, id, "other", nil)
+			version := func(taskId int) int {
+				t.Helper()
+				task, err := s.Tasks.GetTaskById(id, strconv.Itoa(taskId))
+				mustNotFail(t, err)
+				return task.Version
+			}
+			expect := func(step string, want map[int]int) {
+				t.Helper()
+				for taskId, v := range want {
+					if got := version(taskId); got != v {
+						t.Fatalf("after %s task %d has version %d, want %d", step, taskId, got, v)
+					}
+				}
+			}
+			patch := func(taskId int, r domain.PatchTaskRequest) {
+				t.Helper()
+				r.ProjectId = id
+				_, err := s.Tasks.PatchTask(strconv.Itoa(taskId), &r)
+				mustNotFail(t, err)
+			}
+
+			child := s.createTaskWith(t, &domain.CreateTaskRequest{Title: "child", Status: domain.Pending, ProjectId: id, ParentId: &parent})
+			expect("creating a subtask", map[int]int{parent: 2, other: 1})
+			patch(child, domain.PatchTaskRequest{Status: domain.Optional[domain.Status]{Set: true, Value: domain.InProgress}})
+			expect("a status change keeping the progress", map[int]int{parent: 2})
+			patch(child, domain.PatchTaskRequest{Status: domain.Optional[domain.Status]{Set: true, Value: domain.Done}})
+			expect("completing a subtask", map[int]int{parent: 3})
+			patch(child, domain.PatchTaskRequest{Title: domain.Optional[string]{Set: true, Value: "renamed"}})
+			expect("renaming a subtask", map[int]int{parent: 3})
+			patch(child, domain.PatchTaskRequest{ParentId: domain.Optional[*int]{Set: true, Value: &other}})
+			expect("moving a subtask", map[int]int{parent: 4, other: 2})
+			patch(child, domain.PatchTaskRequest{ParentId: domain.Optional[*int]{Set: true, Value: nil}})
+			expect("moving a subtask to the top level", map[int]int{parent: 4, other: 3})
+			patch(child, domain.PatchTaskRequest{ParentId: domain.Optional[*int]{Set: true, Value: &parent}})
+			expect("moving a task under another", map[int]int{parent: 5, other: 3})
+			mustNotFail(t, s.Tasks.DeleteTask(id, strconv.Itoa(child), 0))
+			expect("deleting a subtask", map[int]int{parent: 6, other: 3})
+		}},
+	})
+}
+
+func taskIds(tasks []domain.Task) []int {
+	var ids []int
+	for _, task := range tasks {
+		ids = append(ids, task.Id)
+	}
+	return ids
+}
+
+func (s Stores) createTask(t *testing.T, projectId int, title string, assignees []int) int {
+	t.Helper()
+	return s.createTaskWith(t, &domain.CreateTaskRequest{Title: title, Status: domain.Pending, ProjectId: projectId, Assignees: assignees})
+}
+
+func (s Stores) createTaskWith(t *testing.T, r *domain.CreateTaskRequest) int {
+	t.Helper()
+	task, err := s.Tasks.CreateTask(r)
+	mustNotFail(t, err)
+	return task.Id
+}
+
+// Drops the monotonic clock readings and locations so tasks read back from a store compare equal
+func normalized(task domain.Task) domain.Task {
+	task.CreatedAt = task.CreatedAt.Round(0).UTC()
+	for _, date := range []**time.Time{&task.StartDate, &task.DueDate} {
+		if *date != nil {
+			d := (*date).Round(0).UTC()
+			*date = &d
+		}
+	}
+	return task
+}
+
+func sorted(ids ...int) []int {
+	s := append([]int{}, ids...)
+	sort.Ints(s)
+	return s
+}
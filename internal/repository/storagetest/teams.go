@@ -0,0 +1,222 @@
+package storagetest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+func runTeamTests(t *testing.T, newStores Factory) {
+	runCases(t, newStores, []testCase{
+		{"GetTeamNotFound", func(t *testing.T, s Stores) {
+			_, err := s.Teams.GetTeam(1)
+			mustFailWith(t, err, domain.ErrTeamNotFound)
+		}},
+		{"CreateTeamUnknownUser", func(t *testing.T, s Stores) {
+			err := s.Teams.CreateTeam(&domain.CreateTeamRequest{Name: "team", UserCognitoId: "unknown"})
+			mustFailWith(t, err, domain.ErrUserNotFound)
+		}},
+		{"CreateTeamAddsOwner", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			id := s.createTeam(t, "alice", "team")
+
+			team, err := s.Teams.GetTeam(id)
+			mustNotFail(t, err)
+			if team.Name != "team" || team.AdminId != alice {
+				t.Fatalf("got team %+v", team)
+			}
+			role, err := s.Teams.GetMemberRole(id, "alice")
+			mustNotFail(t, err)
+			if role != domain.RoleOwner {
+				t.Fatalf("creator got role %q", role)
+			}
+			owners, err := s.Teams.CountOwners(id)
+			mustNotFail(t, err)
+			if owners != 1 {
+				t.Fatalf("got %d owners", owners)
+			}
+		}},
+		{"GetTeamsScopedToMember", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			first := s.createTeam(t, "alice", "first")
+			s.createTeam(t, "bob", "bobs")
+			second := s.createTeam(t, "alice", "second")
+			mustNotFail(t, s.Teams.AddMember(first, bob, domain.RoleViewer))
+
+			teams, err := s.Teams.GetTeams("alice")
+			mustNotFail(t, err)
+			if len(teams) != 2 || teams[0].Id != first || teams[1].Id != second || teams[0].Role != domain.RoleOwner {
+				t.Fatalf("got teams %+v", teams)
+			}
+			teams, err = s.Teams.GetTeams("bob")
+			mustNotFail(t, err)
+			if len(teams) != 2 || teams[0].Id != first || teams[0].Role != domain.RoleViewer {
+				t.Fatalf("got teams %+v", teams)
+			}
+		}},
+		{"GetMemberRoleErrors", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newUser(t, "bob")
+			id := s.createTeam(t, "alice", "team")
+
+			_, err := s.Teams.GetMemberRole(id, "bob")
+			mustFailWith(t, err, domain.ErrTeamMemberNotFound)
+			_, err = s.Teams.GetMemberRole(id+1, "alice")
+			mustFailWith(t, err, domain.ErrTeamNotFound)
+		}},
+		{"Members", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			carol := s.newUser(t, "carol")
+			id := s.createTeam(t, "alice", "team")
+
+			mustNotFail(t, s.Teams.AddMember(id, bob, domain.RoleMember))
+			mustNotFail(t, s.Teams.AddMember(id, carol, domain.RoleViewer))
+			mustFailWith(t, s.Teams.AddMember(id, bob, domain.RoleAdmin), domain.ErrAlreadyTeamMember)
+			mustFailWith(t, s.Teams.AddMember(id, carol+100, domain.RoleAdmin), domain.ErrUserNotFound)
+
+			members, err := s.Teams.GetMembers(id)
+			mustNotFail(t, err)
+			if len(members) != 3 || members[0].UserId != alice || members[1].UserId != bob || members[2].UserId != carol {
+				t.Fatalf("got members %+v, want them in joining order", members)
+			}
+			if members[1].CognitoId != "bob" || members[1].Role != domain.RoleMember || members[1].TeamId != id {
+				t.Fatalf("got member %+v", members[1])
+			}
+
+			mustNotFail(t, s.Teams.UpdateMemberRole(id, bob, domain.RoleOwner))
+			member, err := s.Teams.GetMember(id, bob)
+			mustNotFail(t, err)
+			if member.Role != domain.RoleOwner {
+				t.Fatalf("got role %q after update", member.Role)
+			}
+			owners, err := s.Teams.CountOwners(id)
+			mustNotFail(t, err)
+			if owners != 2 {
+				t.Fatalf("got %d owners", owners)
+			}
+
+			mustNotFail(t, s.Teams.RemoveMember(id, carol))
+			_, err = s.Teams.GetMember(id, carol)
+			mustFailWith(t, err, domain.ErrTeamMemberNotFound)
+			mustFailWith(t, s.Teams.RemoveMember(id, carol), domain.ErrTeamMemberNotFound)
+			mustFailWith(t, s.Teams.UpdateMemberRole(id, carol, domain.RoleAdmin), domain.ErrTeamMemberNotFound)
+		}},
+		{"LastOwner", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			id := s.createTeam(t, "alice", "team")
+			mustNotFail(t, s.Teams.AddMember(id, bob, domain.RoleAdmin))
+
+			mustFailWith(t, s.Teams.UpdateMemberRole(id, alice, domain.RoleAdmin), domain.ErrLastTeamOwner)
+			mustFailWith(t, s.Teams.RemoveMember(id, alice), domain.ErrLastTeamOwner)
+			// Other members and owners that are not the last one can still be changed
+			mustNotFail(t, s.Teams.UpdateMemberRole(id, alice, domain.RoleOwner))
+			mustNotFail(t, s.Teams.UpdateMemberRole(id, bob, domain.RoleOwner))
+			mustNotFail(t, s.Teams.UpdateMemberRole(id, alice, domain.RoleMember))
+			mustFailWith(t, s.Teams.RemoveMember(id, bob), domain.ErrLastTeamOwner)
+			mustNotFail(t, s.Teams.RemoveMember(id, alice))
+			role, err := s.Teams.GetMemberRole(id, "bob")
+			mustNotFail(t, err)
+			if role != domain.RoleOwner {
+				t.Fatalf("last owner got role %q", role)
+			}
+		}},
+		{"LastOwnerConcurrent", func(t *testing.T, s Stores) {
+			alice := s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			carol := s.newUser(t, "carol")
+			id := s.createTeam(t, "alice", "team")
+			mustNotFail(t, s.Teams.AddMember(id, bob, domain.RoleOwner))
+			mustNotFail(t, s.Teams.AddMember(id, carol, domain.RoleOwner))
+
+			// Every owner demotes or removes the others at once, one of them must be left
+			var wg sync.WaitGroup
+			for i, userId := range []int{alice, bob, carol} {
+				wg.Add(1)
+				go func(i, userId int) {
+					defer wg.Done()
+					var err error
+					if i%2 == 0 {
+						err = s.Teams.RemoveMember(id, userId)
+					} else {
+						err = s.Teams.UpdateMemberRole(id, userId, domain.RoleViewer)
+					}
+					if err != nil && !errors.Is(err, domain.ErrLastTeamOwner) {
+						t.Errorf("unexpected error: %v", err)
+					}
+				}(i, userId)
+			}
+			wg.Wait()
+			owners, err := s.Teams.CountOwners(id)
+			mustNotFail(t, err)
+			if owners != 1 {
+				t.Fatalf("got %d owners after the owners removed each other, want 1", owners)
+			}
+		}},
+		{"Invitations", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			bob := s.newUser(t, "bob")
+			s.newUser(t, "carol")
+			id := s.createTeam(t, "alice", "team")
+			expires := time.Now().Add(time.Hour)
+
+			invitation, err := s.Teams.CreateInvitation(&domain.CreateTeamInvitationRequest{TeamId: id, Role: domain.RoleAdmin, ExpiresAt: &expires, UserCognitoId: "alice"}, "hash")
+			mustNotFail(t, err)
+			if invitation.TeamId != id || invitation.Role != domain.RoleAdmin || invitation.Id == 0 {
+				t.Fatalf("got invitation %+v", invitation)
+			}
+
+			member, err := s.Teams.AcceptInvitation("hash", "bob")
+			mustNotFail(t, err)
+			if member.TeamId != id || member.UserId != bob || member.Role != domain.RoleAdmin || member.CognitoId != "bob" {
+				t.Fatalf("got member %+v", member)
+			}
+			// An invitation can only be accepted once
+			_, err = s.Teams.AcceptInvitation("hash", "carol")
+			mustFailWith(t, err, domain.ErrInvitationNotFound)
+			_, err = s.Teams.AcceptInvitation("unknown", "carol")
+			mustFailWith(t, err, domain.ErrInvitationNotFound)
+		}},
+		{"FailedAcceptanceKeepsInvitation", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newUser(t, "carol")
+			id := s.createTeam(t, "alice", "team")
+			expires := time.Now().Add(time.Hour)
+			_, err := s.Teams.CreateInvitation(&domain.CreateTeamInvitationRequest{TeamId: id, Role: domain.RoleMember, ExpiresAt: &expires, UserCognitoId: "alice"}, "hash")
+			mustNotFail(t, err)
+
+			_, err = s.Teams.AcceptInvitation("hash", "alice")
+			mustFailWith(t, err, domain.ErrAlreadyTeamMember)
+			_, err = s.Teams.AcceptInvitation("hash", "carol")
+			mustNotFail(t, err)
+		}},
+		{"ExpiredInvitation", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newUser(t, "bob")
+			id := s.createTeam(t, "alice", "team")
+			expired := time.Now().Add(-time.Minute)
+			_, err := s.Teams.CreateInvitation(&domain.CreateTeamInvitationRequest{TeamId: id, Role: domain.RoleMember, ExpiresAt: &expired, UserCognitoId: "alice"}, "hash")
+			mustNotFail(t, err)
+
+			_, err = s.Teams.AcceptInvitation("hash", "bob")
+			mustFailWith(t, err, domain.ErrInvitationNotFound)
+		}},
+	})
+}
+
+// Creates a team and returns its id, the storage does not return it on creation
+func (s Stores) createTeam(t *testing.T, owner, name string) int {
+	t.Helper()
+	mustNotFail(t, s.Teams.CreateTeam(&domain.CreateTeamRequest{Name: name, UserCognitoId: owner}))
+	teams, err := s.Teams.GetTeams(owner)
+	mustNotFail(t, err)
+	if len(teams) == 0 || teams[len(teams)-1].Name != name {
+		t.Fatalf("created team %q is not the last team of %s: %+v", name, owner, teams)
+	}
+	return teams[len(teams)-1].Id
+}
@@ -0,0 +1,105 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+func (s Stores) newToken(t *testing.T, cognitoId, hash string, expiresAt *time.Time) domain.AccessToken {
+	t.Helper()
+	token, err := s.Tokens.CreateToken(&domain.CreateAccessTokenRequest{Name: hash, Scopes: []string{"tasks:read"}, ExpiresAt: expiresAt, UserCognitoId: cognitoId}, hash)
+	mustNotFail(t, err)
+	return token
+}
+
+// Reads the token back from the list of its user
+func (s Stores) getToken(t *testing.T, cognitoId string, id int) domain.AccessToken {
+	t.Helper()
+	tokens, err := s.Tokens.GetTokens(cognitoId)
+	mustNotFail(t, err)
+	for _, token := range tokens {
+		if token.Id == id {
+			return token
+		}
+	}
+	t.Fatalf("token %d not listed for %s", id, cognitoId)
+	return domain.AccessToken{}
+}
+
+func runTokenTests(t *testing.T, newStores Factory) {
+	// Second precision so the times survive the round trip through every backend
+	now := time.Now().UTC().Truncate(time.Second)
+
+	runCases(t, newStores, []testCase{
+		{"UseToken", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			expiresAt := now.Add(time.Hour)
+			created := s.newToken(t, "alice", "hash", &expiresAt)
+			if created.LastUsedAt != nil {
+				t.Fatalf("new token used at %s", created.LastUsedAt)
+			}
+
+			token, err := s.Tokens.UseToken("hash", now)
+			mustNotFail(t, err)
+			if token.Id != created.Id || token.CognitoId != "alice" {
+				t.Fatalf("got token %d of %s, want %d of alice", token.Id, token.CognitoId, created.Id)
+			}
+			if token.LastUsedAt == nil || !token.LastUsedAt.Equal(now) {
+				t.Fatalf("token used at %v, want %s", token.LastUsedAt, now)
+			}
+			if used := s.getToken(t, "alice", created.Id).LastUsedAt; used == nil || !used.Equal(now) {
+				t.Fatalf("listed token used at %v, want %s", used, now)
+			}
+		}},
+		{"UseUnknownToken", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newToken(t, "alice", "hash", nil)
+			_, err := s.Tokens.UseToken("other", now)
+			mustFailWith(t, err, domain.ErrAccessTokenNotFound)
+		}},
+		{"UseExpiredToken", func(t *testing.T, s Stores) {
+			// Expired tokens are returned so the caller can tell why they are rejected, but not recorded as used
+			s.newUser(t, "alice")
+			expiresAt := now.Add(time.Hour)
+			created := s.newToken(t, "alice", "hash", &expiresAt)
+			_, err := s.Tokens.UseToken("hash", now)
+			mustNotFail(t, err)
+
+			later := expiresAt.Add(time.Second)
+			token, err := s.Tokens.UseToken("hash", later)
+			mustNotFail(t, err)
+			if token.ExpiresAt == nil || !token.ExpiresAt.Equal(expiresAt) {
+				t.Fatalf("got expiry %v, want %s", token.ExpiresAt, expiresAt)
+			}
+			if used := s.getToken(t, "alice", created.Id).LastUsedAt; used == nil || !used.Equal(now) {
+				t.Fatalf("expired token used at %v, want the last use before it expired at %s", used, now)
+			}
+		}},
+		{"DeleteToken", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			s.newUser(t, "bob")
+			created := s.newToken(t, "alice", "hash", nil)
+			// Ids too large for the id columns are not found either
+			for _, id := range []int{created.Id + 1, 1 << 40} {
+				mustFailWith(t, s.Tokens.DeleteToken(id, "alice"), domain.ErrAccessTokenNotFound)
+			}
+			mustFailWith(t, s.Tokens.DeleteToken(created.Id, "bob"), domain.ErrAccessTokenNotFound)
+			mustNotFail(t, s.Tokens.DeleteToken(created.Id, "alice"))
+			mustFailWith(t, s.Tokens.DeleteToken(created.Id, "alice"), domain.ErrAccessTokenNotFound)
+		}},
+		{"UseRevokedToken", func(t *testing.T, s Stores) {
+			s.newUser(t, "alice")
+			created := s.newToken(t, "alice", "hash", nil)
+			mustNotFail(t, s.Tokens.DeleteToken(created.Id, "alice"))
+			_, err := s.Tokens.UseToken("hash", now)
+			mustFailWith(t, err, domain.ErrAccessTokenNotFound)
+			tokens, err := s.Tokens.GetTokens("alice")
+			mustNotFail(t, err)
+			if len(tokens) != 0 {
+				t.Fatalf("got %d tokens after the revocation, want none", len(tokens))
+			}
+		}},
+	})
+}
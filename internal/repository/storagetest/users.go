@@ -0,0 +1,40 @@
+package storagetest
+
+import "testing"
+
+func runUserTests(t *testing.T, newStores Factory) {
+	runCases(t, newStores, []testCase{
+		{"CheckUnknownUser", func(t *testing.T, s Stores) {
+			ok, err := s.Users.CheckUser("unknown")
+			mustNotFail(t, err)
+			if ok {
+				t.Fatal("unknown user reported as existing")
+			}
+		}},
+		{"CreateUser", func(t *testing.T, s Stores) {
+			mustNotFail(t, s.Users.CreateUser("alice"))
+			ok, err := s.Users.CheckUser("alice")
+			mustNotFail(t, err)
+			if !ok {
+				t.Fatal("created user reported as missing")
+			}
+		}},
+		{"CreateExistingUser", func(t *testing.T, s Stores) {
+			first := s.newUser(t, "alice")
+			second := s.newUser(t, "alice")
+			if first != second {
+				t.Fatalf("creating an existing user changed its id from %d to %d", first, second)
+			}
+		}},
+		{"CreateExistingUserKeepsIds", func(t *testing.T, s Stores) {
+			// Users are provisioned again on every cache miss, that must not use up ids
+			alice := s.newUser(t, "alice")
+			for i := 0; i < 3; i++ {
+				s.newUser(t, "alice")
+			}
+			if bob := s.newUser(t, "bob"); bob != alice+1 {
+				t.Fatalf("got id %d for the next user, want %d", bob, alice+1)
+			}
+		}},
+	})
+}
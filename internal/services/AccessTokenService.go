@@ -0,0 +1,63 @@
+package svc
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Personal access token service, tokens are random secrets and only their sha256 hash is stored
+
+type AccessTokenService struct {
+	store domain.AccessTokenStorage
+}
+
+func NewAccessTokenService(store domain.AccessTokenStorage) *AccessTokenService {
+	return &AccessTokenService{
+		store: store,
+	}
+}
+
+func (s *AccessTokenService) CreateToken(r *domain.CreateAccessTokenRequest) (domain.CreatedAccessToken, error) {
+	if err := r.Validate(); err != nil {
+		return domain.CreatedAccessToken{}, err
+	}
+	secret, err := generateSecret(domain.AccessTokenPrefix)
+	if err != nil {
+		return domain.CreatedAccessToken{}, err
+	}
+	token, err := s.store.CreateToken(r, hashSecret(secret))
+	if err != nil {
+		return domain.CreatedAccessToken{}, err
+	}
+	return domain.CreatedAccessToken{AccessToken: token, Token: secret}, nil
+}
+
+func (s *AccessTokenService) GetTokens(cognitoId string) ([]domain.AccessToken, error) {
+	return s.store.GetTokens(cognitoId)
+}
+
+func (s *AccessTokenService) RevokeToken(tokenId int, cognitoId string) error {
+	return s.store.DeleteToken(tokenId, cognitoId)
+}
+
+// Resolves the token sent by the client, unknown and expired tokens are rejected
+func (s *AccessTokenService) Authenticate(secret string) (domain.AccessToken, error) {
+	if !strings.HasPrefix(secret, domain.AccessTokenPrefix) {
+		return domain.AccessToken{}, domain.ErrInvalidAccessToken
+	}
+	now := time.Now()
+	token, err := s.store.UseToken(hashSecret(secret), now)
+	if errors.Is(err, domain.ErrAccessTokenNotFound) {
+		return domain.AccessToken{}, domain.ErrInvalidAccessToken
+	}
+	if err != nil {
+		return domain.AccessToken{}, err
+	}
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return domain.AccessToken{}, domain.ErrAccessTokenExpired
+	}
+	return token, nil
+}
@@ -0,0 +1,205 @@
+package svc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Number of detached attachments whose content is deleted at once
+const purgeBatchSize = 100
+
+type AttachmentService struct {
+	store  domain.AttachmentStorage
+	blobs  domain.BlobStore
+	limits domain.AttachmentLimits
+}
+
+func NewAttachmentService(store domain.AttachmentStorage, blobs domain.BlobStore, limits domain.AttachmentLimits) *AttachmentService {
+	return &AttachmentService{
+		store:  store,
+		blobs:  blobs,
+		limits: limits,
+	}
+}
+
+func (s *AttachmentService) GetAttachments(projectId, taskId int, cognitoId string) ([]domain.Attachment, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return nil, err
+	}
+	attachments, err := s.store.GetAttachments(projectId, taskId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (s *AttachmentService) GetAttachment(projectId, taskId, attachmentId int, cognitoId string) (domain.Attachment, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return domain.Attachment{}, err
+	}
+	return s.store.GetAttachment(projectId, taskId, attachmentId)
+}
+
+func (s *AttachmentService) OpenAttachment(ctx context.Context, projectId, taskId, attachmentId int, cognitoId string) (domain.Attachment, io.ReadCloser, error) {
+	attachment, err := s.GetAttachment(projectId, taskId, attachmentId, cognitoId)
+	if err != nil {
+		return domain.Attachment{}, nil, err
+	}
+	content, err := s.blobs.Get(ctx, attachment.BlobKey)
+	if err != nil {
+		log.Println(err)
+		return domain.Attachment{}, nil, err
+	}
+	return attachment, content, nil
+}
+
+// The content is written under a new random key before the attachment is recorded,
+// it is deleted again when the attachment can not be recorded
+func (s *AttachmentService) UploadAttachment(ctx context.Context, r *domain.AttachmentRequest, content io.Reader) (domain.Attachment, error) {
+	if err := requireProjectRole(s.store, r.ProjectId, r.UserCognitoId, domain.ProjectWriteRole); err != nil {
+		return domain.Attachment{}, err
+	}
+	if err := s.normalizeAttachment(r); err != nil {
+		return domain.Attachment{}, err
+	}
+	key, err := newBlobKey(r.TaskId)
+	if err != nil {
+		return domain.Attachment{}, err
+	}
+
+	limited := &limitedReader{r: content, remaining: s.limits.MaxSize}
+	if err := s.blobs.Put(ctx, key, limited, r.ContentType); err != nil {
+		if !errors.Is(err, domain.ErrAttachmentTooLarge) {
+			log.Println(err)
+		}
+		return domain.Attachment{}, err
+	}
+	r.Size = s.limits.MaxSize - limited.remaining
+	r.BlobKey = key
+
+	attachment, err := s.store.CreateAttachment(r)
+	if err != nil {
+		if err := s.blobs.Delete(context.Background(), key); err != nil {
+			log.Println("Error deleting the content of an attachment that was not recorded: ", err)
+		}
+		return domain.Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// The attachment is detached before its content is deleted, content that could not be
+// deleted is retried by PurgeAttachments
+func (s *AttachmentService) DeleteAttachment(ctx context.Context, projectId, taskId, attachmentId int, cognitoId string) error {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectWriteRole); err != nil {
+		return err
+	}
+	attachment, err := s.store.DetachAttachment(projectId, taskId, attachmentId)
+	if err != nil {
+		return err
+	}
+	if err := s.purge(ctx, attachment); err != nil {
+		log.Println("Error deleting the content of an attachment: ", err)
+	}
+	return nil
+}
+
+// Deletes the content of the detached attachments, those of deleted tasks included
+func (s *AttachmentService) PurgeAttachments(ctx context.Context) error {
+	for {
+		attachments, err := s.store.GetDetachedAttachments(purgeBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, attachment := range attachments {
+			if err := s.purge(ctx, attachment); err != nil {
+				return err
+			}
+		}
+		if len(attachments) < purgeBatchSize {
+			return nil
+		}
+	}
+}
+
+// StartPurge purges the detached attachments every interval until the context is canceled
+func (s *AttachmentService) StartPurge(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.PurgeAttachments(ctx); err != nil {
+				log.Println("Error purging the detached attachments: ", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *AttachmentService) purge(ctx context.Context, attachment domain.Attachment) error {
+	if err := s.blobs.Delete(ctx, attachment.BlobKey); err != nil {
+		return err
+	}
+	if err := s.store.DeleteAttachment(attachment.Id); err != nil && !errors.Is(err, domain.ErrAttachmentNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Keeps only the file name of paths sent by some clients and checks the type against the limits
+func (s *AttachmentService) normalizeAttachment(r *domain.AttachmentRequest) error {
+	r.Name = strings.TrimSpace(path.Base(strings.ReplaceAll(r.Name, `\`, "/")))
+	if r.Name == "." || r.Name == "/" {
+		r.Name = ""
+	}
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	r.ContentType = strings.ToLower(r.ContentType)
+	if !s.limits.Allows(r.ContentType) {
+		return domain.ErrAttachmentType
+	}
+	return nil
+}
+
+// Keys are grouped by task and random so they can not be guessed nor collide
+func newBlobKey(taskId int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tasks/%d/%s", taskId, hex.EncodeToString(b)), nil
+}
+
+// Reader failing with ErrAttachmentTooLarge once more than remaining bytes were read
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.remaining = 0
+		return 0, domain.ErrAttachmentTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
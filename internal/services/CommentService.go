@@ -0,0 +1,175 @@
+package svc
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+var (
+	// A mention is an @ that does not follow a word character so email addresses are not taken for one
+	mentionPattern = regexp.MustCompile(`(^|[^\w@])@([\w.+-]+)`)
+	// Code blocks and spans are not rendered as mentions
+	codePattern = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+)
+
+type CommentService struct {
+	store domain.CommentStorage
+}
+
+func NewCommentService(store domain.CommentStorage) *CommentService {
+	return &CommentService{
+		store: store,
+	}
+}
+
+func (s *CommentService) GetComments(projectId, taskId int, cognitoId string) ([]domain.Comment, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return nil, err
+	}
+	comments, err := s.store.GetComments(projectId, taskId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return nestReplies(comments), nil
+}
+
+func (s *CommentService) GetComment(projectId, taskId, commentId int, cognitoId string) (domain.Comment, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return domain.Comment{}, err
+	}
+	return s.store.GetComment(projectId, taskId, commentId)
+}
+
+func (s *CommentService) GetCommentEdits(projectId, taskId, commentId int, cognitoId string) ([]domain.CommentEdit, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return nil, err
+	}
+	return s.store.GetCommentEdits(projectId, taskId, commentId)
+}
+
+func (s *CommentService) CreateComment(r *domain.CommentRequest) (domain.Comment, error) {
+	if err := requireProjectRole(s.store, r.ProjectId, r.UserCognitoId, domain.ProjectWriteRole); err != nil {
+		return domain.Comment{}, err
+	}
+	if err := r.Validate(); err != nil {
+		return domain.Comment{}, err
+	}
+	if err := s.resolveMentions(r); err != nil {
+		return domain.Comment{}, err
+	}
+	return s.store.CreateComment(r)
+}
+
+// Only the body can be edited, a comment stays in the thread it was written in
+func (s *CommentService) UpdateComment(commentId int, r *domain.CommentRequest) (domain.Comment, error) {
+	if err := requireProjectRole(s.store, r.ProjectId, r.UserCognitoId, domain.ProjectWriteRole); err != nil {
+		return domain.Comment{}, err
+	}
+	if err := r.Validate(); err != nil {
+		return domain.Comment{}, err
+	}
+	if err := s.authorizeAuthor(r.ProjectId, r.TaskId, commentId, r.UserCognitoId); err != nil {
+		return domain.Comment{}, err
+	}
+	if err := s.resolveMentions(r); err != nil {
+		return domain.Comment{}, err
+	}
+	return s.store.UpdateComment(commentId, r)
+}
+
+func (s *CommentService) DeleteComment(projectId, taskId, commentId int, cognitoId string) error {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectWriteRole); err != nil {
+		return err
+	}
+	if err := s.authorizeAuthor(projectId, taskId, commentId, cognitoId); err != nil {
+		return err
+	}
+	return s.store.DeleteComment(projectId, taskId, commentId)
+}
+
+func (s *CommentService) GetMentions(cognitoId string, since *time.Time) ([]domain.Mention, error) {
+	mentions, err := s.store.GetMentions(cognitoId, since)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return mentions, nil
+}
+
+// Deleted comments can not be touched anymore, they only hold their replies together
+func (s *CommentService) authorizeAuthor(projectId, taskId, commentId int, cognitoId string) error {
+	comment, err := s.store.GetComment(projectId, taskId, commentId)
+	if err != nil {
+		return err
+	}
+	if comment.Deleted {
+		return domain.ErrCommentNotFound
+	}
+	if comment.Author != cognitoId {
+		return domain.ErrNotCommentAuthor
+	}
+	return nil
+}
+
+// Fills r.Mentions with the project members mentioned in the body, handles that do not
+// belong to a member are left as plain text
+func (s *CommentService) resolveMentions(r *domain.CommentRequest) error {
+	handles := parseMentions(r.Body)
+	r.Mentions = nil
+	if len(handles) == 0 {
+		return nil
+	}
+	mentions, err := s.store.GetProjectMembers(r.ProjectId, handles)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	r.Mentions = mentions
+	return nil
+}
+
+// Returns the distinct handles written as @handle outside of code, a dot ending the
+// sentence is not part of the handle
+func parseMentions(body string) []string {
+	body = codePattern.ReplaceAllString(body, " ")
+	var handles []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		handle := strings.TrimRight(match[2], ".")
+		if handle != "" && !seen[handle] {
+			seen[handle] = true
+			handles = append(handles, handle)
+		}
+	}
+	return handles
+}
+
+// Nests the replies in the comment they answer, the comments come in the order they were
+// written so every thread keeps that order
+func nestReplies(comments []domain.Comment) []domain.Comment {
+	replies := make(map[int][]domain.Comment)
+	threads := []domain.Comment{}
+	for _, comment := range comments {
+		if comment.ParentId == nil {
+			threads = append(threads, comment)
+		} else {
+			replies[*comment.ParentId] = append(replies[*comment.ParentId], comment)
+		}
+	}
+	for i, comment := range threads {
+		threads[i] = withReplies(comment, replies)
+	}
+	return threads
+}
+
+func withReplies(comment domain.Comment, replies map[int][]domain.Comment) domain.Comment {
+	for _, reply := range replies[comment.Id] {
+		comment.Replies = append(comment.Replies, withReplies(reply, replies))
+	}
+	return comment
+}
@@ -0,0 +1,45 @@
+package svc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"None", "no mentions here", nil},
+		{"Start", "@alice can you look", []string{"alice"}},
+		{"Middle", "thanks @alice and @bob", []string{"alice", "bob"}},
+		{"Email", "mail alice@example.com or bob.smith+tag@example.co.uk", nil},
+		{"EmailAndMention", "@carol mail alice@example.com", []string{"carol"}},
+		{"DoubleAt", "@@alice", nil},
+		{"TrailingPeriod", "ask @bob.", []string{"bob"}},
+		{"TrailingEllipsis", "ask @bob...", []string{"bob"}},
+		{"TrailingPunctuation", "@alice, @bob! @carol? @dave: (@erin)", []string{"alice", "bob", "carol", "dave", "erin"}},
+		{"DotsInside", "ping @bob.smith.", []string{"bob.smith"}},
+		{"HandleCharacters", "@a_b-c+d", []string{"a_b-c+d"}},
+		{"Twice", "@bob, see what @bob said", []string{"bob"}},
+		{"TwiceWithPunctuation", "@bob. @bob", []string{"bob"}},
+		{"NewLines", "first line\n@alice\n\t@bob", []string{"alice", "bob"}},
+		{"Alone", "@ and @.", nil},
+		{"InlineCode", "run `npm i @types/node` and tell @alice", []string{"alice"}},
+		{"InlineCodeOnly", "`@alice`", nil},
+		{"FencedCode", "```\n@alice\nimport x from '@bob/pkg'\n```\n@carol", []string{"carol"}},
+		{"FencedCodeWithLanguage", "before @alice\n```go\n// @bob\n```\nafter @carol", []string{"alice", "carol"}},
+		{"UnclosedFence", "```\n@alice", []string{"alice"}},
+		// An unclosed backtick does not hide the rest of the body
+		{"UnclosedBacktick", "a ` in the text @alice", []string{"alice"}},
+		{"CodeSpanDoesNotCrossLines", "`start\n@alice` end", []string{"alice"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := parseMentions(c.body); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("parseMentions(%q) = %q, want %q", c.body, got, c.want)
+			}
+		})
+	}
+}
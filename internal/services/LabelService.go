@@ -0,0 +1,85 @@
+package svc
+
+import (
+	"log"
+	"strings"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type LabelService struct {
+	store domain.LabelStorage
+}
+
+func NewLabelService(store domain.LabelStorage) *LabelService {
+	return &LabelService{
+		store: store,
+	}
+}
+
+func (s *LabelService) GetLabels(projectId int, cognitoId string) ([]domain.Label, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return nil, err
+	}
+	labels, err := s.store.GetLabels(projectId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return labels, nil
+}
+
+func (s *LabelService) GetLabel(projectId, labelId int, cognitoId string) (domain.Label, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return domain.Label{}, err
+	}
+	return s.store.GetLabel(projectId, labelId)
+}
+
+func (s *LabelService) CreateLabel(r *domain.LabelRequest) (domain.Label, error) {
+	if err := requireProjectRole(s.store, r.ProjectId, r.UserCognitoId, domain.ProjectWriteRole); err != nil {
+		return domain.Label{}, err
+	}
+	if err := normalizeLabel(r); err != nil {
+		return domain.Label{}, err
+	}
+	return s.store.CreateLabel(r)
+}
+
+// Renames or recolors the label, the tasks tagged with it keep it
+func (s *LabelService) UpdateLabel(labelId int, r *domain.LabelRequest) (domain.Label, error) {
+	if err := requireProjectRole(s.store, r.ProjectId, r.UserCognitoId, domain.ProjectWriteRole); err != nil {
+		return domain.Label{}, err
+	}
+	if err := normalizeLabel(r); err != nil {
+		return domain.Label{}, err
+	}
+	return s.store.UpdateLabel(labelId, r)
+}
+
+func (s *LabelService) DeleteLabel(projectId, labelId int, cognitoId string) error {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectManageRole); err != nil {
+		return err
+	}
+	return s.store.DeleteLabel(projectId, labelId)
+}
+
+func (s *LabelService) MergeLabel(projectId, labelId, intoId int, cognitoId string) (domain.Label, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectManageRole); err != nil {
+		return domain.Label{}, err
+	}
+	if labelId == intoId {
+		return domain.Label{}, domain.ErrMergeLabelItself
+	}
+	return s.store.MergeLabel(projectId, labelId, intoId)
+}
+
+// Trims the name, defaults and lowercases the color so names and colors compare as they are displayed
+func normalizeLabel(r *domain.LabelRequest) error {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Color = strings.ToLower(r.Color)
+	if r.Color == "" {
+		r.Color = domain.DefaultLabelColor
+	}
+	return r.Validate()
+}
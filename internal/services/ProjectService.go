@@ -1,6 +1,7 @@
 package svc
 
 import (
+	"errors"
 	"log"
 
 	"github.com/Desgue/ttracker-api/internal/domain"
@@ -10,24 +11,30 @@ import (
 
 type ProjectService struct {
 	store domain.ProjectStorage
+	teams domain.TeamStorage
 }
 
-func NewProjectService(store domain.ProjectStorage) *ProjectService {
+func NewProjectService(store domain.ProjectStorage, teams domain.TeamStorage) *ProjectService {
 	return &ProjectService{
 		store: store,
+		teams: teams,
 	}
 }
 
-func (s *ProjectService) GetProjects(cognitoId string) ([]domain.Project, error) {
-	projects, err := s.store.GetProjects(cognitoId)
+func (s *ProjectService) GetProjects(cognitoId string, filter domain.ProjectFilter, page domain.Page) ([]domain.Project, *domain.Cursor, error) {
+	projects, err := s.store.GetProjects(cognitoId, filter, peekPage(page))
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, nil, err
 	}
-	return projects, nil
+	projects, next := trimPage(projects, page, func(p domain.Project) int { return p.Id })
+	return projects, next, nil
 }
 
 func (s *ProjectService) GetProjectById(projectId, cognitoId string) (domain.Project, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return domain.Project{}, err
+	}
 	project, err := s.store.GetProjectById(projectId, cognitoId)
 	if err != nil {
 		log.Println(err)
@@ -36,47 +43,85 @@ func (s *ProjectService) GetProjectById(projectId, cognitoId string) (domain.Pro
 	return project, nil
 }
 
-func (s *ProjectService) CreateProject(r *domain.CreateProjectRequest) error {
-	switch r.Priority {
-	case "domain.High", "high", "HIGH":
-		r.Priority = domain.High
-	case "domain.Medium", "medium", "MEDIUM":
-		r.Priority = domain.Medium
-	case "domain.Low", "low", "LOW":
+func (s *ProjectService) CreateProject(r *domain.CreateProjectRequest) (domain.Project, error) {
+	if r.Priority == "" {
 		r.Priority = domain.Low
-	default:
+	}
+	if err := r.Validate(); err != nil {
+		return domain.Project{}, err
+	}
+
+	// Any team member allowed to write can create projects owned by the team
+	if r.TeamId != nil {
+		role, err := s.teams.GetMemberRole(*r.TeamId, r.UserCognitoId)
+		if errors.Is(err, domain.ErrTeamMemberNotFound) {
+			return domain.Project{}, domain.ErrTeamForbidden
+		}
+		if err != nil {
+			return domain.Project{}, err
+		}
+		if !role.AtLeast(domain.ProjectWriteRole) {
+			return domain.Project{}, domain.ErrTeamForbidden
+		}
+	}
+
+	return s.store.CreateProject(r)
+}
+
+func (s *ProjectService) UpdateProject(id string, r *domain.CreateProjectRequest) (domain.Project, error) {
+	if err := requireProjectRole(s.store, id, r.UserCognitoId, domain.ProjectManageRole); err != nil {
+		return domain.Project{}, err
+	}
+	if r.Priority == "" {
 		r.Priority = domain.Low
 	}
+	if err := r.Validate(); err != nil {
+		return domain.Project{}, err
+	}
+	return s.store.UpdateProject(id, r)
+}
 
-	if err := s.store.CreateProject(r); err != nil {
+func (s *ProjectService) PatchProject(id string, r *domain.PatchProjectRequest) (domain.Project, error) {
+	if err := requireProjectRole(s.store, id, r.UserCognitoId, domain.ProjectManageRole); err != nil {
+		return domain.Project{}, err
+	}
+	if err := r.Validate(); err != nil {
+		return domain.Project{}, err
+	}
+	return s.store.PatchProject(id, r)
+}
 
+func (s *ProjectService) DeleteProject(projectId, cognitoId string, version int) error {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectDeleteRole); err != nil {
+		return err
+	}
+	if err := s.store.DeleteProject(projectId, version); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *ProjectService) UpdateProject(id string, r *domain.CreateProjectRequest) error {
-	switch r.Priority {
-	case "domain.High", "high", "HIGH":
-		r.Priority = domain.High
-	case "domain.Medium", "medium", "MEDIUM":
-		r.Priority = domain.Medium
-	case "domain.Low", "low", "LOW":
-		r.Priority = domain.Low
-	default:
-		r.Priority = domain.Low
+func (s *ProjectService) GetShares(projectId, cognitoId string) ([]domain.ProjectShare, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return nil, err
 	}
+	return s.store.GetShares(projectId)
+}
 
-	if err := s.store.UpdateProject(id, r); err != nil {
-
+// Owner is reserved to the creator of the project so it can not be given through a share
+func (s *ProjectService) ShareProject(r *domain.ShareProjectRequest) error {
+	if !r.Role.Valid() || r.Role == domain.RoleOwner {
+		return domain.ErrInvalidShareRole
+	}
+	if err := requireProjectRole(s.store, r.ProjectId, r.UserCognitoId, domain.ProjectManageRole); err != nil {
 		return err
 	}
-	return nil
+	return s.store.ShareProject(r.ProjectId, r.UserId, r.Role)
 }
 
-func (s *ProjectService) DeleteProject(projectId, cognitoId string) error {
-	if err := s.store.DeleteProject(projectId, cognitoId); err != nil {
+func (s *ProjectService) RemoveShare(projectId string, userId int, cognitoId string) error {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectManageRole); err != nil {
 		return err
 	}
-	return nil
+	return s.store.RemoveShare(projectId, userId)
 }
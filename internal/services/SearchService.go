@@ -0,0 +1,34 @@
+package svc
+
+import (
+	"log"
+	"strings"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+type SearchService struct {
+	store domain.SearchStorage
+}
+
+func NewSearchService(store domain.SearchStorage) *SearchService {
+	return &SearchService{
+		store: store,
+	}
+}
+
+// The store scopes the results to the projects of the user, no role is required otherwise
+func (s *SearchService) Search(cognitoId string, q domain.SearchQuery) ([]domain.SearchResult, error) {
+	if strings.TrimSpace(q.Text) == "" {
+		return nil, domain.ErrEmptySearch
+	}
+	if q.Limit == 0 {
+		q.Limit = domain.DefaultPageLimit
+	}
+	results, err := s.store.Search(cognitoId, q)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return results, nil
+}
@@ -2,6 +2,7 @@ package svc
 
 import (
 	"log"
+	"time"
 
 	"github.com/Desgue/ttracker-api/internal/domain"
 )
@@ -16,63 +17,215 @@ func NewTaskService(store domain.TaskStorage) *TaskService {
 	}
 }
 
-func (s *TaskService) GetTasks(projectId int) ([]domain.Task, error) {
-	projects, err := s.store.GetTasks(projectId)
+// Every operation first checks the role of the user in the project the task belongs to,
+// reading requires domain.ProjectReadRole and writing domain.ProjectWriteRole
+
+func (s *TaskService) GetTasks(projectId int, cognitoId string, filter domain.TaskFilter, page domain.Page) ([]domain.Task, *domain.Cursor, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return nil, nil, err
+	}
+	tasks, err := s.store.GetTasks(projectId, filter, peekPage(page))
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, nil, err
+	}
+	tasks, next := trimPage(tasks, page, func(t domain.Task) int { return t.Id })
+	now := time.Now()
+	for i := range tasks {
+		tasks[i].Overdue = tasks[i].IsOverdue(now)
+	}
+	return tasks, next, nil
+}
+
+// The page holds top level tasks only, every subtask below them is read at once
+// and nested in the order the subtasks were created. The filter only applies to the top level
+func (s *TaskService) GetTaskTree(projectId int, cognitoId string, filter domain.TaskFilter, page domain.Page) ([]domain.Task, *domain.Cursor, error) {
+	filter.TopLevel = true
+	roots, next, err := s.GetTasks(projectId, cognitoId, filter, page)
+	if err != nil || len(roots) == 0 {
+		return roots, next, err
+	}
+	rootIds := make([]int, len(roots))
+	for i, root := range roots {
+		rootIds[i] = root.Id
+	}
+	tasks, err := s.store.GetDescendants(projectId, rootIds)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, err
+	}
+	now := time.Now()
+	children := map[int][]domain.Task{}
+	for _, t := range tasks {
+		if t.ParentId != nil {
+			t.Overdue = t.IsOverdue(now)
+			children[*t.ParentId] = append(children[*t.ParentId], t)
+		}
+	}
+	for i := range roots {
+		roots[i] = nestSubtasks(roots[i], children)
+	}
+	return roots, next, nil
+}
+
+func nestSubtasks(task domain.Task, children map[int][]domain.Task) domain.Task {
+	for _, child := range children[task.Id] {
+		task.Children = append(task.Children, nestSubtasks(child, children))
+	}
+	return task
+}
+
+func (s *TaskService) GetSubtasks(projectId int, taskId, cognitoId string, page domain.Page) ([]domain.Task, *domain.Cursor, error) {
+	task, err := s.GetTaskById(projectId, taskId, cognitoId)
+	if err != nil {
+		return nil, nil, err
 	}
-	return projects, nil
+	return s.GetTasks(projectId, cognitoId, domain.TaskFilter{ParentId: task.Id}, page)
 }
 
-func (s *TaskService) GetTaskById(id string) (domain.Task, error) {
-	project, err := s.store.GetTaskById(id)
+func (s *TaskService) GetTaskById(projectId int, taskId, cognitoId string) (domain.Task, error) {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectReadRole); err != nil {
+		return domain.Task{}, err
+	}
+	project, err := s.store.GetTaskById(projectId, taskId)
 	if err != nil {
 		log.Println(err)
 		return domain.Task{}, err
 	}
+	project.Overdue = project.IsOverdue(time.Now())
 	return project, nil
 }
 
-func (s *TaskService) CreateTask(r *domain.CreateTaskRequest) (*domain.CreateTaskRequest, error) {
-	switch r.Status {
-	case "domain.Pending", "pending", "PENDING":
-		r.Status = domain.Pending
-	case "domain.InProgress", "inprogress", "Inprogress", "INPROGRESS", "In Progress", "in progress", "IN PROGRESS":
-		r.Status = domain.InProgress
-	case "domain.Done", "done", "DONE":
-		r.Status = domain.Done
-	default:
+// Groups the tasks assigned to the user by project and then by status,
+// the store returns them already ordered so the project order is kept
+func (s *TaskService) GetAssignedTasks(cognitoId string, filter domain.TaskFilter) ([]domain.ProjectTasks, error) {
+	tasks, err := s.store.GetAssignedTasks(cognitoId, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	now := time.Now()
+	grouped := []domain.ProjectTasks{}
+	for _, t := range tasks {
+		t.Overdue = t.IsOverdue(now)
+		if len(grouped) == 0 || grouped[len(grouped)-1].ProjectId != t.ProjectId {
+			grouped = append(grouped, domain.ProjectTasks{
+				ProjectId:    t.ProjectId,
+				ProjectTitle: t.ProjectTitle,
+				Tasks:        map[domain.Status][]domain.Task{},
+			})
+		}
+		group := &grouped[len(grouped)-1]
+		group.Tasks[t.Status] = append(group.Tasks[t.Status], t.Task)
+	}
+	return grouped, nil
+}
+
+func (s *TaskService) CreateTask(r *domain.CreateTaskRequest) (domain.Task, error) {
+	if err := requireProjectRole(s.store, r.ProjectId, r.UserCognitoId, domain.ProjectWriteRole); err != nil {
+		return domain.Task{}, err
+	}
+	if r.Status == "" {
 		r.Status = domain.Pending
 	}
+	if err := r.Validate(); err != nil {
+		return domain.Task{}, err
+	}
+	assignees, err := s.checkAssignees(r.ProjectId, r.Assignees)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	r.Assignees = assignees
 
-	if err := s.store.CreateTask(r); err != nil {
-		return &domain.CreateTaskRequest{}, err
+	task, err := s.store.CreateTask(r)
+	if err != nil {
+		return domain.Task{}, err
 	}
-	return r, nil
+	task.Overdue = task.IsOverdue(time.Now())
+	return task, nil
 }
 
-func (s *TaskService) UpdateTask(id string, r *domain.CreateTaskRequest) error {
-	switch r.Status {
-	case "domain.Pending", "pending", "PENDING":
-		r.Status = domain.Pending
-	case "domain.InProgress", "inprogress", "Inprogress", "INPROGRESS", "In Progress", "in progress", "IN PROGRESS":
-		r.Status = domain.InProgress
-	case "domain.Done", "done", "DONE":
-		r.Status = domain.Done
-	default:
+func (s *TaskService) UpdateTask(taskId string, r *domain.CreateTaskRequest) (domain.Task, error) {
+	if err := requireProjectRole(s.store, r.ProjectId, r.UserCognitoId, domain.ProjectWriteRole); err != nil {
+		return domain.Task{}, err
+	}
+	if r.Status == "" {
 		r.Status = domain.Pending
 	}
+	if err := r.Validate(); err != nil {
+		return domain.Task{}, err
+	}
+	assignees, err := s.checkAssignees(r.ProjectId, r.Assignees)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	r.Assignees = assignees
 
-	if err := s.store.UpdateTask(id, r); err != nil {
-		return err
+	task, err := s.store.UpdateTask(taskId, r)
+	if err != nil {
+		return domain.Task{}, err
 	}
-	return nil
+	task.Overdue = task.IsOverdue(time.Now())
+	return task, nil
 }
 
-func (s *TaskService) DeleteTask(id string) error {
-	if err := s.store.DeleteTask(id); err != nil {
+// The current task is read first, the dates are validated against its current dates
+func (s *TaskService) PatchTask(taskId string, r *domain.PatchTaskRequest) (domain.Task, error) {
+	if err := requireProjectRole(s.store, r.ProjectId, r.UserCognitoId, domain.ProjectWriteRole); err != nil {
+		return domain.Task{}, err
+	}
+	current, err := s.store.GetTaskById(r.ProjectId, taskId)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	if err := r.Validate(current); err != nil {
+		return domain.Task{}, err
+	}
+	if r.Assignees.Set {
+		assignees, err := s.checkAssignees(r.ProjectId, r.Assignees.Value)
+		if err != nil {
+			return domain.Task{}, err
+		}
+		r.Assignees.Value = assignees
+	}
+
+	task, err := s.store.PatchTask(taskId, r)
+	if err != nil {
+		return domain.Task{}, err
+	}
+	task.Overdue = task.IsOverdue(time.Now())
+	return task, nil
+}
+
+func (s *TaskService) DeleteTask(projectId int, taskId, cognitoId string, version int) error {
+	if err := requireProjectRole(s.store, projectId, cognitoId, domain.ProjectWriteRole); err != nil {
+		return err
+	}
+	if err := s.store.DeleteTask(projectId, taskId, version); err != nil {
 		return err
 	}
 	return nil
 }
+
+// Removes duplicated assignees and makes sure every one of them can access the project
+func (s *TaskService) checkAssignees(projectId int, userIds []int) ([]int, error) {
+	if len(userIds) == 0 {
+		return userIds, nil
+	}
+	seen := make(map[int]bool, len(userIds))
+	unique := make([]int, 0, len(userIds))
+	for _, id := range userIds {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	allowed, err := s.store.GetProjectUsers(projectId, unique)
+	if err != nil {
+		return nil, err
+	}
+	if len(allowed) != len(unique) {
+		return nil, domain.ErrInvalidAssignee
+	}
+	return unique, nil
+}
@@ -1,6 +1,14 @@
 package svc
 
-import "github.com/Desgue/ttracker-api/internal/domain"
+import (
+	"errors"
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Invitations are valid for a week unless the request sets a different expiry
+const defaultInvitationTTL = 7 * 24 * time.Hour
 
 type TeamService struct {
 	store domain.TeamStorage
@@ -12,8 +20,15 @@ func NewTeamService(store domain.TeamStorage) *TeamService {
 	}
 }
 
-func (svc *TeamService) GetTeam(id int) (domain.Team, error) {
-	team, err := svc.store.GetTeam(id)
+func (svc *TeamService) GetTeams(cognitoId string) ([]domain.Team, error) {
+	return svc.store.GetTeams(cognitoId)
+}
+
+func (svc *TeamService) GetTeam(teamId int, cognitoId string) (domain.Team, error) {
+	if _, err := svc.authorize(teamId, cognitoId, domain.RoleViewer); err != nil {
+		return domain.Team{}, err
+	}
+	team, err := svc.store.GetTeam(teamId)
 	if err != nil {
 		return domain.Team{}, err
 	}
@@ -29,3 +44,115 @@ func (svc *TeamService) CreateTeam(r *domain.CreateTeamRequest) error {
 	}
 	return nil
 }
+
+func (svc *TeamService) GetMembers(teamId int, cognitoId string) ([]domain.TeamMember, error) {
+	if _, err := svc.authorize(teamId, cognitoId, domain.RoleViewer); err != nil {
+		return nil, err
+	}
+	return svc.store.GetMembers(teamId)
+}
+
+// Only admins can add members and only owners can add other owners
+func (svc *TeamService) AddMember(r *domain.TeamMemberRequest) error {
+	if !r.Role.Valid() {
+		return domain.ErrInvalidTeamRole
+	}
+	callerRole, err := svc.authorize(r.TeamId, r.UserCognitoId, domain.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if r.Role == domain.RoleOwner && callerRole != domain.RoleOwner {
+		return domain.ErrTeamForbidden
+	}
+	return svc.store.AddMember(r.TeamId, r.UserId, r.Role)
+}
+
+// Only admins can change roles, owners can only be promoted or demoted by another owner
+func (svc *TeamService) UpdateMemberRole(r *domain.TeamMemberRequest) error {
+	if !r.Role.Valid() {
+		return domain.ErrInvalidTeamRole
+	}
+	callerRole, err := svc.authorize(r.TeamId, r.UserCognitoId, domain.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	member, err := svc.store.GetMember(r.TeamId, r.UserId)
+	if err != nil {
+		return err
+	}
+	if (r.Role == domain.RoleOwner || member.Role == domain.RoleOwner) && callerRole != domain.RoleOwner {
+		return domain.ErrTeamForbidden
+	}
+	return svc.store.UpdateMemberRole(r.TeamId, r.UserId, r.Role)
+}
+
+// Admins can remove members and any member can leave the team, the last owner can not be removed
+func (svc *TeamService) RemoveMember(teamId, userId int, cognitoId string) error {
+	callerRole, err := svc.authorize(teamId, cognitoId, domain.RoleViewer)
+	if err != nil {
+		return err
+	}
+	member, err := svc.store.GetMember(teamId, userId)
+	if err != nil {
+		return err
+	}
+	leaving := member.CognitoId == cognitoId
+	if !leaving && !callerRole.AtLeast(domain.RoleAdmin) {
+		return domain.ErrTeamForbidden
+	}
+	if !leaving && member.Role == domain.RoleOwner && callerRole != domain.RoleOwner {
+		return domain.ErrTeamForbidden
+	}
+	return svc.store.RemoveMember(teamId, userId)
+}
+
+// Creates an invitation token the invited user can accept to join the team with the given role
+func (svc *TeamService) CreateInvitation(r *domain.CreateTeamInvitationRequest) (domain.TeamInvitation, error) {
+	if !r.Role.Valid() {
+		return domain.TeamInvitation{}, domain.ErrInvalidTeamRole
+	}
+	if r.ExpiresAt == nil {
+		expiresAt := time.Now().Add(defaultInvitationTTL)
+		r.ExpiresAt = &expiresAt
+	}
+	if !r.ExpiresAt.After(time.Now()) {
+		return domain.TeamInvitation{}, domain.ErrInvalidInvitationTTL
+	}
+	callerRole, err := svc.authorize(r.TeamId, r.UserCognitoId, domain.RoleAdmin)
+	if err != nil {
+		return domain.TeamInvitation{}, err
+	}
+	if r.Role == domain.RoleOwner && callerRole != domain.RoleOwner {
+		return domain.TeamInvitation{}, domain.ErrTeamForbidden
+	}
+
+	secret, err := generateSecret(domain.TeamInvitationPrefix)
+	if err != nil {
+		return domain.TeamInvitation{}, err
+	}
+	invitation, err := svc.store.CreateInvitation(r, hashSecret(secret))
+	if err != nil {
+		return domain.TeamInvitation{}, err
+	}
+	invitation.Token = secret
+	return invitation, nil
+}
+
+func (svc *TeamService) AcceptInvitation(token, cognitoId string) (domain.TeamMember, error) {
+	return svc.store.AcceptInvitation(hashSecret(token), cognitoId)
+}
+
+// Returns the role of the user in the team if it is at least the required role
+func (svc *TeamService) authorize(teamId int, cognitoId string, required domain.Role) (domain.Role, error) {
+	role, err := svc.store.GetMemberRole(teamId, cognitoId)
+	if errors.Is(err, domain.ErrTeamMemberNotFound) {
+		return "", domain.ErrTeamForbidden
+	}
+	if err != nil {
+		return "", err
+	}
+	if !role.AtLeast(required) {
+		return "", domain.ErrTeamForbidden
+	}
+	return role, nil
+}
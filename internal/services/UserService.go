@@ -1,16 +1,24 @@
 package svc
 
-import "github.com/Desgue/ttracker-api/internal/domain"
+import (
+	"time"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+	"github.com/Desgue/ttracker-api/internal/util"
+)
 
 // User service that handles business logic before inserting user into the database
 
 type UserService struct {
 	store domain.UserStorage
+	// Users already known to be in the database, avoids a query on every request
+	known *util.LRU[string, struct{}]
 }
 
 func NewUserService(store domain.UserStorage) *UserService {
 	return &UserService{
 		store: store,
+		known: util.NewLRU[string, struct{}](10000, 10*time.Minute),
 	}
 }
 
@@ -18,6 +26,7 @@ func (s *UserService) CreateUser(cognitoId string) error {
 	if err := s.store.CreateUser(cognitoId); err != nil {
 		return err
 	}
+	s.known.Add(cognitoId, struct{}{})
 	return nil
 }
 
@@ -28,3 +37,11 @@ func (s *UserService) CheckUser(cognitoId string) (bool, error) {
 	}
 	return exists, nil
 }
+
+// Makes sure the user is present on the database, creating it on the first request
+func (s *UserService) EnsureUser(cognitoId string) error {
+	if _, ok := s.known.Get(cognitoId); ok {
+		return nil
+	}
+	return s.CreateUser(cognitoId)
+}
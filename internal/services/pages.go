@@ -0,0 +1,25 @@
+package svc
+
+import "github.com/Desgue/ttracker-api/internal/domain"
+
+// Stores are asked for one item more than the page holds, getting it back means there is a next page
+func peekPage(page domain.Page) domain.Page {
+	if page.Limit > 0 {
+		page.Limit++
+	}
+	return page
+}
+
+// Cuts the items fetched with peekPage down to the page and returns the cursor of the next page,
+// nil when the items fit in the page. An empty page is an empty list, not nil
+func trimPage[T interface{ SortKey(domain.SortField) string }](items []T, page domain.Page, id func(T) int) ([]T, *domain.Cursor) {
+	if items == nil {
+		items = []T{}
+	}
+	if page.Limit == 0 || len(items) <= page.Limit {
+		return items, nil
+	}
+	items = items[:page.Limit]
+	last := items[len(items)-1]
+	return items, &domain.Cursor{Key: last.SortKey(page.Sort), Id: id(last)}
+}
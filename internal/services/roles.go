@@ -0,0 +1,21 @@
+package svc
+
+import "github.com/Desgue/ttracker-api/internal/domain"
+
+// Implemented by every store scoped to projects, projects themselves are looked up by their string id
+type projectRoles[ID int | string] interface {
+	GetProjectRole(projectId ID, cognitoId string) (domain.Role, error)
+}
+
+// Fails with the error of the store when the project does not exist or the user has no role in it,
+// and with ErrForbidden when their role is below the required one
+func requireProjectRole[ID int | string](store projectRoles[ID], projectId ID, cognitoId string, required domain.Role) error {
+	role, err := store.GetProjectRole(projectId, cognitoId)
+	if err != nil {
+		return err
+	}
+	if !role.AtLeast(required) {
+		return domain.ErrForbidden
+	}
+	return nil
+}
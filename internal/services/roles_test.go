@@ -0,0 +1,48 @@
+package svc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Desgue/ttracker-api/internal/domain"
+)
+
+// Roles on project 1, other projects do not exist
+type fakeRoles map[string]domain.Role
+
+func (f fakeRoles) GetProjectRole(projectId int, cognitoId string) (domain.Role, error) {
+	if projectId != 1 {
+		return "", domain.ErrProjectNotFound
+	}
+	role, ok := f[cognitoId]
+	if !ok {
+		return "", domain.ErrForbidden
+	}
+	return role, nil
+}
+
+func TestRequireProjectRole(t *testing.T) {
+	store := fakeRoles{"owner": domain.RoleOwner, "member": domain.RoleMember, "viewer": domain.RoleViewer}
+	cases := []struct {
+		projectId int
+		cognitoId string
+		required  domain.Role
+		want      error
+	}{
+		{1, "owner", domain.ProjectDeleteRole, nil},
+		{1, "member", domain.ProjectWriteRole, nil},
+		{1, "member", domain.ProjectReadRole, nil},
+		{1, "member", domain.ProjectManageRole, domain.ErrForbidden},
+		{1, "viewer", domain.ProjectReadRole, nil},
+		{1, "viewer", domain.ProjectWriteRole, domain.ErrForbidden},
+		{1, "stranger", domain.ProjectReadRole, domain.ErrForbidden},
+		{2, "owner", domain.ProjectReadRole, domain.ErrProjectNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.cognitoId+" "+string(c.required), func(t *testing.T) {
+			if err := requireProjectRole(store, c.projectId, c.cognitoId, c.required); !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,9 @@ package util
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,8 +18,57 @@ var (
 	Cognito_jwk_url string
 	Cognito_issuer  string
 	IsProd          bool
+
+	// Storage backend, postgres or memory. The memory backend loses everything on restart
+	Storage_backend string
+
+	// Address of the internal listener serving the expvar metrics, disabled when empty. It is not
+	// authenticated and must not be reachable from outside
+	Admin_addr string
+
+	// How long the cached Cognito keys can be used after expiring when Cognito is unreachable
+	Jwks_max_staleness time.Duration
+
+	// Authentication provider, one of cognito, oidc or static
+	Auth_provider string
+	// Used by the oidc provider
+	Oidc_issuer   string
+	Oidc_audience string
+	// Used by the static provider, either an HMAC secret or a path to an RSA public key in PEM format
+	Auth_static_secret     string
+	Auth_static_public_key string
+	Auth_static_issuer     string
+
+	// Rejects the writes of projects and tasks sent without an If-Match header with a 428
+	Require_if_match bool
+
+	// Where the content of the attachments is kept, local or s3
+	Blob_backend string
+	// Directory of the local blob backend
+	Blob_dir string
+	// Used by the s3 blob backend, any S3 compatible service works
+	S3_endpoint   string
+	S3_region     string
+	S3_bucket     string
+	S3_access_key string
+	S3_secret_key string
+	S3_prefix     string
+
+	// Largest attachment accepted in bytes and the media types accepted, type/* accepts every subtype
+	Attachment_max_size int64
+	Attachment_types    []string
 )
 
+var defaultAttachmentTypes = []string{
+	"image/*",
+	"application/pdf",
+	"text/plain",
+	"text/markdown",
+	"text/csv",
+	"application/json",
+	"application/zip",
+}
+
 func LoadENV() {
 	// Load variables from .env file
 	if os.Getenv("APP_ENV") != "production" {
@@ -26,8 +78,9 @@ func LoadENV() {
 		if err := godotenv.Load(); err != nil {
 			log.Fatal("Error loading .env file")
 		}
+		Storage_backend = os.Getenv("STORAGE_BACKEND")
 		ConnStr, Ok = os.LookupEnv("LOCAL_DB")
-		if !Ok {
+		if !Ok && Storage_backend != "memory" {
 			log.Fatal("LOCAL_DB not found in .env file")
 		}
 		HostPort, Ok = os.LookupEnv("PORT")
@@ -35,17 +88,17 @@ func LoadENV() {
 			HostPort = "8000"
 		}
 
-		if err := godotenv.Load(); err != nil {
-			log.Fatal("Error loading .env file")
-		}
-		Cognito_jwk_url, Ok = os.LookupEnv("COGNITO_JWK_URL")
-		if !Ok {
-			log.Fatalln("Cognito Url not found in .env file")
-		}
-		// get the cognito endpoint
-		Cognito_issuer, Ok = os.LookupEnv("COGNITO_ISSUER")
-		if !Ok {
-			log.Fatalln("Cognito Issuer not found in .env file")
+		Auth_provider = os.Getenv("AUTH_PROVIDER")
+		if Auth_provider == "" || Auth_provider == "cognito" {
+			Cognito_jwk_url, Ok = os.LookupEnv("COGNITO_JWK_URL")
+			if !Ok {
+				log.Fatalln("Cognito Url not found in .env file")
+			}
+			// get the cognito endpoint
+			Cognito_issuer, Ok = os.LookupEnv("COGNITO_ISSUER")
+			if !Ok {
+				log.Fatalln("Cognito Issuer not found in .env file")
+			}
 		}
 
 		ListenAddr = "localhost:" + HostPort
@@ -54,9 +107,75 @@ func LoadENV() {
 		log.Println("Loading environment variables")
 		IsProd = true
 		ConnStr = os.Getenv("DATABASE_URL")
+		Storage_backend = os.Getenv("STORAGE_BACKEND")
 		HostPort = os.Getenv("PORT")
 		ListenAddr = `0.0.0.0:` + HostPort
 		Cognito_jwk_url = os.Getenv("COGNITO_JWK_URL")
 		Cognito_issuer = os.Getenv("COGNITO_ISSUER")
+		Auth_provider = os.Getenv("AUTH_PROVIDER")
+	}
+
+	if Storage_backend == "" {
+		Storage_backend = "postgres"
+	}
+	if Auth_provider == "" {
+		Auth_provider = "cognito"
+	}
+	Oidc_issuer = os.Getenv("OIDC_ISSUER")
+	Oidc_audience = os.Getenv("OIDC_AUDIENCE")
+	Auth_static_secret = os.Getenv("AUTH_STATIC_SECRET")
+	Auth_static_public_key = os.Getenv("AUTH_STATIC_PUBLIC_KEY")
+	Auth_static_issuer = os.Getenv("AUTH_STATIC_ISSUER")
+
+	Admin_addr = os.Getenv("ADMIN_ADDR")
+
+	Jwks_max_staleness = 6 * time.Hour
+	if v, ok := os.LookupEnv("JWKS_MAX_STALENESS"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalln("Invalid JWKS_MAX_STALENESS: ", err)
+		}
+		Jwks_max_staleness = d
+	}
+
+	if v, ok := os.LookupEnv("REQUIRE_IF_MATCH"); ok {
+		required, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalln("Invalid REQUIRE_IF_MATCH: ", err)
+		}
+		Require_if_match = required
+	}
+
+	Blob_backend = os.Getenv("BLOB_BACKEND")
+	if Blob_backend == "" {
+		Blob_backend = "local"
+	}
+	Blob_dir = os.Getenv("BLOB_DIR")
+	if Blob_dir == "" {
+		Blob_dir = "attachments"
+	}
+	S3_endpoint = os.Getenv("S3_ENDPOINT")
+	S3_region = os.Getenv("S3_REGION")
+	S3_bucket = os.Getenv("S3_BUCKET")
+	S3_access_key = os.Getenv("S3_ACCESS_KEY")
+	S3_secret_key = os.Getenv("S3_SECRET_KEY")
+	S3_prefix = os.Getenv("S3_PREFIX")
+
+	Attachment_max_size = 25 << 20
+	if v, ok := os.LookupEnv("ATTACHMENT_MAX_SIZE"); ok {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size <= 0 {
+			log.Fatalln("Invalid ATTACHMENT_MAX_SIZE, must be a number of bytes: ", v)
+		}
+		Attachment_max_size = size
+	}
+	Attachment_types = defaultAttachmentTypes
+	if v, ok := os.LookupEnv("ATTACHMENT_TYPES"); ok {
+		Attachment_types = nil
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				Attachment_types = append(Attachment_types, t)
+			}
+		}
 	}
 }
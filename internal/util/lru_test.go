@@ -0,0 +1,91 @@
+package util
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Cache whose clock only moves when the test advances it
+func newTestLRU(size int, ttl time.Duration) (*LRU[string, int], func(time.Duration)) {
+	cache := NewLRU[string, int](size, ttl)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	return cache, func(d time.Duration) { now = now.Add(d) }
+}
+
+func mustHave(t *testing.T, cache *LRU[string, int], key string, want int) {
+	t.Helper()
+	got, ok := cache.Get(key)
+	if !ok {
+		t.Fatalf("%q is missing from the cache", key)
+	}
+	if got != want {
+		t.Fatalf("got %d for %q, want %d", got, key, want)
+	}
+}
+
+func mustMiss(t *testing.T, cache *LRU[string, int], key string) {
+	t.Helper()
+	if got, ok := cache.Get(key); ok {
+		t.Fatalf("%q is still cached with %d", key, got)
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	cache, advance := newTestLRU(10, time.Minute)
+	cache.Add("a", 1)
+	advance(30 * time.Second)
+	cache.Add("b", 2)
+
+	advance(30 * time.Second)
+	mustHave(t, cache, "a", 1)
+	advance(time.Second)
+	mustMiss(t, cache, "a")
+	mustHave(t, cache, "b", 2)
+
+	// Adding an existing key refreshes its ttl
+	cache.Add("b", 3)
+	advance(59 * time.Second)
+	mustHave(t, cache, "b", 3)
+	advance(2 * time.Second)
+	mustMiss(t, cache, "b")
+}
+
+func TestLRUEvictionOrder(t *testing.T) {
+	cache, _ := newTestLRU(3, time.Minute)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	cache.Add("c", 3)
+
+	// Reading and adding again both make a key the most recently used
+	mustHave(t, cache, "a", 1)
+	cache.Add("b", 20)
+	cache.Add("d", 4)
+	mustMiss(t, cache, "c")
+
+	cache.Add("e", 5)
+	mustMiss(t, cache, "a")
+	mustHave(t, cache, "b", 20)
+	mustHave(t, cache, "d", 4)
+	mustHave(t, cache, "e", 5)
+}
+
+func TestLRUCapacity(t *testing.T) {
+	cache, _ := newTestLRU(100, time.Minute)
+	for i := 0; i < 1000; i++ {
+		cache.Add(strconv.Itoa(i), i)
+		if n := cache.order.Len(); n > 100 || len(cache.items) != n {
+			t.Fatalf("cache holds %d entries and %d keys after %d adds, want at most 100", n, len(cache.items), i+1)
+		}
+	}
+	mustMiss(t, cache, "899")
+	mustHave(t, cache, "900", 900)
+	mustHave(t, cache, "999", 999)
+
+	cache.Remove("999")
+	mustMiss(t, cache, "999")
+	if n := cache.order.Len(); n != 99 || len(cache.items) != 99 {
+		t.Fatalf("cache holds %d entries after a removal, want 99", n)
+	}
+}
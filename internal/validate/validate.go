@@ -0,0 +1,52 @@
+// Package validate collects the field errors of a request so every invalid field
+// is reported at once instead of only the first one
+package validate
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error about a single field of a request, Field is the JSON name of the field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Validator struct {
+	errors []FieldError
+}
+
+// Records msg against field when ok is false
+func (v *Validator) Check(ok bool, field, msg string) {
+	if !ok {
+		v.errors = append(v.errors, FieldError{Field: field, Message: msg})
+	}
+}
+
+func (v *Validator) Required(field, value string) {
+	v.Check(value != "", field, field+" is required")
+}
+
+// Lengths are counted in characters, the same way varchar columns count them
+func (v *Validator) MaxLength(field, value string, max int) {
+	v.Check(utf8.RuneCountInString(value) <= max, field, fmt.Sprintf("%s must not be longer than %d characters", field, max))
+}
+
+func (v *Validator) OneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.Check(false, field, fmt.Sprintf("%s must be one of %s", field, strings.Join(allowed, ", ")))
+}
+
+func (v *Validator) Valid() bool {
+	return len(v.errors) == 0
+}
+
+func (v *Validator) Errors() []FieldError {
+	return v.errors
+}
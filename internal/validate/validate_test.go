@@ -0,0 +1,40 @@
+package validate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidatorReportsEveryField(t *testing.T) {
+	v := new(Validator)
+	v.Required("title", "")
+	v.MaxLength("title", "", 3)
+	v.MaxLength("name", "abcd", 3)
+	v.OneOf("color", "pink", "red", "blue")
+	v.Check(false, "dueDate", "dueDate must be after startDate")
+	if v.Valid() {
+		t.Fatal("validator with failed checks reported as valid")
+	}
+	want := []FieldError{
+		{"title", "title is required"},
+		{"name", "name must not be longer than 3 characters"},
+		{"color", "color must be one of red, blue"},
+		{"dueDate", "dueDate must be after startDate"},
+	}
+	if got := v.Errors(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got errors %+v, want %+v", got, want)
+	}
+}
+
+func TestValidatorPasses(t *testing.T) {
+	v := new(Validator)
+	v.Required("title", "a")
+	// Characters are counted, not bytes
+	v.MaxLength("title", strings.Repeat("é", 3), 3)
+	v.OneOf("color", "red", "red", "blue")
+	v.Check(true, "dueDate", "unused")
+	if !v.Valid() || len(v.Errors()) != 0 {
+		t.Fatalf("got errors %+v for valid fields", v.Errors())
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log"
+	"os"
+	"time"
 
 	"github.com/Desgue/ttracker-api/internal/api"
+	"github.com/Desgue/ttracker-api/internal/domain"
 	repo "github.com/Desgue/ttracker-api/internal/repository"
 	svc "github.com/Desgue/ttracker-api/internal/services"
 	"github.com/Desgue/ttracker-api/internal/util"
@@ -14,36 +19,160 @@ func main() {
 
 	util.LoadENV()
 
-	// Database initialization
-	postgress, err := repo.NewPostgresStore(util.ConnStr)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	if err := postgress.Ping(); err != nil {
-		log.Fatalln(err)
-	}
-	if !util.IsProd {
-		postgress.Init()
-	}
+	// Storage initialization
+	stores := newStorage()
 
 	// User initialization
-	//userStore := repo.NewPostgresUserStore(postgress.DB)
+	userService := svc.NewUserService(stores.users)
+
+	// Access token initialization
+	accessTokenService := svc.NewAccessTokenService(stores.accessTokens)
+
+	// Team initialization
+	teamService := svc.NewTeamService(stores.teams)
 
 	// Project initialization
-	projectStore := repo.NewPostgresProjectStore(postgress.DB)
-	projectService := svc.NewProjectService(projectStore)
+	projectService := svc.NewProjectService(stores.projects, stores.teams)
 
 	// Task initialization
-	taskStore := repo.NewPostgresTaskStore(postgress.DB)
-	taskService := svc.NewTaskService(taskStore)
+	taskService := svc.NewTaskService(stores.tasks)
+
+	// Search initialization
+	searchService := svc.NewSearchService(stores.search)
+
+	labelService := svc.NewLabelService(stores.labels)
+
+	commentService := svc.NewCommentService(stores.comments)
+
+	// Attachment initialization, the content of deleted attachments and tasks is purged hourly
+	attachmentService := svc.NewAttachmentService(stores.attachments, newBlobStore(), domain.AttachmentLimits{
+		MaxSize: util.Attachment_max_size,
+		Types:   util.Attachment_types,
+	})
+	attachmentService.StartPurge(context.Background(), time.Hour)
+
+	// Authentication initialization
+	auth, err := api.NewAuthenticator(context.Background())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if jwtAuth, ok := auth.(*api.JWTAuthenticator); ok && jwtAuth.Keys != nil {
+		expvar.Publish("jwks", expvar.Func(func() any { return jwtAuth.Keys.Metrics() }))
+	}
 
 	// Server initialization
 	contollers := &api.Controllers{
-		Project: api.NewProjectController(projectService),
-		Task:    api.NewTaskController(taskService),
+		Project:     api.NewProjectController(projectService),
+		Task:        api.NewTaskController(taskService),
+		Team:        api.NewTeamController(teamService),
+		User:        api.NewUserController(userService),
+		AccessToken: api.NewAccessTokenController(accessTokenService),
+		Meta:        api.NewMetaController(),
+		Search:      api.NewSearchController(searchService),
+		Label:       api.NewLabelController(labelService),
+		Comment:     api.NewCommentController(commentService),
+		Attachment:  api.NewAttachmentController(attachmentService),
 	}
 
-	server := api.NewServer(util.ListenAddr, contollers)
+	server := api.NewServer(util.ListenAddr, util.Admin_addr, contollers, auth, userService, accessTokenService)
 	server.Run()
 
 }
+
+type storage struct {
+	users        domain.UserStorage
+	accessTokens domain.AccessTokenStorage
+	teams        domain.TeamStorage
+	projects     domain.ProjectStorage
+	tasks        domain.TaskStorage
+	search       domain.SearchStorage
+	labels       domain.LabelStorage
+	comments     domain.CommentStorage
+	attachments  domain.AttachmentStorage
+}
+
+// Builds the stores of the backend selected by STORAGE_BACKEND
+func newStorage() storage {
+	switch util.Storage_backend {
+	case "memory":
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			log.Fatalln("The memory storage backend has no schema to migrate")
+		}
+		log.Println("Using the in-memory storage backend, data is lost on restart")
+		kv := repo.NewKvRepository()
+		return storage{
+			users:        repo.NewKVUserStore(kv),
+			accessTokens: repo.NewKVAccessTokenStore(kv),
+			teams:        repo.NewKVTeamStore(kv),
+			projects:     repo.NewKVProjectStore(kv),
+			tasks:        repo.NewKVTaskStore(kv),
+			search:       repo.NewKVSearchStore(kv),
+			labels:       repo.NewKVLabelStore(kv),
+			comments:     repo.NewKVCommentStore(kv),
+			attachments:  repo.NewKVAttachmentStore(kv),
+		}
+	case "postgres":
+		postgress, err := repo.NewPostgresStore(util.ConnStr)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := postgress.Ping(); err != nil {
+			log.Fatalln(err)
+		}
+		// Schema changes are applied on startup during development,
+		// production deploys run them deliberately with the migrate subcommand
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			if err := runMigrate(postgress.DB, os.Args[2:]); err != nil {
+				log.Fatalln(err)
+			}
+			os.Exit(0)
+		}
+		if !util.IsProd {
+			if err := runMigrate(postgress.DB, []string{"up"}); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		return storage{
+			users:        repo.NewPostgresUserStore(postgress.DB),
+			accessTokens: repo.NewPostgresAccessTokenStore(postgress.DB),
+			teams:        repo.NewPostgresTeamStore(postgress.DB),
+			projects:     repo.NewPostgresProjectStore(postgress.DB),
+			tasks:        repo.NewPostgresTaskStore(postgress.DB),
+			search:       repo.NewPostgresSearchStore(postgress.DB),
+			labels:       repo.NewPostgresLabelStore(postgress.DB),
+			comments:     repo.NewPostgresCommentStore(postgress.DB),
+			attachments:  repo.NewPostgresAttachmentStore(postgress.DB),
+		}
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q, must be postgres or memory", util.Storage_backend)
+		return storage{}
+	}
+}
+
+// Builds the store of the attachment content selected by BLOB_BACKEND
+func newBlobStore() domain.BlobStore {
+	switch util.Blob_backend {
+	case "local":
+		store, err := repo.NewLocalBlobStore(util.Blob_dir)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return store
+	case "s3":
+		store, err := repo.NewS3BlobStore(repo.S3Config{
+			Endpoint:  util.S3_endpoint,
+			Region:    util.S3_region,
+			Bucket:    util.S3_bucket,
+			AccessKey: util.S3_access_key,
+			SecretKey: util.S3_secret_key,
+			Prefix:    util.S3_prefix,
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return store
+	default:
+		log.Fatalf("Unknown BLOB_BACKEND %q, must be local or s3", util.Blob_backend)
+		return nil
+	}
+}